@@ -0,0 +1,201 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/db"
+)
+
+// Format and Version identify the archive layout written by Export
+const (
+	Format  = "fms-backup"
+	Version = 1
+)
+
+// Tables are exported in dependency order so a restore can insert them top to bottom
+var Tables = []string{
+	"fms_sensor_config",
+	"fms_projects",
+	"fms_ships",
+	"fms_ship_aliases",
+	"fms_ship_sensors",
+	"fms_app_config",
+	"fms_device_reports",
+	"fms_rules",
+	"fms_alerts",
+}
+
+// Header is the first line of an archive
+type Header struct {
+	Format    string         `json:"format"`
+	Version   int            `json:"version"`
+	CreatedAt time.Time      `json:"created_at"`
+	Tables    []string       `json:"tables"`
+	Counts    map[string]int `json:"counts,omitempty"`
+}
+
+// Line is one exported row
+type Line struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+// Export writes every table as NDJSON: a Header line followed by one Line per row.
+// Rows are read inside a single repeatable-read transaction so the archive is consistent.
+func Export(ctx context.Context, w io.Writer) (Header, error) {
+	h := Header{Format: Format, Version: Version, CreatedAt: time.Now().UTC(), Tables: Tables, Counts: map[string]int{}}
+
+	tx, err := db.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return h, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range Tables {
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+			return h, fmt.Errorf("count %s: %w", table, err)
+		}
+		h.Counts[table] = n
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(h); err != nil {
+		return h, err
+	}
+
+	for _, table := range Tables {
+		order, err := primaryKeyOrder(ctx, tx, table)
+		if err != nil {
+			return h, fmt.Errorf("primary key of %s: %w", table, err)
+		}
+		rows, err := tx.QueryContext(ctx, "SELECT row_to_json(t) FROM "+table+" t"+order)
+		if err != nil {
+			return h, fmt.Errorf("export %s: %w", table, err)
+		}
+		for rows.Next() {
+			var row json.RawMessage
+			if err := rows.Scan(&row); err != nil {
+				rows.Close()
+				return h, err
+			}
+			if err := enc.Encode(Line{Table: table, Row: row}); err != nil {
+				rows.Close()
+				return h, err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return h, err
+		}
+	}
+
+	return h, bw.Flush()
+}
+
+// primaryKeyOrder returns the ORDER BY clause that lists table by its primary key,
+// or "" for a table without one, so archives of the same data are identical
+func primaryKeyOrder(ctx context.Context, tx *sql.Tx, table string) (string, error) {
+	var cols string
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(string_agg('t.' || quote_ident(a.attname), ', ' ORDER BY array_position(i.indkey::int2[], a.attnum)), '')
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = $1::regclass AND i.indisprimary
+	`, table).Scan(&cols)
+	if err != nil || cols == "" {
+		return "", err
+	}
+	return " ORDER BY " + cols, nil
+}
+
+// WriteFile exports a gzipped archive into dir and returns its path
+func WriteFile(ctx context.Context, dir string) (string, Header, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", Header{}, err
+	}
+	name := fmt.Sprintf("fms-backup-%s.ndjson.gz", time.Now().Format("20060102-150405"))
+	path := filepath.Join(dir, name)
+
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return "", Header{}, err
+	}
+	defer os.Remove(path + ".tmp")
+
+	gz := gzip.NewWriter(f)
+	h, err := Export(ctx, gz)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", h, err
+	}
+	return path, h, os.Rename(path+".tmp", path)
+}
+
+// Prune keeps the newest keep archives in dir and deletes the rest
+func Prune(dir string, keep int) (int, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "fms-backup-*.ndjson.gz"))
+	if err != nil {
+		return 0, err
+	}
+	// Names embed a sortable timestamp
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+
+	removed := 0
+	for i, m := range matches {
+		if i < keep {
+			continue
+		}
+		if err := os.Remove(m); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Task is the scheduled backup: writes an archive to BACKUP_DIR (default ./backups)
+// and keeps the newest BACKUP_KEEP (default 14)
+func Task(ctx context.Context) (string, error) {
+	dir := os.Getenv("BACKUP_DIR")
+	if dir == "" {
+		dir = "backups"
+	}
+	keep, err := strconv.Atoi(os.Getenv("BACKUP_KEEP"))
+	if err != nil || keep < 1 {
+		keep = 14
+	}
+
+	path, h, err := WriteFile(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	removed, err := Prune(dir, keep)
+	if err != nil {
+		return "", err
+	}
+
+	var counts []string
+	for _, t := range h.Tables {
+		counts = append(counts, fmt.Sprintf("%s=%d", strings.TrimPrefix(t, "fms_"), h.Counts[t]))
+	}
+	return fmt.Sprintf("%s (%s), %d old archive(s) removed", path, strings.Join(counts, ", "), removed), nil
+}
@@ -0,0 +1,241 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"fms-app/db"
+
+	"github.com/lib/pq"
+)
+
+// maxLine bounds a single archive line; report rows are well below this
+const maxLine = 16 << 20
+
+// ErrNotEmpty is returned when restoring into a database that already holds data
+var ErrNotEmpty = errors.New("database is not empty")
+
+// dataTables must be empty before a restore. The other tables only hold the defaults
+// seeded by db.Migrate, which the archive replaces; tables referencing them must be empty
+// too, see checkDependents.
+var dataTables = []string{"fms_ships", "fms_device_reports", "fms_alerts"}
+
+// references are soft links without a foreign key; a restore fails if any row dangles
+var references = []struct {
+	desc  string
+	query string
+}{
+	{"reports for unknown ships", `SELECT COUNT(*) FROM fms_device_reports r WHERE NOT EXISTS (SELECT 1 FROM fms_ships s WHERE s.name = r.ship_name)`},
+	{"alerts for unknown ships", `SELECT COUNT(*) FROM fms_alerts a WHERE NOT EXISTS (SELECT 1 FROM fms_ships s WHERE s.name = a.ship_name)`},
+}
+
+// Restore loads an archive written by Export into an empty database in one transaction.
+// Foreign keys are checked as rows are inserted, soft references and row counts at the end;
+// any failure rolls the whole restore back.
+func Restore(ctx context.Context, r io.Reader) (Header, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxLine)
+
+	var h Header
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return h, err
+		}
+		return h, fmt.Errorf("empty archive")
+	}
+	if err := json.Unmarshal(sc.Bytes(), &h); err != nil || h.Format != Format {
+		return h, fmt.Errorf("not a %s archive", Format)
+	}
+	if h.Version < 1 || h.Version > Version {
+		return h, fmt.Errorf("archive version %d is not supported (max %d)", h.Version, Version)
+	}
+	order := map[string]int{}
+	for i, t := range Tables {
+		order[t] = i
+	}
+	declared := map[string]bool{}
+	for _, t := range h.Tables {
+		if _, ok := order[t]; !ok {
+			return h, fmt.Errorf("archive contains unknown table %s", t)
+		}
+		declared[t] = true
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return h, err
+	}
+	defer tx.Rollback()
+
+	for _, t := range dataTables {
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+t).Scan(&n); err != nil {
+			return h, err
+		}
+		if n > 0 {
+			return h, fmt.Errorf("%w: %s has %d rows", ErrNotEmpty, t, n)
+		}
+	}
+	if err := checkDependents(ctx, tx); err != nil {
+		return h, err
+	}
+	for i := len(Tables) - 1; i >= 0; i-- {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+Tables[i]); err != nil {
+			return h, fmt.Errorf("clear %s: %w", Tables[i], err)
+		}
+	}
+
+	counts := map[string]int{}
+	last, lineNo := -1, 1
+	for sc.Scan() {
+		lineNo++
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var l Line
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			return h, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if !declared[l.Table] {
+			return h, fmt.Errorf("line %d: table %s is not declared in the header", lineNo, l.Table)
+		}
+		if order[l.Table] < last {
+			return h, fmt.Errorf("line %d: %s rows must come before %s", lineNo, l.Table, Tables[last])
+		}
+		last = order[l.Table]
+
+		// Columns missing from older archives take NULL; unknown keys are ignored
+		q := fmt.Sprintf("INSERT INTO %[1]s SELECT * FROM json_populate_record(NULL::%[1]s, $1)", l.Table)
+		if _, err := tx.ExecContext(ctx, q, string(l.Row)); err != nil {
+			return h, fmt.Errorf("line %d (%s): %w", lineNo, l.Table, err)
+		}
+		counts[l.Table]++
+	}
+	if err := sc.Err(); err != nil {
+		return h, err
+	}
+
+	for _, t := range h.Tables {
+		if want, ok := h.Counts[t]; ok && counts[t] != want {
+			return h, fmt.Errorf("%s: archive declares %d rows but contains %d", t, want, counts[t])
+		}
+	}
+	if err := checkReferences(ctx, tx); err != nil {
+		return h, err
+	}
+	if err := resetSequences(ctx, tx); err != nil {
+		return h, err
+	}
+
+	h.Counts = counts
+	return h, tx.Commit()
+}
+
+// checkDependents refuses the restore when a table outside the archive has rows that
+// reference an archived table (recipients of a project, device tokens, readings, drafts
+// and logs of a ship, ...): clearing the seeded defaults would cascade into them
+func checkDependents(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT c.conrelid::regclass::text
+		FROM pg_constraint c
+		WHERE c.contype = 'f'
+		  AND c.confrelid::regclass::text = ANY($1)
+		  AND NOT c.conrelid::regclass::text = ANY($1)
+		ORDER BY 1
+	`, pq.Array(Tables))
+	if err != nil {
+		return err
+	}
+	var dependents []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			rows.Close()
+			return err
+		}
+		dependents = append(dependents, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range dependents {
+		var found bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+t+")").Scan(&found); err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("%w: %s has rows that depend on restored tables", ErrNotEmpty, t)
+		}
+	}
+	return nil
+}
+
+func checkReferences(ctx context.Context, tx *sql.Tx) error {
+	var problems []string
+	for _, ref := range references {
+		var n int
+		if err := tx.QueryRowContext(ctx, ref.query).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			problems = append(problems, fmt.Sprintf("%d %s", n, ref.desc))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("referential check failed: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// resetSequences moves every SERIAL id sequence past the restored ids
+func resetSequences(ctx context.Context, tx *sql.Tx) error {
+	for _, t := range Tables {
+		var seq sql.NullString
+		err := tx.QueryRowContext(ctx, `
+			SELECT pg_get_serial_sequence(table_name, column_name) FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 AND column_name = 'id'`, t).Scan(&seq)
+		if err == sql.ErrNoRows || (err == nil && !seq.Valid) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		q := fmt.Sprintf("SELECT setval($1, COALESCE((SELECT MAX(id) FROM %s), 0) + 1, false)", t)
+		if _, err := tx.ExecContext(ctx, q, seq.String); err != nil {
+			return fmt.Errorf("reset sequence of %s: %w", t, err)
+		}
+	}
+	return nil
+}
+
+// RestoreFile restores an archive from disk; gzipped and plain NDJSON are both accepted
+func RestoreFile(ctx context.Context, path string) (Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return Header{}, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return Restore(ctx, r)
+}
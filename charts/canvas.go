@@ -0,0 +1,68 @@
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Canvas is the drawing surface charts render onto. Coordinates are in chart units with
+// the origin top-left; colours are "#rrggbb". SVGCanvas writes SVG, other outputs (PDF)
+// implement the same methods.
+type Canvas interface {
+	Rect(x, y, w, h float64, fill string)
+	Line(x1, y1, x2, y2 float64, stroke string, width float64)
+	Polyline(points []Point, stroke string, width float64)
+	Circle(cx, cy, r float64, fill string)
+	// Text draws s with its baseline at y; anchor is "start", "middle" or "end"
+	Text(x, y float64, s string, size float64, anchor, color string)
+}
+
+// Point is a position on the canvas
+type Point struct {
+	X, Y float64
+}
+
+// SVGCanvas builds an SVG document
+type SVGCanvas struct {
+	buf  bytes.Buffer
+	w, h float64
+}
+
+// NewSVGCanvas starts an SVG document of the given size
+func NewSVGCanvas(w, h float64) *SVGCanvas {
+	c := &SVGCanvas{w: w, h: h}
+	fmt.Fprintf(&c.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g" font-family="Inter, Arial, sans-serif">`, w, h, w, h)
+	c.Rect(0, 0, w, h, "#ffffff")
+	return c
+}
+
+func (c *SVGCanvas) Rect(x, y, w, h float64, fill string) {
+	fmt.Fprintf(&c.buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, x, y, w, h, fill)
+}
+
+func (c *SVGCanvas) Line(x1, y1, x2, y2 float64, stroke string, width float64) {
+	fmt.Fprintf(&c.buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="%g"/>`, x1, y1, x2, y2, stroke, width)
+}
+
+func (c *SVGCanvas) Polyline(points []Point, stroke string, width float64) {
+	coords := make([]string, len(points))
+	for i, p := range points {
+		coords[i] = fmt.Sprintf("%.1f,%.1f", p.X, p.Y)
+	}
+	fmt.Fprintf(&c.buf, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%g" stroke-linejoin="round"/>`, strings.Join(coords, " "), stroke, width)
+}
+
+func (c *SVGCanvas) Circle(cx, cy, r float64, fill string) {
+	fmt.Fprintf(&c.buf, `<circle cx="%.1f" cy="%.1f" r="%g" fill="%s"/>`, cx, cy, r, fill)
+}
+
+func (c *SVGCanvas) Text(x, y float64, s string, size float64, anchor, color string) {
+	fmt.Fprintf(&c.buf, `<text x="%.1f" y="%.1f" font-size="%g" text-anchor="%s" fill="%s">%s</text>`, x, y, size, anchor, color, html.EscapeString(s))
+}
+
+// Bytes closes the document and returns it
+func (c *SVGCanvas) Bytes() []byte {
+	return append(c.buf.Bytes(), "</svg>"...)
+}
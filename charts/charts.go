@@ -0,0 +1,242 @@
+// Package charts renders availability charts on the server so they can be embedded in
+// pages, PDF exports and emails without client-side JavaScript.
+package charts
+
+import (
+	"fmt"
+	"math"
+)
+
+// Colours shared with the web UI
+const (
+	ColorGood  = "#16a34a"
+	ColorWarn  = "#d97706"
+	ColorBad   = "#dc2626"
+	ColorEmpty = "#e2e8f0"
+	ColorTrack = "#f1f5f9"
+	ColorText  = "#334155"
+	ColorMuted = "#94a3b8"
+	ColorLine  = "#1e40af"
+)
+
+// Missing marks a heatmap cell without data
+var Missing = math.NaN()
+
+// Chart is anything that can be drawn on a Canvas
+type Chart interface {
+	Size() (w, h float64)
+	Draw(c Canvas)
+}
+
+// SVG renders a chart as a standalone SVG document
+func SVG(ch Chart) []byte {
+	c := NewSVGCanvas(ch.Size())
+	ch.Draw(c)
+	return c.Bytes()
+}
+
+// AvailabilityColor maps an online percentage to green (>= 80), amber (>= 50) or red
+func AvailabilityColor(pct float64) string {
+	switch {
+	case math.IsNaN(pct):
+		return ColorEmpty
+	case pct >= 80:
+		return ColorGood
+	case pct >= 50:
+		return ColorWarn
+	default:
+		return ColorBad
+	}
+}
+
+const (
+	titleSize = 14
+	labelSize = 11
+	padding   = 12
+	titleH    = 28
+)
+
+func drawTitle(c Canvas, title string) {
+	if title != "" {
+		c.Text(padding, padding+titleSize, title, titleSize, "start", ColorText)
+	}
+}
+
+// truncate shortens s to roughly fit width at the given font size
+func truncate(s string, width, size float64) string {
+	n := int(width / (size * 0.55))
+	r := []rune(s)
+	if len(r) <= n || n < 2 {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// Bar is one bar of a BarChart
+type Bar struct {
+	Label string
+	Value float64 // percentage 0-100
+}
+
+// BarChart is a horizontal percentage bar chart, one row per bar
+type BarChart struct {
+	Title  string
+	Bars   []Bar
+	Width  float64
+	LabelW float64
+}
+
+const barRowH = 22
+
+func (b BarChart) Size() (float64, float64) {
+	return b.Width, titleH + padding*2 + float64(max(len(b.Bars), 1))*barRowH
+}
+
+func (b BarChart) Draw(c Canvas) {
+	drawTitle(c, b.Title)
+	labelW := b.LabelW
+	if labelW == 0 {
+		labelW = 160
+	}
+	const valueW = 56
+	trackW := b.Width - padding*2 - labelW - valueW
+	y := float64(titleH + padding)
+
+	if len(b.Bars) == 0 {
+		c.Text(padding, y+barRowH/2+4, "Tidak ada data", labelSize, "start", ColorMuted)
+		return
+	}
+	for _, bar := range b.Bars {
+		c.Text(padding, y+barRowH/2+4, truncate(bar.Label, labelW-8, labelSize), labelSize, "start", ColorText)
+		x := padding + labelW
+		c.Rect(x, y+4, trackW, barRowH-8, ColorTrack)
+		if w := trackW * clamp(bar.Value) / 100; w > 0 {
+			c.Rect(x, y+4, w, barRowH-8, AvailabilityColor(bar.Value))
+		}
+		c.Text(b.Width-padding, y+barRowH/2+4, fmt.Sprintf("%.1f%%", bar.Value), labelSize, "end", ColorText)
+		y += barRowH
+	}
+}
+
+// LineChart plots a percentage trend over labelled periods
+type LineChart struct {
+	Title  string
+	Labels []string
+	Values []float64 // percentages 0-100
+	Width  float64
+	Height float64
+}
+
+func (l LineChart) Size() (float64, float64) {
+	h := l.Height
+	if h == 0 {
+		h = 260
+	}
+	return l.Width, h
+}
+
+func (l LineChart) Draw(c Canvas) {
+	drawTitle(c, l.Title)
+	w, h := l.Size()
+	const axisW, axisH = 40, 28
+	left, top := float64(padding+axisW), float64(titleH+padding)
+	plotW, plotH := w-left-padding, h-top-padding-axisH
+
+	// Grid at 0, 25, 50, 75, 100 percent
+	for pct := 0.0; pct <= 100; pct += 25 {
+		y := top + plotH*(1-pct/100)
+		c.Line(left, y, left+plotW, y, ColorEmpty, 1)
+		c.Text(left-6, y+4, fmt.Sprintf("%.0f%%", pct), labelSize-1, "end", ColorMuted)
+	}
+
+	if len(l.Values) == 0 {
+		c.Text(left+plotW/2, top+plotH/2, "Tidak ada data", labelSize, "middle", ColorMuted)
+		return
+	}
+
+	step := plotW
+	if len(l.Values) > 1 {
+		step = plotW / float64(len(l.Values)-1)
+	}
+	points := make([]Point, len(l.Values))
+	for i, v := range l.Values {
+		x := left + float64(i)*step
+		if len(l.Values) == 1 {
+			x = left + plotW/2
+		}
+		points[i] = Point{X: x, Y: top + plotH*(1-clamp(v)/100)}
+	}
+	c.Polyline(points, ColorLine, 2)
+
+	// Skip labels when there are too many to fit
+	every := int(math.Ceil(float64(len(l.Labels)) * 70 / plotW))
+	for i, p := range points {
+		c.Circle(p.X, p.Y, 4, AvailabilityColor(l.Values[i]))
+		if i < len(l.Labels) && (every <= 1 || i%every == 0) {
+			c.Text(p.X, top+plotH+18, l.Labels[i], labelSize-1, "middle", ColorText)
+		}
+	}
+}
+
+// Heatmap shows a percentage per row (ship) and column (period); use Missing for no data
+type Heatmap struct {
+	Title  string
+	Rows   []string
+	Cols   []string
+	Values [][]float64
+	Width  float64
+	LabelW float64
+}
+
+const heatRowH = 20
+
+func (m Heatmap) Size() (float64, float64) {
+	return m.Width, titleH + padding*2 + 20 + float64(max(len(m.Rows), 1))*heatRowH
+}
+
+func (m Heatmap) Draw(c Canvas) {
+	drawTitle(c, m.Title)
+	labelW := m.LabelW
+	if labelW == 0 {
+		labelW = 160
+	}
+	top := float64(titleH + padding)
+
+	if len(m.Rows) == 0 || len(m.Cols) == 0 {
+		c.Text(padding, top+20, "Tidak ada data", labelSize, "start", ColorMuted)
+		return
+	}
+
+	cellW := (m.Width - padding*2 - labelW) / float64(len(m.Cols))
+	for j, col := range m.Cols {
+		x := padding + labelW + float64(j)*cellW + cellW/2
+		c.Text(x, top+12, truncate(col, cellW, labelSize-1), labelSize-1, "middle", ColorText)
+	}
+	top += 20
+
+	for i, row := range m.Rows {
+		y := top + float64(i)*heatRowH
+		c.Text(padding, y+heatRowH/2+4, truncate(row, labelW-8, labelSize), labelSize, "start", ColorText)
+		for j := range m.Cols {
+			v := Missing
+			if i < len(m.Values) && j < len(m.Values[i]) {
+				v = m.Values[i][j]
+			}
+			x := padding + labelW + float64(j)*cellW
+			c.Rect(x+1, y+1, cellW-2, heatRowH-2, AvailabilityColor(v))
+			if !math.IsNaN(v) && cellW >= 36 {
+				c.Text(x+cellW/2, y+heatRowH/2+4, fmt.Sprintf("%.0f%%", v), labelSize-1, "middle", "#ffffff")
+			}
+		}
+	}
+}
+
+func clamp(v float64) float64 {
+	switch {
+	case math.IsNaN(v) || v < 0:
+		return 0
+	case v > 100:
+		return 100
+	}
+	return v
+}
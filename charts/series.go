@@ -0,0 +1,91 @@
+package charts
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// SeriesChart plots a sensor value over [From, To): the average of each point as a line
+// over a bar from its minimum to its maximum
+type SeriesChart struct {
+	Title    string
+	From, To time.Time
+	Times    []time.Time
+	Avg      []float64
+	Min      []float64
+	Max      []float64
+	Width    float64
+	Height   float64
+}
+
+func (s SeriesChart) Size() (float64, float64) {
+	h := s.Height
+	if h == 0 {
+		h = 260
+	}
+	return s.Width, h
+}
+
+func (s SeriesChart) Draw(c Canvas) {
+	drawTitle(c, s.Title)
+	w, h := s.Size()
+	const axisW, axisH = 56, 28
+	left, top := float64(padding+axisW), float64(titleH+padding)
+	plotW, plotH := w-left-padding, h-top-padding-axisH
+
+	if len(s.Times) == 0 || !s.To.After(s.From) {
+		c.Line(left, top+plotH, left+plotW, top+plotH, ColorEmpty, 1)
+		c.Text(left+plotW/2, top+plotH/2, "Tidak ada data", labelSize, "middle", ColorMuted)
+		return
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i := range s.Times {
+		lo, hi = math.Min(lo, s.Min[i]), math.Max(hi, s.Max[i])
+	}
+	if hi-lo < 1e-9 {
+		lo, hi = lo-1, hi+1
+	}
+	y := func(v float64) float64 { return top + plotH*(1-(v-lo)/(hi-lo)) }
+	span := s.To.Sub(s.From)
+	x := func(t time.Time) float64 { return left + plotW*float64(t.Sub(s.From))/float64(span) }
+
+	// Five horizontal grid lines with values, six time labels
+	for i := 0; i <= 4; i++ {
+		v := lo + (hi-lo)*float64(i)/4
+		c.Line(left, y(v), left+plotW, y(v), ColorEmpty, 1)
+		c.Text(left-6, y(v)+4, fmt.Sprintf("%.4g", v), labelSize-1, "end", ColorMuted)
+	}
+	layout := "Jan 06"
+	switch {
+	case span <= 48*time.Hour:
+		layout = "15:04"
+	case span <= 62*24*time.Hour:
+		layout = "02 Jan"
+	}
+	for i := 0; i <= 5; i++ {
+		t := s.From.Add(span * time.Duration(i) / 5)
+		anchor := "middle"
+		switch i {
+		case 0:
+			anchor = "start"
+		case 5:
+			anchor = "end"
+		}
+		c.Text(x(t), top+plotH+18, t.Format(layout), labelSize-1, anchor, ColorText)
+	}
+
+	points := make([]Point, len(s.Times))
+	for i, t := range s.Times {
+		points[i] = Point{X: x(t), Y: y(s.Avg[i])}
+		if s.Max[i] > s.Min[i] {
+			c.Line(points[i].X, y(s.Min[i]), points[i].X, y(s.Max[i]), ColorMuted, 1)
+		}
+	}
+	if len(points) == 1 {
+		c.Circle(points[0].X, points[0].Y, 3, ColorLine)
+		return
+	}
+	c.Polyline(points, ColorLine, 1.5)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fms-app/backup"
+	"fms-app/config"
+	"fms-app/db"
+)
+
+const usage = `usage:
+  fms-app                 start the web server
+  fms-app backup [dir]    write a backup archive to dir (default BACKUP_DIR or ./backups)
+  fms-app restore <file>  load a backup archive into an empty database
+  fms-app config export [file]     write the configuration as YAML (JSON for *.json, stdout if no file)
+  fms-app config diff <file>       show what applying a configuration file would change
+  fms-app config apply <file> [-y] show the diff, confirm and apply it`
+
+// runCommand runs a maintenance subcommand instead of the server and returns the exit code
+func runCommand(args []string) int {
+	ctx := context.Background()
+
+	switch args[0] {
+	case "backup":
+		dir := os.Getenv("BACKUP_DIR")
+		if len(args) > 1 {
+			dir = args[1]
+		}
+		if dir == "" {
+			dir = "backups"
+		}
+		path, h, err := backup.WriteFile(ctx, dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "backup failed:", err)
+			return 1
+		}
+		fmt.Println(path)
+		printCounts(h)
+		return 0
+
+	case "restore":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, usage)
+			return 2
+		}
+		h, err := backup.RestoreFile(ctx, args[1])
+		if errors.Is(err, backup.ErrNotEmpty) {
+			fmt.Fprintln(os.Stderr, "restore refused:", err)
+			fmt.Fprintln(os.Stderr, "restore only runs against a freshly migrated database")
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "restore failed, nothing was written:", err)
+			return 1
+		}
+		fmt.Printf("restored archive from %s (version %d)\n", h.CreatedAt.Format("2006-01-02 15:04:05"), h.Version)
+		printCounts(h)
+		return 0
+
+	case "config":
+		if len(args) > 1 {
+			return runConfig(ctx, args[1], args[2:])
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+func printCounts(h backup.Header) {
+	for _, t := range h.Tables {
+		fmt.Printf("  %-24s %d\n", t, h.Counts[t])
+	}
+}
+
+func runConfig(ctx context.Context, sub string, args []string) int {
+	switch sub {
+	case "export":
+		doc, err := config.Load(ctx, db.DB)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "export failed:", err)
+			return 1
+		}
+		format := "yaml"
+		if len(args) > 0 && strings.EqualFold(filepath.Ext(args[0]), ".json") {
+			format = "json"
+		}
+		out, err := config.Encode(doc, format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "export failed:", err)
+			return 1
+		}
+		if len(args) == 0 {
+			os.Stdout.Write(out)
+			return 0
+		}
+		if err := os.WriteFile(args[0], out, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, "export failed:", err)
+			return 1
+		}
+		fmt.Println(args[0])
+		return 0
+
+	case "diff", "apply":
+		if len(args) < 1 {
+			break
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		doc, err := config.Parse(data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid config:\n"+err.Error())
+			return 1
+		}
+		plan, err := config.PlanFor(ctx, doc)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "diff failed:", err)
+			return 1
+		}
+		fmt.Print(plan.String())
+		if sub == "diff" || plan.Empty() {
+			return 0
+		}
+		if !(len(args) > 1 && args[1] == "-y") && !confirm("apply these changes?") {
+			fmt.Println("aborted, nothing was changed")
+			return 1
+		}
+		// Apply re-reads the database inside its transaction, so concurrent edits made
+		// since the diff above are taken into account rather than overwritten blindly
+		applied, err := config.Apply(ctx, doc)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "apply failed, nothing was written:", err)
+			return 1
+		}
+		fmt.Printf("applied %d change(s)\n", len(applied.Changes))
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+func confirm(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
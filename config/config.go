@@ -0,0 +1,190 @@
+// Package config exports the FMS configuration (sensors, projects, ships and schedules)
+// as a declarative YAML/JSON document and applies such a document back idempotently.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"fms-app/mailer"
+	"fms-app/scheduler"
+	"fms-app/shipname"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the document format understood by Parse
+const Version = 1
+
+// Document is the desired configuration. Sensors and projects missing from it are
+// deactivated on apply; ships missing from it are left alone.
+type Document struct {
+	Version   int        `yaml:"version" json:"version"`
+	Sensors   []Sensor   `yaml:"sensors" json:"sensors"`
+	Projects  []Project  `yaml:"projects" json:"projects"`
+	Ships     []Ship     `yaml:"ships" json:"ships"`
+	Schedules []Schedule `yaml:"schedules,omitempty" json:"schedules,omitempty"`
+
+	// aliases maps the key of every ship alias to its master name; set by Load
+	aliases map[string]string
+}
+
+// Sensor is a global sensor; OfflineAfterHours is its draft derivation rule (0: none)
+type Sensor struct {
+	Code              string `yaml:"code" json:"code"`
+	Name              string `yaml:"name" json:"name"`
+	Active            *bool  `yaml:"active,omitempty" json:"active,omitempty"`
+	Order             int    `yaml:"order" json:"order"`
+	OfflineAfterHours int    `yaml:"offline_after_hours,omitempty" json:"offline_after_hours,omitempty"`
+}
+
+// Project lists its active notification recipients; the list is applied as a whole
+type Project struct {
+	Code       string   `yaml:"code" json:"code"`
+	Name       string   `yaml:"name" json:"name"`
+	Active     *bool    `yaml:"active,omitempty" json:"active,omitempty"`
+	Recipients []string `yaml:"recipients" json:"recipients"`
+}
+
+// Ship is identified by its name, matched like every other write: "TB. Celebes Sejati 1"
+// updates the registered TB CELEBES SEJATI 01, as does a name recorded as an alias.
+// Sensors holds the per-ship overrides (sensor code -> enabled); overrides and metadata
+// are applied as a whole.
+type Ship struct {
+	Name     string            `yaml:"name" json:"name"`
+	Code     string            `yaml:"code,omitempty" json:"code,omitempty"`
+	Metadata map[string]string `yaml:"metadata,omitempty" json:"metadata,omitempty"`
+	Sensors  map[string]bool   `yaml:"sensors,omitempty" json:"sensors,omitempty"`
+}
+
+// Schedule updates an existing scheduled task; tasks themselves are defined in code
+type Schedule struct {
+	Name    string `yaml:"name" json:"name"`
+	Cron    string `yaml:"cron" json:"cron"`
+	Enabled *bool  `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+}
+
+// enabled treats an omitted active/enabled flag as true
+func enabled(b *bool) bool {
+	return b == nil || *b
+}
+
+// Parse reads a YAML or JSON document (JSON is valid YAML) and validates it.
+// Unknown fields are rejected so typos do not silently drop settings.
+func Parse(data []byte) (Document, error) {
+	var doc Document
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil {
+		return doc, fmt.Errorf("parse config: %w", err)
+	}
+	return doc, Validate(doc)
+}
+
+// Encode writes doc as "json" or YAML (any other format)
+func Encode(doc Document, format string) ([]byte, error) {
+	if format == "json" {
+		out, err := json.MarshalIndent(doc, "", "  ")
+		return append(out, '\n'), err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	err := enc.Close()
+	return buf.Bytes(), err
+}
+
+// Validate checks a document for missing keys, duplicates and unknown references
+func Validate(doc Document) error {
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	if doc.Version != Version {
+		fail("version must be %d, got %d", Version, doc.Version)
+	}
+
+	sensors := map[string]bool{}
+	for i, s := range doc.Sensors {
+		switch {
+		case s.Code == "":
+			fail("sensors[%d]: code is required", i)
+		case sensors[s.Code]:
+			fail("sensors[%d]: duplicate code %q", i, s.Code)
+		}
+		if s.Name == "" {
+			fail("sensors[%d]: name is required", i)
+		}
+		if s.OfflineAfterHours < 0 {
+			fail("sensors[%d]: offline_after_hours must not be negative", i)
+		}
+		sensors[s.Code] = true
+	}
+
+	projects := map[string]bool{}
+	for i, p := range doc.Projects {
+		switch {
+		case p.Code == "":
+			fail("projects[%d]: code is required", i)
+		case projects[p.Code]:
+			fail("projects[%d]: duplicate code %q", i, p.Code)
+		}
+		if p.Name == "" {
+			fail("projects[%d]: name is required", i)
+		}
+		projects[p.Code] = true
+		seen := map[string]bool{}
+		for _, email := range p.Recipients {
+			addr, err := mailer.NormalizeAddress(email)
+			if err != nil {
+				fail("projects[%d]: invalid recipient %q", i, email)
+				continue
+			}
+			if seen[strings.ToLower(addr)] {
+				fail("projects[%d]: duplicate recipient %q", i, email)
+			}
+			seen[strings.ToLower(addr)] = true
+		}
+	}
+
+	names, codes := map[string]bool{}, map[string]bool{}
+	for i, s := range doc.Ships {
+		key := shipname.Key(s.Name)
+		switch {
+		case key == "":
+			fail("ships[%d]: name is required", i)
+		case names[key]:
+			fail("ships[%d]: duplicate name %q (same ship as an earlier entry)", i, s.Name)
+		}
+		names[key] = true
+		if s.Code != "" {
+			if codes[strings.ToLower(s.Code)] {
+				fail("ships[%d]: duplicate code %q", i, s.Code)
+			}
+			codes[strings.ToLower(s.Code)] = true
+		}
+		for code := range s.Sensors {
+			if !sensors[code] {
+				fail("ships[%d] (%s): unknown sensor %q", i, s.Name, code)
+			}
+		}
+	}
+
+	schedules := map[string]bool{}
+	for i, s := range doc.Schedules {
+		if s.Name == "" || schedules[s.Name] {
+			fail("schedules[%d]: missing or duplicate name %q", i, s.Name)
+		}
+		schedules[s.Name] = true
+		if _, err := scheduler.ParseCron(s.Cron); err != nil {
+			fail("schedules[%d] (%s): %v", i, s.Name, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
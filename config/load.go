@@ -0,0 +1,148 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// Querier is satisfied by *sql.DB and *sql.Tx
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Load reads the current configuration in the same shape as a document
+func Load(ctx context.Context, q Querier) (Document, error) {
+	doc := Document{Version: Version, Sensors: []Sensor{}, Projects: []Project{}, Ships: []Ship{}}
+
+	err := each(ctx, q, "SELECT code, name, is_active, display_order, COALESCE(offline_after_hours, 0) FROM fms_sensor_config ORDER BY display_order, code",
+		func(rows *sql.Rows) error {
+			var s Sensor
+			var active bool
+			if err := rows.Scan(&s.Code, &s.Name, &active, &s.Order, &s.OfflineAfterHours); err != nil {
+				return err
+			}
+			s.Active = &active
+			doc.Sensors = append(doc.Sensors, s)
+			return nil
+		})
+	if err != nil {
+		return doc, err
+	}
+
+	projectIndex := map[string]int{}
+	err = each(ctx, q, "SELECT code, name, is_active FROM fms_projects ORDER BY code", func(rows *sql.Rows) error {
+		p := Project{Recipients: []string{}}
+		var active bool
+		if err := rows.Scan(&p.Code, &p.Name, &active); err != nil {
+			return err
+		}
+		p.Active = &active
+		projectIndex[p.Code] = len(doc.Projects)
+		doc.Projects = append(doc.Projects, p)
+		return nil
+	})
+	if err != nil {
+		return doc, err
+	}
+	err = each(ctx, q, "SELECT project_code, email FROM fms_notification_recipients WHERE is_active = true ORDER BY email",
+		func(rows *sql.Rows) error {
+			var code, email string
+			if err := rows.Scan(&code, &email); err != nil {
+				return err
+			}
+			if i, ok := projectIndex[code]; ok {
+				doc.Projects[i].Recipients = append(doc.Projects[i].Recipients, email)
+			}
+			return nil
+		})
+	if err != nil {
+		return doc, err
+	}
+
+	shipIndex := map[int]int{}
+	err = each(ctx, q, "SELECT id, name, COALESCE(code, ''), COALESCE(metadata, '{}') FROM fms_ships ORDER BY name",
+		func(rows *sql.Rows) error {
+			var id int
+			var s Ship
+			var raw []byte
+			if err := rows.Scan(&id, &s.Name, &s.Code, &raw); err != nil {
+				return err
+			}
+			var meta map[string]any
+			if err := json.Unmarshal(raw, &meta); err == nil && len(meta) > 0 {
+				s.Metadata = map[string]string{}
+				for k, v := range meta {
+					if v != nil {
+						s.Metadata[k] = fmt.Sprint(v)
+					}
+				}
+			}
+			shipIndex[id] = len(doc.Ships)
+			doc.Ships = append(doc.Ships, s)
+			return nil
+		})
+	if err != nil {
+		return doc, err
+	}
+	err = each(ctx, q, "SELECT ship_id, sensor_code, is_active FROM fms_ship_sensors ORDER BY sensor_code",
+		func(rows *sql.Rows) error {
+			var id int
+			var code string
+			var active bool
+			if err := rows.Scan(&id, &code, &active); err != nil {
+				return err
+			}
+			if i, ok := shipIndex[id]; ok {
+				if doc.Ships[i].Sensors == nil {
+					doc.Ships[i].Sensors = map[string]bool{}
+				}
+				doc.Ships[i].Sensors[code] = active
+			}
+			return nil
+		})
+	if err != nil {
+		return doc, err
+	}
+
+	doc.aliases = map[string]string{}
+	err = each(ctx, q, "SELECT a.alias_key, s.name FROM fms_ship_aliases a JOIN fms_ships s ON s.id = a.ship_id",
+		func(rows *sql.Rows) error {
+			var key, name string
+			if err := rows.Scan(&key, &name); err != nil {
+				return err
+			}
+			doc.aliases[key] = name
+			return nil
+		})
+	if err != nil {
+		return doc, err
+	}
+
+	err = each(ctx, q, "SELECT name, cron, is_enabled FROM fms_schedules ORDER BY name", func(rows *sql.Rows) error {
+		var s Schedule
+		var on bool
+		if err := rows.Scan(&s.Name, &s.Cron, &on); err != nil {
+			return err
+		}
+		s.Enabled = &on
+		doc.Schedules = append(doc.Schedules, s)
+		return nil
+	})
+	return doc, err
+}
+
+func each(ctx context.Context, q Querier, query string, fn func(*sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,450 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/mailer"
+	"fms-app/scheduler"
+	"fms-app/shipname"
+)
+
+// Change is one entity the apply would create, update or deactivate
+type Change struct {
+	Section string // "sensor", "project", "ship" or "schedule"
+	Key     string
+	Action  string // "create", "update" or "deactivate"
+	Details []string
+	apply   func(ctx context.Context, tx *sql.Tx) error
+}
+
+// Plan is the difference between the database and a document
+type Plan struct {
+	Changes  []Change
+	Warnings []string
+}
+
+// Empty reports whether applying the document would change nothing
+func (p Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// String renders the plan as a readable diff
+func (p Plan) String() string {
+	var b strings.Builder
+	for _, c := range p.Changes {
+		sign := "~"
+		switch c.Action {
+		case "create":
+			sign = "+"
+		case "deactivate":
+			sign = "-"
+		}
+		fmt.Fprintf(&b, "%s %s %s (%s)\n", sign, c.Section, c.Key, c.Action)
+		for _, d := range c.Details {
+			fmt.Fprintf(&b, "    %s\n", d)
+		}
+	}
+	for _, w := range p.Warnings {
+		fmt.Fprintf(&b, "! %s\n", w)
+	}
+	if p.Empty() {
+		b.WriteString("no changes\n")
+	}
+	return b.String()
+}
+
+// Diff compares the current configuration with the desired document
+func Diff(current, desired Document) Plan {
+	var p Plan
+	diffSensors(&p, current.Sensors, desired.Sensors)
+	diffProjects(&p, current.Projects, desired.Projects)
+	diffShips(&p, current.Ships, current.aliases, desired.Ships)
+	diffSchedules(&p, current.Schedules, desired.Schedules)
+	return p
+}
+
+// Apply validates doc, diffs it against the database and applies the changes in one
+// transaction. Applying the same document again yields an empty plan.
+func Apply(ctx context.Context, doc Document) (Plan, error) {
+	if err := Validate(doc); err != nil {
+		return Plan{}, err
+	}
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer tx.Rollback()
+
+	current, err := Load(ctx, tx)
+	if err != nil {
+		return Plan{}, err
+	}
+	p := Diff(current, doc)
+	for _, c := range p.Changes {
+		if err := c.apply(ctx, tx); err != nil {
+			return p, fmt.Errorf("%s %s: %w", c.Section, c.Key, err)
+		}
+	}
+	return p, tx.Commit()
+}
+
+// PlanFor diffs doc against the database without changing anything
+func PlanFor(ctx context.Context, doc Document) (Plan, error) {
+	if err := Validate(doc); err != nil {
+		return Plan{}, err
+	}
+	current, err := Load(ctx, db.DB)
+	if err != nil {
+		return Plan{}, err
+	}
+	return Diff(current, doc), nil
+}
+
+func diffSensors(p *Plan, current, desired []Sensor) {
+	existing := map[string]Sensor{}
+	for _, s := range current {
+		existing[s.Code] = s
+	}
+	listed := map[string]bool{}
+	for _, s := range desired {
+		listed[s.Code] = true
+		active := enabled(s.Active)
+		old, ok := existing[s.Code]
+		if !ok {
+			p.Changes = append(p.Changes, Change{
+				Section: "sensor", Key: s.Code, Action: "create",
+				Details: []string{fmt.Sprintf("name: %s, order: %d, active: %t", s.Name, s.Order, active)},
+				apply: func(ctx context.Context, tx *sql.Tx) error {
+					_, err := tx.ExecContext(ctx,
+						"INSERT INTO fms_sensor_config (code, name, is_active, display_order, offline_after_hours) VALUES ($1, $2, $3, $4, NULLIF($5, 0))",
+						s.Code, s.Name, active, s.Order, s.OfflineAfterHours)
+					return err
+				},
+			})
+			continue
+		}
+		var details []string
+		details = field(details, "name", old.Name, s.Name)
+		details = field(details, "order", old.Order, s.Order)
+		details = field(details, "active", enabled(old.Active), active)
+		details = field(details, "offline_after_hours", old.OfflineAfterHours, s.OfflineAfterHours)
+		if len(details) == 0 {
+			continue
+		}
+		p.Changes = append(p.Changes, Change{
+			Section: "sensor", Key: s.Code, Action: "update", Details: details,
+			apply: func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					"UPDATE fms_sensor_config SET name = $2, is_active = $3, display_order = $4, offline_after_hours = NULLIF($5, 0) WHERE code = $1",
+					s.Code, s.Name, active, s.Order, s.OfflineAfterHours)
+				return err
+			},
+		})
+	}
+
+	// Sensors are referenced by reports, so unlisted ones are only deactivated
+	for _, s := range current {
+		code := s.Code
+		if listed[code] || !enabled(s.Active) {
+			continue
+		}
+		p.Changes = append(p.Changes, Change{
+			Section: "sensor", Key: code, Action: "deactivate",
+			Details: []string{"not in file"},
+			apply: func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "UPDATE fms_sensor_config SET is_active = false WHERE code = $1", code)
+				return err
+			},
+		})
+	}
+}
+
+func diffProjects(p *Plan, current, desired []Project) {
+	existing := map[string]Project{}
+	for _, pr := range current {
+		existing[pr.Code] = pr
+	}
+	listed := map[string]bool{}
+	for _, pr := range desired {
+		listed[pr.Code] = true
+		pr.Recipients = normalizeRecipients(pr.Recipients)
+		active := enabled(pr.Active)
+		old, ok := existing[pr.Code]
+
+		var details []string
+		action := "update"
+		if !ok {
+			action = "create"
+			details = append(details, fmt.Sprintf("name: %s, active: %t", pr.Name, active))
+		} else {
+			details = field(details, "name", old.Name, pr.Name)
+			details = field(details, "active", enabled(old.Active), active)
+		}
+		added, removed := setDiff(old.Recipients, pr.Recipients)
+		if len(added) > 0 || len(removed) > 0 {
+			details = append(details, "recipients: "+signed(added, removed))
+		}
+		if ok && len(details) == 0 {
+			continue
+		}
+
+		p.Changes = append(p.Changes, Change{
+			Section: "project", Key: pr.Code, Action: action, Details: details,
+			apply: func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `
+					INSERT INTO fms_projects (code, name, is_active) VALUES ($1, $2, $3)
+					ON CONFLICT (code) DO UPDATE SET name = EXCLUDED.name, is_active = EXCLUDED.is_active`,
+					pr.Code, pr.Name, active)
+				if err != nil {
+					return err
+				}
+				for _, email := range added {
+					_, err := tx.ExecContext(ctx, `
+						INSERT INTO fms_notification_recipients (project_code, email, is_active) VALUES ($1, $2, true)
+						ON CONFLICT (project_code, email) DO UPDATE SET is_active = true`, pr.Code, email)
+					if err != nil {
+						return err
+					}
+				}
+				for _, email := range removed {
+					_, err := tx.ExecContext(ctx,
+						"UPDATE fms_notification_recipients SET is_active = false WHERE project_code = $1 AND email = $2",
+						pr.Code, email)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		})
+	}
+
+	for _, pr := range current {
+		code := pr.Code
+		if listed[code] || !enabled(pr.Active) {
+			continue
+		}
+		p.Changes = append(p.Changes, Change{
+			Section: "project", Key: code, Action: "deactivate",
+			Details: []string{"not in file"},
+			apply: func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "UPDATE fms_projects SET is_active = false WHERE code = $1", code)
+				return err
+			},
+		})
+	}
+}
+
+// diffShips matches ships by shipname.Key and the aliases (alias key -> master name), so a
+// variant spelling in the file updates the registered ship under its master name
+func diffShips(p *Plan, current []Ship, aliases map[string]string, desired []Ship) {
+	byName := map[string]Ship{}
+	for _, s := range current {
+		byName[s.Name] = s
+	}
+	existing := map[string]Ship{}
+	for key, name := range aliases {
+		if s, ok := byName[name]; ok {
+			existing[key] = s
+		}
+	}
+	// Master names win over aliases
+	for _, s := range current {
+		existing[shipname.Key(s.Name)] = s
+	}
+	listed := map[string]bool{}
+	for _, s := range desired {
+		s.Name = shipname.Clean(s.Name)
+		old, ok := existing[shipname.Key(s.Name)]
+		spelling := s.Name
+		if ok {
+			s.Name = old.Name
+		}
+		listed[s.Name] = true
+
+		var details []string
+		action := "update"
+		if !ok {
+			action = "create"
+			if s.Code != "" {
+				details = append(details, "code: "+s.Code)
+			}
+		} else {
+			details = field(details, "code", old.Code, s.Code)
+		}
+		details = append(details, mapDiff("metadata", old.Metadata, s.Metadata)...)
+		details = append(details, mapDiff("sensor", old.Sensors, s.Sensors)...)
+		if ok && len(details) == 0 {
+			continue
+		}
+		if spelling != s.Name {
+			details = append([]string{fmt.Sprintf("file spelling %q", spelling)}, details...)
+		}
+
+		p.Changes = append(p.Changes, Change{
+			Section: "ship", Key: s.Name, Action: action, Details: details,
+			apply: func(ctx context.Context, tx *sql.Tx) error {
+				meta, err := json.Marshal(s.Metadata)
+				if err != nil {
+					return err
+				}
+				if s.Metadata == nil {
+					meta = []byte("{}")
+				}
+				var id int
+				err = tx.QueryRowContext(ctx, `
+					INSERT INTO fms_ships (name, code, metadata) VALUES ($1, NULLIF($2, ''), $3)
+					ON CONFLICT (name) DO UPDATE SET code = EXCLUDED.code, metadata = EXCLUDED.metadata
+					RETURNING id`, s.Name, s.Code, meta).Scan(&id)
+				if err != nil {
+					return err
+				}
+				// Overrides are set as a whole
+				if _, err := tx.ExecContext(ctx, "DELETE FROM fms_ship_sensors WHERE ship_id = $1", id); err != nil {
+					return err
+				}
+				for code, active := range s.Sensors {
+					_, err := tx.ExecContext(ctx,
+						"INSERT INTO fms_ship_sensors (ship_id, sensor_code, is_active) VALUES ($1, $2, $3)", id, code, active)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		})
+	}
+
+	// Ships carry report history and are never removed by a config apply
+	for _, s := range current {
+		if !listed[s.Name] {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("ship %s is not in the file and was left unchanged", s.Name))
+		}
+	}
+}
+
+func diffSchedules(p *Plan, current, desired []Schedule) {
+	existing := map[string]Schedule{}
+	for _, s := range current {
+		existing[s.Name] = s
+	}
+	for _, s := range desired {
+		old, ok := existing[s.Name]
+		if !ok {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("schedule %s does not exist yet (start the app once) and was skipped", s.Name))
+			continue
+		}
+		on := enabled(s.Enabled)
+		var details []string
+		details = field(details, "cron", old.Cron, s.Cron)
+		details = field(details, "enabled", enabled(old.Enabled), on)
+		if len(details) == 0 {
+			continue
+		}
+		p.Changes = append(p.Changes, Change{
+			Section: "schedule", Key: s.Name, Action: "update", Details: details,
+			apply: func(ctx context.Context, tx *sql.Tx) error {
+				c, err := scheduler.ParseCron(s.Cron)
+				if err != nil {
+					return err
+				}
+				_, err = tx.ExecContext(ctx,
+					"UPDATE fms_schedules SET cron = $2, is_enabled = $3, next_run_at = $4 WHERE name = $1",
+					s.Name, s.Cron, on, c.Next(time.Now()))
+				return err
+			},
+		})
+	}
+}
+
+// field appends "name: old → new" when the values differ
+func field[T comparable](details []string, name string, from, to T) []string {
+	if from == to {
+		return details
+	}
+	return append(details, fmt.Sprintf("%s: %v → %v", name, from, to))
+}
+
+// mapDiff describes added, changed and removed keys, sorted by key
+func mapDiff[V comparable](label string, from, to map[string]V) []string {
+	keys := map[string]bool{}
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var out []string
+	for _, k := range sorted {
+		ov, inOld := from[k]
+		nv, inNew := to[k]
+		switch {
+		case inOld && !inNew:
+			out = append(out, fmt.Sprintf("%s %s: %v → (removed)", label, k, ov))
+		case !inOld && inNew:
+			out = append(out, fmt.Sprintf("%s %s: %v", label, k, nv))
+		case ov != nv:
+			out = append(out, fmt.Sprintf("%s %s: %v → %v", label, k, ov, nv))
+		}
+	}
+	return out
+}
+
+// setDiff returns the entries of to missing from from and of from missing from to
+// (case-insensitive)
+// normalizeRecipients stores recipients the way the settings page does, as bare addresses
+func normalizeRecipients(list []string) []string {
+	out := make([]string, 0, len(list))
+	for _, email := range list {
+		if addr, err := mailer.NormalizeAddress(email); err == nil {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+func setDiff(from, to []string) (added, removed []string) {
+	in := func(list []string, v string) bool {
+		for _, x := range list {
+			if strings.EqualFold(x, v) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range to {
+		if !in(from, v) {
+			added = append(added, v)
+		}
+	}
+	for _, v := range from {
+		if !in(to, v) {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+func signed(added, removed []string) string {
+	var parts []string
+	for _, v := range added {
+		parts = append(parts, "+"+v)
+	}
+	for _, v := range removed {
+		parts = append(parts, "-"+v)
+	}
+	return strings.Join(parts, ", ")
+}
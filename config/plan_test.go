@@ -0,0 +1,52 @@
+package config
+
+import "testing"
+
+func TestDiffShipsMatchesSpellings(t *testing.T) {
+	current := Document{
+		Ships: []Ship{
+			{Name: "TB CELEBES SEJATI 01", Code: "CS1"},
+			{Name: "KM NUSA INDAH", Code: "NI"},
+		},
+		aliases: map[string]string{"NUSA INDAH": "KM NUSA INDAH"},
+	}
+	desired := Document{
+		Ships: []Ship{
+			{Name: " tb. Celebes Sejati 1 ", Code: "CS1"}, // same ship, unchanged
+			{Name: "Nusa Indah", Code: "NI2"},             // alias, code changed
+			{Name: "mv  baru", Code: "MB"},                // new
+		},
+	}
+
+	p := Diff(current, desired)
+	if len(p.Changes) != 2 {
+		t.Fatalf("expected 2 changes, got:\n%s", p)
+	}
+	if c := p.Changes[0]; c.Key != "KM NUSA INDAH" || c.Action != "update" {
+		t.Errorf("alias spelling: got %s %s", c.Action, c.Key)
+	}
+	if c := p.Changes[1]; c.Key != "MV BARU" || c.Action != "create" {
+		t.Errorf("new ship: got %s %s", c.Action, c.Key)
+	}
+	if len(p.Warnings) != 0 {
+		t.Errorf("no ship is missing from the file, got warnings %v", p.Warnings)
+	}
+}
+
+func TestValidateDuplicateShipSpellings(t *testing.T) {
+	doc := Document{Version: Version, Ships: []Ship{{Name: "TB Celebes Sejati 1"}, {Name: "TB. CELEBES SEJATI 01"}}}
+	if err := Validate(doc); err == nil {
+		t.Error("two spellings of one ship must be rejected")
+	}
+}
+
+func TestValidateRecipients(t *testing.T) {
+	doc := Document{Version: Version, Projects: []Project{{Code: "FMS", Name: "Fleet", Recipients: []string{"ops@example.com\r\nBcc: x@evil.test"}}}}
+	if err := Validate(doc); err == nil {
+		t.Error("recipient with a line break accepted")
+	}
+	doc.Projects[0].Recipients = []string{"Ops <ops@example.com>", "OPS@example.com"}
+	if err := Validate(doc); err == nil {
+		t.Error("two spellings of one recipient accepted")
+	}
+}
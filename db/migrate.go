@@ -51,6 +51,15 @@ CREATE TABLE IF NOT EXISTS fms_ship_sensors (
     PRIMARY KEY (ship_id, sensor_code)
 );
 
+-- Alternative spellings of a ship name; alias_key is the normalized form used for matching
+CREATE TABLE IF NOT EXISTS fms_ship_aliases (
+    id SERIAL PRIMARY KEY,
+    ship_id INT NOT NULL REFERENCES fms_ships(id) ON DELETE CASCADE,
+    alias VARCHAR(255) NOT NULL,
+    alias_key VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 CREATE TABLE IF NOT EXISTS fms_projects (
     id SERIAL PRIMARY KEY,
     code VARCHAR(50) UNIQUE NOT NULL,
@@ -64,6 +73,227 @@ CREATE TABLE IF NOT EXISTS fms_app_config (
 );
 INSERT INTO fms_app_config (key, value) VALUES ('company_logo', '/static/images/logo-placeholder.png') ON CONFLICT DO NOTHING;
 
+-- Open/resolved offline sensor alerts per ship (one open alert per ship+sensor and rule,
+-- see idx_fms_alerts_open_rule below)
+CREATE TABLE IF NOT EXISTS fms_alerts (
+    id SERIAL PRIMARY KEY,
+    ship_name VARCHAR(255) NOT NULL,
+    sensor_code VARCHAR(50) NOT NULL,
+    report_id INT REFERENCES fms_device_reports(id) ON DELETE SET NULL,
+    occurrences INT DEFAULT 1,
+    escalation_level INT DEFAULT 0,
+    opened_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP,
+    resolution_note TEXT
+);
+
+CREATE TABLE IF NOT EXISTS fms_notification_recipients (
+    id SERIAL PRIMARY KEY,
+    project_code VARCHAR(50) NOT NULL REFERENCES fms_projects(code) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    is_active BOOLEAN DEFAULT TRUE,
+    UNIQUE (project_code, email)
+);
+
+CREATE TABLE IF NOT EXISTS fms_email_log (
+    id SERIAL PRIMARY KEY,
+    recipients TEXT NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Outbound webhook subscriptions; events is a comma-separated filter (e.g. "report.*,alert.resolved")
+CREATE TABLE IF NOT EXISTS fms_webhooks (
+    id SERIAL PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret VARCHAR(255),
+    events TEXT NOT NULL DEFAULT '*',
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS fms_webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    webhook_id INT NOT NULL REFERENCES fms_webhooks(id) ON DELETE CASCADE,
+    event VARCHAR(50) NOT NULL,
+    payload JSONB,
+    status VARCHAR(20) NOT NULL,
+    attempts INT DEFAULT 0,
+    response_code INT,
+    error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_fms_webhook_deliveries_created ON fms_webhook_deliveries(created_at);
+
+-- Transactional outbox: rows are written in the same transaction as the report
+-- change and published by the background dispatcher (at-least-once)
+CREATE TABLE IF NOT EXISTS fms_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    dedup_key VARCHAR(255) NOT NULL UNIQUE,
+    event VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INT DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    available_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    dispatched_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_fms_outbox_pending ON fms_outbox(available_at) WHERE dispatched_at IS NULL;
+
+-- Background job queue, claimed by workers with FOR UPDATE SKIP LOCKED
+CREATE TABLE IF NOT EXISTS fms_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    payload JSONB DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    attempts INT DEFAULT 0,
+    max_attempts INT DEFAULT 5,
+    run_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    locked_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_fms_jobs_due ON fms_jobs(run_at) WHERE status = 'queued';
+
+-- Recurring tasks (cron expressions editable in settings) and their run history
+CREATE TABLE IF NOT EXISTS fms_schedules (
+    name VARCHAR(100) PRIMARY KEY,
+    cron VARCHAR(100) NOT NULL,
+    description TEXT,
+    is_enabled BOOLEAN DEFAULT TRUE,
+    next_run_at TIMESTAMP NOT NULL,
+    last_run_at TIMESTAMP,
+    last_status VARCHAR(20)
+);
+
+CREATE TABLE IF NOT EXISTS fms_schedule_runs (
+    id BIGSERIAL PRIMARY KEY,
+    schedule_name VARCHAR(100) NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP,
+    duration_ms BIGINT,
+    status VARCHAR(20) NOT NULL,
+    output TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_fms_schedule_runs_started ON fms_schedule_runs(started_at);
+
+-- Snapshot of each project's figures taken when a period is rolled over
+CREATE TABLE IF NOT EXISTS fms_period_summaries (
+    project_code VARCHAR(50) NOT NULL,
+    period DATE NOT NULL,
+    total_ships INT NOT NULL,
+    reported_ships INT NOT NULL,
+    total_online INT NOT NULL,
+    total_offline INT NOT NULL,
+    closed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_code, period)
+);
+
+CREATE TABLE IF NOT EXISTS fms_audit_log (
+    id SERIAL PRIMARY KEY,
+    event VARCHAR(50) NOT NULL,
+    payload JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS fms_import_uploads (
+    token VARCHAR(32) PRIMARY KEY,
+    kind VARCHAR(30) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content BYTEA NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Credentials of onboard gateways posting to the ingestion endpoint. Only the SHA-256
+-- of a token is stored; ship_id NULL lets the gateway report for any ship.
+CREATE TABLE IF NOT EXISTS fms_device_tokens (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    ship_id INT REFERENCES fms_ships(id) ON DELETE CASCADE,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+-- Latest sample received from a device for each ship sensor
+CREATE TABLE IF NOT EXISTS fms_sensor_last_seen (
+    ship_id INT NOT NULL REFERENCES fms_ships(id) ON DELETE CASCADE,
+    sensor_code VARCHAR(50) NOT NULL,
+    seen_at TIMESTAMP NOT NULL,
+    online BOOLEAN NOT NULL,
+    value DOUBLE PRECISION,
+    PRIMARY KEY (ship_id, sensor_code)
+);
+
+-- Period reports derived from device last-seen data, waiting for an operator. sensors maps
+-- a sensor code to {"online", "reason", "last_seen_at"}; status is pending, confirmed or dismissed.
+CREATE TABLE IF NOT EXISTS fms_report_drafts (
+    id SERIAL PRIMARY KEY,
+    ship_id INT NOT NULL REFERENCES fms_ships(id) ON DELETE CASCADE,
+    period DATE NOT NULL,
+    sensors JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    derived_at TIMESTAMP NOT NULL,
+    decided_at TIMESTAMP,
+    report_id INT REFERENCES fms_device_reports(id) ON DELETE SET NULL,
+    UNIQUE (ship_id, period)
+);
+
+-- Raw sensor values from gateways, one partition per month (fms_sensor_readings_YYYY_MM,
+-- created and dropped by the readings package)
+CREATE TABLE IF NOT EXISTS fms_sensor_readings (
+    ship_id INT NOT NULL REFERENCES fms_ships(id) ON DELETE CASCADE,
+    sensor_code VARCHAR(50) NOT NULL,
+    ts TIMESTAMP NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (ship_id, sensor_code, ts)
+) PARTITION BY RANGE (ts);
+
+-- Hourly and daily aggregates of fms_sensor_readings, kept longer than the raw values
+CREATE TABLE IF NOT EXISTS fms_sensor_rollups (
+    resolution VARCHAR(10) NOT NULL,
+    ship_id INT NOT NULL REFERENCES fms_ships(id) ON DELETE CASCADE,
+    sensor_code VARCHAR(50) NOT NULL,
+    bucket TIMESTAMP NOT NULL,
+    samples INT NOT NULL,
+    total DOUBLE PRECISION NOT NULL,
+    min_value DOUBLE PRECISION NOT NULL,
+    max_value DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (resolution, ship_id, sensor_code, bucket)
+);
+
+-- Gateway log files uploaded from USB, one row per upload; the readings go to
+-- fms_sensor_readings and the proposed statuses to fms_report_drafts
+CREATE TABLE IF NOT EXISTS fms_gateway_logs (
+    id SERIAL PRIMARY KEY,
+    ship_id INT NOT NULL REFERENCES fms_ships(id) ON DELETE CASCADE,
+    format VARCHAR(50) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    covered_from TIMESTAMP NOT NULL,
+    covered_to TIMESTAMP NOT NULL,
+    samples INT NOT NULL,
+    readings INT NOT NULL,
+    ignored_sensors TEXT NOT NULL DEFAULT '',
+    uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Status rules defined in settings; expression is parsed by the rules package and a hit
+-- opens an alert on sensor_code with the rule's id
+CREATE TABLE IF NOT EXISTS fms_rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    sensor_code VARCHAR(50) NOT NULL,
+    expression TEXT NOT NULL,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
 
 CREATE INDEX IF NOT EXISTS idx_fms_device_reports_code ON fms_device_reports(code);
 CREATE INDEX IF NOT EXISTS idx_fms_device_reports_date ON fms_device_reports(report_date);
@@ -73,11 +303,12 @@ CREATE INDEX IF NOT EXISTS idx_fms_device_reports_ship ON fms_device_reports(shi
 		return err
 	}
 
-	// Migrate existing ships to master table
+	// Migrate existing ships to master table, skipping spellings recorded as an alias
 	_, _ = DB.Exec(`
         INSERT INTO fms_ships (name) 
-        SELECT DISTINCT ship_name FROM fms_device_reports 
+        SELECT DISTINCT ship_name FROM fms_device_reports r
         WHERE ship_name IS NOT NULL AND ship_name != ''
+          AND NOT EXISTS (SELECT 1 FROM fms_ship_aliases a WHERE a.alias = r.ship_name)
         ON CONFLICT (name) DO NOTHING;
     `)
 
@@ -105,6 +336,56 @@ CREATE INDEX IF NOT EXISTS idx_fms_device_reports_ship ON fms_device_reports(shi
 	// Ensure sensors_data column exists if migrating existing DB
 	_, _ = DB.Exec(`ALTER TABLE fms_device_reports ADD COLUMN IF NOT EXISTS sensors_data JSONB DEFAULT '{}';`)
 
+	// Free-form ship attributes (IMO, type, owner, ...) filled by the ship master import
+	_, _ = DB.Exec(`ALTER TABLE fms_ships ADD COLUMN IF NOT EXISTS metadata JSONB DEFAULT '{}';`)
+
+	// Webhook deliveries are deduplicated per subscription by the outbox key
+	_, _ = DB.Exec(`ALTER TABLE fms_webhook_deliveries ADD COLUMN IF NOT EXISTS dedup_key VARCHAR(255);`)
+	_, _ = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_fms_webhook_deliveries_dedup ON fms_webhook_deliveries(webhook_id, dedup_key);`)
+
+	// Lease of the instance sending a delivery, so a restarting instance does not resend it
+	_, _ = DB.Exec(`ALTER TABLE fms_webhook_deliveries ADD COLUMN IF NOT EXISTS locked_at TIMESTAMP;`)
+
+	// Who wrote the latest version of a report: "manual" (forms, imports, API) or "device"
+	_, _ = DB.Exec(`ALTER TABLE fms_device_reports ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'manual';`)
+
+	// Hours without a device sample after which a sensor is derived offline (NULL: no rule)
+	_, _ = DB.Exec(`ALTER TABLE fms_sensor_config ADD COLUMN IF NOT EXISTS offline_after_hours INT;`)
+
+	// Where a draft came from: "device" (derived from fms_sensor_last_seen) or "log" (an uploaded gateway log)
+	_, _ = DB.Exec(`ALTER TABLE fms_report_drafts ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'device';`)
+
+	// Alerts opened by a status rule carry its id and the values that made it hold. Offline
+	// alerts (rule_id NULL) and each rule keep their own open alert per ship and sensor.
+	_, _ = DB.Exec(`ALTER TABLE fms_alerts ADD COLUMN IF NOT EXISTS rule_id INT REFERENCES fms_rules(id);`)
+	_, _ = DB.Exec(`ALTER TABLE fms_alerts ADD COLUMN IF NOT EXISTS rule_detail TEXT;`)
+	// Every report insert upserts against this index, so it must exist: duplicate open alerts
+	// are closed first (the newest stays open) and the old index goes only once it is in place
+	if _, err := DB.Exec(`
+        UPDATE fms_alerts a
+        SET resolved_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP,
+            resolution_note = COALESCE(a.resolution_note, 'Duplikat, ditutup saat migrasi')
+        WHERE a.resolved_at IS NULL AND EXISTS (
+            SELECT 1 FROM fms_alerts b
+            WHERE b.resolved_at IS NULL AND b.id > a.id
+              AND b.ship_name = a.ship_name AND b.sensor_code = a.sensor_code
+              AND COALESCE(b.rule_id, 0) = COALESCE(a.rule_id, 0)
+        );`); err != nil {
+		return fmt.Errorf("close duplicate open alerts: %w", err)
+	}
+	if _, err := DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_fms_alerts_open_rule ON fms_alerts(ship_name, sensor_code, COALESCE(rule_id, 0)) WHERE resolved_at IS NULL;`); err != nil {
+		return fmt.Errorf("open alert index: %w", err)
+	}
+	if _, err := DB.Exec(`DROP INDEX IF EXISTS idx_fms_alerts_open;`); err != nil {
+		return fmt.Errorf("drop old open alert index: %w", err)
+	}
+
+	// What a finished job hands back: a message and an optional file to download
+	_, _ = DB.Exec(`ALTER TABLE fms_jobs ADD COLUMN IF NOT EXISTS result TEXT;`)
+	_, _ = DB.Exec(`ALTER TABLE fms_jobs ADD COLUMN IF NOT EXISTS result_name VARCHAR(255);`)
+	_, _ = DB.Exec(`ALTER TABLE fms_jobs ADD COLUMN IF NOT EXISTS result_type VARCHAR(100);`)
+	_, _ = DB.Exec(`ALTER TABLE fms_jobs ADD COLUMN IF NOT EXISTS result_data BYTEA;`)
+
 	// Optional seed sample rows
 	if os.Getenv("SEED_SAMPLE") == "true" {
 		_, _ = DB.Exec(`
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"log"
+	"reflect"
+	"runtime/debug"
+	"sync"
+)
+
+// Event is implemented by every domain event published on the bus
+type Event interface {
+	EventName() string
+}
+
+var (
+	mu       sync.RWMutex
+	handlers = make(map[reflect.Type][]func(Event))
+	catchAll []func(Event)
+)
+
+// Subscribe registers fn for every published event of type E.
+// Subscribers run synchronously in registration order; wrap slow ones with Async.
+func Subscribe[E Event](fn func(E)) {
+	t := reflect.TypeOf((*E)(nil)).Elem()
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[t] = append(handlers[t], func(e Event) { fn(e.(E)) })
+}
+
+// SubscribeAll registers fn for every event regardless of type
+func SubscribeAll(fn func(Event)) {
+	mu.Lock()
+	defer mu.Unlock()
+	catchAll = append(catchAll, fn)
+}
+
+// Async wraps a subscriber so it runs in its own goroutine
+func Async[E any](fn func(E)) func(E) {
+	return func(e E) {
+		go func() {
+			defer recoverSubscriber(e)
+			fn(e)
+		}()
+	}
+}
+
+// Publish delivers the event to its subscribers. A panicking subscriber is
+// logged and does not stop the others or the publishing handler.
+func Publish(e Event) {
+	mu.RLock()
+	subs := append([]func(Event){}, handlers[reflect.TypeOf(e)]...)
+	subs = append(subs, catchAll...)
+	mu.RUnlock()
+
+	for _, fn := range subs {
+		func() {
+			defer recoverSubscriber(e)
+			fn(e)
+		}()
+	}
+}
+
+func recoverSubscriber(e any) {
+	if r := recover(); r != nil {
+		log.Printf("events: subscriber for %T panicked: %v\n%s", e, r, debug.Stack())
+	}
+}
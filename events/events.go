@@ -0,0 +1,84 @@
+package events
+
+import "time"
+
+// Report is the report snapshot carried by report events
+type Report struct {
+	ID          int
+	Code        string
+	ProjectCode string
+	ReportDate  time.Time
+	ShipName    string
+	Sensors     map[string]bool
+}
+
+// AlertChange describes an alert that was opened or escalated by a report
+type AlertChange struct {
+	ID              int
+	ShipName        string
+	SensorCode      string
+	Occurrences     int
+	EscalationLevel int
+}
+
+// ReportCreated is published after a report is committed (single input, batch or import).
+// Imported is set for historical imports, which should not trigger notifications.
+type ReportCreated struct {
+	Report    Report
+	Opened    []AlertChange
+	Escalated []AlertChange
+	Imported  bool
+}
+
+// ReportUpdated is published after a report is edited
+type ReportUpdated struct {
+	Report    Report
+	Field     string // edited fields and sensor codes, comma separated
+	Opened    []AlertChange
+	Escalated []AlertChange
+}
+
+// SensorResolved is published when an offline sensor is marked fixed from the dashboard
+type SensorResolved struct {
+	ReportID   int
+	ShipName   string
+	SensorCode string
+	Note       string
+}
+
+// ShipCreated is published when a vessel is added to the master list
+type ShipCreated struct {
+	ID   int
+	Name string
+	Code string
+}
+
+// ShipMerged is published when duplicate ships are merged into ID; their reports,
+// alerts and overrides now belong to it
+type ShipMerged struct {
+	ID          int
+	Name        string
+	MergedNames []string
+}
+
+// SensorConfigChanged is published when a sensor is added or toggled globally
+// (ShipID 0) or overridden for a single ship
+type SensorConfigChanged struct {
+	Code     string
+	ShipID   int
+	IsActive bool
+}
+
+// AppConfigChanged is published when a fms_app_config value is written
+type AppConfigChanged struct {
+	Key   string
+	Value string
+}
+
+func (ReportCreated) EventName() string       { return "report.created" }
+func (ReportUpdated) EventName() string       { return "report.updated" }
+func (SensorResolved) EventName() string      { return "sensor.resolved" }
+func (ShipCreated) EventName() string         { return "ship.created" }
+func (ShipMerged) EventName() string          { return "ship.merged" }
+func (SensorConfigChanged) EventName() string { return "sensor_config.changed" }
+func (AppConfigChanged) EventName() string    { return "app_config.changed" }
@@ -0,0 +1,251 @@
+package gatewaylog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the timestamp forms found in gateway logs. Times without a zone are
+// taken as server local time.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+}
+
+func parseTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil && sec > 0 {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", v)
+}
+
+// parseStatus reads online/offline words; ok is false for anything else
+func parseStatus(v string) (online, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "online", "on", "ok", "1", "true":
+		return true, true
+	case "offline", "off", "err", "error", "fail", "0", "false", "nan":
+		return false, true
+	}
+	return false, false
+}
+
+// parseValue reads a number with a decimal point or comma; NaN is not a value
+func parseValue(v string) (*float64, bool) {
+	f, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(v), ",", ".", 1), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, false
+	}
+	return &f, true
+}
+
+// table is a CSV file: "# key: value" lines before the header become meta (lower-case
+// keys), the delimiter is detected from the header and keys of the header are lower-cased
+type table struct {
+	meta   map[string]string
+	header []string
+	rows   [][]string
+	lines  []int // file line of each row
+}
+
+func readTable(r io.Reader) (table, error) {
+	t := table{meta: map[string]string{}}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	var body bytes.Buffer
+	lineNo, first := 0, 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimPrefix(sc.Text(), "\ufeff")
+		if body.Len() == 0 {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "#") {
+				if k, v, ok := strings.Cut(strings.TrimPrefix(line, "#"), ":"); ok {
+					t.meta[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+				}
+				continue
+			}
+			first = lineNo
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return t, err
+	}
+	if body.Len() == 0 {
+		return t, fmt.Errorf("log has no header line")
+	}
+
+	cr := csv.NewReader(&body)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	if head, _, _ := bytes.Cut(body.Bytes(), []byte("\n")); bytes.Count(head, []byte(";")) > bytes.Count(head, []byte(",")) {
+		cr.Comma = ';'
+	}
+	header, err := cr.Read()
+	if err != nil {
+		return t, fmt.Errorf("line %d: %w", first, err)
+	}
+	for _, h := range header {
+		t.header = append(t.header, strings.ToLower(strings.TrimSpace(h)))
+	}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return t, err
+		}
+		line, _ := cr.FieldPos(0)
+		t.rows = append(t.rows, row)
+		t.lines = append(t.lines, first+line-1)
+	}
+	return t, nil
+}
+
+// column returns the index of the first header among names, or -1
+func (t table) column(names ...string) int {
+	for _, name := range names {
+		for i, h := range t.header {
+			if h == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func cell(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// ParseLong reads logs with one sensor per line:
+//
+//	# ship: SHP1
+//	timestamp,sensor,value,status
+//	2025-01-15 08:00:00,rpm_me_port,850,online
+//
+// value and status are optional columns but a line needs one of them; without a status a
+// line with a number is online and NaN or an error word in value is offline. A ship or ship_code column may replace the "# ship:" line.
+func ParseLong(r io.Reader) (Log, error) {
+	t, err := readTable(r)
+	if err != nil {
+		return Log{}, err
+	}
+	l := Log{ShipCode: t.meta["ship"]}
+	ts := t.column("timestamp", "time", "datetime")
+	sensor := t.column("sensor", "sensor_code", "channel")
+	value := t.column("value")
+	status := t.column("status", "state")
+	ship := t.column("ship", "ship_code")
+	switch {
+	case ts < 0:
+		return l, fmt.Errorf("missing timestamp column")
+	case sensor < 0:
+		return l, fmt.Errorf("missing sensor column")
+	case value < 0 && status < 0:
+		return l, fmt.Errorf("missing value or status column")
+	}
+
+	for i, row := range t.rows {
+		at, err := parseTime(cell(row, ts))
+		if err != nil {
+			return l, fmt.Errorf("line %d: %w", t.lines[i], err)
+		}
+		s := Sample{At: at, Sensor: strings.ToLower(cell(row, sensor))}
+		if s.Sensor == "" {
+			return l, fmt.Errorf("line %d: missing sensor", t.lines[i])
+		}
+		v := cell(row, value)
+		if v != "" {
+			var ok bool
+			if s.Value, ok = parseValue(v); ok {
+				s.Online = true
+			} else if s.Online, ok = parseStatus(v); !ok {
+				return l, fmt.Errorf("line %d: invalid value %q", t.lines[i], v)
+			}
+		}
+		if st := cell(row, status); st != "" {
+			var ok bool
+			if s.Online, ok = parseStatus(st); !ok {
+				return l, fmt.Errorf("line %d: invalid status %q", t.lines[i], st)
+			}
+		} else if v == "" {
+			return l, fmt.Errorf("line %d: needs a value or a status", t.lines[i])
+		}
+		if code := cell(row, ship); code != "" {
+			if l.ShipCode != "" && !strings.EqualFold(l.ShipCode, code) {
+				return l, fmt.Errorf("line %d: log mixes ships %s and %s", t.lines[i], l.ShipCode, code)
+			}
+			l.ShipCode = code
+		}
+		l.Samples = append(l.Samples, s)
+	}
+	return l, nil
+}
+
+// ParseWide reads logs with one column per sensor:
+//
+//	# ship: SHP1
+//	timestamp;gps;rpm_me_port;flowmeter_input
+//	15/01/2025 08:00:00;ok;850;12,5
+//
+// A number is an online value, online/offline words are a status and an empty cell means
+// the sensor was not logged on that line.
+func ParseWide(r io.Reader) (Log, error) {
+	t, err := readTable(r)
+	if err != nil {
+		return Log{}, err
+	}
+	l := Log{ShipCode: t.meta["ship"]}
+	ts := t.column("timestamp", "time", "datetime")
+	if ts < 0 {
+		return l, fmt.Errorf("missing timestamp column")
+	}
+
+	for i, row := range t.rows {
+		at, err := parseTime(cell(row, ts))
+		if err != nil {
+			return l, fmt.Errorf("line %d: %w", t.lines[i], err)
+		}
+		for col, sensor := range t.header {
+			v := cell(row, col)
+			if col == ts || sensor == "" || v == "" || v == "-" {
+				continue
+			}
+			s := Sample{At: at, Sensor: sensor}
+			if value, ok := parseValue(v); ok {
+				s.Value, s.Online = value, true
+			} else if s.Online, ok = parseStatus(v); !ok {
+				return l, fmt.Errorf("line %d: invalid %s value %q", t.lines[i], sensor, v)
+			}
+			l.Samples = append(l.Samples, s)
+		}
+	}
+	return l, nil
+}
@@ -0,0 +1,171 @@
+// Package gatewaylog reads the log files FMS gateways write to USB on vessels without
+// connectivity. Every vendor format is a Parser registered under a name; Summarize turns
+// the parsed samples into per-sensor availability for each month the log covers.
+package gatewaylog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sample is one sensor reading of a log; Value is nil when the line only has a status
+type Sample struct {
+	At     time.Time
+	Sensor string
+	Online bool
+	Value  *float64
+}
+
+// Log is a parsed file. ShipCode is set when the file names its ship.
+type Log struct {
+	ShipCode string
+	Samples  []Sample
+}
+
+// Parser reads one vendor format
+type Parser func(r io.Reader) (Log, error)
+
+// Format is a registered parser
+type Format struct {
+	Name  string
+	Label string
+	Parse Parser
+}
+
+var (
+	mu      sync.RWMutex
+	formats = map[string]Format{}
+)
+
+// Register makes a parser available under name. Call before serving uploads.
+func Register(name, label string, p Parser) {
+	mu.Lock()
+	defer mu.Unlock()
+	formats[name] = Format{Name: name, Label: label, Parse: p}
+}
+
+// Formats returns the registered formats ordered by name
+func Formats() []Format {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]Format, 0, len(formats))
+	for _, f := range formats {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Parse reads r with the parser registered under name. Samples are returned oldest first.
+func Parse(name string, r io.Reader) (Log, error) {
+	mu.RLock()
+	f, ok := formats[name]
+	mu.RUnlock()
+	if !ok {
+		return Log{}, fmt.Errorf("unknown log format %q", name)
+	}
+	l, err := f.Parse(r)
+	if err != nil {
+		return l, err
+	}
+	if len(l.Samples) == 0 {
+		return l, fmt.Errorf("log contains no samples")
+	}
+	sort.SliceStable(l.Samples, func(i, j int) bool { return l.Samples[i].At.Before(l.Samples[j].At) })
+	return l, nil
+}
+
+// Availability of one sensor in a Period: the hours with at least one online sample
+type Availability struct {
+	Sensor  string
+	Online  int // hours
+	Samples int
+	Last    time.Time
+}
+
+// Period is the part of a log that falls in one month. From is the hour of the first
+// sample and To the end of the hour of the last one, both clipped to the month.
+type Period struct {
+	Month   time.Time
+	From    time.Time
+	To      time.Time
+	Sensors []Availability // ordered by sensor code
+}
+
+// Hours is the number of hours the period covers
+func (p Period) Hours() int {
+	return int(p.To.Sub(p.From) / time.Hour)
+}
+
+// Percent is the share of the period's hours a sensor was online
+func (p Period) Percent(a Availability) float64 {
+	if p.Hours() == 0 {
+		return 0
+	}
+	return float64(a.Online) / float64(p.Hours()) * 100
+}
+
+// Summarize splits samples (oldest first) into months of loc and computes the availability
+// of every sensor over the hours the whole log covers within each month
+func Summarize(samples []Sample, loc *time.Location) []Period {
+	if len(samples) == 0 {
+		return nil
+	}
+	first := hourOf(samples[0].At.In(loc))
+	last := hourOf(samples[len(samples)-1].At.In(loc)).Add(time.Hour)
+
+	var periods []Period
+	index := map[time.Time]int{}
+	for m := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, loc); m.Before(last); m = m.AddDate(0, 1, 0) {
+		p := Period{Month: m, From: m, To: m.AddDate(0, 1, 0)}
+		if first.After(p.From) {
+			p.From = first
+		}
+		if last.Before(p.To) {
+			p.To = last
+		}
+		index[m] = len(periods)
+		periods = append(periods, p)
+	}
+
+	type key struct {
+		month  time.Time
+		sensor string
+	}
+	stats := map[key]*Availability{}
+	hours := map[key]map[time.Time]bool{}
+	for _, s := range samples {
+		at := s.At.In(loc)
+		k := key{time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, loc), s.Sensor}
+		a := stats[k]
+		if a == nil {
+			a = &Availability{Sensor: s.Sensor}
+			stats[k] = a
+			hours[k] = map[time.Time]bool{}
+		}
+		a.Samples++
+		if at.After(a.Last) {
+			a.Last = at
+		}
+		if s.Online {
+			hours[k][hourOf(at)] = true
+		}
+	}
+	for k, a := range stats {
+		a.Online = len(hours[k])
+		p := &periods[index[k.month]]
+		p.Sensors = append(p.Sensors, *a)
+	}
+	for i := range periods {
+		sort.Slice(periods[i].Sensors, func(a, b int) bool { return periods[i].Sensors[a].Sensor < periods[i].Sensors[b].Sensor })
+	}
+	return periods
+}
+
+// hourOf is the wall-clock hour of t in its location
+func hourOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
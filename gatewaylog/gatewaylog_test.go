@@ -0,0 +1,88 @@
+package gatewaylog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLong(t *testing.T) {
+	l, err := ParseLong(strings.NewReader("\ufeff# ship: SHP1\n# gateway: GW-7\ntimestamp,sensor,value,status\n" +
+		"2025-01-15 08:00:00,RPM_ME_PORT,850,\n" +
+		"2025-01-15 08:00:00,gps,,online\n" +
+		"2025-01-15 09:00:00,flowmeter_input,NaN,\n" +
+		"2025-01-15 09:00:00,rpm_me_port,0,offline\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ShipCode != "SHP1" || len(l.Samples) != 4 {
+		t.Fatalf("got ship %q and %d samples", l.ShipCode, len(l.Samples))
+	}
+	s := l.Samples[0]
+	if s.Sensor != "rpm_me_port" || !s.Online || s.Value == nil || *s.Value != 850 {
+		t.Errorf("first sample: %+v", s)
+	}
+	if l.Samples[1].Value != nil || !l.Samples[1].Online {
+		t.Errorf("status-only sample: %+v", l.Samples[1])
+	}
+	if l.Samples[2].Online || l.Samples[3].Online {
+		t.Error("NaN and offline must be offline")
+	}
+
+	for _, bad := range []string{
+		"time,sensor\n2025-01-15 08:00:00,gps\n",
+		"timestamp,sensor,value\nyesterday,gps,1\n",
+		"timestamp,sensor,value\n2025-01-15 08:00:00,gps,fast\n",
+		"timestamp,sensor,status,ship\n2025-01-15 08:00:00,gps,on,A\n2025-01-15 09:00:00,gps,on,B\n",
+	} {
+		if _, err := ParseLong(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestParseWide(t *testing.T) {
+	l, err := ParseWide(strings.NewReader("timestamp;GPS;rpm_me_port;flowmeter_input\n" +
+		"15/01/2025 08:00:00;ok;850;12,5\n" +
+		"15/01/2025 08:01:00;;err;-\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Samples) != 4 {
+		t.Fatalf("got %d samples, want 4", len(l.Samples))
+	}
+	if s := l.Samples[2]; s.Sensor != "flowmeter_input" || s.Value == nil || *s.Value != 12.5 {
+		t.Errorf("decimal comma: %+v", s)
+	}
+	if s := l.Samples[3]; s.Sensor != "rpm_me_port" || s.Online {
+		t.Errorf("error word: %+v", s)
+	}
+	if _, err := ParseWide(strings.NewReader("timestamp,gps\n2025-01-15 08:00:00,maybe\n")); err == nil {
+		t.Error("expected an error for an unknown cell")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	at := func(day, hour, min int) time.Time { return time.Date(2025, 1, day, hour, min, 0, 0, time.UTC) }
+	var samples []Sample
+	// gps online every hour from 31 Jan 20:00 to 1 Feb 03:00, rpm offline after midnight
+	for h := at(31, 20, 0); !h.After(at(32, 3, 0)); h = h.Add(time.Hour) {
+		samples = append(samples, Sample{At: h.Add(10 * time.Minute), Sensor: "gps", Online: true})
+		samples = append(samples, Sample{At: h.Add(20 * time.Minute), Sensor: "rpm_me_port", Online: h.Day() == 31})
+	}
+
+	periods := Summarize(samples, time.UTC)
+	if len(periods) != 2 {
+		t.Fatalf("got %d periods, want 2", len(periods))
+	}
+	jan, feb := periods[0], periods[1]
+	if jan.Hours() != 4 || feb.Hours() != 4 {
+		t.Errorf("hours: jan %d, feb %d", jan.Hours(), feb.Hours())
+	}
+	if len(jan.Sensors) != 2 || jan.Sensors[0].Sensor != "gps" || jan.Percent(jan.Sensors[0]) != 100 {
+		t.Errorf("january: %+v", jan.Sensors)
+	}
+	if rpm := feb.Sensors[1]; rpm.Online != 0 || rpm.Samples != 4 || feb.Percent(rpm) != 0 {
+		t.Errorf("february rpm: %+v", rpm)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+
+	"fms-app/events"
+)
+
+// dbExecutor is satisfied by both *sql.DB and *sql.Tx so alert bookkeeping
+// can run inside the same transaction as the report write
+type dbExecutor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// alertEscalationThreshold is the number of consecutive offline reports per escalation level
+func alertEscalationThreshold() int {
+	n, err := strconv.Atoi(os.Getenv("ALERT_ESCALATION_THRESHOLD"))
+	if err != nil || n < 1 {
+		return 2
+	}
+	return n
+}
+
+// syncAlerts opens, bumps or resolves the offline alerts for every sensor status in a report;
+// alerts opened by status rules are left to the rule evaluation. Offline statuses of sensors
+// that are inactive globally or for the ship do not open alerts.
+// It returns the alerts that were newly opened and the ones that moved up an escalation level.
+func syncAlerts(q dbExecutor, shipName string, reportID int, sensors map[string]bool) (opened, escalated []events.AlertChange, err error) {
+	threshold := alertEscalationThreshold()
+	inactive, err := inactiveShipSensors(q, shipName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for code, online := range sensors {
+		if !online && inactive[code] {
+			continue
+		}
+		if online {
+			_, err = q.Exec(`
+				UPDATE fms_alerts
+				SET resolved_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP,
+				    resolution_note = COALESCE(resolution_note, 'Online pada laporan berikutnya')
+				WHERE ship_name = $1 AND sensor_code = $2 AND rule_id IS NULL AND resolved_at IS NULL
+			`, shipName, code)
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		a := events.AlertChange{ShipName: shipName, SensorCode: code}
+		var inserted bool
+		err = q.QueryRow(`
+			INSERT INTO fms_alerts (ship_name, sensor_code, report_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (ship_name, sensor_code, COALESCE(rule_id, 0)) WHERE resolved_at IS NULL
+			DO UPDATE SET
+				occurrences = fms_alerts.occurrences +
+					CASE WHEN fms_alerts.report_id IS NOT DISTINCT FROM EXCLUDED.report_id THEN 0 ELSE 1 END,
+				report_id = EXCLUDED.report_id,
+				updated_at = CURRENT_TIMESTAMP
+			RETURNING id, occurrences, escalation_level, (xmax = 0) AS inserted
+		`, shipName, code, reportID).Scan(&a.ID, &a.Occurrences, &a.EscalationLevel, &inserted)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if inserted {
+			opened = append(opened, a)
+		}
+
+		// Escalate one level every `threshold` consecutive offline reports
+		if level := a.Occurrences / threshold; level > a.EscalationLevel {
+			if _, err = q.Exec(`UPDATE fms_alerts SET escalation_level = $1 WHERE id = $2`, level, a.ID); err != nil {
+				return nil, nil, err
+			}
+			a.EscalationLevel = level
+			escalated = append(escalated, a)
+		}
+	}
+
+	return opened, escalated, nil
+}
+
+// inactiveShipSensors returns the configured sensors that are switched off for a ship,
+// by its own override or globally
+func inactiveShipSensors(q dbExecutor, shipName string) (map[string]bool, error) {
+	rows, err := q.Query(`
+		SELECT g.code
+		FROM fms_sensor_config g
+		LEFT JOIN fms_ship_sensors s ON s.sensor_code = g.code
+			AND s.ship_id = (SELECT id FROM fms_ships WHERE name = $1)
+		WHERE NOT COALESCE(s.is_active, g.is_active)
+	`, shipName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	inactive := map[string]bool{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		inactive[code] = true
+	}
+	return inactive, rows.Err()
+}
+
+// resolveOpenAlert closes the open offline alert for a ship sensor with an optional note
+// and reports whether there was one
+func resolveOpenAlert(q dbExecutor, shipName, sensorCode, note string) (bool, error) {
+	if note == "" {
+		note = "Ditandai selesai dari dashboard"
+	}
+	res, err := q.Exec(`
+		UPDATE fms_alerts
+		SET resolved_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, resolution_note = $3
+		WHERE ship_name = $1 AND sensor_code = $2 AND rule_id IS NULL AND resolved_at IS NULL
+	`, shipName, sensorCode, note)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 
 	"fms-app/db"
 
@@ -89,3 +93,91 @@ func GetDashboardData(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+// APIError is the error body of every /api/v1 response: {"error": {...}}
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// APIErrorResponse wraps an APIError
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIPage describes the page returned by a list endpoint
+type APIPage struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+}
+
+const (
+	apiDefaultPerPage = 50
+	apiMaxPerPage     = 500
+)
+
+func apiError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, APIErrorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+// apiInvalid rejects a request whose fields failed validation, keyed by field name
+func apiInvalid(c *gin.Context, fields map[string]string) {
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, APIErrorResponse{Error: APIError{
+		Code: "validation_failed", Message: "request has invalid fields", Fields: fields,
+	}})
+}
+
+func apiInternal(c *gin.Context, err error) {
+	log.Printf("api %s %s: %v", c.Request.Method, c.FullPath(), err)
+	apiError(c, http.StatusInternalServerError, "internal_error", "internal server error")
+}
+
+func apiNotFound(c *gin.Context, what string) {
+	apiError(c, http.StatusNotFound, "not_found", what+" not found")
+}
+
+// apiBind decodes a JSON body into v, rejecting unknown fields and trailing data
+func apiBind(c *gin.Context, v any) bool {
+	dec := json.NewDecoder(c.Request.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_json", err.Error())
+		return false
+	}
+	if dec.More() {
+		apiError(c, http.StatusBadRequest, "invalid_json", "body must hold a single JSON object")
+		return false
+	}
+	return true
+}
+
+// apiID parses the :id path parameter
+func apiID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		apiError(c, http.StatusBadRequest, "invalid_id", "id must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// apiPaging reads ?page= and ?per_page=
+func apiPaging(c *gin.Context) (APIPage, bool) {
+	p := APIPage{Page: 1, PerPage: apiDefaultPerPage}
+	var err error
+	if v := c.Query("page"); v != "" {
+		if p.Page, err = strconv.Atoi(v); err != nil || p.Page < 1 {
+			apiError(c, http.StatusBadRequest, "invalid_request", "page must be a positive integer")
+			return p, false
+		}
+	}
+	if v := c.Query("per_page"); v != "" {
+		if p.PerPage, err = strconv.Atoi(v); err != nil || p.PerPage < 1 || p.PerPage > apiMaxPerPage {
+			apiError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("per_page must be between 1 and %d", apiMaxPerPage))
+			return p, false
+		}
+	}
+	return p, true
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fms-app/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contractCase is one request against the API. Route is the gin route it must hit so the
+// response can be checked against the operation in the spec.
+type contractCase struct {
+	method, route, url, body string
+	status                   int
+}
+
+// The database refuses connections, so handlers run their validation and error paths:
+// 400/422 from validation and 500 once they reach the database. Every status and body must
+// be declared in the spec.
+var contractCases = []contractCase{
+	{"GET", "/api/v1/reports", "/api/v1/reports", "", 500},
+	{"GET", "/api/v1/reports", "/api/v1/reports?status=broken", "", 400},
+	{"GET", "/api/v1/reports", "/api/v1/reports?per_page=1000", "", 400},
+	{"GET", "/api/v1/reports", "/api/v1/reports?period_from=2024-13", "", 400},
+	{"POST", "/api/v1/reports", "/api/v1/reports", `{}`, 400},
+	{"POST", "/api/v1/reports", "/api/v1/reports", `{"ship_name":"KM A","report_date":"2024-02-30","sensors":{}}`, 400},
+	{"POST", "/api/v1/reports", "/api/v1/reports", `{"ship_name":"KM A","report_date":"2024-05-01","sensors":{"gps":true},"extra":1}`, 400},
+	{"POST", "/api/v1/reports", "/api/v1/reports", `{"ship_name":"KM A","project_code":"FMS","report_date":"2024-05-01","sensors":{"gps":true}}`, 500},
+	{"GET", "/api/v1/reports/:id", "/api/v1/reports/abc", "", 400},
+	{"GET", "/api/v1/reports/:id", "/api/v1/reports/1", "", 500},
+	{"PATCH", "/api/v1/reports/:id", "/api/v1/reports/1", `{"code":" "}`, 422},
+	{"PATCH", "/api/v1/reports/:id", "/api/v1/reports/1", `{"sensors":{"gps":"on"}}`, 400},
+	{"PATCH", "/api/v1/reports/:id", "/api/v1/reports/1", `{"report_date":"2024-05-01"}`, 500},
+	{"DELETE", "/api/v1/reports/:id", "/api/v1/reports/1", "", 500},
+
+	{"GET", "/api/v1/ships", "/api/v1/ships", "", 500},
+	{"POST", "/api/v1/ships", "/api/v1/ships", `{"name":" "}`, 422},
+	{"POST", "/api/v1/ships", "/api/v1/ships", `{"name":"KM A","metadata":{"owner":1}}`, 400},
+	{"POST", "/api/v1/ships", "/api/v1/ships", `{"name":"KM A"}`, 500},
+	{"GET", "/api/v1/ships/:id", "/api/v1/ships/0", "", 400},
+	{"GET", "/api/v1/ships/:id", "/api/v1/ships/1", "", 500},
+	{"PATCH", "/api/v1/ships/:id", "/api/v1/ships/1", `{"code":"X1"}`, 500},
+	{"DELETE", "/api/v1/ships/:id", "/api/v1/ships/1", "", 500},
+	{"GET", "/api/v1/ships/:id/sensors", "/api/v1/ships/1/sensors", "", 500},
+	{"PATCH", "/api/v1/ships/:id/sensors", "/api/v1/ships/1/sensors", `{"gps":"off"}`, 400},
+	{"PATCH", "/api/v1/ships/:id/sensors", "/api/v1/ships/1/sensors", `{"gps":false,"rpm_me_port":null}`, 500},
+	{"PUT", "/api/v1/ships/:id/sensors/:code", "/api/v1/ships/1/sensors/gps", `{"active":1}`, 400},
+	{"PUT", "/api/v1/ships/:id/sensors/:code", "/api/v1/ships/1/sensors/gps", `{"active":true}`, 500},
+	{"DELETE", "/api/v1/ships/:id/sensors/:code", "/api/v1/ships/1/sensors/gps", "", 500},
+	{"GET", "/api/v1/ships/:id/readings", "/api/v1/ships/1/readings", "", 400},
+	{"GET", "/api/v1/ships/:id/readings", "/api/v1/ships/abc/readings?sensor=gps", "", 400},
+	{"GET", "/api/v1/ships/:id/readings", "/api/v1/ships/1/readings?sensor=gps&resolution=minute", "", 400},
+	{"GET", "/api/v1/ships/:id/readings", "/api/v1/ships/1/readings?sensor=gps&from=2024-01-01T00:00:00Z&to=2024-06-01T00:00:00Z&resolution=raw", "", 400},
+	{"GET", "/api/v1/ships/:id/readings", "/api/v1/ships/1/readings?sensor=gps&to=2024-01-01", "", 400},
+	{"GET", "/api/v1/ships/:id/readings", "/api/v1/ships/1/readings?sensor=rpm_me_port&resolution=hour", "", 500},
+
+	{"GET", "/api/v1/sensors", "/api/v1/sensors", "", 500},
+	{"POST", "/api/v1/sensors", "/api/v1/sensors", `{"name":"Engine RPM","code":"Engine RPM"}`, 400},
+	{"POST", "/api/v1/sensors", "/api/v1/sensors", `{"name":" "}`, 422},
+	{"GET", "/api/v1/sensors/:code", "/api/v1/sensors/gps", "", 500},
+	{"PATCH", "/api/v1/sensors/:code", "/api/v1/sensors/gps", `{"display_order":"first"}`, 400},
+	{"PATCH", "/api/v1/sensors/:code", "/api/v1/sensors/gps", `{"active":false}`, 500},
+	{"DELETE", "/api/v1/sensors/:code", "/api/v1/sensors/gps", "", 500},
+
+	{"GET", "/api/v1/projects", "/api/v1/projects", "", 500},
+	{"POST", "/api/v1/projects", "/api/v1/projects", `{"code":"","name":""}`, 422},
+	{"POST", "/api/v1/projects", "/api/v1/projects", `{"code":"FMS"}`, 400},
+	{"GET", "/api/v1/projects/:code", "/api/v1/projects/FMS", "", 500},
+	{"PATCH", "/api/v1/projects/:code", "/api/v1/projects/FMS", `{"name":"Fleet"}`, 500},
+	{"DELETE", "/api/v1/projects/:code", "/api/v1/projects/FMS", "", 500},
+}
+
+// authContractCase sends token as a bearer token when it is not empty
+type authContractCase struct {
+	contractCase
+	token string
+}
+
+// Ingestion cases send a device token; looking it up fails on the unreachable database
+var ingestContractCases = []authContractCase{
+	{contractCase{"POST", "/api/v1/ingest", "/api/v1/ingest", `{"project_code":"FMS","samples":[]}`, 401}, ""},
+	{contractCase{"POST", "/api/v1/ingest", "/api/v1/ingest", `{"project_code":"FMS","samples":[]}`, 401}, " "},
+	{contractCase{"POST", "/api/v1/ingest", "/api/v1/ingest", `{"project_code":"FMS","samples":[{"ship_code":"SHP1","timestamp":"2024-05-01","sensors":{}}]}`, 400}, "gw-token"},
+	{contractCase{"POST", "/api/v1/ingest", "/api/v1/ingest", `{"project_code":"FMS","samples":[{"ship_code":"SHP1","timestamp":"2024-05-01T08:00:00Z","sensors":{"gps":{"status":"up"}}}]}`, 400}, "gw-token"},
+	{contractCase{"POST", "/api/v1/ingest", "/api/v1/ingest", `{"project_code":"FMS","samples":[{"ship_code":"SHP1","timestamp":"2024-05-01T08:00:00Z","sensors":{"gps":{"status":"online","value":1.5}}}]}`, 500}, "gw-token"},
+}
+
+func contractRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	// apiInternal logs every 500
+	logOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(logOutput) })
+
+	unreachable, err := sql.Open("postgres", "host=127.0.0.1 port=1 user=fms dbname=fms sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db.DB
+	db.DB = unreachable
+	t.Cleanup(func() {
+		unreachable.Close()
+		db.DB = previous
+	})
+
+	r := gin.New()
+	RegisterAPI(r)
+	return r
+}
+
+func TestAPIRoutesMatchSpec(t *testing.T) {
+	r := contractRouter(t)
+	spec := APISpec()
+
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		if !strings.HasPrefix(route.Path, apiPrefix+"/") {
+			continue
+		}
+		registered[route.Method+" "+route.Path] = true
+		if _, ok := spec.Lookup(route.Method, route.Path); !ok {
+			t.Errorf("%s %s is routed but not in the OpenAPI spec", route.Method, route.Path)
+		}
+	}
+	for _, op := range spec.Operations() {
+		if !registered[op.Method+" "+apiPrefix+op.Path] {
+			t.Errorf("%s %s is in the OpenAPI spec but not routed", op.Method, apiPrefix+op.Path)
+		}
+	}
+}
+
+func TestAPIResponsesMatchSpec(t *testing.T) {
+	r := contractRouter(t)
+	spec := APISpec()
+
+	var cases []authContractCase
+	for _, tc := range contractCases {
+		cases = append(cases, authContractCase{contractCase: tc})
+	}
+	cases = append(cases, ingestContractCases...)
+
+	covered := map[string]bool{}
+	for _, tc := range cases {
+		var body io.Reader
+		if tc.body != "" {
+			body = strings.NewReader(tc.body)
+		}
+		req := httptest.NewRequest(tc.method, tc.url, body)
+		req.Header.Set("Content-Type", "application/json")
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		name := tc.method + " " + tc.url + " " + tc.body
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d (body %s)", name, w.Code, tc.status, w.Body.String())
+			continue
+		}
+		if err := spec.ValidateResponse(tc.method, tc.route, w.Code, w.Body.Bytes()); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		covered[tc.method+" "+tc.route] = true
+	}
+
+	// A new endpoint needs at least one case here
+	for _, op := range spec.Operations() {
+		if !covered[op.Method+" "+apiPrefix+op.Path] {
+			t.Errorf("%s %s has no contract case", op.Method, apiPrefix+op.Path)
+		}
+	}
+}
+
+// TestAPIResourcesMatchSpec checks the conversions handlers use to build success bodies,
+// which cannot run without a database
+func TestAPIResourcesMatchSpec(t *testing.T) {
+	spec := APISpec()
+	now := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+
+	r := DeviceReport{ID: 7, Code: "FMS SHP1 May 2024", ReportDate: now, ShipName: "KM A",
+		SensorsData: map[string]bool{"gps": true, "rpm_me_port": false}, Source: ReportSourceDevice, CreatedAt: now, UpdatedAt: now}
+	r.CalculateTotals()
+	empty := DeviceReport{ID: 8, Code: "FMS May 2024", ReportDate: now, ShipName: "KM B", Source: ReportSourceManual}
+
+	responses := []struct {
+		method, route string
+		status        int
+		value         any
+	}{
+		{"GET", "/api/v1/reports/:id", 200, reportResource(r)},
+		{"GET", "/api/v1/reports/:id", 200, reportResource(empty)},
+		{"GET", "/api/v1/reports", 200, ReportList{Data: []ReportResource{reportResource(r)}, Meta: APIPage{Page: 1, PerPage: 50, Total: 1}}},
+		{"GET", "/api/v1/ships/:id", 200, ShipResource{ID: 1, Name: "KM A", Metadata: decodeShipMetadata([]byte(`{"owner":"PT X","year":2010}`)), Aliases: []string{}, CreatedAt: now}},
+		{"GET", "/api/v1/ships/:id/sensors", 200, ShipSensorList{Data: []ShipSensorResource{{Code: "gps", Name: "GPS", GlobalActive: true}}}},
+		{"GET", "/api/v1/sensors/:code", 200, SensorResource{Code: "gps", Name: "GPS", Active: true, DisplayOrder: 2}},
+		{"GET", "/api/v1/ships/:id/readings", 200, ReadingSeries{ShipID: 1, Sensor: "rpm_me_port", Resolution: "hour", From: now, To: now.Add(time.Hour),
+			Points: []ReadingPoint{{T: now, Avg: 750.5, Min: 700, Max: 801, Count: 60}}}},
+		{"GET", "/api/v1/projects/:code", 200, ProjectResource{Code: "FMS", Name: "Fleet", Active: true}},
+		{"POST", "/api/v1/ingest", 200, DeviceIngestResult{Reports: []DeviceIngestReport{{ID: 7, Code: "FMS SHP1 May 2024", ShipName: "KM A", Created: true, Sensors: sortedKeys(r.SensorsData)}}}},
+	}
+	for _, resp := range responses {
+		body, err := json.Marshal(resp.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := spec.ValidateResponse(resp.method, resp.route, resp.status, body); err != nil {
+			t.Errorf("%T: %v", resp.value, err)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	r := contractRouter(t)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") || len(doc.Paths) == 0 {
+		t.Fatalf("unexpected document header: openapi=%q, %d paths", doc.OpenAPI, len(doc.Paths))
+	}
+
+	// Every $ref must point at a component
+	for _, ref := range strings.Split(w.Body.String(), `"$ref": "`)[1:] {
+		name := strings.TrimPrefix(ref[:strings.Index(ref, `"`)], "#/components/schemas/")
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("dangling $ref to %s", name)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/events"
+	"fms-app/shipname"
+
+	"github.com/gin-gonic/gin"
+)
+
+const apiDateLayout = "2006-01-02"
+
+// ReportResource is the JSON form of a report. Sensors holds every recorded sensor
+// code with its status (true = online).
+type ReportResource struct {
+	ID             int             `json:"id"`
+	Code           string          `json:"code"`
+	ProjectCode    string          `json:"project_code"`
+	ReportDate     string          `json:"report_date" openapi:"format=date"`
+	ShipName       string          `json:"ship_name"`
+	Sensors        map[string]bool `json:"sensors"`
+	Source         string          `json:"source" openapi:"enum=manual|device"`
+	OnlineTotal    int             `json:"online_total"`
+	OfflineTotal   int             `json:"offline_total"`
+	OnlinePercent  float64         `json:"online_percent"`
+	OfflinePercent float64         `json:"offline_percent"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// ReportList is the response of GET /api/v1/reports
+type ReportList struct {
+	Data []ReportResource `json:"data"`
+	Meta APIPage          `json:"meta"`
+}
+
+// ReportCreateRequest creates a report. The ship is given by ship_id or ship_name; when
+// code is omitted it is built from project_code, the ship code and the report month.
+type ReportCreateRequest struct {
+	ShipID      int             `json:"ship_id,omitempty" openapi:"min=1"`
+	ShipName    string          `json:"ship_name,omitempty"`
+	ProjectCode string          `json:"project_code,omitempty"`
+	Code        string          `json:"code,omitempty"`
+	ReportDate  string          `json:"report_date" openapi:"format=date"`
+	Sensors     map[string]bool `json:"sensors"`
+}
+
+// ReportPatchRequest changes the given fields; sensors are merged into the existing ones
+type ReportPatchRequest struct {
+	Code       *string         `json:"code,omitempty"`
+	ReportDate *string         `json:"report_date,omitempty" openapi:"format=date"`
+	ShipName   *string         `json:"ship_name,omitempty"`
+	Sensors    map[string]bool `json:"sensors,omitempty"`
+}
+
+func reportResource(r DeviceReport) ReportResource {
+	sensors := r.SensorsData
+	if sensors == nil {
+		sensors = map[string]bool{}
+	}
+	return ReportResource{
+		ID:             r.ID,
+		Code:           r.Code,
+		ProjectCode:    projectFromCode(r.Code),
+		ReportDate:     r.ReportDate.Format(apiDateLayout),
+		ShipName:       r.ShipName,
+		Sensors:        sensors,
+		Source:         r.Source,
+		OnlineTotal:    r.OnlineTotal,
+		OfflineTotal:   r.OfflineTotal,
+		OnlinePercent:  r.OnlinePercent,
+		OfflinePercent: r.OfflinePercent,
+		CreatedAt:      r.CreatedAt,
+		UpdatedAt:      r.UpdatedAt,
+	}
+}
+
+// knownSensorCodes is the set of configured sensor codes, active or not
+func knownSensorCodes() (map[string]bool, error) {
+	configured, err := allSensors()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(configured))
+	for _, s := range configured {
+		known[s.Code] = true
+	}
+	return known, nil
+}
+
+// validateSensorCodes records an error for every code that is not a configured sensor
+func validateSensorCodes(sensors map[string]bool, problems map[string]string) error {
+	if len(sensors) == 0 {
+		return nil
+	}
+	known, err := knownSensorCodes()
+	if err != nil {
+		return err
+	}
+	for code := range sensors {
+		if !known[code] {
+			problems["sensors."+code] = "unknown sensor code"
+		}
+	}
+	return nil
+}
+
+// APIListReports lists reports filtered by project, ship, period range (YYYY-MM, inclusive)
+// and sensor status
+func APIListReports(c *gin.Context) {
+	page, ok := apiPaging(c)
+	if !ok {
+		return
+	}
+
+	f := reportFilter{
+		Project: strings.TrimSpace(c.Query("project")),
+		Sensor:  strings.TrimSpace(c.Query("sensor")),
+		Status:  c.Query("status"),
+	}
+	problems := map[string]string{}
+	if v := c.Query("period_from"); v != "" {
+		if t, err := time.Parse("2006-01", v); err != nil {
+			problems["period_from"] = "must be YYYY-MM"
+		} else {
+			f.From = t
+		}
+	}
+	if v := c.Query("period_to"); v != "" {
+		if t, err := time.Parse("2006-01", v); err != nil {
+			problems["period_to"] = "must be YYYY-MM"
+		} else {
+			f.To = t.AddDate(0, 1, 0)
+		}
+	}
+	if f.Status != "" && f.Status != "online" && f.Status != "offline" {
+		problems["status"] = "must be online or offline"
+	}
+	if len(problems) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, APIErrorResponse{Error: APIError{
+			Code: "invalid_request", Message: "invalid query parameters", Fields: problems,
+		}})
+		return
+	}
+	if ship := c.Query("ship"); ship != "" {
+		name, err := resolveShipName(db.DB, ship)
+		if err != nil {
+			apiInternal(c, err)
+			return
+		}
+		f.Ship = name
+	}
+
+	reports, total, err := queryReports(f, page.PerPage, (page.Page-1)*page.PerPage)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	page.Total = total
+
+	list := ReportList{Data: make([]ReportResource, 0, len(reports)), Meta: page}
+	for _, r := range reports {
+		list.Data = append(list.Data, reportResource(r))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// APIGetReport returns one report
+func APIGetReport(c *gin.Context) {
+	id, ok := apiID(c)
+	if !ok {
+		return
+	}
+	r, err := fetchReport(db.DB, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		apiNotFound(c, "report")
+		return
+	}
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reportResource(r))
+}
+
+// APICreateReport stores a report through the same path as the input form. The ship is
+// given by id or by any known spelling of its name; without an explicit code, the code is
+// built from the project and the ship's code.
+func APICreateReport(c *gin.Context) {
+	var req ReportCreateRequest
+	if !apiBind(c, &req) {
+		return
+	}
+
+	problems := map[string]string{}
+	if req.ShipID == 0 && shipname.Clean(req.ShipName) == "" {
+		problems["ship_name"] = "ship_id or ship_name is required"
+	}
+
+	reportDate, dateErr := time.Parse(apiDateLayout, req.ReportDate)
+	if dateErr != nil {
+		problems["report_date"] = "must be YYYY-MM-DD"
+	}
+
+	code := strings.TrimSpace(req.Code)
+	project := strings.ToUpper(strings.TrimSpace(req.ProjectCode))
+	if code == "" && project == "" {
+		problems["code"] = "code or project_code is required"
+	}
+	if project == "" {
+		project = projectFromCode(code)
+	}
+
+	if len(req.Sensors) == 0 {
+		problems["sensors"] = "at least one sensor is required"
+	}
+	if err := validateSensorCodes(req.Sensors, problems); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if len(problems) > 0 {
+		apiInvalid(c, problems)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	names, err := shipNameIndex(tx)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+
+	// Resolve the ship and project against the master data, as confirming a draft does
+	var shipName, shipCode string
+	if req.ShipID != 0 {
+		err = tx.QueryRow("SELECT name, COALESCE(code, '') FROM fms_ships WHERE id = $1", req.ShipID).Scan(&shipName, &shipCode)
+		if errors.Is(err, sql.ErrNoRows) {
+			problems["ship_id"] = "unknown ship"
+		}
+	} else {
+		shipName = names.resolve(req.ShipName)
+		err = tx.QueryRow("SELECT COALESCE(code, '') FROM fms_ships WHERE name = $1", shipName).Scan(&shipCode)
+		if errors.Is(err, sql.ErrNoRows) {
+			problems["ship_name"] = "unknown ship"
+		}
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		apiInternal(c, err)
+		return
+	}
+	if code == "" && shipCode == "" && len(problems) == 0 {
+		problems["code"] = "ship has no code, code is required"
+	}
+
+	var active bool
+	err = tx.QueryRow("SELECT is_active FROM fms_projects WHERE code = $1", project).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !active) {
+		problems["project_code"] = "unknown or inactive project"
+	} else if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if len(problems) > 0 {
+		apiInvalid(c, problems)
+		return
+	}
+
+	if code == "" {
+		code = reportCode(project, shipCode, reportDate)
+	}
+	r := DeviceReport{Code: code, ReportDate: reportDate, ShipName: shipName, SensorsData: req.Sensors}
+	created, err := insertReport(tx, names, &r, project)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apiInternal(c, err)
+		return
+	}
+
+	events.Publish(created)
+
+	c.Header("Location", fmt.Sprintf("/api/v1/reports/%d", r.ID))
+	c.JSON(http.StatusCreated, reportResource(r))
+}
+
+// APIUpdateReport applies a partial update to a report
+func APIUpdateReport(c *gin.Context) {
+	id, ok := apiID(c)
+	if !ok {
+		return
+	}
+	var req ReportPatchRequest
+	if !apiBind(c, &req) {
+		return
+	}
+
+	problems := map[string]string{}
+	patch := reportPatch{Sensors: req.Sensors}
+	if req.Code != nil {
+		code := strings.TrimSpace(*req.Code)
+		if code == "" {
+			problems["code"] = "must not be empty"
+		}
+		patch.Code = &code
+	}
+	if req.ReportDate != nil {
+		d, err := time.Parse(apiDateLayout, *req.ReportDate)
+		if err != nil {
+			problems["report_date"] = "must be YYYY-MM-DD"
+		}
+		patch.ReportDate = &d
+	}
+	if req.ShipName != nil {
+		if shipname.Clean(*req.ShipName) == "" {
+			problems["ship_name"] = "must not be empty"
+		}
+		patch.ShipName = req.ShipName
+	}
+	if err := validateSensorCodes(req.Sensors, problems); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if len(problems) > 0 {
+		apiInvalid(c, problems)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	r, updated, err := updateReport(tx, id, patch)
+	if errors.Is(err, sql.ErrNoRows) {
+		apiNotFound(c, "report")
+		return
+	}
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apiInternal(c, err)
+		return
+	}
+
+	events.Publish(updated)
+
+	c.JSON(http.StatusOK, reportResource(r))
+}
+
+// APIDeleteReport deletes a report
+func APIDeleteReport(c *gin.Context) {
+	id, ok := apiID(c)
+	if !ok {
+		return
+	}
+	found, err := deleteReport(id)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if !found {
+		apiNotFound(c, "report")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
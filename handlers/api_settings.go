@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"fms-app/db"
+	"fms-app/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SensorResource is the JSON form of a global sensor configuration
+type SensorResource struct {
+	Code         string `json:"code"`
+	Name         string `json:"name"`
+	Active       bool   `json:"active"`
+	DisplayOrder int    `json:"display_order"`
+}
+
+// SensorList is the response of GET /api/v1/sensors
+type SensorList struct {
+	Data []SensorResource `json:"data"`
+}
+
+// SensorCreateRequest adds a sensor. Without a code one is derived from the name like the
+// settings form does; without display_order the sensor is placed last.
+type SensorCreateRequest struct {
+	Code         string `json:"code,omitempty" openapi:"pattern=^[a-z0-9]+(_[a-z0-9]+)*$"`
+	Name         string `json:"name"`
+	Active       *bool  `json:"active,omitempty"`
+	DisplayOrder *int   `json:"display_order,omitempty"`
+}
+
+// SensorPatchRequest changes the given fields of a sensor
+type SensorPatchRequest struct {
+	Name         *string `json:"name,omitempty"`
+	Active       *bool   `json:"active,omitempty"`
+	DisplayOrder *int    `json:"display_order,omitempty"`
+}
+
+// ProjectResource is the JSON form of a project
+type ProjectResource struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+// ProjectList is the response of GET /api/v1/projects
+type ProjectList struct {
+	Data []ProjectResource `json:"data"`
+}
+
+// ProjectCreateRequest adds a project; the code is stored upper case
+type ProjectCreateRequest struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Active *bool  `json:"active,omitempty"`
+}
+
+// ProjectPatchRequest changes the given fields of a project
+type ProjectPatchRequest struct {
+	Name   *string `json:"name,omitempty"`
+	Active *bool   `json:"active,omitempty"`
+}
+
+// sensorCodePattern is the shape of generated sensor codes; explicit codes must match it.
+// The same expression is declared on SensorCreateRequest.Code for the API schema.
+var sensorCodePattern = regexp.MustCompile("^[a-z0-9]+(_[a-z0-9]+)*$")
+
+func fetchSensor(code string) (SensorResource, error) {
+	var s SensorResource
+	err := db.DB.QueryRow("SELECT code, name, is_active, display_order FROM fms_sensor_config WHERE code = $1", code).
+		Scan(&s.Code, &s.Name, &s.Active, &s.DisplayOrder)
+	return s, err
+}
+
+// apiSensor loads the sensor of the :code path parameter, answering 404 when it does not exist
+func apiSensor(c *gin.Context) (SensorResource, bool) {
+	s, err := fetchSensor(c.Param("code"))
+	if errors.Is(err, sql.ErrNoRows) {
+		apiNotFound(c, "sensor")
+		return s, false
+	}
+	if err != nil {
+		apiInternal(c, err)
+		return s, false
+	}
+	return s, true
+}
+
+// APIListSensors lists every sensor, active or not, in display order
+func APIListSensors(c *gin.Context) {
+	rows, err := db.DB.Query("SELECT code, name, is_active, display_order FROM fms_sensor_config ORDER BY display_order ASC, code ASC")
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	defer rows.Close()
+	list := SensorList{Data: []SensorResource{}}
+	for rows.Next() {
+		var s SensorResource
+		if err := rows.Scan(&s.Code, &s.Name, &s.Active, &s.DisplayOrder); err != nil {
+			apiInternal(c, err)
+			return
+		}
+		list.Data = append(list.Data, s)
+	}
+	if err := rows.Err(); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// APIGetSensor returns one sensor
+func APIGetSensor(c *gin.Context) {
+	if s, ok := apiSensor(c); ok {
+		c.JSON(http.StatusOK, s)
+	}
+}
+
+// APICreateSensor adds a sensor
+func APICreateSensor(c *gin.Context) {
+	var req SensorCreateRequest
+	if !apiBind(c, &req) {
+		return
+	}
+	s := SensorResource{Code: strings.TrimSpace(req.Code), Name: strings.TrimSpace(req.Name), Active: true}
+	if s.Name == "" {
+		apiInvalid(c, map[string]string{"name": "is required"})
+		return
+	}
+	if s.Code == "" {
+		s.Code = uniqueSensorCode(s.Name)
+	} else if !sensorCodePattern.MatchString(s.Code) {
+		apiInvalid(c, map[string]string{"code": "must be lower case letters and digits separated by _"})
+		return
+	} else if _, err := fetchSensor(s.Code); err == nil {
+		apiError(c, http.StatusConflict, "conflict", "sensor "+s.Code+" already exists")
+		return
+	}
+	if req.Active != nil {
+		s.Active = *req.Active
+	}
+	if req.DisplayOrder != nil {
+		s.DisplayOrder = *req.DisplayOrder
+	} else {
+		s.DisplayOrder = maxSensorOrder() + 1
+	}
+
+	_, err := db.DB.Exec("INSERT INTO fms_sensor_config (code, name, is_active, display_order) VALUES ($1, $2, $3, $4)",
+		s.Code, s.Name, s.Active, s.DisplayOrder)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+
+	events.Publish(events.SensorConfigChanged{Code: s.Code, IsActive: s.Active})
+
+	c.Header("Location", "/api/v1/sensors/"+s.Code)
+	c.JSON(http.StatusCreated, s)
+}
+
+// APIUpdateSensor changes a sensor's name, global status or display order
+func APIUpdateSensor(c *gin.Context) {
+	s, ok := apiSensor(c)
+	if !ok {
+		return
+	}
+	var req SensorPatchRequest
+	if !apiBind(c, &req) {
+		return
+	}
+	wasActive := s.Active
+	if req.Name != nil {
+		if s.Name = strings.TrimSpace(*req.Name); s.Name == "" {
+			apiInvalid(c, map[string]string{"name": "must not be empty"})
+			return
+		}
+	}
+	if req.Active != nil {
+		s.Active = *req.Active
+	}
+	if req.DisplayOrder != nil {
+		s.DisplayOrder = *req.DisplayOrder
+	}
+
+	_, err := db.DB.Exec("UPDATE fms_sensor_config SET name = $2, is_active = $3, display_order = $4 WHERE code = $1",
+		s.Code, s.Name, s.Active, s.DisplayOrder)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if s.Active != wasActive {
+		events.Publish(events.SensorConfigChanged{Code: s.Code, IsActive: s.Active})
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// APIDeleteSensor removes a sensor that no report has recorded; used sensors can only be
+// deactivated so their history stays readable
+func APIDeleteSensor(c *gin.Context) {
+	s, ok := apiSensor(c)
+	if !ok {
+		return
+	}
+	var used int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM fms_device_reports WHERE jsonb_exists(sensors_data, $1)", s.Code).Scan(&used); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if used > 0 {
+		apiError(c, http.StatusConflict, "conflict",
+			fmt.Sprintf("sensor is recorded in %d reports; set active to false instead", used))
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM fms_ship_sensors WHERE sensor_code = $1", s.Code); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM fms_sensor_config WHERE code = $1", s.Code); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apiInternal(c, err)
+		return
+	}
+
+	events.Publish(events.SensorConfigChanged{Code: s.Code, IsActive: false})
+
+	c.Status(http.StatusNoContent)
+}
+
+func fetchProject(code string) (ProjectResource, error) {
+	var p ProjectResource
+	err := db.DB.QueryRow("SELECT code, name, is_active FROM fms_projects WHERE code = $1", strings.ToUpper(code)).
+		Scan(&p.Code, &p.Name, &p.Active)
+	return p, err
+}
+
+// apiProject loads the project of the :code path parameter, answering 404 when it does not exist
+func apiProject(c *gin.Context) (ProjectResource, bool) {
+	p, err := fetchProject(c.Param("code"))
+	if errors.Is(err, sql.ErrNoRows) {
+		apiNotFound(c, "project")
+		return p, false
+	}
+	if err != nil {
+		apiInternal(c, err)
+		return p, false
+	}
+	return p, true
+}
+
+// APIListProjects lists every project, active or not
+func APIListProjects(c *gin.Context) {
+	rows, err := db.DB.Query("SELECT code, name, is_active FROM fms_projects ORDER BY code ASC")
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	defer rows.Close()
+	list := ProjectList{Data: []ProjectResource{}}
+	for rows.Next() {
+		var p ProjectResource
+		if err := rows.Scan(&p.Code, &p.Name, &p.Active); err != nil {
+			apiInternal(c, err)
+			return
+		}
+		list.Data = append(list.Data, p)
+	}
+	if err := rows.Err(); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// APIGetProject returns one project
+func APIGetProject(c *gin.Context) {
+	if p, ok := apiProject(c); ok {
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// APICreateProject adds a project
+func APICreateProject(c *gin.Context) {
+	var req ProjectCreateRequest
+	if !apiBind(c, &req) {
+		return
+	}
+	p := ProjectResource{Code: strings.ToUpper(strings.TrimSpace(req.Code)), Name: strings.TrimSpace(req.Name), Active: true}
+	problems := map[string]string{}
+	if p.Code == "" {
+		problems["code"] = "is required"
+	} else if strings.ContainsAny(p.Code, " \t") {
+		// Report codes start with the project code followed by a space
+		problems["code"] = "must not contain spaces"
+	}
+	if p.Name == "" {
+		problems["name"] = "is required"
+	}
+	if len(problems) > 0 {
+		apiInvalid(c, problems)
+		return
+	}
+	if req.Active != nil {
+		p.Active = *req.Active
+	}
+	if _, err := fetchProject(p.Code); err == nil {
+		apiError(c, http.StatusConflict, "conflict", "project "+p.Code+" already exists")
+		return
+	}
+
+	if _, err := db.DB.Exec("INSERT INTO fms_projects (code, name, is_active) VALUES ($1, $2, $3)", p.Code, p.Name, p.Active); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.Header("Location", "/api/v1/projects/"+p.Code)
+	c.JSON(http.StatusCreated, p)
+}
+
+// APIUpdateProject changes a project's name or status
+func APIUpdateProject(c *gin.Context) {
+	p, ok := apiProject(c)
+	if !ok {
+		return
+	}
+	var req ProjectPatchRequest
+	if !apiBind(c, &req) {
+		return
+	}
+	if req.Name != nil {
+		if p.Name = strings.TrimSpace(*req.Name); p.Name == "" {
+			apiInvalid(c, map[string]string{"name": "must not be empty"})
+			return
+		}
+	}
+	if req.Active != nil {
+		p.Active = *req.Active
+	}
+	if _, err := db.DB.Exec("UPDATE fms_projects SET name = $2, is_active = $3 WHERE code = $1", p.Code, p.Name, p.Active); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// APIDeleteProject removes a project without reports together with its notification
+// recipients; projects with reports can only be deactivated
+func APIDeleteProject(c *gin.Context) {
+	p, ok := apiProject(c)
+	if !ok {
+		return
+	}
+	var used int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM fms_device_reports WHERE upper(split_part(code, ' ', 1)) = $1", p.Code).Scan(&used); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if used > 0 {
+		apiError(c, http.StatusConflict, "conflict",
+			fmt.Sprintf("project has %d reports; set active to false instead", used))
+		return
+	}
+	if _, err := db.DB.Exec("DELETE FROM fms_projects WHERE code = $1", p.Code); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
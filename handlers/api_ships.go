@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/events"
+	"fms-app/shipname"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShipResource is the JSON form of a master ship
+type ShipResource struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Code      string            `json:"code"`
+	Metadata  map[string]string `json:"metadata"`
+	Aliases   []string          `json:"aliases"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ShipList is the response of GET /api/v1/ships
+type ShipList struct {
+	Data []ShipResource `json:"data"`
+}
+
+// ShipCreateRequest registers a ship; the name is normalized like the settings form
+type ShipCreateRequest struct {
+	Name     string            `json:"name"`
+	Code     string            `json:"code,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ShipPatchRequest changes the given fields. Metadata replaces the stored metadata as a
+// whole; renaming keeps the old name as an alias and moves reports and alerts.
+type ShipPatchRequest struct {
+	Name     *string           `json:"name,omitempty"`
+	Code     *string           `json:"code,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ShipSensorResource is the effective status of one sensor for a ship
+type ShipSensorResource struct {
+	Code         string `json:"code"`
+	Name         string `json:"name"`
+	GlobalActive bool   `json:"global_active"`
+	Active       bool   `json:"active"`
+	Override     bool   `json:"override"`
+}
+
+// ShipSensorList is the response of GET /api/v1/ships/:id/sensors
+type ShipSensorList struct {
+	Data []ShipSensorResource `json:"data"`
+}
+
+// ShipSensorRequest sets the override of one sensor to an explicit state
+type ShipSensorRequest struct {
+	Active *bool `json:"active"`
+}
+
+// loadShipResources reads ships (all when id is 0) with their aliases
+func loadShipResources(id int) ([]ShipResource, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, name, COALESCE(code, ''), COALESCE(metadata, '{}'), created_at
+		FROM fms_ships WHERE $1 = 0 OR id = $1 ORDER BY name ASC`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ships := []ShipResource{}
+	byID := map[int]int{}
+	for rows.Next() {
+		var s ShipResource
+		var raw []byte
+		if err := rows.Scan(&s.ID, &s.Name, &s.Code, &raw, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		s.Metadata = decodeShipMetadata(raw)
+		s.Aliases = []string{}
+		byID[s.ID] = len(ships)
+		ships = append(ships, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	aRows, err := db.DB.Query("SELECT ship_id, alias FROM fms_ship_aliases WHERE $1 = 0 OR ship_id = $1 ORDER BY alias ASC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer aRows.Close()
+	for aRows.Next() {
+		var shipID int
+		var alias string
+		if err := aRows.Scan(&shipID, &alias); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[shipID]; ok {
+			ships[i].Aliases = append(ships[i].Aliases, alias)
+		}
+	}
+	return ships, aRows.Err()
+}
+
+// apiShip loads the ship of the :id path parameter, answering 404 when it does not exist
+func apiShip(c *gin.Context) (ShipResource, bool) {
+	id, ok := apiID(c)
+	if !ok {
+		return ShipResource{}, false
+	}
+	ships, err := loadShipResources(id)
+	if err != nil {
+		apiInternal(c, err)
+		return ShipResource{}, false
+	}
+	if len(ships) == 0 {
+		apiNotFound(c, "ship")
+		return ShipResource{}, false
+	}
+	return ships[0], true
+}
+
+// shipCodeTaken reports whether another ship already uses code (case-insensitive)
+func shipCodeTaken(code string, exceptID int) (bool, error) {
+	var taken bool
+	err := db.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM fms_ships WHERE upper(code) = upper($1) AND id <> $2)", code, exceptID,
+	).Scan(&taken)
+	return taken, err
+}
+
+func encodeShipMetadata(m map[string]string) []byte {
+	if m == nil {
+		m = map[string]string{}
+	}
+	raw, _ := json.Marshal(m)
+	return raw
+}
+
+// APIListShips lists the master ships
+func APIListShips(c *gin.Context) {
+	ships, err := loadShipResources(0)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ShipList{Data: ships})
+}
+
+// APIGetShip returns one ship
+func APIGetShip(c *gin.Context) {
+	if ship, ok := apiShip(c); ok {
+		c.JSON(http.StatusOK, ship)
+	}
+}
+
+// APICreateShip registers a ship
+func APICreateShip(c *gin.Context) {
+	var req ShipCreateRequest
+	if !apiBind(c, &req) {
+		return
+	}
+	name := shipname.Clean(req.Name)
+	code := strings.TrimSpace(req.Code)
+	if name == "" {
+		apiInvalid(c, map[string]string{"name": "is required"})
+		return
+	}
+
+	if existing, found, err := registeredShipName(db.DB, name); err != nil {
+		apiInternal(c, err)
+		return
+	} else if found {
+		apiError(c, http.StatusConflict, "conflict", "ship is already registered as "+existing)
+		return
+	}
+	if code != "" {
+		if taken, err := shipCodeTaken(code, 0); err != nil {
+			apiInternal(c, err)
+			return
+		} else if taken {
+			apiError(c, http.StatusConflict, "conflict", "ship code "+code+" is already in use")
+			return
+		}
+	}
+
+	var id int
+	err := db.DB.QueryRow(
+		"INSERT INTO fms_ships (name, code, metadata) VALUES ($1, NULLIF($2, ''), $3) RETURNING id",
+		name, code, encodeShipMetadata(req.Metadata),
+	).Scan(&id)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+
+	events.Publish(events.ShipCreated{ID: id, Name: name, Code: code})
+
+	ships, err := loadShipResources(id)
+	if err != nil || len(ships) == 0 {
+		apiInternal(c, fmt.Errorf("reload ship %d: %v", id, err))
+		return
+	}
+	c.Header("Location", fmt.Sprintf("/api/v1/ships/%d", id))
+	c.JSON(http.StatusCreated, ships[0])
+}
+
+// APIUpdateShip changes a ship's name, code or metadata
+func APIUpdateShip(c *gin.Context) {
+	ship, ok := apiShip(c)
+	if !ok {
+		return
+	}
+	var req ShipPatchRequest
+	if !apiBind(c, &req) {
+		return
+	}
+
+	name, code, metadata := ship.Name, ship.Code, ship.Metadata
+	if req.Name != nil {
+		if name = shipname.Clean(*req.Name); name == "" {
+			apiInvalid(c, map[string]string{"name": "must not be empty"})
+			return
+		}
+		existing, found, err := registeredShipName(db.DB, name)
+		if err != nil {
+			apiInternal(c, err)
+			return
+		}
+		if found && existing != ship.Name {
+			apiError(c, http.StatusConflict, "conflict", "ship is already registered as "+existing)
+			return
+		}
+	}
+	if req.Code != nil {
+		code = strings.TrimSpace(*req.Code)
+		if taken, err := shipCodeTaken(code, ship.ID); err != nil {
+			apiInternal(c, err)
+			return
+		} else if code != "" && taken {
+			apiError(c, http.StatusConflict, "conflict", "ship code "+code+" is already in use")
+			return
+		}
+	}
+	if req.Metadata != nil {
+		metadata = req.Metadata
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE fms_ships SET name = $1, code = NULLIF($2, ''), metadata = $3 WHERE id = $4",
+		name, code, encodeShipMetadata(metadata), ship.ID)
+	if err == nil && name != ship.Name {
+		err = renameShipHistory(tx, ship.Name, name)
+		if err == nil {
+			// The new name must not linger as an alias, the old one becomes one
+			_, err = tx.Exec("DELETE FROM fms_ship_aliases WHERE ship_id = $1 AND alias_key = $2", ship.ID, shipname.Key(name))
+		}
+		if err == nil {
+			err = addShipAlias(tx, ship.ID, ship.Name)
+		}
+	}
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		apiInternal(c, err)
+		return
+	}
+
+	ships, err := loadShipResources(ship.ID)
+	if err != nil || len(ships) == 0 {
+		apiInternal(c, fmt.Errorf("reload ship %d: %v", ship.ID, err))
+		return
+	}
+	c.JSON(http.StatusOK, ships[0])
+}
+
+// APIDeleteShip removes a ship without reports. Ships with history are merged instead.
+func APIDeleteShip(c *gin.Context) {
+	ship, ok := apiShip(c)
+	if !ok {
+		return
+	}
+	var reports int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM fms_device_reports WHERE ship_name = $1", ship.Name).Scan(&reports); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	if reports > 0 {
+		apiError(c, http.StatusConflict, "conflict",
+			fmt.Sprintf("ship has %d reports; merge it into another ship instead", reports))
+		return
+	}
+	// Overrides and aliases cascade
+	if _, err := db.DB.Exec("DELETE FROM fms_ships WHERE id = $1", ship.ID); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// loadShipSensors lists every configured sensor with its effective status for a ship
+func loadShipSensors(q dbExecutor, shipID int) ([]ShipSensorResource, error) {
+	rows, err := q.Query(`
+		SELECT g.code, g.name, g.is_active, COALESCE(s.is_active, g.is_active), s.ship_id IS NOT NULL
+		FROM fms_sensor_config g
+		LEFT JOIN fms_ship_sensors s ON s.sensor_code = g.code AND s.ship_id = $1
+		ORDER BY g.display_order ASC, g.code ASC
+	`, shipID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sensors := []ShipSensorResource{}
+	for rows.Next() {
+		var s ShipSensorResource
+		if err := rows.Scan(&s.Code, &s.Name, &s.GlobalActive, &s.Active, &s.Override); err != nil {
+			return nil, err
+		}
+		sensors = append(sensors, s)
+	}
+	return sensors, rows.Err()
+}
+
+// APIListShipSensors lists the effective sensor configuration of a ship
+func APIListShipSensors(c *gin.Context) {
+	ship, ok := apiShip(c)
+	if !ok {
+		return
+	}
+	sensors, err := loadShipSensors(db.DB, ship.ID)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ShipSensorList{Data: sensors})
+}
+
+// APIPatchShipSensors sets several overrides at once: {"<code>": true|false|null}, where
+// null removes the override. Sensors not in the body are left unchanged.
+func APIPatchShipSensors(c *gin.Context) {
+	ship, ok := apiShip(c)
+	if !ok {
+		return
+	}
+	var req map[string]*bool
+	if !apiBind(c, &req) {
+		return
+	}
+	applyShipSensors(c, ship.ID, req)
+}
+
+// APISetShipSensor sets the override of one sensor to {"active": true|false}
+func APISetShipSensor(c *gin.Context) {
+	ship, ok := apiShip(c)
+	if !ok {
+		return
+	}
+	var req ShipSensorRequest
+	if !apiBind(c, &req) {
+		return
+	}
+	if req.Active == nil {
+		apiInvalid(c, map[string]string{"active": "is required"})
+		return
+	}
+	applyShipSensors(c, ship.ID, map[string]*bool{c.Param("code"): req.Active})
+}
+
+// APIDeleteShipSensor removes the override of one sensor so the ship follows the global setting
+func APIDeleteShipSensor(c *gin.Context) {
+	ship, ok := apiShip(c)
+	if !ok {
+		return
+	}
+	applyShipSensors(c, ship.ID, map[string]*bool{c.Param("code"): nil})
+}
+
+// applyShipSensors writes the overrides in one transaction and answers with the resulting
+// configuration. Setting a state that is already in place is a no-op.
+func applyShipSensors(c *gin.Context, shipID int, states map[string]*bool) {
+	known, err := knownSensorCodes()
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	problems := map[string]string{}
+	for code := range states {
+		if !known[code] {
+			problems[code] = "unknown sensor code"
+		}
+	}
+	if len(problems) > 0 {
+		apiInvalid(c, problems)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var changed []events.Event
+	for code, want := range states {
+		before, override, err := shipSensorState(tx, shipID, code)
+		if err != nil {
+			apiInternal(c, err)
+			return
+		}
+		if want == nil && !override || want != nil && override && before == *want {
+			continue
+		}
+		if err := setShipSensor(tx, shipID, code, want); err != nil {
+			apiInternal(c, err)
+			return
+		}
+		after, _, err := shipSensorState(tx, shipID, code)
+		if err != nil {
+			apiInternal(c, err)
+			return
+		}
+		changed = append(changed, events.SensorConfigChanged{Code: code, ShipID: shipID, IsActive: after})
+	}
+	if err := tx.Commit(); err != nil {
+		apiInternal(c, err)
+		return
+	}
+	for _, ev := range changed {
+		events.Publish(ev)
+	}
+
+	sensors, err := loadShipSensors(db.DB, shipID)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ShipSensorList{Data: sensors})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"fms-app/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiPrefix is where the versioned API is mounted
+const apiPrefix = "/api/v1"
+
+// apiRoute is one /api/v1 endpoint. The same table registers the gin routes and generates
+// the OpenAPI document, so a handler cannot be routed without being described.
+type apiRoute struct {
+	openapi.Operation
+	Handler gin.HandlerFunc
+}
+
+// Status codes every endpoint may answer with besides its own
+var apiCommonErrors = []int{http.StatusBadRequest, http.StatusInternalServerError}
+
+func apiRoutes() []apiRoute {
+	page := []openapi.Param{
+		openapi.Query("page", "page number, from 1", openapi.Integer(1, 0)),
+		openapi.Query("per_page", "page size", openapi.Integer(1, apiMaxPerPage)),
+	}
+	period := openapi.String(`^\d{4}-(0[1-9]|1[0-2])$`)
+
+	return []apiRoute{
+		{op("GET", "/reports", "listReports", "List reports", "reports", nil, http.StatusOK, ReportList{},
+			append(page,
+				openapi.Query("project", "project code", openapi.String("")),
+				openapi.Query("ship", "ship name or alias", openapi.String("")),
+				openapi.Query("period_from", "first month (YYYY-MM)", period),
+				openapi.Query("period_to", "last month (YYYY-MM), inclusive", period),
+				openapi.Query("sensor", "sensor code", openapi.String("")),
+				openapi.Query("status", "sensor status; without sensor any sensor matches", openapi.Enum("online", "offline")),
+			)...), APIListReports},
+		{op("POST", "/reports", "createReport", "Create a report", "reports", ReportCreateRequest{}, http.StatusCreated, ReportResource{}), APICreateReport},
+		{op("GET", "/reports/:id", "getReport", "Get a report", "reports", nil, http.StatusOK, ReportResource{}), APIGetReport},
+		{op("PATCH", "/reports/:id", "updateReport", "Update a report", "reports", ReportPatchRequest{}, http.StatusOK, ReportResource{}), APIUpdateReport},
+		{op("DELETE", "/reports/:id", "deleteReport", "Delete a report", "reports", nil, http.StatusNoContent, nil), APIDeleteReport},
+
+		{op("GET", "/ships", "listShips", "List ships", "ships", nil, http.StatusOK, ShipList{}), APIListShips},
+		{op("POST", "/ships", "createShip", "Register a ship", "ships", ShipCreateRequest{}, http.StatusCreated, ShipResource{}), APICreateShip},
+		{op("GET", "/ships/:id", "getShip", "Get a ship", "ships", nil, http.StatusOK, ShipResource{}), APIGetShip},
+		{op("PATCH", "/ships/:id", "updateShip", "Update a ship", "ships", ShipPatchRequest{}, http.StatusOK, ShipResource{}), APIUpdateShip},
+		{op("DELETE", "/ships/:id", "deleteShip", "Delete a ship without reports", "ships", nil, http.StatusNoContent, nil), APIDeleteShip},
+		{op("GET", "/ships/:id/sensors", "listShipSensors", "Effective sensor configuration of a ship", "ships", nil, http.StatusOK, ShipSensorList{}), APIListShipSensors},
+		{op("PATCH", "/ships/:id/sensors", "patchShipSensors", "Set or clear several sensor overrides", "ships", map[string]*bool{}, http.StatusOK, ShipSensorList{}), APIPatchShipSensors},
+		{op("PUT", "/ships/:id/sensors/:code", "setShipSensor", "Set a sensor override", "ships", ShipSensorRequest{}, http.StatusOK, ShipSensorList{}), APISetShipSensor},
+		{op("DELETE", "/ships/:id/sensors/:code", "clearShipSensor", "Remove a sensor override", "ships", nil, http.StatusOK, ShipSensorList{}), APIDeleteShipSensor},
+		{op("GET", "/ships/:id/readings", "listShipReadings", "Downsampled sensor values of a ship", "readings", nil, http.StatusOK, ReadingSeries{},
+			openapi.Query("sensor", "sensor code, required", openapi.String("")),
+			openapi.Query("from", "start of the range (RFC 3339), default 24 hours before to", openapi.String("")),
+			openapi.Query("to", "end of the range (RFC 3339), default now", openapi.String("")),
+			openapi.Query("resolution", "raw values or hourly/daily rollups; auto picks by range and retention", openapi.Enum("auto", "raw", "hour", "day")),
+		), APIShipReadings},
+
+		{op("GET", "/sensors", "listSensors", "List sensors", "sensors", nil, http.StatusOK, SensorList{}), APIListSensors},
+		{op("POST", "/sensors", "createSensor", "Add a sensor", "sensors", SensorCreateRequest{}, http.StatusCreated, SensorResource{}), APICreateSensor},
+		{op("GET", "/sensors/:code", "getSensor", "Get a sensor", "sensors", nil, http.StatusOK, SensorResource{}), APIGetSensor},
+		{op("PATCH", "/sensors/:code", "updateSensor", "Update a sensor", "sensors", SensorPatchRequest{}, http.StatusOK, SensorResource{}), APIUpdateSensor},
+		{op("DELETE", "/sensors/:code", "deleteSensor", "Delete an unused sensor", "sensors", nil, http.StatusNoContent, nil), APIDeleteSensor},
+
+		{op("GET", "/projects", "listProjects", "List projects", "projects", nil, http.StatusOK, ProjectList{}), APIListProjects},
+		{op("POST", "/projects", "createProject", "Add a project", "projects", ProjectCreateRequest{}, http.StatusCreated, ProjectResource{}), APICreateProject},
+		{op("GET", "/projects/:code", "getProject", "Get a project", "projects", nil, http.StatusOK, ProjectResource{}), APIGetProject},
+		{op("PATCH", "/projects/:code", "updateProject", "Update a project", "projects", ProjectPatchRequest{}, http.StatusOK, ProjectResource{}), APIUpdateProject},
+		{op("DELETE", "/projects/:code", "deleteProject", "Delete an unused project", "projects", nil, http.StatusNoContent, nil), APIDeleteProject},
+
+		{deviceAuth(op("POST", "/ingest", "ingestDevice", "Roll a batch of gateway samples into period reports", "ingestion", DeviceIngestRequest{}, http.StatusOK, DeviceIngestResult{})), APIIngestDevice},
+	}
+}
+
+// op declares an operation answering status with response on success. Endpoints with a
+// path parameter may answer 404, endpoints that write may answer 409 and 422.
+func op(method, path, id, summary, tag string, body any, status int, response any, query ...openapi.Param) openapi.Operation {
+	responses := map[int]any{status: response}
+	for _, code := range apiCommonErrors {
+		responses[code] = APIErrorResponse{}
+	}
+	if strings.Contains(path, ":") {
+		responses[http.StatusNotFound] = APIErrorResponse{}
+	}
+	if method != http.MethodGet {
+		responses[http.StatusConflict] = APIErrorResponse{}
+		responses[http.StatusUnprocessableEntity] = APIErrorResponse{}
+	}
+	return openapi.Operation{
+		Method: method, Path: path, ID: id, Summary: summary, Tag: tag,
+		Query: query, Body: body, Responses: responses,
+	}
+}
+
+// deviceAuth marks an operation that requires a device token
+func deviceAuth(o openapi.Operation) openapi.Operation {
+	o.Responses[http.StatusUnauthorized] = APIErrorResponse{}
+	return o
+}
+
+var (
+	apiSpecOnce sync.Once
+	apiSpec     *openapi.Spec
+)
+
+// APISpec is the OpenAPI document of /api/v1, generated from apiRoutes on first use
+func APISpec() *openapi.Spec {
+	apiSpecOnce.Do(func() {
+		apiSpec = openapi.New("FMS API", "1.0.0", apiPrefix)
+		for _, r := range apiRoutes() {
+			apiSpec.Add(r.Operation)
+		}
+	})
+	return apiSpec
+}
+
+// RegisterAPI mounts every /api/v1 route behind the request validation middleware
+func RegisterAPI(r gin.IRouter) {
+	spec := APISpec()
+	v1 := r.Group(apiPrefix, spec.Middleware(func(c *gin.Context, err *openapi.ValidationError) {
+		c.JSON(http.StatusBadRequest, APIErrorResponse{Error: APIError{
+			Code: "schema_violation", Message: "request does not match the API schema", Fields: err.Fields,
+		}})
+	}))
+	for _, route := range apiRoutes() {
+		v1.Handle(route.Method, route.Path, route.Handler)
+	}
+	r.GET("/api/openapi.json", spec.Handler())
+}
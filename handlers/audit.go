@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+
+	"fms-app/db"
+	"fms-app/events"
+)
+
+// RecordAudit stores every domain event in fms_audit_log
+func RecordAudit(e events.Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.Println("audit: marshal:", err)
+		return
+	}
+	_, err = db.DB.Exec("INSERT INTO fms_audit_log (event, payload) VALUES ($1, $2)", e.EventName(), payload)
+	if err != nil {
+		log.Println("audit: insert:", err)
+	}
+}
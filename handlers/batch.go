@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"fms-app/db"
+	"fms-app/events"
 	"fmt"
 	"log"
 	"net/http"
@@ -27,13 +28,12 @@ type ShipBatchRow struct {
 	Config map[string]bool
 }
 
-// BatchInputPage renders the batch input form as a checkbox matrix
-func BatchInputPage(c *gin.Context) {
+// loadBatchMatrix returns the active sensors and every ship with the sensors that apply to it
+func loadBatchMatrix() ([]SensorColumn, []ShipBatchRow, error) {
 	// 1. Get All Active Global Sensors (Sorted) is_active=true
 	sRows, err := db.DB.Query("SELECT code, name FROM fms_sensor_config WHERE is_active = true ORDER BY display_order ASC")
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Error fetching sensors: %v", err)
-		return
+		return nil, nil, fmt.Errorf("Error fetching sensors: %v", err)
 	}
 	defer sRows.Close()
 
@@ -45,10 +45,9 @@ func BatchInputPage(c *gin.Context) {
 	}
 
 	// 2. Get All Active Ships (Rows)
-	rows, err := db.DB.Query("SELECT id, name, code FROM fms_ships ORDER BY name ASC")
+	rows, err := db.DB.Query("SELECT id, name, COALESCE(code, '') FROM fms_ships ORDER BY name ASC")
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Error fetching ships: %v", err)
-		return
+		return nil, nil, fmt.Errorf("Error fetching ships: %v", err)
 	}
 	defer rows.Close()
 
@@ -90,22 +89,36 @@ func BatchInputPage(c *gin.Context) {
 			ships[i].Config[col.Code] = allowed
 		}
 	}
+	return columns, ships, nil
+}
 
-	// 5. Get Active Projects for Dropdown
-	type Project struct {
-		Code string
-		Name string
-	}
-	var projects []Project
+// BatchProject is a project in the batch form dropdown
+type BatchProject struct {
+	Code string
+	Name string
+}
+
+func activeBatchProjects() []BatchProject {
+	var projects []BatchProject
 	pRows, err := db.DB.Query("SELECT code, name FROM fms_projects WHERE is_active = true ORDER BY name ASC")
 	if err == nil {
 		defer pRows.Close()
 		for pRows.Next() {
-			var p Project
+			var p BatchProject
 			pRows.Scan(&p.Code, &p.Name)
 			projects = append(projects, p)
 		}
 	}
+	return projects
+}
+
+// BatchInputPage renders the batch input form as a checkbox matrix
+func BatchInputPage(c *gin.Context) {
+	columns, ships, err := loadBatchMatrix()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
 
 	currentTime := time.Now()
 	currentPeriod := currentTime.Format("2006-01")
@@ -113,7 +126,7 @@ func BatchInputPage(c *gin.Context) {
 	c.HTML(http.StatusOK, "batch_input.html", gin.H{
 		"Ships":         ships,
 		"Columns":       columns,
-		"Projects":      projects,
+		"Projects":      activeBatchProjects(),
 		"CurrentPeriod": currentPeriod,
 		"ActiveTab":     "batch",
 		"Logo":          GetCompanyLogo(),
@@ -130,6 +143,37 @@ func BatchSubmit(c *gin.Context) {
 		return
 	}
 
+	// Only selected ships are reported; an unchecked sensor is offline
+	selected := make(map[int]map[string]bool)
+	for key, values := range c.Request.PostForm {
+		if !strings.HasPrefix(key, "status_") || len(values) == 0 || values[0] != "on" {
+			continue
+		}
+		sid, err := strconv.Atoi(strings.TrimPrefix(key, "status_"))
+		if err != nil {
+			continue
+		}
+		selected[sid] = make(map[string]bool)
+		prefix := fmt.Sprintf("sensor_%d_", sid)
+		for field, v := range c.Request.PostForm {
+			if strings.HasPrefix(field, prefix) && len(v) > 0 && v[0] == "on" {
+				selected[sid][strings.TrimPrefix(field, prefix)] = true
+			}
+		}
+	}
+
+	count, err := saveBatch(projectCode, reportPeriod, selected)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/batch-input?success=Batch+sukses!+%d+laporan+disimpan.✅", count))
+}
+
+// saveBatch stores one report per selected ship (ship ID -> online sensors) for the
+// period in a single transaction and publishes the events once it has committed
+func saveBatch(projectCode, reportPeriod string, selected map[int]map[string]bool) (int, error) {
 	periodDate, err := time.Parse("2006-01", reportPeriod)
 	reportDateStr := reportPeriod + "-01"
 	reportCodeSuffix := ""
@@ -138,30 +182,38 @@ func BatchSubmit(c *gin.Context) {
 	}
 
 	// We need Ship CODE for unique report code generation
-	rows, err := db.DB.Query("SELECT id, name, code FROM fms_ships")
+	rows, err := db.DB.Query("SELECT id, name, COALESCE(code, '') FROM fms_ships")
 	if err != nil {
-		c.String(http.StatusInternalServerError, "DB Error: %v", err)
-		return
+		return 0, fmt.Errorf("DB Error: %v", err)
 	}
 	defer rows.Close()
 
 	tx, err := db.DB.Begin()
 	if err != nil {
-		c.String(http.StatusInternalServerError, "DB Error: %v", err)
-		return
+		return 0, fmt.Errorf("DB Error: %v", err)
 	}
 	defer tx.Rollback()
 
+	names, err := shipNameIndex(tx)
+	if err != nil {
+		return 0, fmt.Errorf("DB Error: %v", err)
+	}
+
 	count := 0
 
+	// Events are published only after the batch is committed
+	var created []events.ReportCreated
+
+	reportDate, _ := time.Parse("2006-01-02", reportDateStr)
+
 	for rows.Next() {
 		var sid int
 		var sName, sCode string
 		rows.Scan(&sid, &sName, &sCode)
-		sidStr := strconv.Itoa(sid)
 
 		// Only process selected ships
-		if c.PostForm("status_"+sidStr) != "on" {
+		online, ok := selected[sid]
+		if !ok {
 			continue
 		}
 
@@ -169,72 +221,36 @@ func BatchSubmit(c *gin.Context) {
 		fullCode := fmt.Sprintf("%s %s %s", projectCode, sCode, reportCodeSuffix)
 		fullCode = strings.TrimSpace(fullCode)
 
-		sensorsStatus := make(map[string]bool)
-
-		// Fetch dynamic sensor list
-		sConfigRows, _ := db.DB.Query("SELECT code FROM fms_sensor_config")
-		var allCodes []string
-		if sConfigRows != nil {
-			for sConfigRows.Next() {
-				var sc string
-				sConfigRows.Scan(&sc)
-				allCodes = append(allCodes, sc)
-			}
-			sConfigRows.Close()
+		// Map checkboxes to JSON status, only for the sensors active on this ship:
+		// the others are locked in the form and must not open offline alerts
+		shipSensors, err := loadShipSensors(tx, sid)
+		if err != nil {
+			return 0, fmt.Errorf("DB Error: %v", err)
 		}
-
-		// Prepare variables for INSERT
-		// We will insert 0 for numeric columns.
-		// 'gps' column appears to be BOOLEAN based on error message.
-		var devCond, gpsStatus bool
-
-		// Map checkboxes to JSON status
-		for _, code := range allCodes {
-			inputName := fmt.Sprintf("sensor_%d_%s", sid, code)
-			val := c.PostForm(inputName)
-			isOn := (val == "on")
-
-			// Store real status in JSON map
-			sensorsStatus[code] = isOn
-
-			// Specific mapping for standard columns
-			if code == "device_condition" {
-				devCond = isOn
-			}
-
-			if code == "gps" {
-				gpsStatus = isOn
+		sensorsStatus := make(map[string]bool)
+		for _, s := range shipSensors {
+			if s.Active {
+				sensorsStatus[s.Code] = online[s.Code]
 			}
-
-			// For RPM and Flowmeters (NUMERIC), we leave them as 0.
-		}
-
-		jsonParts := []string{}
-		for k, v := range sensorsStatus {
-			jsonParts = append(jsonParts, fmt.Sprintf(`"%s": %v`, k, v))
 		}
-		jsonStr := "{" + strings.Join(jsonParts, ",") + "}"
-
-		// Use 0 for numeric columns to avoid type errors
-		_, err := tx.Exec(`
-			INSERT INTO fms_device_reports 
-			(code, report_date, ship_name, device_condition, gps, rpm_me_port, rpm_me_stbd, flowmeter_input, flowmeter_output, flowmeter_bunker, sensors_data)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		`, fullCode, reportDateStr, sName, devCond, gpsStatus, 0, 0, 0, 0, 0, jsonStr)
 
+		report := DeviceReport{Code: fullCode, ReportDate: reportDate, ShipName: sName, SensorsData: sensorsStatus}
+		ev, err := insertReport(tx, names, &report, projectCode)
 		if err != nil {
 			log.Printf("Batch Insert Error %s: %v", sName, err)
 			// Return error to user instead of breaking transaction silently
-			c.String(http.StatusInternalServerError, "Gagal menyimpan laporan untuk %s. \nError: %v. \nKemungkinan duplikat laporan untuk periode ini.", sName, err)
-			return
+			return 0, fmt.Errorf("Gagal menyimpan laporan untuk %s. \nError: %v. \nKemungkinan duplikat laporan untuk periode ini.", sName, err)
 		}
+		created = append(created, ev)
 		count++
 	}
 
 	if err := tx.Commit(); err != nil {
-		c.String(http.StatusInternalServerError, "Commit Error: %v", err)
-		return
+		return 0, fmt.Errorf("Commit Error: %v", err)
 	}
 
-	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/batch-input?success=Batch+sukses!+%d+laporan+disimpan.✅", count))
+	for _, ev := range created {
+		events.Publish(ev)
+	}
+	return count, nil
 }
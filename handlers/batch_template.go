@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/xuri/excelize/v2"
+)
+
+// Layout of the offline batch template. Row 4 is hidden and carries the sensor codes so
+// renamed sensors still map back; ships start below the header row.
+const (
+	batchSheet     = "Batch"
+	batchCodesRow  = 4
+	batchHeaderRow = 5
+	batchFirstCol  = 4 // sensors start at column D
+	batchInactive  = "✕"
+	batchMaxErrors = 5
+)
+
+// DownloadBatchTemplate serves the batch matrix as an XLSX file to fill in offline
+func DownloadBatchTemplate(c *gin.Context) {
+	columns, ships, err := loadBatchMatrix()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	project := c.Query("project_code")
+	if project == "" {
+		if projects := activeBatchProjects(); len(projects) > 0 {
+			project = projects[0].Code
+		}
+	}
+	period := c.Query("report_period")
+	if _, err := time.Parse("2006-01", period); err != nil {
+		period = time.Now().Format("2006-01")
+	}
+
+	f, err := buildBatchTemplate(project, period, columns, ships)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", xlsxContentType)
+	attachment(c, fmt.Sprintf("Batch %s %s.xlsx", project, period))
+	if _, err := f.WriteTo(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
+
+func buildBatchTemplate(project, period string, columns []SensorColumn, ships []ShipBatchRow) (*excelize.File, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", batchSheet); err != nil {
+		return nil, err
+	}
+	styles, err := newReportStyles(f)
+	if err != nil {
+		return nil, err
+	}
+	border := []excelize.Border{
+		{Type: "left", Color: "E2E8F0", Style: 1},
+		{Type: "right", Color: "E2E8F0", Style: 1},
+		{Type: "top", Color: "E2E8F0", Style: 1},
+		{Type: "bottom", Color: "E2E8F0", Style: 1},
+	}
+	input, err := f.NewStyle(&excelize.Style{
+		Border:     border,
+		Alignment:  &excelize.Alignment{Horizontal: "center"},
+		Protection: &excelize.Protection{Locked: false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	field, err := f.NewStyle(&excelize.Style{
+		Border:     border,
+		Fill:       excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FEF9C3"}},
+		NumFmt:     49, // text, so Excel keeps "2025-01" as typed
+		Protection: &excelize.Protection{Locked: false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	inactive, err := f.NewStyle(&excelize.Style{
+		Border:    border,
+		Font:      &excelize.Font{Color: "94A3B8"},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"F1F5F9"}},
+		Alignment: &excelize.Alignment{Horizontal: "center"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_ = f.SetCellValue(batchSheet, "A1", "Template Batch Sensor Status")
+	_ = f.SetCellStyle(batchSheet, "A1", "A1", styles.title)
+	_ = f.SetCellValue(batchSheet, "A2", "Project")
+	_ = f.SetCellValue(batchSheet, "B2", project)
+	_ = f.SetCellValue(batchSheet, "A3", "Periode (YYYY-MM)")
+	_ = f.SetCellValue(batchSheet, "B3", period)
+	_ = f.SetCellStyle(batchSheet, "B2", "B3", field)
+	_ = f.SetCellValue(batchSheet, "D2", "Isi Online/Offline (atau 1/0) per sensor. Kapal tanpa isian tidak dilaporkan; "+
+		"sel kosong pada kapal yang diisi dianggap Offline. "+batchInactive+" = sensor tidak berlaku untuk kapal.")
+
+	// Hidden key row and visible header
+	_ = f.SetCellValue(batchSheet, cell(1, batchCodesRow), "id")
+	header := []string{"ID", "Nama Kapal", "Kode"}
+	for i, h := range header {
+		_ = f.SetCellValue(batchSheet, cell(i+1, batchHeaderRow), h)
+	}
+	for i, col := range columns {
+		_ = f.SetCellValue(batchSheet, cell(batchFirstCol+i, batchCodesRow), col.Code)
+		_ = f.SetCellValue(batchSheet, cell(batchFirstCol+i, batchHeaderRow), col.Name)
+	}
+	lastCol := batchFirstCol + len(columns) - 1
+	if lastCol < 3 {
+		lastCol = 3
+	}
+	_ = f.SetRowVisible(batchSheet, batchCodesRow, false)
+	_ = f.SetCellStyle(batchSheet, cell(1, batchHeaderRow), cell(lastCol, batchHeaderRow), styles.header)
+	_ = f.SetRowHeight(batchSheet, batchHeaderRow, 32)
+
+	for i, ship := range ships {
+		row := batchHeaderRow + 1 + i
+		_ = f.SetCellValue(batchSheet, cell(1, row), ship.ID)
+		_ = f.SetCellValue(batchSheet, cell(2, row), ship.Name)
+		_ = f.SetCellValue(batchSheet, cell(3, row), ship.Code)
+		_ = f.SetCellStyle(batchSheet, cell(1, row), cell(3, row), styles.body)
+		for j, col := range columns {
+			ref := cell(batchFirstCol+j, row)
+			if ship.Config[col.Code] {
+				_ = f.SetCellStyle(batchSheet, ref, ref, input)
+			} else {
+				_ = f.SetCellValue(batchSheet, ref, batchInactive)
+				_ = f.SetCellStyle(batchSheet, ref, ref, inactive)
+			}
+		}
+	}
+
+	if len(ships) > 0 && len(columns) > 0 {
+		dv := excelize.NewDataValidation(true)
+		dv.Sqref = cell(batchFirstCol, batchHeaderRow+1) + ":" + cell(lastCol, batchHeaderRow+len(ships))
+		if err := dv.SetDropList([]string{"Online", "Offline"}); err != nil {
+			return nil, err
+		}
+		// A warning rather than a stop so 1/0 can still be typed
+		dv.SetError(excelize.DataValidationErrorStyleWarning, "Nilai sensor", "Gunakan Online/Offline atau 1/0")
+		if err := f.AddDataValidation(batchSheet, dv); err != nil {
+			return nil, err
+		}
+	}
+
+	_ = f.SetColWidth(batchSheet, "A", "A", 8)
+	_ = f.SetColWidth(batchSheet, "B", "B", 30)
+	_ = f.SetColWidth(batchSheet, "C", "C", 12)
+	if len(columns) > 0 {
+		first, _ := excelize.ColumnNumberToName(batchFirstCol)
+		last, _ := excelize.ColumnNumberToName(lastCol)
+		_ = f.SetColWidth(batchSheet, first, last, 14)
+	}
+	_ = f.SetPanes(batchSheet, &excelize.Panes{
+		Freeze: true, XSplit: 3, YSplit: batchHeaderRow,
+		TopLeftCell: cell(batchFirstCol, batchHeaderRow+1), ActivePane: "bottomRight",
+	})
+
+	// Only the yellow fields and applicable sensor cells can be edited
+	if err := f.ProtectSheet(batchSheet, &excelize.SheetProtectionOptions{
+		SelectLockedCells:   true,
+		SelectUnlockedCells: true,
+		FormatColumns:       true,
+	}); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// UploadBatchTemplate validates a filled batch template and saves it through the same
+// path as the batch form. Any error rejects the whole file.
+func UploadBatchTemplate(c *gin.Context) {
+	fail := func(msg string) {
+		c.Redirect(http.StatusSeeOther, "/batch-input?error="+url.QueryEscape(msg))
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		fail("File belum dipilih")
+		return
+	}
+	if fh.Size > maxImportSize {
+		fail("File terlalu besar (maks 10 MB)")
+		return
+	}
+	src, err := fh.Open()
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	defer src.Close()
+	data, err := io.ReadAll(src)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+
+	project, period, selected, errs := parseBatchTemplate(data)
+	if len(errs) > 0 {
+		msg := strings.Join(errs[:min(len(errs), batchMaxErrors)], "; ")
+		if len(errs) > batchMaxErrors {
+			msg += fmt.Sprintf(" (+%d lainnya)", len(errs)-batchMaxErrors)
+		}
+		fail(msg)
+		return
+	}
+	if len(selected) == 0 {
+		fail("Template belum diisi")
+		return
+	}
+
+	count, err := saveBatch(project, period, selected)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/batch-input?success=Template+diimpor!+%d+laporan+disimpan.✅", count))
+}
+
+// parseBatchTemplate reads the project, period and filled ship rows of a template,
+// checking them against the current matrix and the reports already stored
+func parseBatchTemplate(data []byte) (project, period string, selected map[int]map[string]bool, errs []string) {
+	failf := func(format string, args ...any) { errs = append(errs, fmt.Sprintf(format, args...)) }
+
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		failf("XLSX tidak valid: %v", err)
+		return
+	}
+	defer f.Close()
+	if idx, _ := f.GetSheetIndex(batchSheet); idx < 0 {
+		failf("Sheet %s tidak ditemukan, gunakan template dari halaman Batch Input", batchSheet)
+		return
+	}
+	rows, err := f.GetRows(batchSheet, excelize.Options{RawCellValue: true})
+	if err != nil || len(rows) < batchHeaderRow {
+		failf("Template tidak valid")
+		return
+	}
+	codes := rows[batchCodesRow-1]
+	if cellAt(codes, 0) != "id" {
+		failf("Baris kode sensor hilang, unduh ulang template")
+		return
+	}
+
+	// Project and period
+	project = strings.ToUpper(cellAt(rows[1], 1))
+	if project != "" {
+		var active bool
+		if db.DB.QueryRow("SELECT is_active FROM fms_projects WHERE code = $1", project).Scan(&active) != nil || !active {
+			failf("Project tidak dikenal: %s", project)
+		}
+	}
+	p, err := parseImportPeriod(cellAt(rows[2], 1))
+	if err != nil {
+		failf("%v", err)
+	}
+	period = p.Format("2006-01")
+
+	columns, ships, err := loadBatchMatrix()
+	if err != nil {
+		failf("%v", err)
+		return
+	}
+	active := map[string]bool{}
+	for _, col := range columns {
+		active[col.Code] = true
+	}
+	byID := map[int]ShipBatchRow{}
+	for _, s := range ships {
+		byID[s.ID] = s
+	}
+
+	selected = make(map[int]map[string]bool)
+	reportCodes := map[int]string{}
+	for i, row := range rows[batchHeaderRow:] {
+		line := batchHeaderRow + 1 + i
+		idText := cellAt(row, 0)
+		if idText == "" {
+			continue
+		}
+		id, err := strconv.Atoi(idText)
+		ship, known := byID[id]
+		if err != nil || !known {
+			failf("Baris %d: kapal dengan ID %s tidak ditemukan", line, idText)
+			continue
+		}
+
+		online := map[string]bool{}
+		filled := false
+		for j := batchFirstCol - 1; j < len(row); j++ {
+			v := cellAt(row, j)
+			code := cellAt(codes, j)
+			if v == "" || code == "" {
+				continue
+			}
+			if !active[code] {
+				failf("Baris %d: sensor %s sudah tidak aktif, unduh ulang template", line, code)
+				continue
+			}
+			if !ship.Config[code] {
+				if v != batchInactive {
+					failf("Baris %d: sensor %s tidak berlaku untuk %s", line, code, ship.Name)
+				}
+				continue
+			}
+			status, ok := parseSensorValue(v)
+			if !ok {
+				failf("Baris %d: nilai %s tidak valid: %q", line, code, v)
+				continue
+			}
+			online[code] = status
+			filled = true
+		}
+		if filled {
+			selected[id] = online
+			reportCodes[id] = reportCode(project, ship.Code, p)
+		}
+	}
+
+	// Reports already stored for this period
+	if len(reportCodes) > 0 && len(errs) == 0 {
+		var list []string
+		for _, code := range reportCodes {
+			list = append(list, code)
+		}
+		existing := map[string]bool{}
+		eRows, err := db.DB.Query("SELECT ship_name, code FROM fms_device_reports WHERE code = ANY($1)", pq.Array(list))
+		if err != nil {
+			failf("%v", err)
+			return
+		}
+		for eRows.Next() {
+			var ship, code string
+			if eRows.Scan(&ship, &code) == nil {
+				existing[ship+"|"+code] = true
+			}
+		}
+		eRows.Close()
+		for _, ship := range ships {
+			if code, ok := reportCodes[ship.ID]; ok && existing[ship.Name+"|"+code] {
+				failf("Laporan %s periode %s sudah ada", ship.Name, period)
+			}
+		}
+	}
+	return
+}
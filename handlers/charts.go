@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fms-app/charts"
+	"fms-app/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const chartWidth = 800
+
+// periodAvailability holds online percentages for the last periods of a project, oldest first
+type periodAvailability struct {
+	Periods []string
+	Fleet   []float64
+	Ships   []string
+	ByShip  [][]float64 // [ship][period], charts.Missing when the ship did not report
+}
+
+// loadPeriodAvailability aggregates the last n periods (report codes) of a project
+func loadPeriodAvailability(project string, n int) (periodAvailability, error) {
+	var pa periodAvailability
+
+	rows, err := db.DB.Query(`
+		SELECT code FROM fms_device_reports
+		WHERE code LIKE $1
+		GROUP BY code
+		ORDER BY MIN(report_date) DESC
+		LIMIT $2
+	`, project+" %", n)
+	if err != nil {
+		return pa, err
+	}
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err == nil {
+			codes = append([]string{code}, codes...)
+		}
+	}
+	rows.Close()
+	if len(codes) == 0 {
+		return pa, nil
+	}
+
+	period := make(map[string]int, len(codes))
+	for i, code := range codes {
+		period[code] = i
+		pa.Periods = append(pa.Periods, strings.TrimSpace(strings.TrimPrefix(code, project)))
+	}
+
+	rRows, err := db.DB.Query(`
+		SELECT `+reportColumns+`
+		FROM fms_device_reports
+		WHERE code = ANY($1)
+		ORDER BY ship_name ASC, report_date ASC
+	`, pq.Array(codes))
+	if err != nil {
+		return pa, err
+	}
+	defer rRows.Close()
+
+	online := make([]int, len(codes))
+	total := make([]int, len(codes))
+	shipIndex := map[string]int{}
+	shipOnline := map[string][]int{}
+	shipTotal := map[string][]int{}
+
+	cur := reportCursor{rRows}
+	for {
+		r, ok, err := cur.next()
+		if err != nil {
+			return pa, err
+		}
+		if !ok {
+			break
+		}
+		i := period[r.Code]
+		online[i] += r.OnlineTotal
+		total[i] += r.OnlineTotal + r.OfflineTotal
+
+		if _, seen := shipIndex[r.ShipName]; !seen {
+			shipIndex[r.ShipName] = len(pa.Ships)
+			pa.Ships = append(pa.Ships, r.ShipName)
+			shipOnline[r.ShipName] = make([]int, len(codes))
+			shipTotal[r.ShipName] = make([]int, len(codes))
+		}
+		shipOnline[r.ShipName][i] += r.OnlineTotal
+		shipTotal[r.ShipName][i] += r.OnlineTotal + r.OfflineTotal
+	}
+
+	for i := range codes {
+		pa.Fleet = append(pa.Fleet, ratio(online[i], total[i])*100)
+	}
+	for _, ship := range pa.Ships {
+		values := make([]float64, len(codes))
+		for i := range codes {
+			values[i] = charts.Missing
+			if shipTotal[ship][i] > 0 {
+				values[i] = ratio(shipOnline[ship][i], shipTotal[ship][i]) * 100
+			}
+		}
+		pa.ByShip = append(pa.ByShip, values)
+	}
+	return pa, nil
+}
+
+// sensorBars returns the online percentage per sensor over the given reports
+func sensorBars(reports []DeviceReport, sensors []SensorConfig) []charts.Bar {
+	bars := make([]charts.Bar, 0, len(sensors))
+	for _, s := range sensors {
+		var online, total int
+		for _, r := range reports {
+			if status, ok := r.SensorsData[s.Code]; ok {
+				total++
+				if status {
+					online++
+				}
+			}
+		}
+		bars = append(bars, charts.Bar{Label: s.Name, Value: ratio(online, total) * 100})
+	}
+	return bars
+}
+
+// trendChart builds the fleet availability line chart for a project
+func trendChart(project string, pa periodAvailability) charts.LineChart {
+	return charts.LineChart{
+		Title:  "Tren Ketersediaan " + project,
+		Labels: pa.Periods,
+		Values: pa.Fleet,
+		Width:  chartWidth,
+	}
+}
+
+// heatmapChart builds the ship x period availability heatmap for a project
+func heatmapChart(project string, pa periodAvailability) charts.Heatmap {
+	return charts.Heatmap{
+		Title:  "Ketersediaan per Kapal " + project,
+		Rows:   pa.Ships,
+		Cols:   pa.Periods,
+		Values: pa.ByShip,
+		Width:  chartWidth,
+		LabelW: 200,
+	}
+}
+
+// chartProject returns ?project= or the project part of a report code filter
+func chartProject(c *gin.Context, code string) string {
+	if p := c.Query("project"); p != "" {
+		return strings.ToUpper(p)
+	}
+	fields := strings.FieldsFunc(code, func(r rune) bool { return r == ' ' || r == '%' })
+	if len(fields) == 0 {
+		return "FMS"
+	}
+	return strings.ToUpper(fields[0])
+}
+
+func chartPeriods(c *gin.Context, def int) int {
+	n, err := strconv.Atoi(c.Query("periods"))
+	if err != nil || n < 1 || n > 36 {
+		return def
+	}
+	return n
+}
+
+func writeSVG(c *gin.Context, ch charts.Chart) {
+	c.Header("Cache-Control", "max-age=300")
+	c.Data(http.StatusOK, "image/svg+xml", charts.SVG(ch))
+}
+
+// ChartTrendSVG renders the fleet availability trend of a project: ?project=FMS&periods=12
+func ChartTrendSVG(c *gin.Context) {
+	project := chartProject(c, "")
+	pa, err := loadPeriodAvailability(project, chartPeriods(c, 12))
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	writeSVG(c, trendChart(project, pa))
+}
+
+// ChartSensorsSVG renders per-sensor availability for a period, same filters as /report
+func ChartSensorsSVG(c *gin.Context) {
+	code := monthlyReportCode(c)
+	reports, err := loadMonthlyReports(code)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	sensors, err := activeSensors()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	writeSVG(c, charts.BarChart{
+		Title: "Ketersediaan per Sensor " + reportTitle(code),
+		Bars:  sensorBars(reports, sensors),
+		Width: chartWidth,
+	})
+}
+
+// ChartHeatmapSVG renders ship availability over the last periods: ?project=FMS&periods=6
+func ChartHeatmapSVG(c *gin.Context) {
+	project := chartProject(c, "")
+	pa, err := loadPeriodAvailability(project, chartPeriods(c, 6))
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	writeSVG(c, heatmapChart(project, pa))
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"fms-app/config"
+	"fms-app/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportConfig downloads the sensor, project, ship and schedule configuration as a
+// document that `fms-app config apply` accepts (?format=json, YAML by default)
+func ExportConfig(c *gin.Context) {
+	doc, err := config.Load(c.Request.Context(), db.DB)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	format, contentType, filename := "yaml", "application/yaml", "fms-config.yaml"
+	if c.Query("format") == "json" {
+		format, contentType, filename = "json", "application/json", "fms-config.json"
+	}
+	out, err := config.Encode(doc, format)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, contentType, out)
+}
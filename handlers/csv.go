@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"fms-app/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// csvDelimiter returns the delimiter from ?delimiter=, falling back to CSV_DELIMITER and then ","
+// Indonesian Excel expects ";" because "," is the decimal separator
+func csvDelimiter(c *gin.Context) rune {
+	v := c.Query("delimiter")
+	if v == "" {
+		v = os.Getenv("CSV_DELIMITER")
+	}
+	switch strings.ToLower(v) {
+	case "", ",", "comma":
+		return ','
+	case ";", "semicolon":
+		return ';'
+	case "tab", "\t", `\t`:
+		return '\t'
+	case "|", "pipe":
+		return '|'
+	}
+	if r, size := utf8.DecodeRuneInString(v); size == len(v) && r != '"' && r != '\r' && r != '\n' {
+		return r
+	}
+	return ','
+}
+
+// csvWriter writes download rows with every text cell passed through csvCell
+type csvWriter struct {
+	*csv.Writer
+}
+
+func (w csvWriter) Write(record []string) error {
+	safe := make([]string, len(record))
+	for i, v := range record {
+		safe[i] = csvCell(v)
+	}
+	return w.Writer.Write(safe)
+}
+
+// csvCell keeps spreadsheet apps from running a cell as a formula (CSV injection): text
+// starting with = + - @ or a tab/CR gets a leading quote. Numbers such as -1,5 are kept.
+func csvCell(v string) string {
+	if v == "" || !strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return v
+	}
+	if _, err := strconv.ParseFloat(strings.Replace(v, ",", ".", 1), 64); err == nil {
+		return v
+	}
+	return "'" + v
+}
+
+// newCSVWriter prepares the response for a streamed CSV download. A UTF-8 BOM is written
+// so Excel picks up the encoding; rows go straight to the client as the buffer fills
+func newCSVWriter(c *gin.Context, filename string) csvWriter {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	attachment(c, filename)
+	c.Status(http.StatusOK)
+	_, _ = c.Writer.WriteString("\ufeff")
+
+	w := csv.NewWriter(c.Writer)
+	w.Comma = csvDelimiter(c)
+	return csvWriter{w}
+}
+
+// csvDecimal formats a number with the decimal separator matching the delimiter
+func csvDecimal(v float64, comma rune) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	if comma == ';' {
+		s = strings.Replace(s, ".", ",", 1)
+	}
+	return s
+}
+
+// csvDateRange reads optional from/to (YYYY-MM-DD) query params, to is inclusive
+func csvDateRange(c *gin.Context) (from, to *time.Time, err error) {
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid from date %q", v)
+		}
+		from = &t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid to date %q", v)
+		}
+		t = t.AddDate(0, 0, 1)
+		to = &t
+	}
+	return from, to, nil
+}
+
+// reportCursor runs a report query and scans rows one at a time
+type reportCursor struct {
+	rows *sql.Rows
+}
+
+func (rc reportCursor) next() (DeviceReport, bool, error) {
+	if !rc.rows.Next() {
+		return DeviceReport{}, false, rc.rows.Err()
+	}
+	r, err := scanReport(rc.rows)
+	return r, err == nil, err
+}
+
+func sensorStatusText(m map[string]bool, code string) string {
+	status, ok := m[code]
+	switch {
+	case !ok:
+		return "-"
+	case status:
+		return "Online"
+	default:
+		return "Offline"
+	}
+}
+
+// ExportReportsCSV streams the reports list as CSV. It takes the report list filters
+// (?code=, ?ship=, ?sensor=, ?status=online|offline) plus optional from/to report dates.
+func ExportReportsCSV(c *gin.Context) {
+	f := reportFilter{
+		Code:   c.Query("code"),
+		Sensor: strings.TrimSpace(c.Query("sensor")),
+		Status: c.Query("status"),
+	}
+	if f.Code == "" {
+		f.Code = time.Now().Format("FMS Jan 2006")
+	}
+	from, to, err := csvDateRange(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if from != nil {
+		f.From = *from
+	}
+	if to != nil {
+		f.To = *to
+	}
+	if f.Status != "" && f.Status != "online" && f.Status != "offline" {
+		c.String(http.StatusBadRequest, "status must be online or offline")
+		return
+	}
+	if ship := c.Query("ship"); ship != "" {
+		if f.Ship, err = resolveShipName(db.DB, ship); err != nil {
+			c.String(http.StatusInternalServerError, "Error: %v", err)
+			return
+		}
+	}
+
+	sensors, err := activeSensors()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	where, args := f.where()
+	rows, err := db.DB.QueryContext(c.Request.Context(),
+		"SELECT "+reportColumns+" FROM fms_device_reports"+where+" ORDER BY report_date ASC, ship_name ASC, id ASC",
+		args...)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	w := newCSVWriter(c, fmt.Sprintf("reports %s.csv", reportTitle(f.Code)))
+	header := []string{"ID", "Code", "Report Date", "Ship Name"}
+	for _, s := range sensors {
+		header = append(header, s.Name)
+	}
+	header = append(header, "Online", "Offline", "% Online", "Created At", "Updated At")
+	_ = w.Write(header)
+
+	cur := reportCursor{rows}
+	for {
+		r, ok, err := cur.next()
+		if err != nil {
+			c.Error(err)
+			break
+		}
+		if !ok {
+			break
+		}
+		record := []string{strconv.Itoa(r.ID), r.Code, r.ReportDate.Format("2006-01-02"), r.ShipName}
+		for _, s := range sensors {
+			record = append(record, sensorStatusText(r.SensorsData, s.Code))
+		}
+		record = append(record,
+			strconv.Itoa(r.OnlineTotal),
+			strconv.Itoa(r.OfflineTotal),
+			csvDecimal(r.OnlinePercent, w.Comma),
+			r.CreatedAt.Format("2006-01-02 15:04:05"),
+			r.UpdatedAt.Format("2006-01-02 15:04:05"),
+		)
+		if err := w.Write(record); err != nil {
+			c.Error(err)
+			break
+		}
+	}
+	w.Flush()
+}
+
+// ExportRekapCSV streams the rekap with one row per code, like the dashboard summary cards.
+// ?code= narrows the codes with a LIKE pattern
+func ExportRekapCSV(c *gin.Context) {
+	code := c.DefaultQuery("code", "%")
+
+	rows, err := db.DB.QueryContext(c.Request.Context(), `
+		SELECT `+reportColumns+`
+		FROM fms_device_reports
+		WHERE code LIKE $1
+		ORDER BY code DESC, report_date ASC
+	`, code)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	w := newCSVWriter(c, "rekap.csv")
+	_ = w.Write([]string{"Code", "Total Ships", "Total Devices", "Online", "Offline", "% Online", "% Offline"})
+
+	// Rows are ordered by code, so each summary is written as soon as its code ends
+	var current RekapSummary
+	flush := func() {
+		if current.Code == "" {
+			return
+		}
+		current.TotalDevices = current.TotalOnline + current.TotalOffline
+		if current.TotalDevices > 0 {
+			current.OnlinePercentage = float64(current.TotalOnline) / float64(current.TotalDevices) * 100
+			current.OfflinePercentage = float64(current.TotalOffline) / float64(current.TotalDevices) * 100
+		}
+		_ = w.Write([]string{
+			current.Code,
+			strconv.Itoa(current.TotalShips),
+			strconv.Itoa(current.TotalDevices),
+			strconv.Itoa(current.TotalOnline),
+			strconv.Itoa(current.TotalOffline),
+			csvDecimal(current.OnlinePercentage, w.Comma),
+			csvDecimal(current.OfflinePercentage, w.Comma),
+		})
+	}
+
+	cur := reportCursor{rows}
+	for {
+		r, ok, err := cur.next()
+		if err != nil {
+			c.Error(err)
+			break
+		}
+		if !ok {
+			break
+		}
+		if r.Code != current.Code {
+			flush()
+			current = RekapSummary{Code: r.Code}
+		}
+		current.TotalShips++
+		current.TotalOnline += r.OnlineTotal
+		current.TotalOffline += r.OfflineTotal
+	}
+	flush()
+	w.Flush()
+}
+
+// ExportTroubleCSV streams the dashboard trouble list: the latest report per ship with offline sensors
+func ExportTroubleCSV(c *gin.Context) {
+	names := sensorNames()
+
+	rows, err := db.DB.QueryContext(c.Request.Context(), `
+		SELECT DISTINCT ON (ship_name) `+reportColumns+`
+		FROM fms_device_reports
+		ORDER BY ship_name, created_at DESC
+	`)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	w := newCSVWriter(c, "trouble.csv")
+	_ = w.Write([]string{"Ship Name", "Code", "Report Date", "Offline", "Offline Sensors"})
+
+	cur := reportCursor{rows}
+	for {
+		r, ok, err := cur.next()
+		if err != nil {
+			c.Error(err)
+			break
+		}
+		if !ok {
+			break
+		}
+		if r.OfflineTotal == 0 {
+			continue
+		}
+		var offline []string
+		for code, status := range r.SensorsData {
+			if !status {
+				if name := names[code]; name != "" {
+					code = name
+				}
+				offline = append(offline, code)
+			}
+		}
+		sort.Strings(offline)
+		_ = w.Write([]string{
+			r.ShipName,
+			r.Code,
+			r.ReportDate.Format("2006-01-02"),
+			strconv.Itoa(r.OfflineTotal),
+			strings.Join(offline, ", "),
+		})
+	}
+	w.Flush()
+}
+
+// ExportAlertsCSV streams the alert history. Filters: ?status=open|resolved, ?ship=, ?from=/?to= on opened_at
+func ExportAlertsCSV(c *gin.Context) {
+	from, to, err := csvDateRange(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	status := c.Query("status")
+	names := sensorNames()
+
+	rows, err := db.DB.QueryContext(c.Request.Context(), `
+		SELECT a.id, a.ship_name, a.sensor_code, a.report_id, a.occurrences, a.escalation_level,
+		       a.opened_at, a.resolved_at, COALESCE(a.resolution_note, ''),
+		       COALESCE(r.name, ''), COALESCE(a.rule_detail, '')
+		FROM fms_alerts a
+		LEFT JOIN fms_rules r ON r.id = a.rule_id
+		WHERE ($1 = '' OR ($1 = 'open' AND a.resolved_at IS NULL) OR ($1 = 'resolved' AND a.resolved_at IS NOT NULL))
+		  AND ($2 = '' OR a.ship_name = $2)
+		  AND ($3::timestamp IS NULL OR a.opened_at >= $3)
+		  AND ($4::timestamp IS NULL OR a.opened_at < $4)
+		ORDER BY a.opened_at ASC, a.id ASC
+	`, status, c.Query("ship"), from, to)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	w := newCSVWriter(c, "alerts.csv")
+	_ = w.Write([]string{"ID", "Ship Name", "Sensor", "Report ID", "Occurrences", "Escalation Level",
+		"Opened At", "Resolved At", "Duration (hours)", "Resolution Note", "Rule", "Rule Detail"})
+
+	for rows.Next() {
+		var id, occurrences, level int
+		var ship, sensor, note, rule, detail string
+		var reportID sql.NullInt64
+		var openedAt time.Time
+		var resolvedAt *time.Time
+		if err := rows.Scan(&id, &ship, &sensor, &reportID, &occurrences, &level, &openedAt, &resolvedAt, &note, &rule, &detail); err != nil {
+			c.Error(err)
+			break
+		}
+
+		if name := names[sensor]; name != "" {
+			sensor = name
+		}
+		report, resolved, duration := "", "", ""
+		if reportID.Valid {
+			report = strconv.FormatInt(reportID.Int64, 10)
+		}
+		if resolvedAt != nil {
+			resolved = resolvedAt.Format("2006-01-02 15:04:05")
+			duration = csvDecimal(resolvedAt.Sub(openedAt).Hours(), w.Comma)
+		}
+		_ = w.Write([]string{
+			strconv.Itoa(id), ship, sensor, report,
+			strconv.Itoa(occurrences), strconv.Itoa(level),
+			openedAt.Format("2006-01-02 15:04:05"), resolved, duration, note, rule, detail,
+		})
+	}
+	w.Flush()
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import "testing"
+
+func TestCSVCell(t *testing.T) {
+	cases := map[string]string{
+		"TB CELEBES SEJATI 01":       "TB CELEBES SEJATI 01",
+		"=HYPERLINK(\"http://x\")":   "'=HYPERLINK(\"http://x\")",
+		"+62 811":                    "'+62 811",
+		"-cmd|' /C calc'!A0":         "'-cmd|' /C calc'!A0",
+		"@SUM(A1:A2)":                "'@SUM(A1:A2)",
+		"\t=1+1":                     "'\t=1+1",
+		"-1.5":                       "-1.5",
+		"-1,50":                      "-1,50",
+		"value(rpm_me_port)=850 > 0": "value(rpm_me_port)=850 > 0",
+		"":                           "",
+	}
+	for in, want := range cases {
+		got := csvCell(in)
+		if got != want {
+			t.Errorf("csvCell(%q) = %q, want %q", in, got, want)
+		}
+		if back := uncsvCell(got); back != in {
+			t.Errorf("uncsvCell(%q) = %q, want %q", got, back, in)
+		}
+	}
+}
@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"fms-app/db"
+	"fms-app/events"
+	"fms-app/outbox"
+	"fms-app/webhooks"
 
 	"github.com/gin-gonic/gin"
 )
@@ -130,17 +137,7 @@ func Dashboard(c *gin.Context) {
 	}
 
 	// Fetch sensors from DB for table headers
-	var sensors []SensorConfig
-	sRows, err := db.DB.Query("SELECT code, name FROM fms_sensor_config WHERE is_active = true ORDER BY display_order ASC")
-	if err == nil {
-		defer sRows.Close()
-		for sRows.Next() {
-			var s SensorConfig
-			if err := sRows.Scan(&s.Code, &s.Name); err == nil {
-				sensors = append(sensors, s)
-			}
-		}
-	}
+	sensors, _ := activeSensors()
 
 	// Fetch trouble reports (latest report per ship with offline sensors)
 	var troubleReports []DeviceReport
@@ -250,16 +247,29 @@ func ResolveAlert(c *gin.Context) {
 		return
 	}
 
-	// 1. Fetch current data
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update"})
+		return
+	}
+	defer tx.Rollback()
+
+	// 1. Fetch current data (locked until commit)
 	var sensorsJson []byte
-	err := db.DB.QueryRow(`
-		SELECT sensors_data 
+	var shipName string
+	err = tx.QueryRow(`
+		SELECT sensors_data, ship_name
 		FROM fms_device_reports 
 		WHERE id = $1
-	`, idStr).Scan(&sensorsJson)
+		FOR UPDATE
+	`, idStr).Scan(&sensorsJson, &shipName)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Report not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update"})
 		return
 	}
 
@@ -297,23 +307,117 @@ func ResolveAlert(c *gin.Context) {
 		query += ` WHERE id = $2`
 	}
 
-	_, err = db.DB.Exec(query, args...)
+	query += ` RETURNING updated_at`
+
+	var updatedAt time.Time
+	err = tx.QueryRow(query, args...).Scan(&updatedAt)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update"})
+		return
+	}
+
+	// Close the open alert with the optional resolution note. Without one there is nothing
+	// to announce: the transaction is rolled back and no event or audit entry is written.
+	note := c.Query("note")
+	closed, err := resolveOpenAlert(tx, shipName, sensorCode, note)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve alert"})
+		return
+	}
+	if !closed {
+		c.JSON(http.StatusConflict, gin.H{"error": "No open alert for this sensor"})
+		return
+	}
+
+	reportID, _ := strconv.Atoi(idStr)
+	resolved := events.SensorResolved{
+		ReportID:   reportID,
+		ShipName:   shipName,
+		SensorCode: sensorCode,
+		Note:       note,
+	}
 
+	dedupKey := fmt.Sprintf("alert.resolved:%d:%s:%d", reportID, sensorCode, updatedAt.UnixNano())
+	err = outbox.Write(tx, webhooks.EventAlertResolved, dedupKey, webhooks.AlertResolvedData{
+		ReportID:   reportID,
+		ShipName:   shipName,
+		SensorCode: sensorCode,
+		Note:       note,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update"})
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update"})
+		return
+	}
+
+	events.Publish(resolved)
+
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 // MonthlyReport shows detailed report for a specific code/month
 func MonthlyReport(c *gin.Context) {
+	code := monthlyReportCode(c)
+	project := c.Query("project")
+
+	reports, err := loadMonthlyReports(code)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	// Get all available codes for navigation
+	codeRows, _ := db.DB.Query(`SELECT DISTINCT code FROM fms_device_reports ORDER BY code DESC`)
+	var codes []string
+	if codeRows != nil {
+		defer codeRows.Close()
+		for codeRows.Next() {
+			var c string
+			if codeRows.Scan(&c) == nil {
+				codes = append(codes, c)
+			}
+		}
+	}
+
+	// Fetch sensors from DB for table headers
+	sensors, _ := activeSensors()
+
+	// Get active projects for filter dropdown
+	var projects []string
+	pRows, err := db.DB.Query("SELECT code FROM fms_projects WHERE is_active = true ORDER BY code ASC")
+	if err == nil {
+		defer pRows.Close()
+		for pRows.Next() {
+			var pCode string
+			if err := pRows.Scan(&pCode); err == nil {
+				projects = append(projects, pCode)
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "monthly_report.html", gin.H{
+		"Code":           code,
+		"Reports":        reports,
+		"Codes":          codes,
+		"Sensors":        sensors,
+		"Projects":       projects,
+		"CurrentProject": project, // Added current project for selection state
+		"ActiveTab":      "report",
+		"Logo":           GetCompanyLogo(),
+	})
+}
+
+// monthlyReportCode resolves the report code filter from the code or project+date query params
+func monthlyReportCode(c *gin.Context) string {
 	code := c.Query("code")
 	project := c.Query("project")
 	dateStr := c.Query("date")
 
-	// Filter by Project + Date if provided (New Filter Logic)
 	// Filter by Project + Date if provided (New Filter Logic)
 	if project != "" && dateStr != "" {
 		// dateStr is YYYY-MM (e.g., 2025-12)
@@ -330,8 +434,11 @@ func MonthlyReport(c *gin.Context) {
 		// Default view: current month
 		code = "FMS % " + time.Now().Format("Jan 2006")
 	}
+	return code
+}
 
-	// Get all reports for this code
+// loadMonthlyReports returns all reports matching a code pattern, ordered by date and ship
+func loadMonthlyReports(code string) ([]DeviceReport, error) {
 	rows, err := db.DB.Query(`
 		SELECT id, code, report_date, ship_name, 
 		       device_condition, gps, rpm_me_port, rpm_me_stbd,
@@ -343,8 +450,7 @@ func MonthlyReport(c *gin.Context) {
 		ORDER BY report_date ASC, ship_name ASC
 	`, code)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Error: %v", err)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -370,54 +476,23 @@ func MonthlyReport(c *gin.Context) {
 		r.CalculateTotals()
 		reports = append(reports, r)
 	}
+	return reports, rows.Err()
+}
 
-	// Get all available codes for navigation
-	codeRows, _ := db.DB.Query(`SELECT DISTINCT code FROM fms_device_reports ORDER BY code DESC`)
-	var codes []string
-	if codeRows != nil {
-		defer codeRows.Close()
-		for codeRows.Next() {
-			var c string
-			if codeRows.Scan(&c) == nil {
-				codes = append(codes, c)
-			}
-		}
+// activeSensors returns the active sensors in display order
+func activeSensors() ([]SensorConfig, error) {
+	rows, err := db.DB.Query("SELECT code, name FROM fms_sensor_config WHERE is_active = true ORDER BY display_order ASC")
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Fetch sensors from DB for table headers
 	var sensors []SensorConfig
-	sRows, err := db.DB.Query("SELECT code, name FROM fms_sensor_config WHERE is_active = true ORDER BY display_order ASC")
-	if err == nil {
-		defer sRows.Close()
-		for sRows.Next() {
-			var s SensorConfig
-			if err := sRows.Scan(&s.Code, &s.Name); err == nil {
-				sensors = append(sensors, s)
-			}
-		}
-	}
-
-	// Get active projects for filter dropdown
-	var projects []string
-	pRows, err := db.DB.Query("SELECT code FROM fms_projects WHERE is_active = true ORDER BY code ASC")
-	if err == nil {
-		defer pRows.Close()
-		for pRows.Next() {
-			var pCode string
-			if err := pRows.Scan(&pCode); err == nil {
-				projects = append(projects, pCode)
-			}
+	for rows.Next() {
+		var s SensorConfig
+		if err := rows.Scan(&s.Code, &s.Name); err == nil {
+			sensors = append(sensors, s)
 		}
 	}
-
-	c.HTML(http.StatusOK, "monthly_report.html", gin.H{
-		"Code":           code,
-		"Reports":        reports,
-		"Codes":          codes,
-		"Sensors":        sensors,
-		"Projects":       projects,
-		"CurrentProject": project, // Added current project for selection state
-		"ActiveTab":      "report",
-		"Logo":           GetCompanyLogo(),
-	})
+	return sensors, rows.Err()
 }
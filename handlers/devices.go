@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SettingsDevicesPage renders the gateway tokens and the last samples received
+func SettingsDevicesPage(c *gin.Context) {
+	renderDevicesPage(c, "")
+}
+
+// renderDevicesPage shows the page; newToken is displayed once right after it is created
+func renderDevicesPage(c *gin.Context, newToken string) {
+	type TokenRow struct {
+		ID         int
+		Name       string
+		ShipName   string
+		IsActive   bool
+		CreatedAt  time.Time
+		LastUsedAt *time.Time
+	}
+	type LastSeenRow struct {
+		ShipName   string
+		SensorName string
+		SeenAt     time.Time
+		Online     bool
+		Value      sql.NullFloat64
+	}
+	type ShipOption struct {
+		ID   int
+		Name string
+	}
+
+	var tokens []TokenRow
+	rows, err := db.DB.Query(`
+		SELECT t.id, t.name, COALESCE(s.name, ''), t.is_active, t.created_at, t.last_used_at
+		FROM fms_device_tokens t
+		LEFT JOIN fms_ships s ON s.id = t.ship_id
+		ORDER BY t.id ASC
+	`)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var t TokenRow
+			if err := rows.Scan(&t.ID, &t.Name, &t.ShipName, &t.IsActive, &t.CreatedAt, &t.LastUsedAt); err == nil {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+
+	var lastSeen []LastSeenRow
+	lRows, err := db.DB.Query(`
+		SELECT s.name, COALESCE(g.name, l.sensor_code), l.seen_at, l.online, l.value
+		FROM fms_sensor_last_seen l
+		JOIN fms_ships s ON s.id = l.ship_id
+		LEFT JOIN fms_sensor_config g ON g.code = l.sensor_code
+		ORDER BY l.seen_at DESC
+		LIMIT 100
+	`)
+	if err == nil {
+		defer lRows.Close()
+		for lRows.Next() {
+			var l LastSeenRow
+			if err := lRows.Scan(&l.ShipName, &l.SensorName, &l.SeenAt, &l.Online, &l.Value); err == nil {
+				lastSeen = append(lastSeen, l)
+			}
+		}
+	}
+
+	var ships []ShipOption
+	sRows, err := db.DB.Query("SELECT id, name FROM fms_ships ORDER BY name ASC")
+	if err == nil {
+		defer sRows.Close()
+		for sRows.Next() {
+			var s ShipOption
+			if err := sRows.Scan(&s.ID, &s.Name); err == nil {
+				ships = append(ships, s)
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_devices.html", gin.H{
+		"Tokens":        tokens,
+		"LastSeen":      lastSeen,
+		"Ships":         ships,
+		"NewToken":      newToken,
+		"ActiveSidebar": "devices",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// CreateDeviceToken issues a token for a gateway. Only its hash is stored, so the token
+// is shown on the rendered page instead of being passed through a redirect.
+func CreateDeviceToken(c *gin.Context) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		c.Redirect(http.StatusSeeOther, "/settings/devices?error=Nama+gateway+wajib+diisi")
+		return
+	}
+	var shipID *int
+	if id, err := strconv.Atoi(c.PostForm("ship_id")); err == nil && id > 0 {
+		shipID = &id
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	token := "fmsd_" + hex.EncodeToString(buf)
+
+	_, err := db.DB.Exec("INSERT INTO fms_device_tokens (name, token_hash, ship_id) VALUES ($1, $2, $3)",
+		name, hashDeviceToken(token), shipID)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/devices?error=Gagal+membuat+token")
+		return
+	}
+
+	renderDevicesPage(c, token)
+}
+
+// ToggleDeviceToken enables or disables a gateway token
+func ToggleDeviceToken(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	_, err := db.DB.Exec("UPDATE fms_device_tokens SET is_active = NOT is_active WHERE id = $1", id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/devices?success=Status+token+diupdate!+🔄")
+}
+
+// DeleteDeviceToken revokes a gateway token
+func DeleteDeviceToken(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	_, err := db.DB.Exec("DELETE FROM fms_device_tokens WHERE id = $1", id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/devices?success=Token+dihapus")
+}
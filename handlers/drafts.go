@@ -0,0 +1,555 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Draft statuses
+const (
+	DraftPending   = "pending"
+	DraftConfirmed = "confirmed"
+	DraftDismissed = "dismissed"
+)
+
+// Draft sources: derived from the gateway's last-seen data or proposed from an uploaded log
+const (
+	DraftSourceDevice = "device"
+	DraftSourceLog    = "log"
+)
+
+// DraftSensor is the status derived for one sensor of a draft report and why
+type DraftSensor struct {
+	Online     bool       `json:"online"`
+	Reason     string     `json:"reason"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// reportDraft is a row of fms_report_drafts
+type reportDraft struct {
+	ID        int
+	ShipID    int
+	Period    time.Time
+	Sensors   map[string]DraftSensor
+	Status    string
+	Source    string
+	DerivedAt time.Time
+}
+
+// deriveSensor applies a sensor's offline rule to its last device sample. ruleHours is
+// offline_after_hours (0: no rule) and seenAt is nil when the ship never sent the sensor.
+// Without a rule or a sample there is nothing to derive and ok is false.
+func deriveSensor(ruleHours int, seenAt *time.Time, online bool, now time.Time) (d DraftSensor, ok bool) {
+	if seenAt == nil {
+		if ruleHours == 0 {
+			return DraftSensor{}, false
+		}
+		return DraftSensor{Reason: "belum pernah mengirim data"}, true
+	}
+	d.LastSeenAt = seenAt
+	silent := now.Sub(*seenAt)
+	switch {
+	case ruleHours > 0 && silent > time.Duration(ruleHours)*time.Hour:
+		d.Reason = fmt.Sprintf("tidak ada data %d jam (batas %d jam)", int(silent.Hours()), ruleHours)
+	case online:
+		d.Online, d.Reason = true, "online menurut gateway"
+	default:
+		d.Reason = "offline menurut gateway"
+	}
+	return d, true
+}
+
+// sameDraftStatuses reports whether two derivations give every sensor the same status;
+// reasons and timestamps change on every run and are ignored
+func sameDraftStatuses(a, b map[string]DraftSensor) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for code, s := range a {
+		if t, ok := b[code]; !ok || t.Online != s.Online {
+			return false
+		}
+	}
+	return true
+}
+
+// DeriveDraftsTask derives the current period's draft report of every ship that has sent
+// device data, for operators to review on /drafts
+func DeriveDraftsTask(ctx context.Context) (string, error) {
+	created, updated, reopened, err := deriveDrafts(ctx, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d draft baru, %d diperbarui, %d dibuka kembali", created, updated, reopened), nil
+}
+
+// deriveDrafts writes the drafts of now's month. Pending drafts follow the latest data; a
+// confirmed or dismissed draft is only reopened when a derived status changed since.
+// Drafts proposed from an uploaded log, pending or reviewed, are left alone.
+func deriveDrafts(ctx context.Context, now time.Time) (created, updated, reopened int, err error) {
+	period := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	// Effective active sensors of ships with a gateway, with their last sample if any
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT sh.id, g.code, COALESCE(g.offline_after_hours, 0), l.seen_at, COALESCE(l.online, false)
+		FROM fms_ships sh
+		CROSS JOIN fms_sensor_config g
+		LEFT JOIN fms_ship_sensors o ON o.ship_id = sh.id AND o.sensor_code = g.code
+		LEFT JOIN fms_sensor_last_seen l ON l.ship_id = sh.id AND l.sensor_code = g.code
+		WHERE COALESCE(o.is_active, g.is_active)
+		  AND EXISTS (SELECT 1 FROM fms_sensor_last_seen x WHERE x.ship_id = sh.id)
+		ORDER BY sh.id ASC, g.display_order ASC, g.code ASC
+	`)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer rows.Close()
+	var shipIDs []int
+	derived := map[int]map[string]DraftSensor{}
+	for rows.Next() {
+		var shipID, rule int
+		var code string
+		var seenAt *time.Time
+		var online bool
+		if err := rows.Scan(&shipID, &code, &rule, &seenAt, &online); err != nil {
+			return 0, 0, 0, err
+		}
+		d, ok := deriveSensor(rule, seenAt, online, now)
+		if !ok {
+			continue
+		}
+		if derived[shipID] == nil {
+			derived[shipID] = map[string]DraftSensor{}
+			shipIDs = append(shipIDs, shipID)
+		}
+		derived[shipID][code] = d
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, shipID := range shipIDs {
+		sensors := derived[shipID]
+		data, err := json.Marshal(sensors)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		existing, err := loadDraftFor(tx, shipID, period)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = tx.Exec(`INSERT INTO fms_report_drafts (ship_id, period, sensors, status, derived_at)
+				VALUES ($1, $2, $3, $4, $5)`, shipID, period, data, DraftPending, now)
+			created++
+		case err != nil:
+			return 0, 0, 0, err
+		case existing.Source == DraftSourceLog:
+			continue // an uploaded log knows more than a stale last-seen row
+		case existing.Status == DraftPending:
+			_, err = tx.Exec("UPDATE fms_report_drafts SET sensors = $2, derived_at = $3 WHERE id = $1",
+				existing.ID, data, now)
+			updated++
+		case !sameDraftStatuses(existing.Sensors, sensors):
+			_, err = tx.Exec(`UPDATE fms_report_drafts
+				SET sensors = $2, derived_at = $3, status = $4, decided_at = NULL, report_id = NULL, source = $5
+				WHERE id = $1`, existing.ID, data, now, DraftPending, DraftSourceDevice)
+			reopened++
+		}
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return created, updated, reopened, tx.Commit()
+}
+
+const draftColumns = "id, ship_id, period, sensors, status, source, derived_at"
+
+func scanDraft(row interface{ Scan(...any) error }) (reportDraft, error) {
+	var d reportDraft
+	var data []byte
+	if err := row.Scan(&d.ID, &d.ShipID, &d.Period, &data, &d.Status, &d.Source, &d.DerivedAt); err != nil {
+		return d, err
+	}
+	d.Sensors = map[string]DraftSensor{}
+	return d, json.Unmarshal(data, &d.Sensors)
+}
+
+// loadDraftFor locks the draft of a ship and period
+func loadDraftFor(tx *sql.Tx, shipID int, period time.Time) (reportDraft, error) {
+	return scanDraft(tx.QueryRow("SELECT "+draftColumns+" FROM fms_report_drafts WHERE ship_id = $1 AND period = $2 FOR UPDATE",
+		shipID, period))
+}
+
+// confirmDraft merges the operator's statuses into the draft's period report of project
+// and marks the draft confirmed. The returned event, if any, is published after commit.
+func confirmDraft(tx *sql.Tx, names shipNames, d reportDraft, project string, sensors map[string]bool, now time.Time) (events.Event, error) {
+	var active bool
+	err := tx.QueryRow("SELECT is_active FROM fms_projects WHERE code = $1", project).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !active) {
+		return nil, fmt.Errorf("project %s tidak aktif", project)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ship := &ingestShip{ID: d.ShipID}
+	if err := tx.QueryRow("SELECT name, COALESCE(code, '') FROM fms_ships WHERE id = $1", d.ShipID).
+		Scan(&ship.Name, &ship.Code); err != nil {
+		return nil, err
+	}
+
+	// A draft of a closed month is dated on its last day
+	date := now
+	if end := d.Period.AddDate(0, 1, -1); end.Before(now) {
+		date = end
+	}
+	out, ev, err := mergePeriodReport(tx, names, project, ship, d.Period, date, sensors, ReportSourceManual)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE fms_report_drafts SET status = $2, decided_at = $3, report_id = $4 WHERE id = $1",
+		d.ID, DraftConfirmed, now, out.ID)
+	return ev, err
+}
+
+// defaultDraftProjects maps ship IDs to the project of their latest report, falling back
+// to the first active project
+func defaultDraftProjects() (map[int]string, string) {
+	fallback := ""
+	if projects, err := activeProjects(); err == nil && len(projects) > 0 {
+		fallback = projects[0]
+	}
+	byShip := map[int]string{}
+	rows, err := db.DB.Query(`
+		SELECT DISTINCT ON (s.id) s.id, upper(split_part(r.code, ' ', 1))
+		FROM fms_ships s
+		JOIN fms_device_reports r ON r.ship_name = s.name
+		ORDER BY s.id, r.report_date DESC, r.id DESC
+	`)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			var project string
+			if err := rows.Scan(&id, &project); err == nil {
+				byShip[id] = project
+			}
+		}
+	}
+	return byShip, fallback
+}
+
+// DraftsPage lists the draft reports of a period (?period=YYYY-MM, default this month)
+// next to the statuses currently in their period reports
+func DraftsPage(c *gin.Context) {
+	type SensorRow struct {
+		Code       string
+		Name       string
+		Draft      DraftSensor
+		HasCurrent bool
+		Current    bool
+		Changed    bool
+	}
+	type DraftRow struct {
+		ID        int
+		ShipName  string
+		Project   string
+		Status    string
+		Source    string
+		DerivedAt time.Time
+		Sensors   []SensorRow
+		Changes   int
+	}
+
+	now := time.Now()
+	period := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	if p, err := time.ParseInLocation("2006-01", c.Query("period"), now.Location()); err == nil {
+		period = p
+	}
+	showAll := c.Query("all") == "1"
+
+	sensorNames := map[string]string{}
+	sensorOrder := map[string]int{}
+	if sRows, err := db.DB.Query("SELECT code, name FROM fms_sensor_config ORDER BY display_order ASC, code ASC"); err == nil {
+		defer sRows.Close()
+		for i := 0; sRows.Next(); i++ {
+			var code, name string
+			if err := sRows.Scan(&code, &name); err == nil {
+				sensorNames[code], sensorOrder[code] = name, i
+			}
+		}
+	}
+
+	// Sensors of the report each ship/project already has in the period
+	current := map[string]map[string]bool{}
+	if rRows, err := db.DB.Query(`
+		SELECT DISTINCT ON (ship_name, upper(split_part(code, ' ', 1)))
+			ship_name, upper(split_part(code, ' ', 1)), sensors_data
+		FROM fms_device_reports
+		WHERE report_date >= $1 AND report_date < $2
+		ORDER BY ship_name, upper(split_part(code, ' ', 1)), id ASC
+	`, period, period.AddDate(0, 1, 0)); err == nil {
+		defer rRows.Close()
+		for rRows.Next() {
+			var ship, project string
+			var data []byte
+			if err := rRows.Scan(&ship, &project, &data); err != nil {
+				continue
+			}
+			sensors := map[string]bool{}
+			if json.Unmarshal(data, &sensors) == nil {
+				current[ship+"\x00"+project] = sensors
+			}
+		}
+	}
+
+	projectByShip, fallback := defaultDraftProjects()
+
+	query := "SELECT d.id, d.ship_id, d.period, d.sensors, d.status, d.source, d.derived_at, s.name FROM fms_report_drafts d JOIN fms_ships s ON s.id = d.ship_id WHERE d.period = $1"
+	if !showAll {
+		query += " AND d.status = '" + DraftPending + "'"
+	}
+	query += " ORDER BY s.name ASC"
+
+	var drafts []DraftRow
+	counts := map[string]int{}
+	rows, err := db.DB.Query(query, period)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var d reportDraft
+			var data []byte
+			var shipName string
+			if err := rows.Scan(&d.ID, &d.ShipID, &d.Period, &data, &d.Status, &d.Source, &d.DerivedAt, &shipName); err != nil {
+				continue
+			}
+			if json.Unmarshal(data, &d.Sensors) != nil {
+				continue
+			}
+			row := DraftRow{ID: d.ID, ShipName: shipName, Status: d.Status, Source: d.Source, DerivedAt: d.DerivedAt, Project: projectByShip[d.ShipID]}
+			if row.Project == "" {
+				row.Project = fallback
+			}
+			existing := current[shipName+"\x00"+row.Project]
+			for code, s := range d.Sensors {
+				sr := SensorRow{Code: code, Name: sensorNames[code], Draft: s}
+				if sr.Name == "" {
+					sr.Name = code
+				}
+				sr.Current, sr.HasCurrent = existing[code]
+				if sr.Changed = !sr.HasCurrent || sr.Current != s.Online; sr.Changed {
+					row.Changes++
+				}
+				row.Sensors = append(row.Sensors, sr)
+			}
+			sort.Slice(row.Sensors, func(i, j int) bool {
+				return sensorOrder[row.Sensors[i].Code] < sensorOrder[row.Sensors[j].Code]
+			})
+			drafts = append(drafts, row)
+		}
+	}
+	if cRows, err := db.DB.Query("SELECT status, COUNT(*) FROM fms_report_drafts WHERE period = $1 GROUP BY status", period); err == nil {
+		defer cRows.Close()
+		for cRows.Next() {
+			var status string
+			var n int
+			if err := cRows.Scan(&status, &n); err == nil {
+				counts[status] = n
+			}
+		}
+	}
+
+	projects, _ := activeProjects()
+	c.HTML(http.StatusOK, "drafts.html", gin.H{
+		"Drafts":    drafts,
+		"Counts":    counts,
+		"Projects":  projects,
+		"Period":    period,
+		"ShowAll":   showAll,
+		"ActiveTab": "drafts",
+		"Logo":      GetCompanyLogo(),
+	})
+}
+
+// draftsRedirect returns to the drafts page of period with a success or error message
+func draftsRedirect(c *gin.Context, period, key, msg string) {
+	q := url.Values{key: {msg}}
+	if period != "" {
+		q.Set("period", period)
+	}
+	c.Redirect(http.StatusSeeOther, "/drafts?"+q.Encode())
+}
+
+// ConfirmDraft writes a reviewed draft into its period report. Every sensor of the draft
+// is posted as sensor_<code>; unchecked boxes are offline.
+func ConfirmDraft(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	period := c.PostForm("period")
+	project := strings.ToUpper(strings.TrimSpace(c.PostForm("project_code")))
+	if project == "" {
+		draftsRedirect(c, period, "error", "Project wajib dipilih")
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	d, err := scanDraft(tx.QueryRow("SELECT "+draftColumns+" FROM fms_report_drafts WHERE id = $1 FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		draftsRedirect(c, period, "error", "Draft tidak ditemukan")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	if d.Status != DraftPending {
+		draftsRedirect(c, period, "error", "Draft sudah diproses")
+		return
+	}
+
+	sensors := make(map[string]bool, len(d.Sensors))
+	for code := range d.Sensors {
+		sensors[code] = c.PostForm("sensor_"+code) == "on"
+	}
+	names, err := shipNameIndex(tx)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	ev, err := confirmDraft(tx, names, d, project, sensors, time.Now())
+	if err != nil {
+		draftsRedirect(c, period, "error", "Gagal konfirmasi draft: "+err.Error())
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	if ev != nil {
+		events.Publish(ev)
+	}
+
+	draftsRedirect(c, period, "success", "Draft dikonfirmasi ke laporan periode ✅")
+}
+
+// ConfirmAllDrafts confirms every pending draft of a period as derived, each into the
+// project of its ship's latest report
+func ConfirmAllDrafts(c *gin.Context) {
+	period := c.PostForm("period")
+	now := time.Now()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	if p, err := time.ParseInLocation("2006-01", period, now.Location()); err == nil {
+		month = p
+	}
+	projectByShip, fallback := defaultDraftProjects()
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT "+draftColumns+" FROM fms_report_drafts WHERE period = $1 AND status = $2 ORDER BY id FOR UPDATE",
+		month, DraftPending)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	var drafts []reportDraft
+	for rows.Next() {
+		d, err := scanDraft(rows)
+		if err != nil {
+			rows.Close()
+			c.String(http.StatusInternalServerError, "Error: %v", err)
+			return
+		}
+		drafts = append(drafts, d)
+	}
+	rows.Close()
+
+	names, err := shipNameIndex(tx)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	var published []events.Event
+	for _, d := range drafts {
+		project := projectByShip[d.ShipID]
+		if project == "" {
+			project = fallback
+		}
+		sensors := make(map[string]bool, len(d.Sensors))
+		for code, s := range d.Sensors {
+			sensors[code] = s.Online
+		}
+		ev, err := confirmDraft(tx, names, d, project, sensors, now)
+		if err != nil {
+			draftsRedirect(c, period, "error", "Gagal konfirmasi draft: "+err.Error())
+			return
+		}
+		if ev != nil {
+			published = append(published, ev)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	for _, ev := range published {
+		events.Publish(ev)
+	}
+
+	draftsRedirect(c, period, "success", fmt.Sprintf("%d draft dikonfirmasi ✅", len(drafts)))
+}
+
+// DismissDraft closes a draft without touching the report; it is only reopened when the
+// derived statuses change
+func DismissDraft(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	_, err := db.DB.Exec("UPDATE fms_report_drafts SET status = $2, decided_at = $3 WHERE id = $1 AND status = $4",
+		id, DraftDismissed, time.Now(), DraftPending)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	draftsRedirect(c, c.PostForm("period"), "success", "Draft diabaikan")
+}
+
+// DeriveDrafts runs the derivation now instead of waiting for the scheduled task
+func DeriveDrafts(c *gin.Context) {
+	msg, err := DeriveDraftsTask(c.Request.Context())
+	if err != nil {
+		draftsRedirect(c, "", "error", "Gagal menurunkan status: "+err.Error())
+		return
+	}
+	draftsRedirect(c, "", "success", msg)
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeriveSensor(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	ago := func(h int) *time.Time {
+		t := now.Add(-time.Duration(h) * time.Hour)
+		return &t
+	}
+
+	cases := []struct {
+		name   string
+		rule   int
+		seenAt *time.Time
+		online bool
+		want   bool
+		ok     bool
+	}{
+		{"no rule, no data", 0, nil, false, false, false},
+		{"rule, never sent", 24, nil, false, false, true},
+		{"gps within 24h", 24, ago(23), true, true, true},
+		{"gps silent for 25h", 24, ago(25), true, false, true},
+		{"flowmeter silent for 30h", 48, ago(30), true, true, true},
+		{"reported offline", 48, ago(1), false, false, true},
+		{"no rule keeps last status", 0, ago(500), true, true, true},
+	}
+	for _, tc := range cases {
+		d, ok := deriveSensor(tc.rule, tc.seenAt, tc.online, now)
+		if ok != tc.ok || d.Online != tc.want {
+			t.Errorf("%s: got online=%t ok=%t, want online=%t ok=%t", tc.name, d.Online, ok, tc.want, tc.ok)
+		}
+		if ok && d.Reason == "" {
+			t.Errorf("%s: no reason", tc.name)
+		}
+	}
+
+	if sameDraftStatuses(map[string]DraftSensor{"gps": {Online: true, Reason: "a"}}, map[string]DraftSensor{"gps": {Online: true, Reason: "b"}}) != true ||
+		sameDraftStatuses(map[string]DraftSensor{"gps": {Online: true}}, map[string]DraftSensor{"gps": {Online: false}}) != false {
+		t.Error("sameDraftStatuses must compare statuses only")
+	}
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"fms-app/jobs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// attachment marks the response as a download named filename. Names carry report and
+// project codes typed by users, so mime quotes or encodes them instead of pasting them in.
+func attachment(c *gin.Context, filename string) {
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+// reportTitle turns a report code filter (e.g. "FMS % Dec 2025") into a readable title
+func reportTitle(code string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(code, "%", " ")), " ")
+}
+
+// ExportXLSXJobKind is the background job kind that builds the monthly report workbook
+const ExportXLSXJobKind = "report.export_xlsx"
+
+// exportJob is the payload of the monthly report export jobs
+type exportJob struct {
+	Code    string `json:"code"`
+	Project string `json:"project,omitempty"`
+}
+
+// ExportMonthlyReportXLSX queues the Excel export of the monthly report and sends the
+// user to the job page, which offers the workbook once it is built
+func ExportMonthlyReportXLSX(c *gin.Context) {
+	enqueueJob(c, ExportXLSXJobKind, exportJob{Code: monthlyReportCode(c)})
+}
+
+// ExportXLSXJob is the jobs.Handler for ExportXLSXJobKind: a detail sheet with one row
+// per ship and a rekap sheet with the totals
+func ExportXLSXJob(ctx context.Context, payload json.RawMessage) error {
+	var p exportJob
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	reports, err := loadMonthlyReports(p.Code)
+	if err != nil {
+		return err
+	}
+	sensors, err := activeSensors()
+	if err != nil {
+		return err
+	}
+
+	title := reportTitle(p.Code)
+	f, err := buildMonthlyReportXLSX(title, reports, sensors)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		return err
+	}
+	return jobs.SetResult(ctx, jobs.Result{
+		Message:     fmt.Sprintf("Laporan %s: %d kapal", title, len(reports)),
+		Filename:    fmt.Sprintf("Laporan %s.xlsx", title),
+		ContentType: xlsxContentType,
+		Data:        buf.Bytes(),
+	})
+}
+
+func buildMonthlyReportXLSX(title string, reports []DeviceReport, sensors []SensorConfig) (*excelize.File, error) {
+	const sheet = "Laporan"
+	const headerRow = 5
+
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return nil, err
+	}
+
+	styles, err := newReportStyles(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logo and title block
+	addLogo(f, sheet, "A1", "B3")
+	_ = f.SetCellValue(sheet, "C1", "Laporan Performa Perangkat")
+	_ = f.SetCellValue(sheet, "C2", "Periode: "+title)
+	_ = f.SetCellValue(sheet, "C3", fmt.Sprintf("%d kapal", len(reports)))
+	_ = f.SetCellStyle(sheet, "C1", "C1", styles.title)
+
+	// Header: No, Ship, Date, one column per sensor, totals
+	header := []interface{}{"No", "Ship Name", "Date"}
+	for _, s := range sensors {
+		header = append(header, s.Name)
+	}
+	header = append(header, "Online", "Offline", "% Online", "% Offline")
+	lastCol, _ := excelize.ColumnNumberToName(len(header))
+	_ = f.SetSheetRow(sheet, cell(1, headerRow), &header)
+	_ = f.SetCellStyle(sheet, cell(1, headerRow), cell(len(header), headerRow), styles.header)
+
+	for i, r := range reports {
+		row := headerRow + 1 + i
+		values := []interface{}{i + 1, r.ShipName, r.ReportDate.Format("02 Jan 2006")}
+		for _, s := range sensors {
+			status, ok := r.SensorsData[s.Code]
+			switch {
+			case !ok:
+				values = append(values, "-")
+			case status:
+				values = append(values, "Online")
+			default:
+				values = append(values, "Offline")
+			}
+		}
+		values = append(values, r.OnlineTotal, r.OfflineTotal, r.OnlinePercent/100, r.OfflinePercent/100)
+		_ = f.SetSheetRow(sheet, cell(1, row), &values)
+	}
+
+	if len(reports) > 0 {
+		firstRow, lastRow := headerRow+1, headerRow+len(reports)
+		_ = f.SetCellStyle(sheet, cell(1, firstRow), cell(len(header), lastRow), styles.body)
+		_ = f.SetCellStyle(sheet, cell(len(header)-1, firstRow), cell(len(header), lastRow), styles.percent)
+
+		if len(sensors) > 0 {
+			sensorRange := cell(4, firstRow) + ":" + cell(3+len(sensors), lastRow)
+			_ = f.SetConditionalFormat(sheet, sensorRange, []excelize.ConditionalFormatOptions{
+				{Type: "cell", Criteria: "==", Value: `"Online"`, Format: &styles.good},
+				{Type: "cell", Criteria: "==", Value: `"Offline"`, Format: &styles.bad},
+			})
+		}
+
+		onlineRange := cell(len(header)-1, firstRow) + ":" + cell(len(header)-1, lastRow)
+		_ = f.SetConditionalFormat(sheet, onlineRange, []excelize.ConditionalFormatOptions{
+			{Type: "cell", Criteria: ">=", Value: "0.8", Format: &styles.good},
+			{Type: "cell", Criteria: "between", MinValue: "0.5", MaxValue: "0.8", Format: &styles.warn},
+			{Type: "cell", Criteria: "<", Value: "0.5", Format: &styles.bad},
+		})
+	}
+
+	_ = f.SetColWidth(sheet, "A", "A", 6)
+	_ = f.SetColWidth(sheet, "B", "B", 28)
+	_ = f.SetColWidth(sheet, "C", lastCol, 14)
+	_ = f.SetPanes(sheet, &excelize.Panes{
+		Freeze:      true,
+		XSplit:      2,
+		YSplit:      headerRow,
+		TopLeftCell: cell(3, headerRow+1),
+		ActivePane:  "bottomRight",
+	})
+
+	if err := writeRekapSheet(f, styles, title, reports, sensors); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeRekapSheet adds the period summary: overall totals and a breakdown per sensor
+func writeRekapSheet(f *excelize.File, styles reportStyles, title string, reports []DeviceReport, sensors []SensorConfig) error {
+	const sheet = "Rekap"
+	if _, err := f.NewSheet(sheet); err != nil {
+		return err
+	}
+
+	var totalOnline, totalOffline int
+	for _, r := range reports {
+		totalOnline += r.OnlineTotal
+		totalOffline += r.OfflineTotal
+	}
+	totalDevices := totalOnline + totalOffline
+
+	addLogo(f, sheet, "A1", "A3")
+	_ = f.SetCellValue(sheet, "B1", "Rekap "+title)
+	_ = f.SetCellStyle(sheet, "B1", "B1", styles.title)
+
+	summary := [][]interface{}{
+		{"Total Kapal", len(reports)},
+		{"Total Perangkat", totalDevices},
+		{"Online", totalOnline},
+		{"Offline", totalOffline},
+		{"% Online", ratio(totalOnline, totalDevices)},
+		{"% Offline", ratio(totalOffline, totalDevices)},
+	}
+	for i, row := range summary {
+		_ = f.SetSheetRow(sheet, cell(1, 5+i), &row)
+	}
+	_ = f.SetCellStyle(sheet, "A5", "A10", styles.header)
+	_ = f.SetCellStyle(sheet, "B5", "B8", styles.body)
+	_ = f.SetCellStyle(sheet, "B9", "B10", styles.percent)
+
+	// Per sensor breakdown
+	const sensorRow = 13
+	header := []interface{}{"Sensor", "Online", "Offline", "% Online"}
+	_ = f.SetSheetRow(sheet, cell(1, sensorRow), &header)
+	_ = f.SetCellStyle(sheet, cell(1, sensorRow), cell(len(header), sensorRow), styles.header)
+	for i, s := range sensors {
+		var online, offline int
+		for _, r := range reports {
+			if status, ok := r.SensorsData[s.Code]; ok {
+				if status {
+					online++
+				} else {
+					offline++
+				}
+			}
+		}
+		row := []interface{}{s.Name, online, offline, ratio(online, online+offline)}
+		_ = f.SetSheetRow(sheet, cell(1, sensorRow+1+i), &row)
+	}
+	if len(sensors) > 0 {
+		lastRow := sensorRow + len(sensors)
+		_ = f.SetCellStyle(sheet, cell(1, sensorRow+1), cell(3, lastRow), styles.body)
+		_ = f.SetCellStyle(sheet, cell(4, sensorRow+1), cell(4, lastRow), styles.percent)
+		_ = f.SetConditionalFormat(sheet, cell(4, sensorRow+1)+":"+cell(4, lastRow), []excelize.ConditionalFormatOptions{
+			{Type: "cell", Criteria: ">=", Value: "0.8", Format: &styles.good},
+			{Type: "cell", Criteria: "between", MinValue: "0.5", MaxValue: "0.8", Format: &styles.warn},
+			{Type: "cell", Criteria: "<", Value: "0.5", Format: &styles.bad},
+		})
+	}
+
+	_ = f.SetColWidth(sheet, "A", "A", 28)
+	_ = f.SetColWidth(sheet, "B", "D", 14)
+	return nil
+}
+
+type reportStyles struct {
+	title, header, body, percent int
+	good, warn, bad              int // conditional formats
+}
+
+func newReportStyles(f *excelize.File) (reportStyles, error) {
+	var s reportStyles
+	var err error
+	border := []excelize.Border{
+		{Type: "left", Color: "E2E8F0", Style: 1},
+		{Type: "right", Color: "E2E8F0", Style: 1},
+		{Type: "top", Color: "E2E8F0", Style: 1},
+		{Type: "bottom", Color: "E2E8F0", Style: 1},
+	}
+
+	if s.title, err = f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}}); err != nil {
+		return s, err
+	}
+	if s.header, err = f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true, Color: "FFFFFF"},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"1E40AF"}},
+		Alignment: &excelize.Alignment{Horizontal: "center", Vertical: "center", WrapText: true},
+		Border:    border,
+	}); err != nil {
+		return s, err
+	}
+	if s.body, err = f.NewStyle(&excelize.Style{Border: border}); err != nil {
+		return s, err
+	}
+	if s.percent, err = f.NewStyle(&excelize.Style{Border: border, NumFmt: 10}); err != nil {
+		return s, err
+	}
+	if s.good, err = f.NewConditionalStyle(&excelize.Style{
+		Font: &excelize.Font{Color: "166534"},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"DCFCE7"}},
+	}); err != nil {
+		return s, err
+	}
+	if s.warn, err = f.NewConditionalStyle(&excelize.Style{
+		Font: &excelize.Font{Color: "92400E"},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FEF3C7"}},
+	}); err != nil {
+		return s, err
+	}
+	if s.bad, err = f.NewConditionalStyle(&excelize.Style{
+		Font: &excelize.Font{Color: "991B1B"},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FEE2E2"}},
+	}); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// addLogo places the company logo in the merged range from..to, skipped if the file is not local
+func addLogo(f *excelize.File, sheet, from, to string) {
+	path := strings.TrimPrefix(GetCompanyLogo(), "/")
+	if !fileExists(path) {
+		return
+	}
+	_ = f.MergeCell(sheet, from, to)
+	_ = f.AddPicture(sheet, from, path, &excelize.GraphicOptions{AutoFit: true, LockAspectRatio: true})
+}
+
+func cell(col, row int) string {
+	name, _ := excelize.CoordinatesToCellName(col, row)
+	return name
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
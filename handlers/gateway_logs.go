@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/gatewaylog"
+	"fms-app/readings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxGatewayLogSize bounds an uploaded gateway log
+const maxGatewayLogSize = 50 << 20
+
+// logOnlineMinPercent is the availability over the covered hours from which a sensor of
+// an uploaded log is proposed online
+func logOnlineMinPercent() float64 {
+	n, err := strconv.ParseFloat(os.Getenv("LOG_ONLINE_MIN_PERCENT"), 64)
+	if err != nil || n <= 0 || n > 100 {
+		return 50
+	}
+	return n
+}
+
+// gatewayLogResult summarises an imported log
+type gatewayLogResult struct {
+	Readings int
+	Drafts   int
+	Ignored  []string
+	Periods  []gatewaylog.Period
+}
+
+// UploadGatewayLog reads a gateway log copied from a vessel (ship_id, format, file),
+// stores its values and proposes a draft report for every month it covers
+func UploadGatewayLog(c *gin.Context) {
+	fail := func(msg string) {
+		c.Redirect(http.StatusSeeOther, "/settings/import?error="+url.QueryEscape(msg))
+	}
+	shipID, _ := strconv.Atoi(c.PostForm("ship_id"))
+	format := c.PostForm("format")
+	fh, err := c.FormFile("file")
+	if err != nil {
+		fail("File belum dipilih")
+		return
+	}
+	if fh.Size > maxGatewayLogSize {
+		fail(fmt.Sprintf("File terlalu besar (maks %d MB)", maxGatewayLogSize>>20))
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	defer f.Close()
+
+	l, err := gatewaylog.Parse(format, f)
+	if err != nil {
+		fail("Log tidak bisa dibaca: " + err.Error())
+		return
+	}
+	result, err := importGatewayLog(c.Request.Context(), shipID, format, fh.Filename, l, time.Now())
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+
+	msg := fmt.Sprintf("Log %s diproses: %d nilai sensor disimpan, %d draft laporan diusulkan 📄",
+		fh.Filename, result.Readings, result.Drafts)
+	if len(result.Ignored) > 0 {
+		msg += "; sensor dilewati (tidak aktif untuk kapal): " + strings.Join(result.Ignored, ", ")
+	}
+	draftsRedirect(c, result.Periods[0].Month.Format("2006-01"), "success", msg)
+}
+
+// importGatewayLog checks a parsed log against the ship, writes its values to the readings
+// time series and proposes one draft per covered month from the sensor availability.
+// Sensors that are not active for the ship are skipped; the log's own ship code, if any,
+// must be the ship's.
+func importGatewayLog(ctx context.Context, shipID int, format, filename string, l gatewaylog.Log, now time.Time) (gatewayLogResult, error) {
+	var result gatewayLogResult
+	ship := &ingestShip{Sensors: map[string]bool{}}
+	err := db.DB.QueryRow("SELECT id, name, COALESCE(code, '') FROM fms_ships WHERE id = $1", shipID).
+		Scan(&ship.ID, &ship.Name, &ship.Code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, fmt.Errorf("kapal belum dipilih")
+	} else if err != nil {
+		return result, err
+	}
+	if l.ShipCode != "" && !strings.EqualFold(l.ShipCode, ship.Code) {
+		return result, fmt.Errorf("log ini dari kapal berkode %s, bukan %s", l.ShipCode, ship.Name)
+	}
+	sensors, err := loadShipSensors(db.DB, ship.ID)
+	if err != nil {
+		return result, err
+	}
+	for _, s := range sensors {
+		if s.Active {
+			ship.Sensors[s.Code] = true
+		}
+	}
+
+	var samples []gatewaylog.Sample
+	var values []readings.Reading
+	ignored := map[string]bool{}
+	latest := now.Add(ingestClockSkew)
+	for _, s := range l.Samples {
+		if s.At.After(latest) {
+			return result, fmt.Errorf("log berisi waktu di masa depan (%s), cek jam gateway", s.At.Format("2006-01-02 15:04"))
+		}
+		if !ship.Sensors[s.Sensor] {
+			ignored[s.Sensor] = true
+			continue
+		}
+		samples = append(samples, s)
+		if s.Value != nil {
+			values = append(values, readings.Reading{ShipID: ship.ID, Sensor: s.Sensor, At: s.At.In(time.Local), Value: *s.Value})
+		}
+	}
+	result.Ignored = sortedKeys(ignored)
+	if len(samples) == 0 {
+		return result, fmt.Errorf("log tidak berisi sensor yang aktif untuk %s", ship.Name)
+	}
+	result.Periods = gatewaylog.Summarize(samples, time.Local)
+
+	// Partitions are DDL, created before the transaction
+	times := make([]time.Time, len(values))
+	for i, v := range values {
+		times[i] = v.At
+	}
+	if err := readings.EnsurePartitions(ctx, times...); err != nil {
+		return result, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	if result.Readings, err = readings.Write(tx, values); err != nil {
+		return result, err
+	}
+
+	minPercent := logOnlineMinPercent()
+	for _, p := range result.Periods {
+		if len(p.Sensors) == 0 {
+			continue
+		}
+		draft := map[string]DraftSensor{}
+		for _, a := range p.Sensors {
+			last := a.Last
+			pct := p.Percent(a)
+			draft[a.Sensor] = DraftSensor{
+				Online:     pct >= minPercent,
+				Reason:     fmt.Sprintf("log gateway: online %d dari %d jam (%.0f%%, batas %.0f%%)", a.Online, p.Hours(), pct, minPercent),
+				LastSeenAt: &last,
+			}
+		}
+		proposed, err := proposeLogDraft(tx, ship.ID, p.Month, draft, now)
+		if err != nil {
+			return result, err
+		}
+		if proposed {
+			result.Drafts++
+		}
+	}
+
+	first, last := result.Periods[0], result.Periods[len(result.Periods)-1]
+	_, err = tx.Exec(`
+		INSERT INTO fms_gateway_logs (ship_id, format, filename, covered_from, covered_to, samples, readings, ignored_sensors)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, ship.ID, format, filename, first.From, last.To, len(samples), result.Readings, strings.Join(result.Ignored, ","))
+	if err != nil {
+		return result, err
+	}
+	return result, tx.Commit()
+}
+
+// proposeLogDraft writes the statuses of an uploaded log into the ship's draft for period.
+// Sensors missing from the log keep their derived status. A confirmed or dismissed draft
+// is only reopened when the log changes a status; proposed reports whether it was written.
+func proposeLogDraft(tx *sql.Tx, shipID int, period time.Time, sensors map[string]DraftSensor, now time.Time) (proposed bool, err error) {
+	existing, err := loadDraftFor(tx, shipID, period)
+	if errors.Is(err, sql.ErrNoRows) {
+		data, err := json.Marshal(sensors)
+		if err != nil {
+			return false, err
+		}
+		_, err = tx.Exec(`INSERT INTO fms_report_drafts (ship_id, period, sensors, status, source, derived_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`, shipID, period, data, DraftPending, DraftSourceLog, now)
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	merged := map[string]DraftSensor{}
+	for code, s := range existing.Sensors {
+		merged[code] = s
+	}
+	for code, s := range sensors {
+		merged[code] = s
+	}
+	if existing.Status != DraftPending && sameDraftStatuses(existing.Sensors, merged) {
+		return false, nil
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`UPDATE fms_report_drafts
+		SET sensors = $2, derived_at = $3, status = $4, decided_at = NULL, report_id = NULL, source = $5
+		WHERE id = $1`, existing.ID, data, now, DraftPending, DraftSourceLog)
+	return err == nil, err
+}
@@ -0,0 +1,546 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/events"
+	"fms-app/jobs"
+	"fms-app/shipname"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const reportImportKind = "reports"
+
+// importColumn is one column of an uploaded file and what it is mapped to:
+// "" (not recognised), "ignore", "ship", "date", "period", "project" or "sensor:<code>"
+type importColumn struct {
+	Index   int
+	Header  string
+	Mapping string
+	Sample  string
+}
+
+// importOption is a choice in the column mapping dropdown
+type importOption struct {
+	Value string
+	Label string
+}
+
+// reportImportRow is one parsed data row with its validation errors
+type reportImportRow struct {
+	Line       int
+	ShipName   string
+	ShipCode   string
+	Project    string
+	Code       string
+	ReportDate time.Time
+	Sensors    map[string]bool
+	Online     int
+	Offline    int
+	Errors     []string
+}
+
+// importShip is a master ship as matched by imports
+type importShip struct {
+	Name string
+	Code string
+}
+
+// shipIndex matches ship names, aliases or codes from a file
+type shipIndex map[string]importShip
+
+// importShipIndex indexes master ships by code (case-insensitive) and by the normalized
+// key of their name and aliases
+func importShipIndex() (shipIndex, error) {
+	rows, err := db.DB.Query("SELECT name, COALESCE(code, '') FROM fms_ships")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	index := make(shipIndex)
+	byName := make(map[string]importShip)
+	for rows.Next() {
+		var s importShip
+		if err := rows.Scan(&s.Name, &s.Code); err != nil {
+			continue
+		}
+		index[shipname.Key(s.Name)] = s
+		byName[s.Name] = s
+		if s.Code != "" {
+			if _, taken := index[strings.ToLower(s.Code)]; !taken {
+				index[strings.ToLower(s.Code)] = s
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	names, err := shipNameIndex(db.DB)
+	if err != nil {
+		return nil, err
+	}
+	for key, name := range names {
+		if _, taken := index[key]; !taken {
+			index[key] = byName[name]
+		}
+	}
+	return index, nil
+}
+
+// find looks a value up as a ship code first, then as a name or alias
+func (ix shipIndex) find(v string) (importShip, bool) {
+	if s, ok := ix[strings.ToLower(strings.TrimSpace(v))]; ok {
+		return s, true
+	}
+	s, ok := ix[shipname.Key(v)]
+	return s, ok
+}
+
+// allSensors returns every configured sensor, active or not, in display order
+func allSensors() ([]SensorConfig, error) {
+	rows, err := db.DB.Query("SELECT code, name, is_active FROM fms_sensor_config ORDER BY display_order ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sensors []SensorConfig
+	for rows.Next() {
+		var s SensorConfig
+		if err := rows.Scan(&s.Code, &s.Name, &s.IsActive); err == nil {
+			sensors = append(sensors, s)
+		}
+	}
+	return sensors, rows.Err()
+}
+
+// guessReportMapping maps a header to a field by its name, "" when unknown
+func guessReportMapping(header string, sensors []SensorConfig) string {
+	h := normalizeHeader(header)
+	switch h {
+	case "ship", "shipname", "kapal", "namakapal", "vessel", "vesselname":
+		return "ship"
+	case "date", "tanggal", "reportdate", "tgl", "tanggallaporan":
+		return "date"
+	case "period", "periode", "bulan", "month":
+		return "period"
+	case "project", "proyek", "projectcode":
+		return "project"
+	case "no", "id", "code", "kode", "online", "offline", "online%", "percentonline", "createdat", "updatedat":
+		return "ignore"
+	}
+	for _, s := range sensors {
+		if h == normalizeHeader(s.Code) || h == normalizeHeader(s.Name) {
+			return "sensor:" + s.Code
+		}
+	}
+	return ""
+}
+
+// reportImportColumns resolves the mapping of each column from col_<i> params, guessing
+// from the header for columns the user has not mapped yet
+func reportImportColumns(params url.Values, header []string, rows [][]string, sensors []SensorConfig) []importColumn {
+	cols := make([]importColumn, len(header))
+	for i, h := range header {
+		mapping := guessReportMapping(h, sensors)
+		if v, set := params["col_"+strconv.Itoa(i)]; set && len(v) > 0 {
+			mapping = v[0]
+		}
+		cols[i] = importColumn{Index: i, Header: h, Mapping: mapping}
+		for _, row := range rows {
+			if v := cellAt(row, i); v != "" {
+				cols[i].Sample = v
+				break
+			}
+		}
+	}
+	return cols
+}
+
+// validateReportImport turns the file rows into reports and reports per-row errors:
+// unknown ship/project/sensor, invalid values and duplicate periods (in the file or database)
+func validateReportImport(cols []importColumn, rows [][]string, defaultProject string) ([]reportImportRow, error) {
+	ships, err := importShipIndex()
+	if err != nil {
+		return nil, err
+	}
+	projects := map[string]bool{}
+	pRows, err := db.DB.Query("SELECT code FROM fms_projects")
+	if err != nil {
+		return nil, err
+	}
+	for pRows.Next() {
+		var p string
+		if pRows.Scan(&p) == nil {
+			projects[strings.ToUpper(p)] = true
+		}
+	}
+	pRows.Close()
+	names := sensorNames()
+
+	field := map[string]int{"ship": -1, "date": -1, "period": -1, "project": -1}
+	for _, col := range cols {
+		if _, ok := field[col.Mapping]; ok {
+			field[col.Mapping] = col.Index
+		}
+	}
+
+	out := make([]reportImportRow, 0, len(rows))
+	seen := map[string]int{}
+	for i, row := range rows {
+		r := reportImportRow{Line: i + 2, Sensors: map[string]bool{}}
+		fail := func(format string, args ...any) { r.Errors = append(r.Errors, fmt.Sprintf(format, args...)) }
+
+		// Ship
+		name := cellAt(row, field["ship"])
+		if name == "" {
+			fail("Nama kapal kosong")
+		} else if s, ok := ships.find(name); ok {
+			r.ShipName, r.ShipCode = s.Name, s.Code
+		} else {
+			r.ShipName = name
+			fail("Kapal tidak dikenal: %s", name)
+		}
+
+		// Project
+		r.Project = strings.ToUpper(cellAt(row, field["project"]))
+		if r.Project == "" {
+			r.Project = strings.ToUpper(defaultProject)
+		}
+		if r.Project == "" {
+			fail("Project belum dipilih")
+		} else if !projects[r.Project] {
+			fail("Project tidak dikenal: %s", r.Project)
+		}
+
+		// Date and period: either can be derived from the other
+		var period time.Time
+		if v := cellAt(row, field["date"]); v != "" {
+			d, err := parseImportDate(v)
+			if err != nil {
+				fail("%v", err)
+			}
+			r.ReportDate = d
+			period = time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, time.UTC)
+		}
+		if v := cellAt(row, field["period"]); v != "" {
+			p, err := parseImportPeriod(v)
+			if err != nil {
+				fail("%v", err)
+			}
+			period = p
+			if r.ReportDate.IsZero() {
+				r.ReportDate = p
+			}
+		}
+		if period.IsZero() && len(r.Errors) == 0 {
+			fail("Tanggal atau periode kosong")
+		}
+
+		// Sensors
+		for _, col := range cols {
+			v := cellAt(row, col.Index)
+			if v == "" || v == "-" {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(col.Mapping, "sensor:"):
+				code := strings.TrimPrefix(col.Mapping, "sensor:")
+				if _, known := names[code]; !known {
+					fail("Sensor tidak dikenal: %s", code)
+					continue
+				}
+				status, ok := parseSensorValue(v)
+				if !ok {
+					fail("Nilai %s tidak valid: %q", names[code], v)
+					continue
+				}
+				r.Sensors[code] = status
+				if status {
+					r.Online++
+				} else {
+					r.Offline++
+				}
+			case col.Mapping == "":
+				fail("Sensor tidak dikenal: kolom %q", col.Header)
+			}
+		}
+		if len(r.Sensors) == 0 {
+			fail("Tidak ada data sensor")
+		}
+
+		if !period.IsZero() {
+			r.Code = reportCode(r.Project, r.ShipCode, period)
+			key := r.ShipName + "|" + r.Code
+			if first, dup := seen[key]; dup {
+				fail("Duplikat periode dengan baris %d", first)
+			} else {
+				seen[key] = r.Line
+			}
+		}
+		out = append(out, r)
+	}
+
+	// Duplicates against reports already in the database
+	var codes []string
+	for _, r := range out {
+		if r.Code != "" {
+			codes = append(codes, r.Code)
+		}
+	}
+	if len(codes) > 0 {
+		existing := map[string]bool{}
+		eRows, err := db.DB.Query("SELECT ship_name, code FROM fms_device_reports WHERE code = ANY($1)", pq.Array(codes))
+		if err != nil {
+			return nil, err
+		}
+		for eRows.Next() {
+			var ship, code string
+			if eRows.Scan(&ship, &code) == nil {
+				existing[ship+"|"+code] = true
+			}
+		}
+		eRows.Close()
+		for i := range out {
+			if existing[out[i].ShipName+"|"+out[i].Code] {
+				out[i].Errors = append(out[i].Errors, "Laporan periode ini sudah ada: "+out[i].Code)
+			}
+		}
+	}
+	return out, nil
+}
+
+func reportImportOptions(sensors []SensorConfig) []importOption {
+	opts := []importOption{
+		{"", "— pilih —"},
+		{"ignore", "Abaikan"},
+		{"ship", "Nama / kode kapal"},
+		{"date", "Tanggal laporan"},
+		{"period", "Periode (bulan)"},
+		{"project", "Project"},
+	}
+	for _, s := range sensors {
+		opts = append(opts, importOption{"sensor:" + s.Code, "Sensor: " + s.Name})
+	}
+	return opts
+}
+
+// UploadReportImport stores an uploaded history file and opens its preview
+func UploadReportImport(c *gin.Context) {
+	token, err := saveImportUpload(c, reportImportKind)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/import?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/settings/import/reports/"+token+"?project="+url.QueryEscape(c.PostForm("project")))
+}
+
+// PreviewReportImport shows the column mapping and a dry run of the import
+func PreviewReportImport(c *gin.Context) {
+	token := c.Param("token")
+	filename, header, rows, err := loadImportUpload(token, reportImportKind)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/import?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	sensors, err := allSensors()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	project := c.Query("project")
+	cols := reportImportColumns(c.Request.URL.Query(), header, rows, sensors)
+	results, err := validateReportImport(cols, rows, project)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	var errorRows, okRows []reportImportRow
+	for _, r := range results {
+		if len(r.Errors) > 0 {
+			errorRows = append(errorRows, r)
+		} else {
+			okRows = append(okRows, r)
+		}
+	}
+	okCount := len(okRows)
+	if len(okRows) > 50 {
+		okRows = okRows[:50]
+	}
+	errorCount := len(errorRows)
+	if len(errorRows) > 500 {
+		errorRows = errorRows[:500]
+	}
+
+	var projects []string
+	pRows, err := db.DB.Query("SELECT code FROM fms_projects WHERE is_active = true ORDER BY code ASC")
+	if err == nil {
+		defer pRows.Close()
+		for pRows.Next() {
+			var p string
+			if pRows.Scan(&p) == nil {
+				projects = append(projects, p)
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_import_preview.html", gin.H{
+		"Token":         token,
+		"Filename":      filename,
+		"Project":       project,
+		"Projects":      projects,
+		"Columns":       cols,
+		"Options":       reportImportOptions(sensors),
+		"SensorNames":   sensorNames(),
+		"OkRows":        okRows,
+		"OkCount":       okCount,
+		"ErrorRows":     errorRows,
+		"ErrorCount":    errorCount,
+		"TotalCount":    len(results),
+		"ActiveSidebar": "import",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// ImportReportsJobKind is the background job kind that commits a previewed report import
+const ImportReportsJobKind = "report.import"
+
+// importReportsJob is the payload of ImportReportsJobKind: the upload and the form
+// submitted from its preview
+type importReportsJob struct {
+	Token      string     `json:"token"`
+	Project    string     `json:"project"`
+	Params     url.Values `json:"params"`
+	SkipErrors bool       `json:"skip_errors"`
+}
+
+// validImportRows validates the upload with the mapping in p and returns the rows
+// without errors, oldest first so the open alerts end up reflecting the latest
+// imported report, and the number of rows left out
+func validImportRows(p importReportsJob) ([]reportImportRow, int, error) {
+	_, header, rows, err := loadImportUpload(p.Token, reportImportKind)
+	if err != nil {
+		return nil, 0, err
+	}
+	sensors, err := allSensors()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cols := reportImportColumns(p.Params, header, rows, sensors)
+	results, err := validateReportImport(cols, rows, p.Project)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var valid []reportImportRow
+	for _, r := range results {
+		if len(r.Errors) == 0 {
+			valid = append(valid, r)
+		}
+	}
+	sort.SliceStable(valid, func(i, j int) bool { return valid[i].ReportDate.Before(valid[j].ReportDate) })
+	return valid, len(results) - len(valid), nil
+}
+
+// CommitReportImport checks the previewed import once more and queues its commit. Rows
+// with errors abort the import unless skip_errors is set.
+func CommitReportImport(c *gin.Context) {
+	p := importReportsJob{
+		Token:      c.Param("token"),
+		Project:    c.PostForm("project"),
+		Params:     c.Request.PostForm,
+		SkipErrors: c.PostForm("skip_errors") == "on",
+	}
+	valid, skipped, err := validImportRows(p)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/import?error="+url.QueryEscape(err.Error()))
+		return
+	}
+
+	preview := "/settings/import/reports/" + p.Token + "?" + p.Params.Encode()
+	if skipped > 0 && !p.SkipErrors {
+		c.Redirect(http.StatusSeeOther, preview+"&error="+url.QueryEscape(fmt.Sprintf("Masih ada %d baris bermasalah", skipped)))
+		return
+	}
+	if len(valid) == 0 {
+		c.Redirect(http.StatusSeeOther, preview+"&error=Tidak+ada+baris+yang+bisa+diimpor")
+		return
+	}
+
+	enqueueJob(c, ImportReportsJobKind, p)
+}
+
+// ImportReportsJob is the jobs.Handler for ImportReportsJobKind. It writes the valid rows
+// in one transaction through the CreateReport write path; the file is validated again
+// since ships, sensors and reports may have changed since the preview.
+func ImportReportsJob(ctx context.Context, payload json.RawMessage) error {
+	var p importReportsJob
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return jobs.Permanent(err)
+	}
+	valid, skipped, err := validImportRows(p)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	if skipped > 0 && !p.SkipErrors {
+		return jobs.Permanent(fmt.Errorf("masih ada %d baris bermasalah, buka lagi preview import", skipped))
+	}
+	if len(valid) == 0 {
+		return jobs.Permanent(fmt.Errorf("tidak ada baris yang bisa diimpor"))
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	names, err := shipNameIndex(tx)
+	if err != nil {
+		return err
+	}
+	var created []events.ReportCreated
+	opened := 0
+	for _, row := range valid {
+		r := DeviceReport{Code: row.Code, ReportDate: row.ReportDate, ShipName: row.ShipName, SensorsData: row.Sensors}
+		ev, err := insertImportedReport(tx, names, &r, row.Project)
+		if err != nil {
+			return jobs.Permanent(fmt.Errorf("baris %d: %w", row.Line, err))
+		}
+		opened += len(ev.Opened)
+		created = append(created, ev)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	deleteImportUpload(p.Token)
+
+	for _, ev := range created {
+		events.Publish(ev)
+	}
+
+	// The upload is gone now, so a failure here must not make the job retry
+	msg := fmt.Sprintf("Import selesai: %d laporan disimpan, %d baris dilewati, %d alert dibuka ✅", len(created), skipped, opened)
+	if err := jobs.SetResult(ctx, jobs.Result{Message: msg}); err != nil {
+		log.Println("import reports: store result:", err)
+	}
+	return nil
+}
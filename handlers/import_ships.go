@@ -0,0 +1,558 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"fms-app/db"
+	"fms-app/events"
+	"fms-app/shipname"
+
+	"github.com/gin-gonic/gin"
+)
+
+const shipImportKind = "ships"
+
+// Ship master files have "name" and "code" columns, "meta:<key>" columns for metadata and
+// "sensor:<code>" columns for per-ship overrides (on/off, "default" to follow the global
+// setting). Blank cells leave the current value untouched.
+const (
+	shipMetaPrefix   = "meta:"
+	shipSensorPrefix = "sensor:"
+	shipSensorReset  = "default"
+)
+
+// masterShip is a ship with its metadata and sensor overrides as stored in the database
+type masterShip struct {
+	ID        int
+	Name      string
+	Code      string
+	Metadata  map[string]string
+	Overrides map[string]bool
+}
+
+// shipImportRow is one row of a ship master file compared against the database
+type shipImportRow struct {
+	Line     int
+	Action   string // "create", "update" or "unchanged"
+	ID       int
+	Name     string
+	Code     string
+	OldName  string
+	Metadata map[string]string
+	// Sensors holds the override to write per sensor code; nil removes the override
+	Sensors map[string]*bool
+	Changes []string
+	Errors  []string
+}
+
+// loadMasterShips reads every ship with its metadata and overrides, ordered by name
+func loadMasterShips() ([]masterShip, error) {
+	rows, err := db.DB.Query("SELECT id, name, COALESCE(code, ''), COALESCE(metadata, '{}') FROM fms_ships ORDER BY name ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ships []masterShip
+	byID := map[int]int{}
+	for rows.Next() {
+		var s masterShip
+		var raw []byte
+		if err := rows.Scan(&s.ID, &s.Name, &s.Code, &raw); err != nil {
+			return nil, err
+		}
+		s.Metadata = decodeShipMetadata(raw)
+		s.Overrides = map[string]bool{}
+		byID[s.ID] = len(ships)
+		ships = append(ships, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	oRows, err := db.DB.Query("SELECT ship_id, sensor_code, is_active FROM fms_ship_sensors")
+	if err != nil {
+		return nil, err
+	}
+	defer oRows.Close()
+	for oRows.Next() {
+		var id int
+		var code string
+		var active bool
+		if oRows.Scan(&id, &code, &active) == nil {
+			if i, ok := byID[id]; ok {
+				ships[i].Overrides[code] = active
+			}
+		}
+	}
+	return ships, oRows.Err()
+}
+
+// decodeShipMetadata flattens the JSONB metadata to strings for display and diffing
+func decodeShipMetadata(raw []byte) map[string]string {
+	var m map[string]any
+	out := map[string]string{}
+	if json.Unmarshal(raw, &m) != nil {
+		return out
+	}
+	for k, v := range m {
+		if v != nil {
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+// shipImportField resolves a header to "name", "code", "meta:<key>" or "sensor:<code>".
+// Headers that are not a known field or sensor are kept as metadata keys.
+func shipImportField(header string, sensors []SensorConfig) string {
+	h := strings.TrimSpace(header)
+	lower := strings.ToLower(h)
+	switch {
+	case strings.HasPrefix(lower, shipMetaPrefix):
+		return shipMetaPrefix + strings.TrimSpace(h[len(shipMetaPrefix):])
+	case strings.HasPrefix(lower, shipSensorPrefix):
+		return shipSensorPrefix + strings.TrimSpace(h[len(shipSensorPrefix):])
+	}
+	switch normalizeHeader(h) {
+	case "name", "nama", "namakapal", "ship", "shipname", "kapal", "vessel", "vesselname":
+		return "name"
+	case "code", "kode", "shipcode", "kodekapal":
+		return "code"
+	}
+	for _, s := range sensors {
+		if normalizeHeader(h) == normalizeHeader(s.Code) || normalizeHeader(h) == normalizeHeader(s.Name) {
+			return shipSensorPrefix + s.Code
+		}
+	}
+	return shipMetaPrefix + h
+}
+
+// validateShipImport matches each row to a ship by code (or by name for legacy ships
+// without a code) and computes what the import would change
+func validateShipImport(header []string, rows [][]string) ([]shipImportRow, []importColumn, error) {
+	ships, err := loadMasterShips()
+	if err != nil {
+		return nil, nil, err
+	}
+	sensors, err := allSensors()
+	if err != nil {
+		return nil, nil, err
+	}
+	sensorName := map[string]string{}
+	for _, s := range sensors {
+		sensorName[s.Code] = s.Name
+	}
+
+	byCode := map[string][]int{}
+	byName := map[string]int{}
+	byID := map[int]int{}
+	for i, s := range ships {
+		if s.Code != "" {
+			byCode[strings.ToLower(s.Code)] = append(byCode[strings.ToLower(s.Code)], i)
+		}
+		byName[shipname.Key(s.Name)] = i
+		byID[s.ID] = i
+	}
+	aRows, err := db.DB.Query("SELECT alias_key, ship_id FROM fms_ship_aliases")
+	if err != nil {
+		return nil, nil, err
+	}
+	for aRows.Next() {
+		var key string
+		var id int
+		if aRows.Scan(&key, &id) == nil {
+			if _, taken := byName[key]; !taken {
+				byName[key] = byID[id]
+			}
+		}
+	}
+	aRows.Close()
+
+	cols := make([]importColumn, len(header))
+	nameCol, codeCol := -1, -1
+	for i, h := range header {
+		cols[i] = importColumn{Index: i, Header: h, Mapping: shipImportField(h, sensors)}
+		switch cols[i].Mapping {
+		case "name":
+			nameCol = i
+		case "code":
+			codeCol = i
+		}
+	}
+	if nameCol < 0 || codeCol < 0 {
+		return nil, cols, fmt.Errorf("file harus memiliki kolom name dan code")
+	}
+
+	out := make([]shipImportRow, 0, len(rows))
+	seenCode := map[string]int{}
+	seenName := map[string]int{}
+	for i, row := range rows {
+		r := shipImportRow{Line: i + 2, Metadata: map[string]string{}, Sensors: map[string]*bool{}}
+		fail := func(format string, args ...any) { r.Errors = append(r.Errors, fmt.Sprintf(format, args...)) }
+		r.Name = shipname.Clean(cellAt(row, nameCol))
+		r.Code = cellAt(row, codeCol)
+
+		if r.Name == "" {
+			fail("Nama kapal kosong")
+		}
+		if r.Code == "" {
+			fail("Kode kapal kosong")
+		}
+		if first, dup := seenCode[strings.ToLower(r.Code)]; dup && r.Code != "" {
+			fail("Kode %s duplikat dengan baris %d", r.Code, first)
+		} else {
+			seenCode[strings.ToLower(r.Code)] = r.Line
+		}
+		if first, dup := seenName[shipname.Key(r.Name)]; dup && r.Name != "" {
+			fail("Nama %s duplikat dengan baris %d", r.Name, first)
+		} else {
+			seenName[shipname.Key(r.Name)] = r.Line
+		}
+
+		// Match on code; fall back to the name or an alias for ships that never had a code
+		var current *masterShip
+		if matches := byCode[strings.ToLower(r.Code)]; len(matches) > 1 {
+			fail("Kode %s dipakai lebih dari satu kapal", r.Code)
+		} else if len(matches) == 1 {
+			current = &ships[matches[0]]
+		} else if i, ok := byName[shipname.Key(r.Name)]; ok && r.Name != "" {
+			if ships[i].Code == "" {
+				current = &ships[i]
+			} else {
+				fail("Nama %s sudah dipakai kapal berkode %s", r.Name, ships[i].Code)
+			}
+		}
+		if current != nil && r.Name != "" {
+			if other, taken := byName[shipname.Key(r.Name)]; taken && ships[other].ID != current.ID {
+				fail("Nama %s sudah dipakai kapal lain", r.Name)
+			} else if taken {
+				// Another spelling of the same ship keeps the master name
+				r.Name = current.Name
+			}
+		}
+
+		for _, col := range cols {
+			v := cellAt(row, col.Index)
+			if v == "" {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(col.Mapping, shipMetaPrefix):
+				key := strings.TrimPrefix(col.Mapping, shipMetaPrefix)
+				if key == "" {
+					fail("Kolom metadata tanpa nama")
+					continue
+				}
+				r.Metadata[key] = v
+			case strings.HasPrefix(col.Mapping, shipSensorPrefix):
+				code := strings.TrimPrefix(col.Mapping, shipSensorPrefix)
+				if _, known := sensorName[code]; !known {
+					fail("Sensor tidak dikenal: %s", code)
+					continue
+				}
+				if strings.EqualFold(v, shipSensorReset) || v == "-" {
+					r.Sensors[code] = nil
+					continue
+				}
+				status, ok := parseSensorValue(v)
+				if !ok {
+					fail("Nilai %s tidak valid: %q", sensorName[code], v)
+					continue
+				}
+				r.Sensors[code] = &status
+			}
+		}
+
+		// Diff against the current master data
+		if current == nil {
+			r.Action = "create"
+			for code, status := range r.Sensors {
+				if status != nil {
+					r.Changes = append(r.Changes, fmt.Sprintf("Sensor %s: %s", sensorName[code], onOff(*status)))
+				}
+			}
+			for k, v := range r.Metadata {
+				r.Changes = append(r.Changes, fmt.Sprintf("%s: %s", k, v))
+			}
+		} else {
+			r.ID = current.ID
+			if current.Name != r.Name {
+				r.OldName = current.Name
+				r.Changes = append(r.Changes, fmt.Sprintf("Nama: %s → %s (laporan & alert ikut diganti)", current.Name, r.Name))
+			}
+			if current.Code != r.Code {
+				r.Changes = append(r.Changes, fmt.Sprintf("Kode: %s → %s", orDash(current.Code), r.Code))
+			}
+			for k, v := range r.Metadata {
+				if current.Metadata[k] != v {
+					r.Changes = append(r.Changes, fmt.Sprintf("%s: %s → %s", k, orDash(current.Metadata[k]), v))
+				} else {
+					delete(r.Metadata, k)
+				}
+			}
+			for code, status := range r.Sensors {
+				old, has := current.Overrides[code]
+				switch {
+				case status == nil && has:
+					r.Changes = append(r.Changes, fmt.Sprintf("Sensor %s: %s → default", sensorName[code], onOff(old)))
+				case status != nil && (!has || old != *status):
+					from := "default"
+					if has {
+						from = onOff(old)
+					}
+					r.Changes = append(r.Changes, fmt.Sprintf("Sensor %s: %s → %s", sensorName[code], from, onOff(*status)))
+				default:
+					delete(r.Sensors, code)
+				}
+			}
+			r.Action = "update"
+			if len(r.Changes) == 0 {
+				r.Action = "unchanged"
+			}
+		}
+		sort.Strings(r.Changes)
+		out = append(out, r)
+	}
+	return out, cols, nil
+}
+
+func onOff(v bool) string {
+	if v {
+		return "on"
+	}
+	return "off"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// UploadShipImport stores an uploaded ship master file and opens its preview
+func UploadShipImport(c *gin.Context) {
+	token, err := saveImportUpload(c, shipImportKind)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/import?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/settings/import/ships/"+token)
+}
+
+// PreviewShipImport shows the per-ship diff the import would apply
+func PreviewShipImport(c *gin.Context) {
+	token := c.Param("token")
+	filename, header, rows, err := loadImportUpload(token, shipImportKind)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/import?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	results, cols, err := validateShipImport(header, rows)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/import?error="+url.QueryEscape(err.Error()))
+		return
+	}
+
+	counts := map[string]int{}
+	for _, r := range results {
+		if len(r.Errors) > 0 {
+			counts["error"]++
+		} else {
+			counts[r.Action]++
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_import_ships_preview.html", gin.H{
+		"Token":         token,
+		"Filename":      filename,
+		"Columns":       cols,
+		"Rows":          results,
+		"Counts":        counts,
+		"ActiveSidebar": "import",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// CommitShipImport creates and updates ships in one transaction. A rename is carried over
+// to the reports and alerts stored under the old name.
+func CommitShipImport(c *gin.Context) {
+	token := c.Param("token")
+	_, header, rows, err := loadImportUpload(token, shipImportKind)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/import?error="+url.QueryEscape(err.Error()))
+		return
+	}
+	results, _, err := validateShipImport(header, rows)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/import?error="+url.QueryEscape(err.Error()))
+		return
+	}
+
+	preview := "/settings/import/ships/" + token
+	var valid []shipImportRow
+	for _, r := range results {
+		if len(r.Errors) == 0 && r.Action != "unchanged" {
+			valid = append(valid, r)
+		}
+	}
+	skipped := 0
+	for _, r := range results {
+		if len(r.Errors) > 0 {
+			skipped++
+		}
+	}
+	if skipped > 0 && c.PostForm("skip_errors") != "on" {
+		c.Redirect(http.StatusSeeOther, preview+"?error="+url.QueryEscape(fmt.Sprintf("Masih ada %d baris bermasalah", skipped)))
+		return
+	}
+	if len(valid) == 0 {
+		c.Redirect(http.StatusSeeOther, preview+"?error=Tidak+ada+perubahan+yang+bisa+disimpan")
+		return
+	}
+
+	globals := map[string]bool{}
+	if sensors, err := allSensors(); err == nil {
+		for _, s := range sensors {
+			globals[s.Code] = s.IsActive
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var published []events.Event
+	created, updated := 0, 0
+	for _, r := range valid {
+		meta, _ := json.Marshal(r.Metadata)
+		if r.Action == "create" {
+			err = tx.QueryRow(
+				"INSERT INTO fms_ships (name, code, metadata) VALUES ($1, $2, $3) RETURNING id",
+				r.Name, r.Code, meta,
+			).Scan(&r.ID)
+			published = append(published, events.ShipCreated{ID: r.ID, Name: r.Name, Code: r.Code})
+			created++
+		} else {
+			_, err = tx.Exec(
+				"UPDATE fms_ships SET name = $1, code = $2, metadata = COALESCE(metadata, '{}') || $3::jsonb WHERE id = $4",
+				r.Name, r.Code, meta, r.ID,
+			)
+			if err == nil && r.OldName != "" {
+				err = renameShipHistory(tx, r.OldName, r.Name)
+			}
+			if err == nil && r.OldName != "" {
+				// Reports sent under the old name keep resolving to this ship
+				err = addShipAlias(tx, r.ID, r.OldName)
+			}
+			updated++
+		}
+		if err != nil {
+			c.Redirect(http.StatusSeeOther, preview+"?error="+url.QueryEscape(fmt.Sprintf("Baris %d: %v", r.Line, err)))
+			return
+		}
+
+		for code, status := range r.Sensors {
+			if status == nil {
+				_, err = tx.Exec("DELETE FROM fms_ship_sensors WHERE ship_id = $1 AND sensor_code = $2", r.ID, code)
+				published = append(published, events.SensorConfigChanged{Code: code, ShipID: r.ID, IsActive: globals[code]})
+			} else {
+				_, err = tx.Exec(`
+					INSERT INTO fms_ship_sensors (ship_id, sensor_code, is_active) VALUES ($1, $2, $3)
+					ON CONFLICT (ship_id, sensor_code) DO UPDATE SET is_active = EXCLUDED.is_active`,
+					r.ID, code, *status,
+				)
+				published = append(published, events.SensorConfigChanged{Code: code, ShipID: r.ID, IsActive: *status})
+			}
+			if err != nil {
+				c.Redirect(http.StatusSeeOther, preview+"?error="+url.QueryEscape(fmt.Sprintf("Baris %d: %v", r.Line, err)))
+				return
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	deleteImportUpload(token)
+
+	for _, ev := range published {
+		events.Publish(ev)
+	}
+
+	msg := fmt.Sprintf("Import kapal selesai: %d dibuat, %d diperbarui, %d baris dilewati 🚢", created, updated, skipped)
+	c.Redirect(http.StatusSeeOther, "/settings/ships?success="+url.QueryEscape(msg))
+}
+
+// renameShipHistory moves reports and alerts stored under a ship's old name to the new one
+func renameShipHistory(tx *sql.Tx, oldName, newName string) error {
+	if _, err := tx.Exec("UPDATE fms_device_reports SET ship_name = $1 WHERE ship_name = $2", newName, oldName); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE fms_alerts SET ship_name = $1 WHERE ship_name = $2", newName, oldName)
+	return err
+}
+
+// ExportShipsCSV downloads the ship master list in the format accepted by the ship import
+func ExportShipsCSV(c *gin.Context) {
+	ships, err := loadMasterShips()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	sensors, err := allSensors()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	keySet := map[string]bool{}
+	for _, s := range ships {
+		for k := range s.Metadata {
+			keySet[k] = true
+		}
+	}
+	var keys []string
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	header := []string{"name", "code"}
+	for _, k := range keys {
+		header = append(header, shipMetaPrefix+k)
+	}
+	for _, s := range sensors {
+		header = append(header, shipSensorPrefix+s.Code)
+	}
+
+	w := newCSVWriter(c, "ships.csv")
+	_ = w.Write(header)
+	for _, s := range ships {
+		record := []string{s.Name, s.Code}
+		for _, k := range keys {
+			record = append(record, s.Metadata[k])
+		}
+		for _, sensor := range sensors {
+			v := ""
+			if active, ok := s.Overrides[sensor.Code]; ok {
+				v = onOff(active)
+			}
+			record = append(record, v)
+		}
+		_ = w.Write(record)
+	}
+	w.Flush()
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"fms-app/db"
+	"fms-app/gatewaylog"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxImportSize = 10 << 20
+
+// SettingsImportPage renders the upload forms for data imports and the latest gateway logs
+func SettingsImportPage(c *gin.Context) {
+	var projects []string
+	rows, err := db.DB.Query("SELECT code FROM fms_projects WHERE is_active = true ORDER BY code ASC")
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var p string
+			if err := rows.Scan(&p); err == nil {
+				projects = append(projects, p)
+			}
+		}
+	}
+
+	type LogRow struct {
+		ShipName   string
+		Filename   string
+		Format     string
+		From, To   time.Time
+		Samples    int
+		Readings   int
+		Ignored    string
+		UploadedAt time.Time
+	}
+	var ships []Ship
+	if sRows, err := db.DB.Query("SELECT id, name FROM fms_ships ORDER BY name ASC"); err == nil {
+		defer sRows.Close()
+		for sRows.Next() {
+			var s Ship
+			if err := sRows.Scan(&s.ID, &s.Name); err == nil {
+				ships = append(ships, s)
+			}
+		}
+	}
+	var logs []LogRow
+	if lRows, err := db.DB.Query(`
+		SELECT s.name, l.filename, l.format, l.covered_from, l.covered_to, l.samples, l.readings,
+		       l.ignored_sensors, l.uploaded_at
+		FROM fms_gateway_logs l
+		JOIN fms_ships s ON s.id = l.ship_id
+		ORDER BY l.uploaded_at DESC
+		LIMIT 10
+	`); err == nil {
+		defer lRows.Close()
+		for lRows.Next() {
+			var l LogRow
+			if err := lRows.Scan(&l.ShipName, &l.Filename, &l.Format, &l.From, &l.To, &l.Samples, &l.Readings, &l.Ignored, &l.UploadedAt); err == nil {
+				logs = append(logs, l)
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_import.html", gin.H{
+		"Projects":      projects,
+		"Ships":         ships,
+		"LogFormats":    gatewaylog.Formats(),
+		"GatewayLogs":   logs,
+		"LogMinPercent": logOnlineMinPercent(),
+		"ActiveSidebar": "import",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// saveImportUpload stores the uploaded "file" form field so the preview and commit steps
+// can re-read it, and returns its token. Uploads older than a day are dropped.
+func saveImportUpload(c *gin.Context, kind string) (string, error) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return "", fmt.Errorf("file belum dipilih")
+	}
+	if fh.Size > maxImportSize {
+		return "", fmt.Errorf("file terlalu besar (maks 10 MB)")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	// Parse once now so a broken file is rejected at upload
+	if _, _, err := readTable(fh.Filename, data); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	_, _ = db.DB.Exec("DELETE FROM fms_import_uploads WHERE created_at < NOW() - INTERVAL '1 day'")
+	_, err = db.DB.Exec(
+		"INSERT INTO fms_import_uploads (token, kind, filename, content) VALUES ($1, $2, $3, $4)",
+		token, kind, fh.Filename, data,
+	)
+	return token, err
+}
+
+// loadImportUpload reads back a stored upload and parses it
+func loadImportUpload(token, kind string) (filename string, header []string, rows [][]string, err error) {
+	var data []byte
+	err = db.DB.QueryRow(
+		"SELECT filename, content FROM fms_import_uploads WHERE token = $1 AND kind = $2",
+		token, kind,
+	).Scan(&filename, &data)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("upload tidak ditemukan atau sudah kedaluwarsa")
+	}
+	header, rows, err = readTable(filename, data)
+	return filename, header, rows, err
+}
+
+func deleteImportUpload(token string) {
+	_, _ = db.DB.Exec("DELETE FROM fms_import_uploads WHERE token = $1", token)
+}
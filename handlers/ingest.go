@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/events"
+	"fms-app/openapi"
+	"fms-app/readings"
+	"fms-app/telemetry"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxIngestSamples bounds the samples of one ingestion batch
+	maxIngestSamples = 1000
+	// ingestClockSkew is how far ahead of the server a gateway clock may run
+	ingestClockSkew = 5 * time.Minute
+)
+
+// DeviceIngestRequest is a batch of gateway samples for the reports of one project
+type DeviceIngestRequest struct {
+	ProjectCode string         `json:"project_code"`
+	Samples     []DeviceSample `json:"samples"`
+}
+
+// DeviceSample is the state of some sensors of one ship at one moment
+type DeviceSample struct {
+	ShipCode  string                        `json:"ship_code"`
+	Timestamp time.Time                     `json:"timestamp"`
+	Sensors   map[string]DeviceSensorSample `json:"sensors"`
+}
+
+// DeviceSensorSample is the status of one sensor with an optional reading
+type DeviceSensorSample struct {
+	Status string   `json:"status" openapi:"enum=online|offline"`
+	Value  *float64 `json:"value,omitempty"`
+}
+
+// DeviceIngestResult lists the period reports a batch was rolled into. Stale counts the
+// sensor samples older than the last one seen, which were not applied.
+type DeviceIngestResult struct {
+	Reports []DeviceIngestReport `json:"reports"`
+	Stale   int                  `json:"stale"`
+}
+
+// DeviceIngestReport is one period report touched by a batch; Sensors lists the sensors
+// whose status was written
+type DeviceIngestReport struct {
+	ID       int      `json:"id"`
+	Code     string   `json:"code"`
+	ShipName string   `json:"ship_name"`
+	Created  bool     `json:"created"`
+	Sensors  []string `json:"sensors"`
+}
+
+// ingestShip is a ship referenced by a batch with the sensors that are active for it
+type ingestShip struct {
+	ID      int
+	Name    string
+	Code    string
+	Sensors map[string]bool
+}
+
+// deviceToken is an authenticated gateway; ShipID 0 may report for any ship
+type deviceToken struct {
+	ID     int
+	ShipID int
+}
+
+// hashDeviceToken is the form a device token is stored and looked up in
+func hashDeviceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiDeviceToken authenticates the bearer token of a gateway and records its use,
+// answering 401 when the token is missing, unknown or disabled
+func apiDeviceToken(c *gin.Context) (deviceToken, bool) {
+	var t deviceToken
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	if !found || token == "" {
+		c.Header("WWW-Authenticate", `Bearer realm="fms-ingest"`)
+		apiError(c, http.StatusUnauthorized, "unauthorized", "a device token is required (Authorization: Bearer <token>)")
+		return t, false
+	}
+
+	err := db.DB.QueryRow(`
+		UPDATE fms_device_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND is_active = true
+		RETURNING id, COALESCE(ship_id, 0)
+	`, hashDeviceToken(token)).Scan(&t.ID, &t.ShipID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.Header("WWW-Authenticate", `Bearer realm="fms-ingest", error="invalid_token"`)
+		apiError(c, http.StatusUnauthorized, "unauthorized", "device token is invalid or disabled")
+		return t, false
+	}
+	if err != nil {
+		apiInternal(c, err)
+		return t, false
+	}
+	return t, true
+}
+
+// APIIngestDevice accepts a batch of gateway samples and rolls them into period reports
+func APIIngestDevice(c *gin.Context) {
+	token, ok := apiDeviceToken(c)
+	if !ok {
+		return
+	}
+	var req DeviceIngestRequest
+	if !apiBind(c, &req) {
+		return
+	}
+
+	result, err := ingestSamples(req.ProjectCode, req.Samples, token.ShipID)
+	var invalid *openapi.ValidationError
+	if errors.As(err, &invalid) {
+		apiInvalid(c, invalid.Fields)
+		return
+	}
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ingestSamples is the device ingestion pipeline. It checks the samples against the
+// effective sensors of their ships, keeps the latest sample of every ship sensor in
+// fms_sensor_last_seen, stores the values in the readings time series and merges the
+// statuses into each ship's period report of projectCode, creating it when needed, with
+// the source recorded as "device". onlyShip restricts the batch to one ship (0 allows any).
+// A *openapi.ValidationError lists the rejected fields; nothing is written unless the whole
+// batch is valid.
+func ingestSamples(projectCode string, samples []DeviceSample, onlyShip int) (DeviceIngestResult, error) {
+	result := DeviceIngestResult{Reports: []DeviceIngestReport{}}
+
+	// Partitions for the values are DDL, created before the batch transaction
+	var valueTimes []time.Time
+	for _, sample := range samples {
+		for _, s := range sample.Sensors {
+			if s.Value != nil {
+				valueTimes = append(valueTimes, sample.Timestamp)
+				break
+			}
+		}
+	}
+	if err := readings.EnsurePartitions(context.Background(), valueTimes...); err != nil {
+		return result, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	project, ships, err := validateIngest(tx, projectCode, samples, onlyShip)
+	if err != nil {
+		return result, err
+	}
+
+	// Apply the oldest samples first so the latest status of a sensor wins
+	order := make([]int, len(samples))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return samples[order[a]].Timestamp.Before(samples[order[b]].Timestamp)
+	})
+
+	type periodKey struct {
+		shipID int
+		month  time.Time
+	}
+	type periodSamples struct {
+		ship    *ingestShip
+		month   time.Time
+		date    time.Time // of the first sample, used when the report is created
+		sensors map[string]bool
+	}
+	var periods []*periodSamples
+	byPeriod := map[periodKey]*periodSamples{}
+	var values []readings.Reading
+
+	for _, i := range order {
+		sample := samples[i]
+		ship := ships[i]
+		at := sample.Timestamp.In(time.Local)
+
+		for _, code := range sortedKeys(sample.Sensors) {
+			s := sample.Sensors[code]
+			online := s.Status == "online"
+			if s.Value != nil {
+				values = append(values, readings.Reading{ShipID: ship.ID, Sensor: code, At: at, Value: *s.Value})
+			}
+			res, err := tx.Exec(`
+				INSERT INTO fms_sensor_last_seen (ship_id, sensor_code, seen_at, online, value)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (ship_id, sensor_code) DO UPDATE
+				SET seen_at = EXCLUDED.seen_at, online = EXCLUDED.online, value = EXCLUDED.value
+				WHERE fms_sensor_last_seen.seen_at <= EXCLUDED.seen_at
+			`, ship.ID, code, at, online, s.Value)
+			if err != nil {
+				return result, fmt.Errorf("last seen: %w", err)
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return result, err
+			} else if n == 0 {
+				result.Stale++
+				continue
+			}
+
+			key := periodKey{ship.ID, time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.Local)}
+			p := byPeriod[key]
+			if p == nil {
+				p = &periodSamples{ship: ship, month: key.month, sensors: map[string]bool{},
+					date: time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.Local)}
+				byPeriod[key] = p
+				periods = append(periods, p)
+			}
+			p.sensors[code] = online
+		}
+	}
+
+	// Values are kept even when their status is stale, as the time series is ordered by ts
+	if _, err := readings.Write(tx, values); err != nil {
+		return result, err
+	}
+
+	names, err := shipNameIndex(tx)
+	if err != nil {
+		return result, err
+	}
+	var published []events.Event
+	for _, p := range periods {
+		out, ev, err := mergePeriodReport(tx, names, project, p.ship, p.month, p.date, p.sensors, ReportSourceDevice)
+		if err != nil {
+			return result, err
+		}
+		if ev != nil {
+			published = append(published, ev)
+		}
+		result.Reports = append(result.Reports, out)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	for _, ev := range published {
+		events.Publish(ev)
+	}
+	return result, nil
+}
+
+// mergePeriodReport writes sensors into the report of ship for month in project, creating
+// it (dated date) when there is none. Only statuses that differ from the report are
+// written, so the returned event is nil when nothing changed. It must be published once
+// tx has committed.
+func mergePeriodReport(tx *sql.Tx, names shipNames, project string, ship *ingestShip, month, date time.Time, sensors map[string]bool, source string) (DeviceIngestReport, events.Event, error) {
+	out := DeviceIngestReport{ShipName: ship.Name, Sensors: sortedKeys(sensors)}
+
+	// Concurrent writers for the same ship and month must not both create its report
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))",
+		fmt.Sprintf("period-report:%s:%d:%s", project, ship.ID, month.Format("2006-01"))); err != nil {
+		return out, nil, err
+	}
+
+	var id int
+	err := tx.QueryRow(`
+		SELECT id FROM fms_device_reports
+		WHERE ship_name = $1 AND upper(split_part(code, ' ', 1)) = $2
+		  AND report_date >= $3 AND report_date < $4
+		ORDER BY id ASC LIMIT 1
+	`, ship.Name, project, month, month.AddDate(0, 1, 0)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		r := DeviceReport{
+			Code:        reportCode(project, ship.Code, month),
+			ReportDate:  date,
+			ShipName:    ship.Name,
+			SensorsData: sensors,
+			Source:      source,
+		}
+		created, err := insertReport(tx, names, &r, project)
+		if err != nil {
+			return out, nil, err
+		}
+		out.ID, out.Code, out.Created = r.ID, r.Code, true
+		return out, created, nil
+	}
+	if err != nil {
+		return out, nil, err
+	}
+
+	existing, err := fetchReport(tx, id)
+	if err != nil {
+		return out, nil, err
+	}
+	out.ID, out.Code = existing.ID, existing.Code
+
+	// Heartbeats repeat the same statuses; only changes are written (and notified)
+	changed := map[string]bool{}
+	for code, online := range sensors {
+		if v, ok := existing.SensorsData[code]; !ok || v != online {
+			changed[code] = online
+		}
+	}
+	out.Sensors = sortedKeys(changed)
+	if len(changed) == 0 {
+		return out, nil, nil
+	}
+	_, updated, err := updateReport(tx, id, reportPatch{Sensors: changed, Source: source})
+	if err != nil {
+		return out, nil, err
+	}
+	return out, updated, nil
+}
+
+// IngestTelemetry feeds a gateway message received over MQTT into ingestSamples
+func IngestTelemetry(m telemetry.Message) error {
+	sample := DeviceSample{ShipCode: m.ShipCode, Timestamp: m.Timestamp, Sensors: map[string]DeviceSensorSample{}}
+	for code, s := range m.Sensors {
+		status := "offline"
+		if s.Online {
+			status = "online"
+		}
+		sample.Sensors[code] = DeviceSensorSample{Status: status, Value: s.Value}
+	}
+	_, err := ingestSamples(m.ProjectCode, []DeviceSample{sample}, 0)
+	return err
+}
+
+// validateIngest resolves the project code and the ship of every sample, returning the
+// ships indexed like samples, or a *openapi.ValidationError
+func validateIngest(q dbExecutor, projectCode string, samples []DeviceSample, onlyShip int) (string, []*ingestShip, error) {
+	problems := map[string]string{}
+
+	var project string
+	err := q.QueryRow("SELECT code FROM fms_projects WHERE upper(code) = upper($1) AND is_active = true",
+		strings.TrimSpace(projectCode)).Scan(&project)
+	if errors.Is(err, sql.ErrNoRows) {
+		problems["project_code"] = "unknown or inactive project"
+	} else if err != nil {
+		return "", nil, err
+	}
+	project = strings.ToUpper(project)
+
+	switch {
+	case len(samples) == 0:
+		problems["samples"] = "must not be empty"
+	case len(samples) > maxIngestSamples:
+		problems["samples"] = fmt.Sprintf("at most %d samples per batch", maxIngestSamples)
+	}
+	if len(problems) > 0 {
+		return "", nil, &openapi.ValidationError{Fields: problems}
+	}
+
+	type lookup struct {
+		ship    *ingestShip
+		problem string
+	}
+	byCode := map[string]lookup{}
+	ships := make([]*ingestShip, len(samples))
+	latest := time.Now().Add(ingestClockSkew)
+	for i, sample := range samples {
+		field := fmt.Sprintf("samples.%d.", i)
+
+		code := strings.ToUpper(strings.TrimSpace(sample.ShipCode))
+		found, seen := byCode[code]
+		if !seen {
+			ship, err := ingestShipByCode(q, code)
+			var invalid *openapi.ValidationError
+			if errors.As(err, &invalid) {
+				found.problem = invalid.Fields["ship_code"]
+			} else if err != nil {
+				return "", nil, err
+			}
+			found.ship = ship
+			byCode[code] = found
+		}
+		if found.problem != "" {
+			problems[field+"ship_code"] = found.problem
+			continue
+		}
+		ship := found.ship
+		if onlyShip != 0 && ship.ID != onlyShip {
+			problems[field+"ship_code"] = "this device token may not report for " + ship.Name
+			continue
+		}
+		ships[i] = ship
+
+		if sample.Timestamp.IsZero() {
+			problems[field+"timestamp"] = "is required"
+		} else if sample.Timestamp.After(latest) {
+			problems[field+"timestamp"] = "is in the future"
+		}
+		if len(sample.Sensors) == 0 {
+			problems[field+"sensors"] = "must not be empty"
+		}
+		for code, s := range sample.Sensors {
+			if !ship.Sensors[code] {
+				problems[field+"sensors."+code] = "is not an active sensor of " + ship.Name
+			} else if s.Status != "online" && s.Status != "offline" {
+				problems[field+"sensors."+code+".status"] = "must be online or offline"
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return "", nil, &openapi.ValidationError{Fields: problems}
+	}
+	return project, ships, nil
+}
+
+// ingestShipByCode finds the ship registered under a ship code with its active sensors.
+// A code that is missing or shared by several ships yields a *openapi.ValidationError.
+func ingestShipByCode(q dbExecutor, code string) (*ingestShip, error) {
+	if code == "" {
+		return nil, &openapi.ValidationError{Fields: map[string]string{"ship_code": "is required"}}
+	}
+	rows, err := q.Query("SELECT id, name, COALESCE(code, '') FROM fms_ships WHERE upper(code) = $1", code)
+	if err != nil {
+		return nil, err
+	}
+	var matches []*ingestShip
+	for rows.Next() {
+		s := &ingestShip{Sensors: map[string]bool{}}
+		if err := rows.Scan(&s.ID, &s.Name, &s.Code); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		matches = append(matches, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	switch len(matches) {
+	case 0:
+		return nil, &openapi.ValidationError{Fields: map[string]string{"ship_code": "unknown ship code"}}
+	case 1:
+	default:
+		return nil, &openapi.ValidationError{Fields: map[string]string{"ship_code": "is used by several ships"}}
+	}
+
+	ship := matches[0]
+	sensors, err := loadShipSensors(q, ship.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sensors {
+		if s.Active {
+			ship.Sensors[s.Code] = true
+		}
+	}
+	return ship, nil
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fms-app/db"
+	"fms-app/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SettingsJobsPage renders background job counts and the most recent jobs
+func SettingsJobsPage(c *gin.Context) {
+	type JobRow struct {
+		ID          int64
+		Kind        string
+		Status      string
+		Attempts    int
+		MaxAttempts int
+		RunAt       time.Time
+		LastError   string
+		Result      string
+		CreatedAt   time.Time
+		FinishedAt  *time.Time
+	}
+
+	status := c.Query("status")
+
+	counts := map[string]int{}
+	cRows, err := db.DB.Query("SELECT status, COUNT(*) FROM fms_jobs GROUP BY status")
+	if err == nil {
+		defer cRows.Close()
+		for cRows.Next() {
+			var s string
+			var n int
+			if err := cRows.Scan(&s, &n); err == nil {
+				counts[s] = n
+			}
+		}
+	}
+
+	var jobRows []JobRow
+	rows, err := db.DB.Query(`
+		SELECT id, kind, status, attempts, max_attempts, run_at, COALESCE(last_error, ''), COALESCE(result, ''), created_at, finished_at
+		FROM fms_jobs
+		WHERE $1 = '' OR status = $1
+		ORDER BY id DESC
+		LIMIT 100
+	`, status)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var j JobRow
+			if err := rows.Scan(&j.ID, &j.Kind, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt, &j.LastError, &j.Result, &j.CreatedAt, &j.FinishedAt); err == nil {
+				jobRows = append(jobRows, j)
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_jobs.html", gin.H{
+		"Jobs":          jobRows,
+		"Counts":        counts,
+		"Statuses":      []string{jobs.StatusQueued, jobs.StatusRunning, jobs.StatusDone, jobs.StatusFailed},
+		"CurrentStatus": status,
+		"ActiveSidebar": "jobs",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// RetryJob requeues a failed job
+func RetryJob(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	if err := jobs.Retry(id); err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/jobs?error=Gagal+mengulang+job")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/jobs?success=Job+dijadwalkan+ulang!+🔄")
+}
+
+// enqueueJob queues a job on behalf of the user and redirects to its status page
+func enqueueJob(c *gin.Context, kind string, payload any) {
+	id, err := jobs.Enqueue(kind, payload)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/jobs/%d", id))
+}
+
+// JobPage shows the status of one job. While it is queued or running the status block
+// polls itself; once done it offers the result file for download.
+func JobPage(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var j struct {
+		ID          int64
+		Kind        string
+		Status      string
+		Attempts    int
+		MaxAttempts int
+		LastError   string
+		Result      string
+		Filename    string
+		HasFile     bool
+		CreatedAt   time.Time
+		FinishedAt  *time.Time
+	}
+	err = db.DB.QueryRow(`
+		SELECT id, kind, status, attempts, max_attempts, COALESCE(last_error, ''), COALESCE(result, ''),
+		       COALESCE(result_name, ''), result_data IS NOT NULL, created_at, finished_at
+		FROM fms_jobs WHERE id = $1
+	`, id).Scan(&j.ID, &j.Kind, &j.Status, &j.Attempts, &j.MaxAttempts, &j.LastError, &j.Result,
+		&j.Filename, &j.HasFile, &j.CreatedAt, &j.FinishedAt)
+	if err == sql.ErrNoRows {
+		c.String(http.StatusNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "job.html", gin.H{
+		"Job":           j,
+		"Finished":      j.Status == jobs.StatusDone || j.Status == jobs.StatusFailed,
+		"Expired":       j.Status == jobs.StatusDone && j.Filename != "" && !j.HasFile,
+		"ActiveSidebar": "jobs",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// DownloadJobResult sends the file stored by a finished job
+func DownloadJobResult(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var name, contentType string
+	var data []byte
+	err = db.DB.QueryRow(`
+		SELECT result_name, COALESCE(result_type, 'application/octet-stream'), result_data
+		FROM fms_jobs
+		WHERE id = $1 AND status = 'done' AND result_data IS NOT NULL
+	`, id).Scan(&name, &contentType, &data)
+	if err == sql.ErrNoRows {
+		c.Redirect(http.StatusSeeOther, fmt.Sprintf("/jobs/%d?error=File+tidak+tersedia", id))
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	attachment(c, name)
+	c.Data(http.StatusOK, contentType, data)
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/events"
+	"fms-app/mailer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// projectFromCode extracts the project prefix from a report code ("FMS TB01 Dec 2025" -> "FMS")
+func projectFromCode(code string) string {
+	fields := strings.Fields(code)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// recipientsFor returns the active email recipients configured for a project
+func recipientsFor(projectCode string) []string {
+	var emails []string
+	rows, err := db.DB.Query(`
+		SELECT email FROM fms_notification_recipients
+		WHERE project_code = $1 AND is_active = true
+		ORDER BY email ASC
+	`, projectCode)
+	if err != nil {
+		log.Println("notifications: recipients query:", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e string
+		if err := rows.Scan(&e); err == nil {
+			emails = append(emails, e)
+		}
+	}
+	return emails
+}
+
+// sensorNames maps sensor codes to their display names
+func sensorNames() map[string]string {
+	names := make(map[string]string)
+	rows, err := db.DB.Query("SELECT code, name FROM fms_sensor_config")
+	if err != nil {
+		return names
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code, name string
+		if err := rows.Scan(&code, &name); err == nil {
+			names[code] = name
+		}
+	}
+	return names
+}
+
+// NotifyReportCreated emails recipients when a new report opens or escalates alerts
+func NotifyReportCreated(e events.ReportCreated) {
+	if e.Imported {
+		return
+	}
+	notifyReportAlerts(e.Report.ProjectCode, e.Report.Code, e.Report.ShipName, e.Opened, e.Escalated)
+}
+
+// NotifyReportUpdated emails recipients when an edited report opens or escalates alerts
+func NotifyReportUpdated(e events.ReportUpdated) {
+	notifyReportAlerts(e.Report.ProjectCode, e.Report.Code, e.Report.ShipName, e.Opened, e.Escalated)
+}
+
+// notifyReportAlerts emails project recipients about newly offline sensors and escalated alerts
+func notifyReportAlerts(projectCode, reportCode, shipName string, opened, escalated []events.AlertChange) {
+	if len(opened) == 0 && len(escalated) == 0 {
+		return
+	}
+	to := recipientsFor(projectCode)
+	if len(to) == 0 {
+		return
+	}
+	names := sensorNames()
+	sensorName := func(code string) string {
+		if n, ok := names[code]; ok {
+			return n
+		}
+		return code
+	}
+
+	if len(opened) > 0 {
+		var sensors []string
+		for _, a := range opened {
+			sensors = append(sensors, sensorName(a.SensorCode))
+		}
+		subject := fmt.Sprintf("[%s] Sensor offline baru: %s", projectCode, shipName)
+		err := mailer.Enqueue(to, subject, "email_offline_sensors.html", gin.H{
+			"Project":    projectCode,
+			"ShipName":   shipName,
+			"ReportCode": reportCode,
+			"Sensors":    sensors,
+			"AppURL":     os.Getenv("APP_URL"),
+		})
+		if err != nil {
+			log.Println("notifications: enqueue offline email:", err)
+		}
+	}
+
+	if len(escalated) > 0 {
+		var rows []gin.H
+		for _, a := range escalated {
+			rows = append(rows, gin.H{
+				"SensorName":      sensorName(a.SensorCode),
+				"Occurrences":     a.Occurrences,
+				"EscalationLevel": a.EscalationLevel,
+			})
+		}
+		subject := fmt.Sprintf("[%s] Eskalasi alert: %s", projectCode, shipName)
+		err := mailer.Enqueue(to, subject, "email_alert_escalation.html", gin.H{
+			"Project":    projectCode,
+			"ShipName":   shipName,
+			"ReportCode": reportCode,
+			"Alerts":     rows,
+			"AppURL":     os.Getenv("APP_URL"),
+		})
+		if err != nil {
+			log.Println("notifications: enqueue escalation email:", err)
+		}
+	}
+}
+
+// missingReportDays is how many days before the end of the month reminders start
+func missingReportDays() int {
+	n, err := strconv.Atoi(os.Getenv("MISSING_REPORT_DAYS"))
+	if err != nil || n < 0 {
+		return 3
+	}
+	return n
+}
+
+// CheckMissingReports queues an email to each active project's recipients listing the ships
+// that have no report for the current period when the period is about to close.
+// Sent at most once per day.
+func CheckMissingReports(now time.Time) (int, error) {
+	periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	periodEnd := periodStart.AddDate(0, 1, 0)
+	daysLeft := int(periodEnd.Sub(now).Hours() / 24)
+	if daysLeft > missingReportDays() {
+		return 0, nil
+	}
+
+	rows, err := db.DB.Query("SELECT code FROM fms_projects WHERE is_active = true")
+	if err != nil {
+		return 0, err
+	}
+	var projects []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err == nil {
+			projects = append(projects, p)
+		}
+	}
+	rows.Close()
+
+	period := periodStart.Format("Jan 2006")
+	today := now.Format("2006-01-02")
+	queued := 0
+
+	for _, project := range projects {
+		to := recipientsFor(project)
+		if len(to) == 0 {
+			continue
+		}
+
+		// Only one reminder per project per day
+		key := missingReminderKey(project)
+		var lastSent string
+		err := db.DB.QueryRow("SELECT value FROM fms_app_config WHERE key = $1", key).Scan(&lastSent)
+		if err != nil && err != sql.ErrNoRows {
+			return queued, err
+		}
+		if lastSent == today {
+			continue
+		}
+
+		sRows, err := db.DB.Query(`
+			SELECT s.name FROM fms_ships s
+			WHERE NOT EXISTS (
+				SELECT 1 FROM fms_device_reports r
+				WHERE r.ship_name = s.name AND r.code LIKE $1
+				  AND r.report_date >= $2 AND r.report_date < $3
+			)
+			ORDER BY s.name ASC
+		`, project+" %", periodStart, periodEnd)
+		if err != nil {
+			return queued, err
+		}
+		var ships []string
+		for sRows.Next() {
+			var name string
+			if err := sRows.Scan(&name); err == nil {
+				ships = append(ships, name)
+			}
+		}
+		sRows.Close()
+
+		if len(ships) == 0 {
+			continue
+		}
+
+		subject := fmt.Sprintf("[%s] %d kapal belum lapor periode %s", project, len(ships), period)
+		err = mailer.Enqueue(to, subject, "email_missing_reports.html", gin.H{
+			"Project":  project,
+			"Period":   period,
+			"DaysLeft": daysLeft,
+			"Ships":    ships,
+			"AppURL":   os.Getenv("APP_URL"),
+		})
+		if err != nil {
+			return queued, err
+		}
+		queued++
+
+		_, err = db.DB.Exec(`
+			INSERT INTO fms_app_config (key, value) VALUES ($1, $2)
+			ON CONFLICT (key) DO UPDATE SET value = $2
+		`, key, today)
+		if err != nil {
+			return queued, fmt.Errorf("record reminder of %s: %w", project, err)
+		}
+	}
+	return queued, nil
+}
+
+// missingReminderKey is the fms_app_config key holding the day a project was last reminded.
+// The project code is hashed so the key fits the 50 character column whatever its length.
+func missingReminderKey(project string) string {
+	sum := sha256.Sum256([]byte(project))
+	return "missing_reminder:" + hex.EncodeToString(sum[:16])
+}
+
+// SettingsNotificationsPage renders email recipients per project and the send log
+func SettingsNotificationsPage(c *gin.Context) {
+	type RecipientRow struct {
+		ID          int
+		ProjectCode string
+		Email       string
+		IsActive    bool
+	}
+	type EmailLogRow struct {
+		Recipients string
+		Subject    string
+		Status     string
+		Error      string
+		CreatedAt  time.Time
+	}
+
+	var recipients []RecipientRow
+	rows, err := db.DB.Query(`
+		SELECT id, project_code, email, is_active
+		FROM fms_notification_recipients
+		ORDER BY project_code ASC, email ASC
+	`)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var r RecipientRow
+			if err := rows.Scan(&r.ID, &r.ProjectCode, &r.Email, &r.IsActive); err == nil {
+				recipients = append(recipients, r)
+			}
+		}
+	}
+
+	var projects []string
+	pRows, err := db.DB.Query("SELECT code FROM fms_projects WHERE is_active = true ORDER BY code ASC")
+	if err == nil {
+		defer pRows.Close()
+		for pRows.Next() {
+			var p string
+			if err := pRows.Scan(&p); err == nil {
+				projects = append(projects, p)
+			}
+		}
+	}
+
+	var emailLog []EmailLogRow
+	lRows, err := db.DB.Query(`
+		SELECT recipients, subject, status, COALESCE(error, ''), created_at
+		FROM fms_email_log
+		ORDER BY created_at DESC
+		LIMIT 50
+	`)
+	if err == nil {
+		defer lRows.Close()
+		for lRows.Next() {
+			var l EmailLogRow
+			if err := lRows.Scan(&l.Recipients, &l.Subject, &l.Status, &l.Error, &l.CreatedAt); err == nil {
+				emailLog = append(emailLog, l)
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_notifications.html", gin.H{
+		"Recipients":    recipients,
+		"Projects":      projects,
+		"EmailLog":      emailLog,
+		"SMTPEnabled":   mailer.Enabled(),
+		"ActiveSidebar": "notifications",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// CreateRecipient adds an email recipient for a project
+func CreateRecipient(c *gin.Context) {
+	projectCode := strings.ToUpper(strings.TrimSpace(c.PostForm("project_code")))
+	email, err := mailer.NormalizeAddress(c.PostForm("email"))
+
+	if projectCode == "" || err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/notifications?error=Project+dan+email+valid+wajib+diisi")
+		return
+	}
+
+	_, err = db.DB.Exec(`
+		INSERT INTO fms_notification_recipients (project_code, email)
+		VALUES ($1, $2)
+		ON CONFLICT (project_code, email) DO UPDATE SET is_active = true
+	`, projectCode, email)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/notifications?error=Gagal+menambah+penerima")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/notifications?success=Penerima+email+ditambahkan!+✅")
+}
+
+// DeleteRecipient removes an email recipient
+func DeleteRecipient(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	if _, err := db.DB.Exec("DELETE FROM fms_notification_recipients WHERE id = $1", id); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/notifications?success=Penerima+email+dihapus")
+}
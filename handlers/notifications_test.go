@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMissingReminderKey(t *testing.T) {
+	long := strings.Repeat("P", 50)
+	if k := missingReminderKey(long); len(k) > 50 {
+		t.Errorf("key %q is %d characters, the column holds 50", k, len(k))
+	}
+	if missingReminderKey("FMS") == missingReminderKey("FMS2") {
+		t.Error("projects share a key")
+	}
+}
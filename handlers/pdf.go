@@ -0,0 +1,486 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/charts"
+	"fms-app/db"
+	"fms-app/jobs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+)
+
+// troubleItem is an alert raised in the report period, shown in the PDF trouble list
+type troubleItem struct {
+	ShipName   string
+	SensorName string
+	OpenedAt   time.Time
+	ResolvedAt *time.Time
+	Note       string
+}
+
+// ExportPDFJobKind is the background job kind that renders the client report PDF
+const ExportPDFJobKind = "report.export_pdf"
+
+// ExportMonthlyReportPDF queues the client report PDF for a project/period and sends the
+// user to the job page, which offers the file once it is rendered
+func ExportMonthlyReportPDF(c *gin.Context) {
+	code := monthlyReportCode(c)
+	enqueueJob(c, ExportPDFJobKind, exportJob{Code: code, Project: chartProject(c, code)})
+}
+
+// ExportPDFJob is the jobs.Handler for ExportPDFJobKind
+func ExportPDFJob(ctx context.Context, payload json.RawMessage) error {
+	var p exportJob
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return jobs.Permanent(err)
+	}
+
+	reports, err := loadMonthlyReports(p.Code)
+	if err != nil {
+		return err
+	}
+	sensors, err := activeSensors()
+	if err != nil {
+		return err
+	}
+	troubles, err := loadPeriodTroubles(p.Code)
+	if err != nil {
+		return err
+	}
+	pa, err := loadPeriodAvailability(p.Project, 6)
+	if err != nil {
+		return err
+	}
+
+	title := reportTitle(p.Code)
+	pdf := buildMonthlyReportPDF(title, p.Project, reports, sensors, troubles, pa)
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return err
+	}
+	return jobs.SetResult(ctx, jobs.Result{
+		Message:     fmt.Sprintf("Laporan %s: %d kapal, %d trouble", title, len(reports), len(troubles)),
+		Filename:    fmt.Sprintf("Laporan %s.pdf", title),
+		ContentType: "application/pdf",
+		Data:        buf.Bytes(),
+	})
+}
+
+// loadPeriodTroubles returns the alerts of the period, oldest first: those linked to its
+// reports, plus alerts without a report (rule alerts, deleted reports) of a ship reported
+// in the period that were open during the report's month
+func loadPeriodTroubles(code string) ([]troubleItem, error) {
+	names := sensorNames()
+	rows, err := db.DB.Query(`
+		SELECT a.ship_name, a.sensor_code, a.opened_at, a.resolved_at, COALESCE(a.resolution_note, '')
+		FROM fms_alerts a
+		LEFT JOIN fms_device_reports r ON r.id = a.report_id
+		WHERE r.code LIKE $1 OR (r.id IS NULL AND EXISTS (
+			SELECT 1 FROM fms_device_reports p
+			WHERE p.code LIKE $1 AND p.ship_name = a.ship_name
+			  AND a.opened_at < date_trunc('month', p.report_date) + INTERVAL '1 month'
+			  AND (a.resolved_at IS NULL OR a.resolved_at >= date_trunc('month', p.report_date))
+		))
+		ORDER BY a.opened_at ASC, a.ship_name ASC
+	`, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []troubleItem
+	for rows.Next() {
+		var t troubleItem
+		var sensor string
+		if err := rows.Scan(&t.ShipName, &sensor, &t.OpenedAt, &t.ResolvedAt, &t.Note); err != nil {
+			continue
+		}
+		t.SensorName = names[sensor]
+		if t.SensorName == "" {
+			t.SensorName = sensor
+		}
+		items = append(items, t)
+	}
+	return items, rows.Err()
+}
+
+// PDF colours, matching the status colours of the web UI and the Excel export
+var (
+	pdfPrimary = [3]int{30, 64, 175}
+	pdfGood    = [3]int{22, 163, 74}
+	pdfBad     = [3]int{220, 38, 38}
+	pdfMuted   = [3]int{148, 163, 184}
+	pdfLight   = [3]int{241, 245, 249}
+)
+
+func availabilityColor(pct float64) [3]int {
+	r, g, b := hexColor(charts.AvailabilityColor(pct))
+	return [3]int{r, g, b}
+}
+
+func buildMonthlyReportPDF(title, project string, reports []DeviceReport, sensors []SensorConfig, troubles []troubleItem, pa periodAvailability) *fpdf.Fpdf {
+	pdf := fpdf.New("L", "mm", "A4", "")
+	pdf.SetMargins(12, 12, 12)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	pdf.SetTitle("Laporan "+title, true)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFooterFunc(func() {
+		if pdf.PageNo() == 1 {
+			return
+		}
+		pdf.SetY(-10)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(pdfMuted[0], pdfMuted[1], pdfMuted[2])
+		pdf.CellFormat(0, 5, tr("Laporan "+title), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, fmt.Sprintf("Halaman %d/{nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+
+	writePDFCover(pdf, tr, title, len(reports))
+	writePDFSummary(pdf, tr, project, reports, sensors, pa)
+	writePDFMatrix(pdf, tr, reports, sensors)
+	writePDFTroubles(pdf, tr, troubles)
+	return pdf
+}
+
+func writePDFCover(pdf *fpdf.Fpdf, tr func(string) string, title string, ships int) {
+	pdf.AddPage()
+	pageW, _ := pdf.GetPageSize()
+
+	if path := strings.TrimPrefix(GetCompanyLogo(), "/"); fileExists(path) {
+		pdf.ImageOptions(path, pageW/2-25, 35, 50, 0, false, fpdf.ImageOptions{ReadDpi: true}, 0, "")
+	}
+
+	pdf.SetY(100)
+	pdf.SetFont("Helvetica", "B", 26)
+	pdf.SetTextColor(15, 23, 42)
+	pdf.CellFormat(0, 12, tr("Laporan Performa Perangkat"), "", 1, "C", false, 0, "")
+	pdf.SetFont("Helvetica", "", 16)
+	pdf.SetTextColor(pdfPrimary[0], pdfPrimary[1], pdfPrimary[2])
+	pdf.CellFormat(0, 10, tr("Periode "+title), "", 1, "C", false, 0, "")
+
+	pdf.Ln(8)
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.SetTextColor(100, 116, 139)
+	pdf.CellFormat(0, 6, fmt.Sprintf("%d kapal", ships), "", 1, "C", false, 0, "")
+	pdf.CellFormat(0, 6, "Dibuat "+time.Now().Format("02 Jan 2006 15:04"), "", 1, "C", false, 0, "")
+}
+
+func writePDFSummary(pdf *fpdf.Fpdf, tr func(string) string, project string, reports []DeviceReport, sensors []SensorConfig, pa periodAvailability) {
+	pdf.AddPage()
+	pdfHeading(pdf, tr, "Ringkasan Ketersediaan Armada")
+
+	var online, offline int
+	for _, r := range reports {
+		online += r.OnlineTotal
+		offline += r.OfflineTotal
+	}
+	total := online + offline
+	pct := ratio(online, total) * 100
+
+	// Key figures
+	figures := []struct {
+		label string
+		value string
+		color [3]int
+	}{
+		{"Kapal", fmt.Sprint(len(reports)), pdfPrimary},
+		{"Perangkat", fmt.Sprint(total), pdfPrimary},
+		{"Online", fmt.Sprint(online), pdfGood},
+		{"Offline", fmt.Sprint(offline), pdfBad},
+		{"Ketersediaan", fmt.Sprintf("%.1f%%", pct), availabilityColor(pct)},
+	}
+	left, _, right, _ := pdf.GetMargins()
+	pageW, _ := pdf.GetPageSize()
+	boxW := (pageW - left - right - 4*4) / float64(len(figures))
+	y := pdf.GetY()
+	for i, f := range figures {
+		x := left + float64(i)*(boxW+4)
+		pdf.SetFillColor(pdfLight[0], pdfLight[1], pdfLight[2])
+		pdf.Rect(x, y, boxW, 22, "F")
+		pdf.SetXY(x, y+3)
+		pdf.SetFont("Helvetica", "B", 18)
+		pdf.SetTextColor(f.color[0], f.color[1], f.color[2])
+		pdf.CellFormat(boxW, 9, f.value, "", 2, "C", false, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.SetTextColor(100, 116, 139)
+		pdf.CellFormat(boxW, 6, tr(f.label), "", 0, "C", false, 0, "")
+	}
+	pdf.SetXY(left, y+30)
+
+	pdfChart(pdf, tr, charts.BarChart{
+		Title: "Ketersediaan per Sensor (% online)",
+		Bars:  sensorBars(reports, sensors),
+		Width: chartWidth,
+	})
+	pdfChart(pdf, tr, trendChart(project, pa))
+
+	// Availability per ship, worst first, split so each chart fits on a page
+	pdf.AddPage()
+	pdfHeading(pdf, tr, "Ketersediaan per Kapal")
+	ships := make([]DeviceReport, len(reports))
+	copy(ships, reports)
+	sort.SliceStable(ships, func(i, j int) bool { return ships[i].OnlinePercent < ships[j].OnlinePercent })
+	const perChart = 25
+	for start := 0; start < len(ships) || start == 0; start += perChart {
+		var bars []charts.Bar
+		for _, r := range ships[start:min(start+perChart, len(ships))] {
+			bars = append(bars, charts.Bar{Label: r.ShipName, Value: r.OnlinePercent})
+		}
+		title := ""
+		if start == 0 {
+			title = "Periode ini (% online)"
+		}
+		pdfChart(pdf, tr, charts.BarChart{Title: title, Bars: bars, Width: chartWidth, LabelW: 200})
+	}
+
+	heatmap := heatmapChart(project, pa)
+	rows, values := heatmap.Rows, heatmap.Values
+	for start := 0; start < len(rows) || start == 0; start += perChart {
+		end := min(start+perChart, len(rows))
+		heatmap.Rows, heatmap.Values = rows[start:end], values[start:end]
+		if start > 0 {
+			heatmap.Title = ""
+		}
+		pdfChart(pdf, tr, heatmap)
+	}
+}
+
+// pdfChart draws a chart across the page width, starting a new page if it does not fit
+func pdfChart(pdf *fpdf.Fpdf, tr func(string) string, ch charts.Chart) {
+	left, _, right, bottom := pdf.GetMargins()
+	pageW, pageH := pdf.GetPageSize()
+	w, h := ch.Size()
+	scale := (pageW - left - right) / w
+
+	if pdf.GetY()+h*scale > pageH-bottom {
+		pdf.AddPage()
+	}
+	y := pdf.GetY()
+	ch.Draw(&pdfCanvas{pdf: pdf, tr: tr, x0: left, y0: y, scale: scale})
+	pdf.SetXY(left, y+h*scale+4)
+}
+
+// pdfCanvas draws charts onto a PDF page, mapping chart units to millimetres
+type pdfCanvas struct {
+	pdf    *fpdf.Fpdf
+	tr     func(string) string
+	x0, y0 float64
+	scale  float64
+}
+
+func (c *pdfCanvas) x(v float64) float64 { return c.x0 + v*c.scale }
+func (c *pdfCanvas) y(v float64) float64 { return c.y0 + v*c.scale }
+
+func (c *pdfCanvas) Rect(x, y, w, h float64, fill string) {
+	r, g, b := hexColor(fill)
+	c.pdf.SetFillColor(r, g, b)
+	c.pdf.Rect(c.x(x), c.y(y), w*c.scale, h*c.scale, "F")
+}
+
+func (c *pdfCanvas) Line(x1, y1, x2, y2 float64, stroke string, width float64) {
+	r, g, b := hexColor(stroke)
+	c.pdf.SetDrawColor(r, g, b)
+	c.pdf.SetLineWidth(width * c.scale)
+	c.pdf.Line(c.x(x1), c.y(y1), c.x(x2), c.y(y2))
+}
+
+func (c *pdfCanvas) Polyline(points []charts.Point, stroke string, width float64) {
+	for i := 1; i < len(points); i++ {
+		c.Line(points[i-1].X, points[i-1].Y, points[i].X, points[i].Y, stroke, width)
+	}
+}
+
+func (c *pdfCanvas) Circle(cx, cy, radius float64, fill string) {
+	r, g, b := hexColor(fill)
+	c.pdf.SetFillColor(r, g, b)
+	c.pdf.Circle(c.x(cx), c.y(cy), radius*c.scale, "F")
+}
+
+func (c *pdfCanvas) Text(x, y float64, s string, size float64, anchor, color string) {
+	r, g, b := hexColor(color)
+	c.pdf.SetTextColor(r, g, b)
+	c.pdf.SetFont("Helvetica", "", size*c.scale*72/25.4)
+	s = c.tr(s)
+	px := c.x(x)
+	switch anchor {
+	case "middle":
+		px -= c.pdf.GetStringWidth(s) / 2
+	case "end":
+		px -= c.pdf.GetStringWidth(s)
+	}
+	c.pdf.Text(px, c.y(y), s)
+}
+
+// hexColor parses "#rrggbb"
+func hexColor(s string) (int, int, int) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+	if err != nil {
+		return 0, 0, 0
+	}
+	return int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)
+}
+
+func writePDFMatrix(pdf *fpdf.Fpdf, tr func(string) string, reports []DeviceReport, sensors []SensorConfig) {
+	pdf.AddPage()
+	pdfHeading(pdf, tr, "Status Sensor per Kapal")
+
+	const shipW, pctW, rowH = 50.0, 18.0, 6.0
+	left, _, right, bottom := pdf.GetMargins()
+	pageW, pageH := pdf.GetPageSize()
+	sensorW := (pageW - left - right - shipW - pctW)
+	if len(sensors) > 0 {
+		sensorW /= float64(len(sensors))
+	}
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 7)
+		pdf.SetFillColor(pdfPrimary[0], pdfPrimary[1], pdfPrimary[2])
+		pdf.SetTextColor(255, 255, 255)
+		pdf.CellFormat(shipW, 8, "Kapal", "1", 0, "L", true, 0, "")
+		for _, s := range sensors {
+			pdf.CellFormat(sensorW, 8, fitText(pdf, tr(s.Name), sensorW-1), "1", 0, "C", true, 0, "")
+		}
+		pdf.CellFormat(pctW, 8, "% Online", "1", 1, "C", true, 0, "")
+	}
+	header()
+
+	if len(reports) == 0 {
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.SetTextColor(100, 116, 139)
+		pdf.CellFormat(0, 8, "Belum ada data laporan untuk periode ini", "", 1, "L", false, 0, "")
+		return
+	}
+
+	for _, r := range reports {
+		if pdf.GetY()+rowH > pageH-bottom {
+			pdf.AddPage()
+			header()
+		}
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(15, 23, 42)
+		pdf.CellFormat(shipW, rowH, fitText(pdf, tr(r.ShipName), shipW-2), "1", 0, "L", false, 0, "")
+
+		pdf.SetFont("Helvetica", "B", 7)
+		pdf.SetTextColor(255, 255, 255)
+		for _, s := range sensors {
+			status, ok := r.SensorsData[s.Code]
+			color, text := pdfMuted, "-"
+			if ok && status {
+				color, text = pdfGood, "ON"
+			} else if ok {
+				color, text = pdfBad, "OFF"
+			}
+			pdf.SetFillColor(color[0], color[1], color[2])
+			pdf.CellFormat(sensorW, rowH, text, "1", 0, "C", true, 0, "")
+		}
+
+		color := availabilityColor(r.OnlinePercent)
+		pdf.SetFont("Helvetica", "B", 8)
+		pdf.SetTextColor(color[0], color[1], color[2])
+		pdf.CellFormat(pctW, rowH, fmt.Sprintf("%.1f%%", r.OnlinePercent), "1", 1, "C", false, 0, "")
+	}
+}
+
+func writePDFTroubles(pdf *fpdf.Fpdf, tr func(string) string, troubles []troubleItem) {
+	pdf.AddPage()
+	pdfHeading(pdf, tr, "Daftar Gangguan")
+
+	cols := []struct {
+		title string
+		w     float64
+	}{
+		{"Kapal", 50}, {"Sensor", 40}, {"Mulai", 28}, {"Selesai", 28}, {"Catatan Penyelesaian", 0},
+	}
+	left, _, right, bottom := pdf.GetMargins()
+	pageW, pageH := pdf.GetPageSize()
+	used := 0.0
+	for _, col := range cols[:len(cols)-1] {
+		used += col.w
+	}
+	cols[len(cols)-1].w = pageW - left - right - used
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 8)
+		pdf.SetFillColor(pdfPrimary[0], pdfPrimary[1], pdfPrimary[2])
+		pdf.SetTextColor(255, 255, 255)
+		for i, col := range cols {
+			ln := 0
+			if i == len(cols)-1 {
+				ln = 1
+			}
+			pdf.CellFormat(col.w, 8, tr(col.title), "1", ln, "L", true, 0, "")
+		}
+	}
+
+	if len(troubles) == 0 {
+		pdf.SetFont("Helvetica", "I", 9)
+		pdf.SetTextColor(100, 116, 139)
+		pdf.CellFormat(0, 8, "Tidak ada gangguan pada periode ini", "", 1, "L", false, 0, "")
+		return
+	}
+	header()
+
+	const rowH = 6.0
+	for _, t := range troubles {
+		note := tr(t.Note)
+		lines := pdf.SplitText(note, cols[4].w-2)
+		h := rowH * float64(max(len(lines), 1))
+		if pdf.GetY()+h > pageH-bottom {
+			pdf.AddPage()
+			header()
+		}
+
+		resolved, resolvedColor := "Belum selesai", pdfBad
+		if t.ResolvedAt != nil {
+			resolved, resolvedColor = t.ResolvedAt.Format("02 Jan 2006"), pdfGood
+		}
+
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(15, 23, 42)
+		pdf.CellFormat(cols[0].w, h, fitText(pdf, tr(t.ShipName), cols[0].w-2), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(cols[1].w, h, fitText(pdf, tr(t.SensorName), cols[1].w-2), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(cols[2].w, h, t.OpenedAt.Format("02 Jan 2006"), "1", 0, "L", false, 0, "")
+		pdf.SetTextColor(resolvedColor[0], resolvedColor[1], resolvedColor[2])
+		pdf.CellFormat(cols[3].w, h, resolved, "1", 0, "L", false, 0, "")
+		pdf.SetTextColor(15, 23, 42)
+		x, y := pdf.GetXY()
+		pdf.Rect(x, y, cols[4].w, h, "D")
+		pdf.MultiCell(cols[4].w, rowH, note, "", "L", false)
+		pdf.SetXY(left, y+h)
+	}
+}
+
+func pdfHeading(pdf *fpdf.Fpdf, tr func(string) string, text string) {
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.SetTextColor(15, 23, 42)
+	pdf.CellFormat(0, 10, tr(text), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+}
+
+// fitText shortens s with an ellipsis until it fits in width w at the current font
+func fitText(pdf *fpdf.Fpdf, s string, w float64) string {
+	if pdf.GetStringWidth(s) <= w {
+		return s
+	}
+	for len(s) > 0 && pdf.GetStringWidth(s+"...") > w {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
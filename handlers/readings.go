@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/charts"
+	"fms-app/db"
+	"fms-app/readings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadingPoint is one value of a reading series; raw values have min = max = avg
+type ReadingPoint struct {
+	T     time.Time `json:"t"`
+	Avg   float64   `json:"avg"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Count int       `json:"count"`
+}
+
+// ReadingSeries is the response of GET /api/v1/ships/{id}/readings
+type ReadingSeries struct {
+	ShipID     int            `json:"ship_id"`
+	Sensor     string         `json:"sensor"`
+	Resolution string         `json:"resolution" openapi:"enum=raw|hour|day"`
+	From       time.Time      `json:"from"`
+	To         time.Time      `json:"to"`
+	Points     []ReadingPoint `json:"points"`
+}
+
+// readingRanges are the time ranges offered on the ship page, the first is the default
+var readingRanges = []struct {
+	Key   string
+	Label string
+	Span  time.Duration
+}{
+	{"24h", "24 Jam", 24 * time.Hour},
+	{"7d", "7 Hari", 7 * 24 * time.Hour},
+	{"30d", "30 Hari", 30 * 24 * time.Hour},
+	{"365d", "1 Tahun", 365 * 24 * time.Hour},
+}
+
+// readingRange resolves a range key to [now - span, now), falling back to the first range
+func readingRange(key string, now time.Time) (string, time.Time, time.Time) {
+	r := readingRanges[0]
+	for _, candidate := range readingRanges {
+		if candidate.Key == key {
+			r = candidate
+		}
+	}
+	return r.Key, now.Add(-r.Span), now
+}
+
+// loadReadingSeries reads a series, choosing the resolution when res is empty
+func loadReadingSeries(c *gin.Context, shipID int, sensor string, from, to time.Time, res readings.Resolution) (ReadingSeries, error) {
+	if res == "" {
+		res = readings.Pick(from, to, time.Now())
+	}
+	series := ReadingSeries{ShipID: shipID, Sensor: sensor, Resolution: string(res), From: from, To: to, Points: []ReadingPoint{}}
+	points, err := readings.Series(c.Request.Context(), db.DB, shipID, sensor, from, to, res)
+	for _, p := range points {
+		series.Points = append(series.Points, ReadingPoint{T: p.At, Avg: p.Avg, Min: p.Min, Max: p.Max, Count: p.Count})
+	}
+	return series, err
+}
+
+// APIShipReadings returns the downsampled values of one sensor of a ship:
+// ?sensor=rpm_me_port&from=2025-01-01T00:00:00Z&to=2025-01-08T00:00:00Z&resolution=hour.
+// Without from/to the last 24 hours are returned; without resolution the finest one
+// still kept for the range is chosen.
+func APIShipReadings(c *gin.Context) {
+	now := time.Now()
+	sensor := strings.TrimSpace(c.Query("sensor"))
+	from, to := now.Add(-24*time.Hour), now
+	res := readings.Resolution(c.Query("resolution"))
+	if res == "auto" {
+		res = ""
+	}
+	problems := map[string]string{}
+	if sensor == "" {
+		problems["sensor"] = "is required"
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			problems["to"] = "must be an RFC 3339 time"
+		}
+		to = t
+	}
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			problems["from"] = "must be an RFC 3339 time"
+		}
+		from = t
+	} else {
+		from = to.Add(-24 * time.Hour)
+	}
+	if len(problems) == 0 && !to.After(from) {
+		problems["to"] = "must be after from"
+	}
+	if limit := readings.MaxSpan[res]; res != "" && limit > 0 && to.Sub(from) > limit {
+		problems["resolution"] = "range is too long for " + string(res) + ", at most " + strconv.Itoa(int(limit.Hours()/24)) + " days"
+	}
+	if len(problems) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, APIErrorResponse{Error: APIError{
+			Code: "invalid_request", Message: "invalid query parameters", Fields: problems,
+		}})
+		return
+	}
+	ship, ok := apiShip(c)
+	if !ok {
+		return
+	}
+	if _, err := fetchSensor(sensor); errors.Is(err, sql.ErrNoRows) {
+		apiNotFound(c, "sensor")
+		return
+	} else if err != nil {
+		apiInternal(c, err)
+		return
+	}
+
+	series, err := loadReadingSeries(c, ship.ID, sensor, from, to, res)
+	if err != nil {
+		apiInternal(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, series)
+}
+
+// ShipReadingsSVG renders the chart of one ship sensor: ?sensor=rpm_me_port&range=7d
+func ShipReadingsSVG(c *gin.Context) {
+	shipID, _ := strconv.Atoi(c.Param("id"))
+	sensor := c.Query("sensor")
+	_, from, to := readingRange(c.Query("range"), time.Now())
+
+	series, err := loadReadingSeries(c, shipID, sensor, from, to, "")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	name := sensor
+	if s, err := fetchSensor(sensor); err == nil {
+		name = s.Name
+	}
+
+	ch := charts.SeriesChart{Title: name, From: from, To: to, Width: chartWidth}
+	switch series.Resolution {
+	case string(readings.Hourly):
+		ch.Title += " (rata-rata per jam)"
+	case string(readings.Daily):
+		ch.Title += " (rata-rata per hari)"
+	}
+	for _, p := range series.Points {
+		ch.Times = append(ch.Times, p.T)
+		ch.Avg = append(ch.Avg, p.Avg)
+		ch.Min = append(ch.Min, p.Min)
+		ch.Max = append(ch.Max, p.Max)
+	}
+	c.Header("Cache-Control", "max-age=60")
+	c.Data(http.StatusOK, "image/svg+xml", charts.SVG(ch))
+}
+
+// ShipDetailPage shows a ship's latest device values with a chart of the selected sensor
+func ShipDetailPage(c *gin.Context) {
+	type ValueRow struct {
+		Code   string
+		Name   string
+		SeenAt time.Time
+		Online bool
+		Value  sql.NullFloat64
+	}
+
+	shipID, _ := strconv.Atoi(c.Param("id"))
+	var ship Ship
+	err := db.DB.QueryRow("SELECT id, name, COALESCE(code, '') FROM fms_ships WHERE id = $1", shipID).Scan(&ship.ID, &ship.Name, &ship.Code)
+	if err != nil {
+		c.String(http.StatusNotFound, "Ship not found")
+		return
+	}
+
+	var values []ValueRow
+	rows, err := db.DB.Query(`
+		SELECT l.sensor_code, COALESCE(g.name, l.sensor_code), l.seen_at, l.online, l.value
+		FROM fms_sensor_last_seen l
+		LEFT JOIN fms_sensor_config g ON g.code = l.sensor_code
+		WHERE l.ship_id = $1
+		ORDER BY g.display_order ASC, l.sensor_code ASC
+	`, shipID)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var v ValueRow
+			if err := rows.Scan(&v.Code, &v.Name, &v.SeenAt, &v.Online, &v.Value); err == nil {
+				values = append(values, v)
+			}
+		}
+	}
+
+	// Chart the requested sensor, or the first one with a value
+	sensor := c.Query("sensor")
+	if sensor == "" {
+		for _, v := range values {
+			if v.Value.Valid {
+				sensor = v.Code
+				break
+			}
+		}
+	}
+	rangeKey, _, _ := readingRange(c.Query("range"), time.Now())
+
+	c.HTML(http.StatusOK, "ship_detail.html", gin.H{
+		"Ship":      ship,
+		"Values":    values,
+		"Sensor":    sensor,
+		"Range":     rangeKey,
+		"Ranges":    readingRanges,
+		"ActiveTab": "",
+		"Logo":      GetCompanyLogo(),
+	})
+}
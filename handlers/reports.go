@@ -1,14 +1,19 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"fms-app/db"
+	"fms-app/events"
+	"fms-app/outbox"
+	"fms-app/webhooks"
 
 	"github.com/gin-gonic/gin"
 )
@@ -30,47 +35,12 @@ func ListReports(c *gin.Context) {
 	perPage := 20
 	offset := (page - 1) * perPage
 
-	// Get total count
-	var totalRecords int
-	err = db.DB.QueryRow(`SELECT COUNT(*) FROM fms_device_reports WHERE code LIKE $1`, code).Scan(&totalRecords)
+	reports, totalRecords, err := queryReports(reportFilter{Code: code}, perPage, offset)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Error: %v", err)
 		return
 	}
 
-	// Get paginated data
-	rows, err := db.DB.Query(`
-		SELECT id, code, report_date, ship_name,
-		       device_condition, gps, rpm_me_port, rpm_me_stbd,
-		       flowmeter_input, flowmeter_output, flowmeter_bunker,
-		       sensors_data,
-		       created_at, updated_at
-		FROM fms_device_reports
-		WHERE code LIKE $1
-		ORDER BY report_date ASC, ship_name ASC
-		LIMIT $2 OFFSET $3
-	`, code, perPage, offset)
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Error: %v", err)
-		return
-	}
-	defer rows.Close()
-
-	var reports []DeviceReport
-	for rows.Next() {
-		var r DeviceReport
-		if err := rows.Scan(
-			&r.ID, &r.Code, &r.ReportDate, &r.ShipName,
-			&r.DeviceCondition, &r.GPS, &r.RpmMEPort, &r.RpmMEStbd,
-			&r.FlowmeterInput, &r.FlowmeterOutput, &r.FlowmeterBunker,
-			&r.CreatedAt, &r.UpdatedAt,
-		); err != nil {
-			continue
-		}
-		r.CalculateTotals()
-		reports = append(reports, r)
-	}
-
 	// Calculate pagination info
 	totalPages := (totalRecords + perPage - 1) / perPage
 	if totalPages < 1 {
@@ -138,11 +108,10 @@ func CreateReport(c *gin.Context) {
 	shipName := c.PostForm("ship_name") // Fallback or direct input
 
 	var shipCode string
-	if shipID != "" {
-		var name string
+	if id, err := strconv.Atoi(shipID); err == nil {
 		// Lookup name and code from ID
-		if err := db.DB.QueryRow("SELECT name, code FROM fms_ships WHERE id = $1", shipID).Scan(&name, &shipCode); err == nil {
-			shipName = name
+		if name, sc, err := lookupShip(id); err == nil {
+			shipName, shipCode = name, sc
 		}
 	}
 
@@ -150,9 +119,7 @@ func CreateReport(c *gin.Context) {
 	if code == "" && projectCode != "" && periodStr != "" {
 		periodDate, err := time.Parse("2006-01", periodStr)
 		if err == nil {
-			// Format: PROJECT SHIPCODE PERIOD
-			code = fmt.Sprintf("%s %s %s", projectCode, shipCode, periodDate.Format("Jan 2006"))
-			code = strings.TrimSpace(code)
+			code = reportCode(projectCode, shipCode, periodDate)
 		}
 	}
 
@@ -170,72 +137,51 @@ func CreateReport(c *gin.Context) {
 	// Parse dynamic sensor inputs
 	sensorsData := make(map[string]bool)
 	form := c.Request.PostForm
-	// Legacy values for backward compatibility
-	var deviceCondition, gps, rpmMEPort, rpmMEStbd, flowmeterInput, flowmeterOutput, flowmeterBunker bool
-
-	// Map legacy codes to variable pointers
-	legacyMap := map[string]*bool{
-		"device_condition": &deviceCondition,
-		"gps":              &gps,
-		"rpm_me_port":      &rpmMEPort,
-		"rpm_me_stbd":      &rpmMEStbd,
-		"flowmeter_input":  &flowmeterInput,
-		"flowmeter_output": &flowmeterOutput,
-		"flowmeter_bunker": &flowmeterBunker,
-	}
-
 	for key, values := range form {
 		if strings.HasPrefix(key, "sensor_") && len(values) > 0 {
-			code := strings.TrimPrefix(key, "sensor_")
-			isOn := values[0] == "on"
-			sensorsData[code] = isOn
-
-			// Populate legacy fields if matching
-			if ptr, ok := legacyMap[code]; ok {
-				*ptr = isOn
-			}
+			sensorsData[strings.TrimPrefix(key, "sensor_")] = values[0] == "on"
 		}
 	}
 
+	r := DeviceReport{
+		Code:        code,
+		ReportDate:  reportDate,
+		ShipName:    shipName,
+		SensorsData: sensorsData,
+	}
+
 	// Double check legacy specific inputs in case the form was old style (fallback)
 	// Although index.html is updated, API calls might differ
-	if !sensorsData["device_condition"] && c.PostForm("device_condition") != "" {
-		deviceCondition = c.PostForm("device_condition") == "on"
+	if _, ok := sensorsData["device_condition"]; !ok && c.PostForm("device_condition") != "" {
+		r.DeviceCondition = c.PostForm("device_condition") == "on"
 	}
 
-	// Serialize to JSON
-	jsonData, _ := json.Marshal(sensorsData)
-
-	var id int
-	err = db.DB.QueryRow(`
-		INSERT INTO fms_device_reports 
-		(code, report_date, ship_name, device_condition, gps, rpm_me_port, rpm_me_stbd, flowmeter_input, flowmeter_output, flowmeter_bunker, sensors_data)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		RETURNING id
-	`, code, reportDate, shipName, deviceCondition, gps, rpmMEPort, rpmMEStbd, flowmeterInput, flowmeterOutput, flowmeterBunker, jsonData).Scan(&id)
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer tx.Rollback()
 
+	names, err := shipNameIndex(tx)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	created, err := insertReport(tx, names, &r, projectCode)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Error: %v", err)
 		return
 	}
 
-	// Return the new row as HTML
-	r := DeviceReport{
-		ID:              id,
-		Code:            code,
-		ReportDate:      reportDate,
-		ShipName:        shipName,
-		DeviceCondition: deviceCondition,
-		GPS:             gps,
-		RpmMEPort:       rpmMEPort,
-		RpmMEStbd:       rpmMEStbd,
-		FlowmeterInput:  flowmeterInput,
-		FlowmeterOutput: flowmeterOutput,
-		FlowmeterBunker: flowmeterBunker,
-		SensorsData:     sensorsData,
+	if err := tx.Commit(); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
 	}
-	r.CalculateTotals()
 
+	events.Publish(created)
+
+	// Return the new row as HTML
 	c.Header("HX-Trigger-After-Swap", `{"showMessage": "Data laporan berhasil ditambahkan! ✅"}`)
 	c.HTML(http.StatusOK, "report_row.html", r)
 }
@@ -249,8 +195,7 @@ func DeleteReport(c *gin.Context) {
 		return
 	}
 
-	_, err = db.DB.Exec(`DELETE FROM fms_device_reports WHERE id = $1`, id)
-	if err != nil {
+	if _, err := deleteReport(id); err != nil {
 		c.String(http.StatusInternalServerError, "Error: %v", err)
 		return
 	}
@@ -270,28 +215,391 @@ func UpdateReport(c *gin.Context) {
 	field := c.PostForm("field")
 	value := c.PostForm("value") == "true"
 
-	validFields := map[string]string{
-		"device_condition": "device_condition",
-		"gps":              "gps",
-		"rpm_me_port":      "rpm_me_port",
-		"rpm_me_stbd":      "rpm_me_stbd",
-		"flowmeter_input":  "flowmeter_input",
-		"flowmeter_output": "flowmeter_output",
-		"flowmeter_bunker": "flowmeter_bunker",
+	// The inline editor only toggles the legacy sensors
+	if _, ok := legacySensorFields(&DeviceReport{})[field]; !ok {
+		c.String(http.StatusBadRequest, "invalid field")
+		return
 	}
 
-	dbField, ok := validFields[field]
-	if !ok {
-		c.String(http.StatusBadRequest, "invalid field")
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
 		return
 	}
+	defer tx.Rollback()
 
-	query := "UPDATE fms_device_reports SET " + dbField + " = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2"
-	_, err = db.DB.Exec(query, value, id)
+	_, updated, err := updateReport(tx, id, reportPatch{Sensors: map[string]bool{field: value}})
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Error: %v", err)
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	events.Publish(updated)
+
 	c.String(http.StatusOK, "updated")
 }
+
+// legacySensorFields points the legacy boolean columns of r at their sensor codes
+func legacySensorFields(r *DeviceReport) map[string]*bool {
+	return map[string]*bool{
+		"device_condition": &r.DeviceCondition,
+		"gps":              &r.GPS,
+		"rpm_me_port":      &r.RpmMEPort,
+		"rpm_me_stbd":      &r.RpmMEStbd,
+		"flowmeter_input":  &r.FlowmeterInput,
+		"flowmeter_output": &r.FlowmeterOutput,
+		"flowmeter_bunker": &r.FlowmeterBunker,
+	}
+}
+
+// insertReport is the write path for new reports: it stores r (filling the legacy
+// columns from SensorsData), syncs its alerts and writes the outbox row, all inside tx.
+// The returned event must be published once tx has committed. names is the ship name
+// index loaded in tx; variant spellings are stored under the master ship name.
+func insertReport(tx *sql.Tx, names shipNames, r *DeviceReport, projectCode string) (events.ReportCreated, error) {
+	if err := storeNewReport(tx, names, r); err != nil {
+		return events.ReportCreated{}, err
+	}
+	return applyNewReport(tx, r, projectCode)
+}
+
+// insertImportedReport stores a report from a history import. Only a report newer than
+// every other report of its ship stands for the ship's current state: older ones do not
+// touch its alerts or go to the outbox, and their event only serves in-process subscribers.
+func insertImportedReport(tx *sql.Tx, names shipNames, r *DeviceReport, projectCode string) (events.ReportCreated, error) {
+	if err := storeNewReport(tx, names, r); err != nil {
+		return events.ReportCreated{}, err
+	}
+
+	var latest bool
+	err := tx.QueryRow(`
+		SELECT NOT EXISTS (
+			SELECT 1 FROM fms_device_reports
+			WHERE ship_name = $1 AND id <> $2 AND report_date > $3
+		)
+	`, r.ShipName, r.ID, r.ReportDate).Scan(&latest)
+	if err != nil {
+		return events.ReportCreated{}, err
+	}
+
+	ev := events.ReportCreated{Imported: true}
+	if latest {
+		if ev, err = applyNewReport(tx, r, projectCode); err != nil {
+			return ev, err
+		}
+		ev.Imported = true
+		return ev, nil
+	}
+	ev.Report = eventReport(*r)
+	if projectCode != "" {
+		ev.Report.ProjectCode = projectCode
+	}
+	return ev, nil
+}
+
+// storeNewReport inserts r under the master ship name, filling the legacy columns from SensorsData
+func storeNewReport(tx *sql.Tx, names shipNames, r *DeviceReport) error {
+	r.ShipName = names.resolve(r.ShipName)
+
+	if r.SensorsData == nil {
+		r.SensorsData = make(map[string]bool)
+	}
+	for code, ptr := range legacySensorFields(r) {
+		if v, ok := r.SensorsData[code]; ok {
+			*ptr = v
+		}
+	}
+
+	if r.Source == "" {
+		r.Source = ReportSourceManual
+	}
+
+	jsonData, err := json.Marshal(r.SensorsData)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO fms_device_reports 
+		(code, report_date, ship_name, device_condition, gps, rpm_me_port, rpm_me_stbd, flowmeter_input, flowmeter_output, flowmeter_bunker, sensors_data, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at, updated_at
+	`, r.Code, r.ReportDate, r.ShipName, r.DeviceCondition, r.GPS, r.RpmMEPort, r.RpmMEStbd,
+		r.FlowmeterInput, r.FlowmeterOutput, r.FlowmeterBunker, jsonData, r.Source).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	r.CalculateTotals()
+	return nil
+}
+
+// applyNewReport syncs the alerts of a stored report and writes its outbox row
+func applyNewReport(tx *sql.Tx, r *DeviceReport, projectCode string) (events.ReportCreated, error) {
+	// Track offline sensors as alerts
+	opened, escalated, err := syncAlerts(tx, r.ShipName, r.ID, r.SensorsData)
+	if err != nil {
+		return events.ReportCreated{}, fmt.Errorf("sync alerts: %w", err)
+	}
+
+	ev := eventReport(*r)
+	if projectCode != "" {
+		ev.ProjectCode = projectCode
+	}
+
+	// Downstream delivery goes through the outbox so it commits with the report
+	if err := writeReportOutbox(tx, webhooks.EventReportCreated, reportCreatedKey(r.ID), ev); err != nil {
+		return events.ReportCreated{}, fmt.Errorf("outbox: %w", err)
+	}
+
+	return events.ReportCreated{Report: ev, Opened: opened, Escalated: escalated}, nil
+}
+
+// reportColumns is the column list read by scanReport
+const reportColumns = `id, code, report_date, ship_name,
+	device_condition, gps, rpm_me_port, rpm_me_stbd,
+	flowmeter_input, flowmeter_output, flowmeter_bunker,
+	sensors_data, source, created_at, updated_at`
+
+// scanReport reads one row selected with reportColumns and computes its totals
+func scanReport(row interface{ Scan(...any) error }) (DeviceReport, error) {
+	var r DeviceReport
+	var sensorsJson []byte
+	err := row.Scan(
+		&r.ID, &r.Code, &r.ReportDate, &r.ShipName,
+		&r.DeviceCondition, &r.GPS, &r.RpmMEPort, &r.RpmMEStbd,
+		&r.FlowmeterInput, &r.FlowmeterOutput, &r.FlowmeterBunker,
+		&sensorsJson, &r.Source, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return r, err
+	}
+	if len(sensorsJson) > 0 {
+		_ = json.Unmarshal(sensorsJson, &r.SensorsData)
+	}
+	r.CalculateTotals()
+	return r, nil
+}
+
+// fetchReport loads a single report including its dynamic sensor data
+func fetchReport(q dbExecutor, id int) (DeviceReport, error) {
+	return scanReport(q.QueryRow("SELECT "+reportColumns+" FROM fms_device_reports WHERE id = $1", id))
+}
+
+// reportFilter selects reports for the HTML table and the JSON API. Zero fields do not filter.
+type reportFilter struct {
+	Code    string // LIKE pattern on the report code
+	Project string
+	Ship    string
+	From    time.Time // first report_date included
+	To      time.Time // first report_date excluded
+	Sensor  string
+	Status  string // "online" or "offline"; without Sensor it matches any sensor
+}
+
+func (f reportFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", len(args))))
+	}
+	if f.Code != "" {
+		add("code LIKE ?", f.Code)
+	}
+	if f.Project != "" {
+		add("upper(split_part(code, ' ', 1)) = upper(?)", f.Project)
+	}
+	if f.Ship != "" {
+		add("ship_name = ?", f.Ship)
+	}
+	if !f.From.IsZero() {
+		add("report_date >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		add("report_date < ?", f.To)
+	}
+	if f.Status != "" {
+		value := f.Status == "online"
+		if f.Sensor != "" {
+			args = append(args, f.Sensor)
+			add(fmt.Sprintf("(sensors_data->>$%d::text)::boolean = ?", len(args)), value)
+		} else {
+			add("EXISTS (SELECT 1 FROM jsonb_each(sensors_data) s WHERE s.value = to_jsonb(?::boolean))", value)
+		}
+	} else if f.Sensor != "" {
+		add("jsonb_exists(sensors_data, ?)", f.Sensor)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// queryReports returns one page of matching reports ordered by date and ship, plus the
+// total number of matches
+func queryReports(f reportFilter, limit, offset int) ([]DeviceReport, int, error) {
+	where, args := f.where()
+
+	var total int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM fms_device_reports"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, limit, offset)
+	rows, err := db.DB.Query(fmt.Sprintf(
+		"SELECT %s FROM fms_device_reports%s ORDER BY report_date ASC, ship_name ASC, id ASC LIMIT $%d OFFSET $%d",
+		reportColumns, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	reports := []DeviceReport{}
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, total, rows.Err()
+}
+
+// reportPatch lists the report fields to change; nil fields are kept. Sensors are merged
+// into the existing sensor data. Source is who made the change, ReportSourceManual when empty.
+type reportPatch struct {
+	Code       *string
+	ReportDate *time.Time
+	ShipName   *string
+	Sensors    map[string]bool
+	Source     string
+}
+
+// updateReport is the single edit path for reports: it applies p, keeps the legacy columns
+// in sync, updates the alerts of the patched sensors and writes the outbox row inside tx.
+// The returned event must be published once tx has committed.
+func updateReport(tx *sql.Tx, id int, p reportPatch) (DeviceReport, events.ReportUpdated, error) {
+	r, err := fetchReport(tx, id)
+	if err != nil {
+		return r, events.ReportUpdated{}, err
+	}
+
+	var fields []string
+	if p.Code != nil {
+		r.Code = *p.Code
+		fields = append(fields, "code")
+	}
+	if p.ReportDate != nil {
+		r.ReportDate = *p.ReportDate
+		fields = append(fields, "report_date")
+	}
+	if p.ShipName != nil {
+		if r.ShipName, err = resolveShipName(tx, *p.ShipName); err != nil {
+			return r, events.ReportUpdated{}, err
+		}
+		fields = append(fields, "ship_name")
+	}
+	if r.SensorsData == nil {
+		r.SensorsData = make(map[string]bool)
+	}
+	codes := make([]string, 0, len(p.Sensors))
+	for code, v := range p.Sensors {
+		r.SensorsData[code] = v
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	fields = append(fields, codes...)
+	r.Source = p.Source
+	if r.Source == "" {
+		r.Source = ReportSourceManual
+	}
+	for code, ptr := range legacySensorFields(&r) {
+		if v, ok := r.SensorsData[code]; ok {
+			*ptr = v
+		}
+	}
+
+	jsonData, err := json.Marshal(r.SensorsData)
+	if err != nil {
+		return r, events.ReportUpdated{}, err
+	}
+	err = tx.QueryRow(`
+		UPDATE fms_device_reports
+		SET code = $2, report_date = $3, ship_name = $4,
+		    device_condition = $5, gps = $6, rpm_me_port = $7, rpm_me_stbd = $8,
+		    flowmeter_input = $9, flowmeter_output = $10, flowmeter_bunker = $11,
+		    sensors_data = $12, source = $13, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING updated_at
+	`, id, r.Code, r.ReportDate, r.ShipName, r.DeviceCondition, r.GPS, r.RpmMEPort, r.RpmMEStbd,
+		r.FlowmeterInput, r.FlowmeterOutput, r.FlowmeterBunker, jsonData, r.Source).Scan(&r.UpdatedAt)
+	if err != nil {
+		return r, events.ReportUpdated{}, err
+	}
+	r.CalculateTotals()
+
+	opened, escalated, err := syncAlerts(tx, r.ShipName, id, p.Sensors)
+	if err != nil {
+		return r, events.ReportUpdated{}, fmt.Errorf("sync alerts: %w", err)
+	}
+
+	ev := eventReport(r)
+	if err := writeReportOutbox(tx, webhooks.EventReportUpdated, reportUpdatedKey(id, r.UpdatedAt), ev); err != nil {
+		return r, events.ReportUpdated{}, fmt.Errorf("outbox: %w", err)
+	}
+
+	return r, events.ReportUpdated{Report: ev, Field: strings.Join(fields, ","), Opened: opened, Escalated: escalated}, nil
+}
+
+// deleteReport removes a report and reports whether it existed
+func deleteReport(id int) (bool, error) {
+	res, err := db.DB.Exec(`DELETE FROM fms_device_reports WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// lookupShip returns the name and code of a master ship
+func lookupShip(id int) (name, code string, err error) {
+	err = db.DB.QueryRow("SELECT name, COALESCE(code, '') FROM fms_ships WHERE id = $1", id).Scan(&name, &code)
+	return name, code, err
+}
+
+// reportCode builds the "PROJECT SHIPCODE Jan 2006" code of a report
+func reportCode(projectCode, shipCode string, period time.Time) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", projectCode, shipCode, period.Format("Jan 2006")))
+}
+
+// eventReport converts a report into the snapshot carried by domain events
+func eventReport(r DeviceReport) events.Report {
+	return events.Report{
+		ID:          r.ID,
+		Code:        r.Code,
+		ProjectCode: projectFromCode(r.Code),
+		ReportDate:  r.ReportDate,
+		ShipName:    r.ShipName,
+		Sensors:     r.SensorsData,
+	}
+}
+
+// writeReportOutbox stores a report webhook event in the outbox as part of tx
+func writeReportOutbox(tx outbox.Execer, event, dedupKey string, r events.Report) error {
+	return outbox.Write(tx, event, dedupKey, webhooks.NewReportData(r))
+}
+
+// reportCreatedKey is the outbox deduplication key of a report creation
+func reportCreatedKey(id int) string {
+	return fmt.Sprintf("report.created:%d", id)
+}
+
+// reportUpdatedKey identifies one specific edit of a report
+func reportUpdatedKey(id int, updatedAt time.Time) string {
+	return fmt.Sprintf("report.updated:%d:%d", id, updatedAt.UnixNano())
+}
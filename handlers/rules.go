@@ -0,0 +1,634 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/rules"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusRule is a row of fms_rules with its parsed expression
+type statusRule struct {
+	ID         int
+	Name       string
+	Sensor     string
+	Expression string
+	IsActive   bool
+	Expr       rules.Expr
+}
+
+// loadRules returns the rules ordered by name; rules whose expression no longer parses
+// are returned without Expr and never hit
+func loadRules(ctx context.Context, activeOnly bool) ([]statusRule, error) {
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT id, name, sensor_code, expression, is_active
+		FROM fms_rules
+		WHERE NOT $1 OR is_active
+		ORDER BY name ASC, id ASC
+	`, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []statusRule
+	for rows.Next() {
+		var r statusRule
+		if err := rows.Scan(&r.ID, &r.Name, &r.Sensor, &r.Expression, &r.IsActive); err != nil {
+			return nil, err
+		}
+		r.Expr, _ = rules.Parse(r.Expression)
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+// ruleSnapshots builds the current snapshot of every ship with gateway data or a report
+// this month: the latest gateway values and their age, and the latest report of the month
+func ruleSnapshots(ctx context.Context, now time.Time) (map[string]rules.Snapshot, error) {
+	snaps := map[string]rules.Snapshot{}
+	get := func(ship string) rules.Snapshot {
+		s, ok := snaps[ship]
+		if !ok {
+			s = rules.Snapshot{Values: map[string]float64{}, Report: map[string]bool{}}
+		}
+		return s
+	}
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT s.name, l.sensor_code, l.seen_at, l.value
+		FROM fms_sensor_last_seen l
+		JOIN fms_ships s ON s.id = l.ship_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ship, code string
+		var seenAt time.Time
+		var value sql.NullFloat64
+		if err := rows.Scan(&ship, &code, &seenAt, &value); err != nil {
+			return nil, err
+		}
+		s := get(ship)
+		if s.Ages == nil {
+			s.Ages = map[string]float64{}
+		}
+		s.Ages[code] = now.Sub(seenAt).Hours()
+		if value.Valid {
+			s.Values[code] = value.Float64
+		}
+		snaps[ship] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	period := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	rRows, err := db.DB.QueryContext(ctx, `
+		SELECT DISTINCT ON (ship_name) ship_name, sensors_data
+		FROM fms_device_reports
+		WHERE report_date >= $1 AND report_date < $2
+		ORDER BY ship_name, report_date DESC, updated_at DESC
+	`, period, period.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rRows.Close()
+	for rRows.Next() {
+		var ship string
+		var data []byte
+		if err := rRows.Scan(&ship, &data); err != nil {
+			return nil, err
+		}
+		s := get(ship)
+		_ = json.Unmarshal(data, &s.Report)
+		snaps[ship] = s
+	}
+	return snaps, rRows.Err()
+}
+
+// EvaluateRulesTask is the scheduled rule evaluation
+func EvaluateRulesTask(ctx context.Context) (string, error) {
+	checked, opened, resolved, err := evaluateRules(ctx, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d aturan x kapal dicek, %d alert dibuka, %d diselesaikan", checked, opened, resolved), nil
+}
+
+// evaluateRules evaluates every active rule against every ship snapshot. A hit opens the
+// rule's alert on its sensor or bumps the open one; a miss resolves it.
+func evaluateRules(ctx context.Context, now time.Time) (checked, opened, resolved int, err error) {
+	list, err := loadRules(ctx, true)
+	if err != nil || len(list) == 0 {
+		return 0, 0, 0, err
+	}
+	snaps, err := ruleSnapshots(ctx, now)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for ship, snap := range snaps {
+		for _, r := range list {
+			checked++
+			hit, detail := r.Expr.Eval(snap)
+			if !hit {
+				res, err := tx.Exec(`
+					UPDATE fms_alerts
+					SET resolved_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP,
+					    resolution_note = 'Aturan tidak terpenuhi lagi'
+					WHERE ship_name = $1 AND rule_id = $2 AND resolved_at IS NULL
+				`, ship, r.ID)
+				if err != nil {
+					return 0, 0, 0, err
+				}
+				n, _ := res.RowsAffected()
+				resolved += int(n)
+				continue
+			}
+
+			var inserted bool
+			err := tx.QueryRow(`
+				INSERT INTO fms_alerts (ship_name, sensor_code, rule_id, rule_detail)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (ship_name, sensor_code, COALESCE(rule_id, 0)) WHERE resolved_at IS NULL
+				DO UPDATE SET
+					occurrences = fms_alerts.occurrences + 1,
+					rule_detail = EXCLUDED.rule_detail,
+					updated_at = CURRENT_TIMESTAMP
+				RETURNING (xmax = 0)
+			`, ship, r.Sensor, r.ID, detail).Scan(&inserted)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			if inserted {
+				opened++
+			}
+		}
+	}
+	return checked, opened, resolved, tx.Commit()
+}
+
+// benchMaxDays is the longest range the test bench evaluates, benchMaxHits the number
+// of hits it lists
+const (
+	benchMaxDays = 31
+	benchMaxHits = 200
+)
+
+// benchHit is a run of consecutive hours in which a rule held for a ship
+type benchHit struct {
+	ShipName string
+	From     time.Time
+	To       time.Time
+	Hours    int
+	Detail   string // values of the first hour
+}
+
+// benchRule evaluates expr hour by hour over [from, to) against the hourly averages of the
+// sensor readings and the period reports, without writing alerts. Ages are measured in
+// whole hours from the last hour with a reading.
+func benchRule(ctx context.Context, expr rules.Expr, shipID int, from, to time.Time) (hits []benchHit, evaluated int, err error) {
+	from, to = from.Truncate(time.Hour), to.Truncate(time.Hour)
+	sensors := expr.Sensors()
+
+	type key struct {
+		ship   int
+		sensor string
+	}
+	names := map[int]string{}
+	values := map[int]map[int64]map[string]float64{} // by ship and hour (Unix)
+	last := map[key]time.Time{}
+
+	// The last reading before the range starts the ages
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT r.ship_id, s.name, r.sensor_code, MAX(r.bucket)
+		FROM fms_sensor_rollups r
+		JOIN fms_ships s ON s.id = r.ship_id
+		WHERE r.resolution = 'hour' AND r.sensor_code = ANY(string_to_array($1, ','))
+		  AND r.bucket < $2 AND ($3 = 0 OR r.ship_id = $3)
+		GROUP BY r.ship_id, s.name, r.sensor_code
+	`, strings.Join(sensors, ","), from, shipID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for rows.Next() {
+		var id int
+		var name, code string
+		var bucket time.Time
+		if err := rows.Scan(&id, &name, &code, &bucket); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		names[id] = name
+		last[key{id, code}] = wallHour(bucket, from.Location())
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err = db.DB.QueryContext(ctx, `
+		SELECT r.ship_id, s.name, r.sensor_code, r.bucket, r.total / r.samples
+		FROM fms_sensor_rollups r
+		JOIN fms_ships s ON s.id = r.ship_id
+		WHERE r.resolution = 'hour' AND r.sensor_code = ANY(string_to_array($1, ','))
+		  AND r.bucket >= $2 AND r.bucket < $3 AND ($4 = 0 OR r.ship_id = $4)
+	`, strings.Join(sensors, ","), from, to, shipID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for rows.Next() {
+		var id int
+		var name, code string
+		var bucket time.Time
+		var avg float64
+		if err := rows.Scan(&id, &name, &code, &bucket, &avg); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		names[id] = name
+		if values[id] == nil {
+			values[id] = map[int64]map[string]float64{}
+		}
+		hour := wallHour(bucket, from.Location()).Unix()
+		if values[id][hour] == nil {
+			values[id][hour] = map[string]float64{}
+		}
+		values[id][hour][code] = avg
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Latest report per ship and month; ships with a report but no readings are benched too
+	reports := map[int]map[string]map[string]bool{} // by ship and month (2006-01)
+	rows, err = db.DB.QueryContext(ctx, `
+		SELECT DISTINCT ON (s.id, date_trunc('month', r.report_date))
+			s.id, s.name, date_trunc('month', r.report_date), r.sensors_data
+		FROM fms_device_reports r
+		JOIN fms_ships s ON s.name = r.ship_name
+		WHERE r.report_date >= $1 AND r.report_date < $2 AND ($3 = 0 OR s.id = $3)
+		ORDER BY s.id, date_trunc('month', r.report_date), r.report_date DESC, r.updated_at DESC
+	`, time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location()), to, shipID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for rows.Next() {
+		var id int
+		var name string
+		var month time.Time
+		var data []byte
+		if err := rows.Scan(&id, &name, &month, &data); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		names[id] = name
+		if reports[id] == nil {
+			reports[id] = map[string]map[string]bool{}
+		}
+		sensors := map[string]bool{}
+		_ = json.Unmarshal(data, &sensors)
+		reports[id][month.Format("2006-01")] = sensors
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for id, name := range names {
+		var open *benchHit
+		for h := from; h.Before(to); h = h.Add(time.Hour) {
+			evaluated++
+			snap := rules.Snapshot{
+				Values: values[id][h.Unix()],
+				Report: reports[id][h.Format("2006-01")],
+			}
+			for code := range values[id][h.Unix()] {
+				last[key{id, code}] = h
+			}
+			for _, code := range sensors {
+				if t, ok := last[key{id, code}]; ok {
+					if snap.Ages == nil {
+						snap.Ages = map[string]float64{}
+					}
+					snap.Ages[code] = h.Sub(t).Hours()
+				}
+			}
+
+			hit, detail := expr.Eval(snap)
+			switch {
+			case hit && open == nil:
+				open = &benchHit{ShipName: name, From: h, Detail: detail}
+			case !hit && open != nil:
+				open.To = h
+				hits = append(hits, *open)
+				open = nil
+			}
+			if open != nil {
+				open.Hours++
+			}
+		}
+		if open != nil {
+			open.To = to
+			hits = append(hits, *open)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].ShipName != hits[j].ShipName {
+			return hits[i].ShipName < hits[j].ShipName
+		}
+		return hits[i].From.Before(hits[j].From)
+	})
+	return hits, evaluated, nil
+}
+
+// wallHour reads a TIMESTAMP column, stored as local wall-clock time, as an hour in loc
+func wallHour(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+}
+
+// SettingsRulesPage lists the status rules, recent rule alerts and the test bench.
+// The bench runs when ?expression= is given: ?expression=&ship_id=&from=&to= (dates).
+func SettingsRulesPage(c *gin.Context) {
+	type AlertRow struct {
+		ShipName    string
+		RuleName    string
+		Detail      string
+		Occurrences int
+		OpenedAt    time.Time
+		ResolvedAt  *time.Time
+	}
+
+	list, err := loadRules(c.Request.Context(), false)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	sensors, _ := allSensors()
+
+	var ships []Ship
+	if rows, err := db.DB.Query("SELECT id, name FROM fms_ships ORDER BY name ASC"); err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var s Ship
+			if err := rows.Scan(&s.ID, &s.Name); err == nil {
+				ships = append(ships, s)
+			}
+		}
+	}
+
+	openCounts := map[int]int{}
+	if rows, err := db.DB.Query("SELECT rule_id, COUNT(*) FROM fms_alerts WHERE rule_id IS NOT NULL AND resolved_at IS NULL GROUP BY rule_id"); err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var id, n int
+			if err := rows.Scan(&id, &n); err == nil {
+				openCounts[id] = n
+			}
+		}
+	}
+
+	var alerts []AlertRow
+	if rows, err := db.DB.Query(`
+		SELECT a.ship_name, r.name, COALESCE(a.rule_detail, ''), a.occurrences, a.opened_at, a.resolved_at
+		FROM fms_alerts a
+		JOIN fms_rules r ON r.id = a.rule_id
+		ORDER BY a.resolved_at IS NULL DESC, a.updated_at DESC
+		LIMIT 50
+	`); err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var a AlertRow
+			if err := rows.Scan(&a.ShipName, &a.RuleName, &a.Detail, &a.Occurrences, &a.OpenedAt, &a.ResolvedAt); err == nil {
+				alerts = append(alerts, a)
+			}
+		}
+	}
+
+	// Edit form, filled from ?edit=<id>
+	var edit statusRule
+	if id, _ := strconv.Atoi(c.Query("edit")); id > 0 {
+		for _, r := range list {
+			if r.ID == id {
+				edit = r
+			}
+		}
+	}
+
+	// Test bench
+	now := time.Now()
+	bench := gin.H{
+		"Expression": c.Query("expression"),
+		"ShipID":     c.Query("ship_id"),
+		"From":       now.AddDate(0, 0, -7).Format("2006-01-02"),
+		"To":         now.Format("2006-01-02"),
+	}
+	if v := c.Query("from"); v != "" {
+		bench["From"] = v
+	}
+	if v := c.Query("to"); v != "" {
+		bench["To"] = v
+	}
+	if expression := strings.TrimSpace(c.Query("expression")); expression != "" {
+		bench["Ran"] = true
+		from, errFrom := time.ParseInLocation("2006-01-02", bench["From"].(string), time.Local)
+		to, errTo := time.ParseInLocation("2006-01-02", bench["To"].(string), time.Local)
+		expr, err := rules.Parse(expression)
+		shipID, _ := strconv.Atoi(c.Query("ship_id"))
+		to = to.AddDate(0, 0, 1) // inclusive end date
+		switch {
+		case err != nil:
+			bench["Error"] = err.Error()
+		case errFrom != nil || errTo != nil || !to.After(from):
+			bench["Error"] = "Rentang tanggal tidak valid"
+		case to.Sub(from) > benchMaxDays*24*time.Hour:
+			bench["Error"] = fmt.Sprintf("Rentang maksimal %d hari", benchMaxDays)
+		default:
+			hits, evaluated, err := benchRule(c.Request.Context(), expr, shipID, from, to)
+			if err != nil {
+				bench["Error"] = err.Error()
+			}
+			hours := 0
+			for _, h := range hits {
+				hours += h.Hours
+			}
+			bench["Hits"], bench["Shown"] = hits, hits
+			if len(hits) > benchMaxHits {
+				bench["Shown"], bench["Truncated"] = hits[:benchMaxHits], true
+			}
+			bench["Evaluated"] = evaluated
+			bench["HitHours"] = hours
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_rules.html", gin.H{
+		"Rules":         list,
+		"OpenCounts":    openCounts,
+		"Alerts":        alerts,
+		"Sensors":       sensors,
+		"SensorNames":   sensorNames(),
+		"Ships":         ships,
+		"Edit":          edit,
+		"Bench":         bench,
+		"Operators":     rules.Operators,
+		"ActiveSidebar": "rules",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// ruleForm reads and validates the rule form; sensors in the expression must exist
+func ruleForm(c *gin.Context) (statusRule, string) {
+	r := statusRule{
+		Name:       strings.TrimSpace(c.PostForm("name")),
+		Sensor:     strings.TrimSpace(c.PostForm("sensor_code")),
+		Expression: strings.TrimSpace(c.PostForm("expression")),
+	}
+	if r.Name == "" || r.Sensor == "" || r.Expression == "" {
+		return r, "Nama, sensor dan aturan wajib diisi"
+	}
+	expr, err := rules.Parse(r.Expression)
+	if err != nil {
+		return r, err.Error()
+	}
+	names := sensorNames()
+	for _, code := range append([]string{r.Sensor}, expr.Sensors()...) {
+		if _, ok := names[code]; !ok {
+			return r, "Sensor tidak dikenal: " + code
+		}
+	}
+	r.Expression = expr.String()
+	return r, ""
+}
+
+// CreateRule adds a status rule
+func CreateRule(c *gin.Context) {
+	r, problem := ruleForm(c)
+	if problem != "" {
+		c.Redirect(http.StatusSeeOther, "/settings/rules?error="+url.QueryEscape(problem))
+		return
+	}
+	_, err := db.DB.Exec("INSERT INTO fms_rules (name, sensor_code, expression) VALUES ($1, $2, $3)",
+		r.Name, r.Sensor, r.Expression)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/rules?error=Gagal+menambah+aturan")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/settings/rules?success=Aturan+ditambahkan!+✅")
+}
+
+// UpdateRule changes a status rule. Open alerts stay open until the next evaluation misses.
+func UpdateRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	r, problem := ruleForm(c)
+	if problem != "" {
+		c.Redirect(http.StatusSeeOther, fmt.Sprintf("/settings/rules?edit=%d&error=%s", id, url.QueryEscape(problem)))
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Alerts are kept on the sensor they were opened for
+	_, err = tx.Exec(`
+		UPDATE fms_alerts a
+		SET resolved_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, resolution_note = 'Sensor aturan diubah'
+		FROM fms_rules r
+		WHERE r.id = $1 AND a.rule_id = r.id AND a.resolved_at IS NULL AND r.sensor_code <> $2
+	`, id, r.Sensor)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE fms_rules SET name = $2, sensor_code = $3, expression = $4,
+			updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id, r.Name, r.Sensor, r.Expression)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/rules?error=Gagal+mengubah+aturan")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/settings/rules?success=Aturan+diupdate!+🔄")
+}
+
+// ToggleRule enables or disables a rule; disabling resolves its open alerts
+func ToggleRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var active bool
+	err = tx.QueryRow("UPDATE fms_rules SET is_active = NOT is_active, updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING is_active", id).Scan(&active)
+	if err == nil && !active {
+		_, err = tx.Exec(`
+			UPDATE fms_alerts
+			SET resolved_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP, resolution_note = 'Aturan dinonaktifkan'
+			WHERE rule_id = $1 AND resolved_at IS NULL
+		`, id)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/rules?success=Status+aturan+diupdate!+🔄")
+}
+
+// DeleteRule removes a rule that never opened an alert; rules with alert history can
+// only be disabled so the history keeps its rule
+func DeleteRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	res, err := db.DB.Exec("DELETE FROM fms_rules WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM fms_alerts WHERE rule_id = $1)", id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.Redirect(http.StatusSeeOther, "/settings/rules?error=Aturan+sudah+punya+riwayat+alert,+nonaktifkan+saja")
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/settings/rules?success=Aturan+dihapus")
+}
+
+// EvaluateRules runs the rule evaluation now
+func EvaluateRules(c *gin.Context) {
+	checked, opened, resolved, err := evaluateRules(c.Request.Context(), time.Now())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/rules?error="+url.QueryEscape("Evaluasi gagal: "+err.Error()))
+		return
+	}
+	msg := fmt.Sprintf("%d aturan x kapal dicek: %d alert dibuka, %d diselesaikan", checked, opened, resolved)
+	c.Redirect(http.StatusSeeOther, "/settings/rules?success="+url.QueryEscape(msg))
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"fms-app/db"
+	"fms-app/scheduler"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SettingsSchedulesPage renders scheduled tasks and their most recent runs
+func SettingsSchedulesPage(c *gin.Context) {
+	type ScheduleRow struct {
+		Name        string
+		Cron        string
+		Description string
+		IsEnabled   bool
+		NextRunAt   time.Time
+		LastRunAt   *time.Time
+		LastStatus  string
+	}
+	type RunRow struct {
+		ID           int64
+		ScheduleName string
+		StartedAt    time.Time
+		DurationMs   int64
+		Status       string
+		Output       string
+	}
+
+	var schedules []ScheduleRow
+	rows, err := db.DB.Query(`
+		SELECT name, cron, COALESCE(description, ''), is_enabled, next_run_at, last_run_at, COALESCE(last_status, '')
+		FROM fms_schedules
+		ORDER BY name ASC
+	`)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var s ScheduleRow
+			if err := rows.Scan(&s.Name, &s.Cron, &s.Description, &s.IsEnabled, &s.NextRunAt, &s.LastRunAt, &s.LastStatus); err == nil {
+				schedules = append(schedules, s)
+			}
+		}
+	}
+
+	var runs []RunRow
+	rRows, err := db.DB.Query(`
+		SELECT id, schedule_name, started_at, COALESCE(duration_ms, 0), status, COALESCE(output, '')
+		FROM fms_schedule_runs
+		ORDER BY id DESC
+		LIMIT 50
+	`)
+	if err == nil {
+		defer rRows.Close()
+		for rRows.Next() {
+			var r RunRow
+			if err := rRows.Scan(&r.ID, &r.ScheduleName, &r.StartedAt, &r.DurationMs, &r.Status, &r.Output); err == nil {
+				runs = append(runs, r)
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_schedules.html", gin.H{
+		"Schedules":     schedules,
+		"Runs":          runs,
+		"ActiveSidebar": "schedules",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// UpdateSchedule changes the cron expression and enabled flag of a scheduled task
+func UpdateSchedule(c *gin.Context) {
+	name := c.Param("name")
+	cronExpr := c.PostForm("cron")
+	enabled := c.PostForm("enabled") == "on"
+
+	if err := scheduler.UpdateSchedule(name, cronExpr, enabled); err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/schedules?error="+url.QueryEscape("Jadwal tidak valid: "+err.Error()))
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/schedules?success=Jadwal+berhasil+disimpan!+✅")
+}
+
+// RunSchedule triggers a scheduled task immediately
+func RunSchedule(c *gin.Context) {
+	if err := scheduler.RunNow(c.Param("name")); err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/schedules?error=Task+tidak+ditemukan")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/schedules?success=Task+dijalankan!+▶️")
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"fms-app/db"
+	"fms-app/events"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,15 +23,16 @@ func SettingsPage(c *gin.Context) {
 		Name         string
 		IsActive     bool
 		DisplayOrder int
+		OfflineAfter *int
 	}
 
 	var sensorRows []SensorRow
-	rows, err := db.DB.Query("SELECT id, code, name, is_active, display_order FROM fms_sensor_config ORDER BY display_order ASC, id ASC")
+	rows, err := db.DB.Query("SELECT id, code, name, is_active, display_order, offline_after_hours FROM fms_sensor_config ORDER BY display_order ASC, id ASC")
 	if err == nil {
 		defer rows.Close()
 		for rows.Next() {
 			var s SensorRow
-			if err := rows.Scan(&s.ID, &s.Code, &s.Name, &s.IsActive, &s.DisplayOrder); err == nil {
+			if err := rows.Scan(&s.ID, &s.Code, &s.Name, &s.IsActive, &s.DisplayOrder, &s.OfflineAfter); err == nil {
 				sensorRows = append(sensorRows, s)
 			}
 		}
@@ -102,7 +104,23 @@ func CreateSensor(c *gin.Context) {
 		return
 	}
 
-	// Generate base code: "Engine RPM" -> "engine_rpm"
+	code := uniqueSensorCode(name)
+	maxOrder := maxSensorOrder()
+
+	_, err := db.DB.Exec("INSERT INTO fms_sensor_config (code, name, is_active, display_order) VALUES ($1, $2, true, $3)", code, name, maxOrder+1)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	events.Publish(events.SensorConfigChanged{Code: code, IsActive: true})
+
+	// Redirect back to settings
+	c.Redirect(http.StatusSeeOther, "/settings?success=Sensor+berhasil+ditambahkan!+✅")
+}
+
+// uniqueSensorCode derives an unused sensor code from a name: "Engine RPM" -> "engine_rpm"
+func uniqueSensorCode(name string) string {
 	baseCode := strings.ToLower(name)
 	reg, _ := regexp.Compile("[^a-z0-9]+")
 	baseCode = reg.ReplaceAllString(baseCode, "_")
@@ -121,33 +139,56 @@ func CreateSensor(c *gin.Context) {
 		counter++
 		code = baseCode + "_" + strconv.Itoa(counter)
 	}
+	return code
+}
 
-	// Default display order = max + 1
+// maxSensorOrder is the highest display order in use; new sensors go after it
+func maxSensorOrder() int {
 	var maxOrder int
 	_ = db.DB.QueryRow("SELECT COALESCE(MAX(display_order), 0) FROM fms_sensor_config").Scan(&maxOrder)
+	return maxOrder
+}
 
-	_, err := db.DB.Exec("INSERT INTO fms_sensor_config (code, name, is_active, display_order) VALUES ($1, $2, true, $3)", code, name, maxOrder+1)
+// ToggleSensor toggles the active status of a sensor
+func ToggleSensor(c *gin.Context) {
+	idStr := c.Param("id")
+	id, _ := strconv.Atoi(idStr)
+
+	var code string
+	var isActive bool
+	err := db.DB.QueryRow("UPDATE fms_sensor_config SET is_active = NOT is_active WHERE id = $1 RETURNING code, is_active", id).Scan(&code, &isActive)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Error: %v", err)
 		return
 	}
 
-	// Redirect back to settings
-	c.Redirect(http.StatusSeeOther, "/settings?success=Sensor+berhasil+ditambahkan!+✅")
+	events.Publish(events.SensorConfigChanged{Code: code, IsActive: isActive})
+
+	c.Redirect(http.StatusSeeOther, "/settings?success=Status+sensor+diupdate!+🔄")
 }
 
-// ToggleSensor toggles the active status of a sensor
-func ToggleSensor(c *gin.Context) {
-	idStr := c.Param("id")
-	id, _ := strconv.Atoi(idStr)
+// UpdateSensorOfflineRule sets after how many hours without device data a sensor is
+// derived offline in draft reports; an empty value removes the rule
+func UpdateSensorOfflineRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var hours *int
+	if v := strings.TrimSpace(c.PostForm("offline_after_hours")); v != "" {
+		h, err := strconv.Atoi(v)
+		if err != nil || h < 1 || h > 24*366 {
+			c.Redirect(http.StatusSeeOther, "/settings?error=Batas+offline+harus+1+sampai+8784+jam")
+			return
+		}
+		hours = &h
+	}
 
-	_, err := db.DB.Exec("UPDATE fms_sensor_config SET is_active = NOT is_active WHERE id = $1", id)
+	_, err := db.DB.Exec("UPDATE fms_sensor_config SET offline_after_hours = $2 WHERE id = $1", id, hours)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Error: %v", err)
 		return
 	}
 
-	c.Redirect(http.StatusSeeOther, "/settings?success=Status+sensor+diupdate!+🔄")
+	c.Redirect(http.StatusSeeOther, "/settings?success=Aturan+offline+sensor+diupdate!+🔄")
 }
 
 // Helper to get company logo
@@ -170,6 +211,13 @@ func GetCompanyLogo() string {
 	return cachedLogo
 }
 
+// InvalidateLogoCache refreshes the cached logo when the company_logo config changes
+func InvalidateLogoCache(e events.AppConfigChanged) {
+	if e.Key == "company_logo" {
+		cachedLogo = e.Value
+	}
+}
+
 // SettingsGeneralPage renders the general settings (Logo, etc)
 func SettingsGeneralPage(c *gin.Context) {
 	logo := GetCompanyLogo()
@@ -219,7 +267,7 @@ func UpdateLogo(c *gin.Context) {
 		return
 	}
 
-	cachedLogo = dbPath // Update cache
+	events.Publish(events.AppConfigChanged{Key: "company_logo", Value: dbPath})
 
 	c.Redirect(http.StatusSeeOther, "/settings/general?success=Logo+updated!+✅")
 }
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"fms-app/db"
+	"fms-app/events"
+	"fms-app/readings"
+	"fms-app/shipname"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShipAlias is an alternative spelling that resolves to a master ship
+type ShipAlias struct {
+	ID    int
+	Alias string
+}
+
+// shipDuplicate is a ship in a group of ships sharing a normalized name
+type shipDuplicate struct {
+	ID         int
+	Name       string
+	Code       string
+	Reports    int
+	LastReport string
+}
+
+type shipDuplicateGroup struct {
+	Key    string
+	Target int
+	Ships  []shipDuplicate
+}
+
+// loadShipAliases lists the aliases of a ship
+func loadShipAliases(shipID int) []ShipAlias {
+	var aliases []ShipAlias
+	rows, err := db.DB.Query("SELECT id, alias FROM fms_ship_aliases WHERE ship_id = $1 ORDER BY alias ASC", shipID)
+	if err != nil {
+		return aliases
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a ShipAlias
+		if rows.Scan(&a.ID, &a.Alias) == nil {
+			aliases = append(aliases, a)
+		}
+	}
+	return aliases
+}
+
+// addShipAlias records alias as a spelling of the ship. Spellings that already normalize
+// to the ship's own name are not stored.
+func addShipAlias(q dbExecutor, shipID int, alias string) error {
+	alias = shipname.Clean(alias)
+	key := shipname.Key(alias)
+	if key == "" {
+		return nil
+	}
+	var name string
+	if err := q.QueryRow("SELECT name FROM fms_ships WHERE id = $1", shipID).Scan(&name); err != nil {
+		return err
+	}
+	if shipname.Key(name) == key {
+		return nil
+	}
+	_, err := q.Exec(`
+		INSERT INTO fms_ship_aliases (ship_id, alias, alias_key) VALUES ($1, $2, $3)
+		ON CONFLICT (alias_key) DO UPDATE SET ship_id = EXCLUDED.ship_id, alias = EXCLUDED.alias`,
+		shipID, alias, key,
+	)
+	return err
+}
+
+// CreateShipAlias adds an alias from the ship configuration page
+func CreateShipAlias(c *gin.Context) {
+	shipID, _ := strconv.Atoi(c.Param("id"))
+	back := "/settings/ships/" + strconv.Itoa(shipID)
+	alias := shipname.Clean(c.PostForm("alias"))
+	if alias == "" {
+		c.Redirect(http.StatusSeeOther, back+"?error=Alias+tidak+boleh+kosong")
+		return
+	}
+
+	// An alias may not hide another master ship; those have to be merged
+	index, err := shipNameIndex(db.DB)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	if owner, ok := index[shipname.Key(alias)]; ok {
+		var ownerID int
+		_ = db.DB.QueryRow("SELECT id FROM fms_ships WHERE name = $1", owner).Scan(&ownerID)
+		if ownerID != shipID {
+			msg := fmt.Sprintf("%s sudah terdaftar untuk kapal %s. Gunakan menu Duplikat untuk menggabungkan.", alias, owner)
+			c.Redirect(http.StatusSeeOther, back+"?error="+url.QueryEscape(msg))
+			return
+		}
+	}
+
+	if err := addShipAlias(db.DB, shipID, alias); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, back+"?success=Alias+ditambahkan")
+}
+
+// DeleteShipAlias removes an alias of a ship
+func DeleteShipAlias(c *gin.Context) {
+	shipID, _ := strconv.Atoi(c.Param("id"))
+	_, err := db.DB.Exec("DELETE FROM fms_ship_aliases WHERE id = $1 AND ship_id = $2", c.Param("alias_id"), shipID)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/settings/ships/"+strconv.Itoa(shipID)+"?success=Alias+dihapus")
+}
+
+// findShipDuplicates groups ships whose names normalize to the same key. The proposed
+// target is the ship with a code, then the one with the most reports.
+func findShipDuplicates() ([]shipDuplicateGroup, error) {
+	rows, err := db.DB.Query(`
+		SELECT s.id, s.name, COALESCE(s.code, ''), COUNT(r.id), COALESCE(TO_CHAR(MAX(r.report_date), 'DD Mon YYYY'), '-')
+		FROM fms_ships s
+		LEFT JOIN fms_device_reports r ON r.ship_name = s.name
+		GROUP BY s.id, s.name, s.code
+		ORDER BY s.name ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byKey := map[string][]shipDuplicate{}
+	var keys []string
+	for rows.Next() {
+		var d shipDuplicate
+		if err := rows.Scan(&d.ID, &d.Name, &d.Code, &d.Reports, &d.LastReport); err != nil {
+			return nil, err
+		}
+		key := shipname.Key(d.Name)
+		if _, seen := byKey[key]; !seen {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var groups []shipDuplicateGroup
+	for _, key := range keys {
+		ships := byKey[key]
+		if len(ships) < 2 {
+			continue
+		}
+		sort.SliceStable(ships, func(i, j int) bool {
+			if (ships[i].Code != "") != (ships[j].Code != "") {
+				return ships[i].Code != ""
+			}
+			if ships[i].Reports != ships[j].Reports {
+				return ships[i].Reports > ships[j].Reports
+			}
+			return ships[i].ID < ships[j].ID
+		})
+		groups = append(groups, shipDuplicateGroup{Key: key, Target: ships[0].ID, Ships: ships})
+	}
+	return groups, nil
+}
+
+// SettingsShipDuplicatesPage lists ships that look like the same vessel and offers merges
+func SettingsShipDuplicatesPage(c *gin.Context) {
+	groups, err := findShipDuplicates()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	var ships []Ship
+	rows, err := db.DB.Query("SELECT id, name, COALESCE(code, '') FROM fms_ships ORDER BY name ASC")
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var s Ship
+			if rows.Scan(&s.ID, &s.Name, &s.Code) == nil {
+				ships = append(ships, s)
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_ship_duplicates.html", gin.H{
+		"Groups":        groups,
+		"Ships":         ships,
+		"ActiveSidebar": "ships",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// MergeShips folds the source ships into target_id: reports and alerts are re-pointed,
+// overrides, aliases and gateway data moved, and each source name is kept as an alias of
+// the target
+func MergeShips(c *gin.Context) {
+	back := "/settings/ships/duplicates"
+	targetID, _ := strconv.Atoi(c.PostForm("target_id"))
+	var sourceIDs []int
+	for _, v := range c.PostFormArray("source_id") {
+		if id, err := strconv.Atoi(v); err == nil && id != targetID {
+			sourceIDs = append(sourceIDs, id)
+		}
+	}
+	if targetID == 0 || len(sourceIDs) == 0 {
+		c.Redirect(http.StatusSeeOther, back+"?error=Pilih+kapal+tujuan+dan+kapal+yang+digabungkan")
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var targetName string
+	if err := tx.QueryRow("SELECT name FROM fms_ships WHERE id = $1 FOR UPDATE", targetID).Scan(&targetName); err != nil {
+		c.Redirect(http.StatusSeeOther, back+"?error=Kapal+tujuan+tidak+ditemukan")
+		return
+	}
+
+	var merged []string
+	for _, id := range sourceIDs {
+		name, err := mergeShip(tx, targetID, targetName, id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			log.Printf("Merge ship %d into %d: %v", id, targetID, err)
+			c.Redirect(http.StatusSeeOther, back+"?error="+url.QueryEscape("Gagal menggabungkan: "+err.Error()))
+			return
+		}
+		merged = append(merged, name)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	events.Publish(events.ShipMerged{ID: targetID, Name: targetName, MergedNames: merged})
+
+	msg := fmt.Sprintf("%d kapal digabungkan ke %s 🔀", len(merged), targetName)
+	c.Redirect(http.StatusSeeOther, back+"?success="+url.QueryEscape(msg))
+}
+
+// mergeShip moves everything of ship sourceID to the target and deletes it, returning the
+// source name. sql.ErrNoRows means the source no longer exists.
+func mergeShip(tx *sql.Tx, targetID int, targetName string, sourceID int) (string, error) {
+	var name, code string
+	var metadata []byte
+	err := tx.QueryRow(
+		"SELECT name, COALESCE(code, ''), COALESCE(metadata, '{}') FROM fms_ships WHERE id = $1 FOR UPDATE", sourceID,
+	).Scan(&name, &code, &metadata)
+	if err != nil {
+		return "", err
+	}
+
+	// Only one alert per ship, sensor and rule may be open: the target's alert wins
+	_, err = tx.Exec(`
+		UPDATE fms_alerts a SET resolved_at = NOW(), resolution_note = $3
+		WHERE a.ship_name = $1 AND a.resolved_at IS NULL AND EXISTS (
+			SELECT 1 FROM fms_alerts t
+			WHERE t.ship_name = $2 AND t.resolved_at IS NULL
+			  AND t.sensor_code = a.sensor_code AND COALESCE(t.rule_id, 0) = COALESCE(a.rule_id, 0)
+		)`, name, targetName, "Digabung ke "+targetName)
+	if err != nil {
+		return "", err
+	}
+	if err := renameShipHistory(tx, name, targetName); err != nil {
+		return "", err
+	}
+
+	// Overrides set on the target are kept
+	_, err = tx.Exec(`
+		INSERT INTO fms_ship_sensors (ship_id, sensor_code, is_active)
+		SELECT $1, sensor_code, is_active FROM fms_ship_sensors WHERE ship_id = $2
+		ON CONFLICT (ship_id, sensor_code) DO NOTHING`, targetID, sourceID)
+	if err != nil {
+		return "", err
+	}
+
+	// The target keeps its own code and metadata values, filling only what it lacks
+	_, err = tx.Exec(`
+		UPDATE fms_ships SET
+			code = COALESCE(NULLIF(code, ''), NULLIF($2, '')),
+			metadata = $3::jsonb || COALESCE(metadata, '{}')
+		WHERE id = $1`, targetID, code, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec("UPDATE fms_ship_aliases SET ship_id = $1 WHERE ship_id = $2", targetID, sourceID); err != nil {
+		return "", err
+	}
+	if err := moveShipDeviceData(tx, targetID, sourceID); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("DELETE FROM fms_ships WHERE id = $1", sourceID); err != nil {
+		return "", err
+	}
+	return name, addShipAlias(tx, targetID, name)
+}
+
+// moveShipDeviceData re-points the gateway data of the source ship before it is deleted,
+// as the ship_id keys cascade: tokens, uploaded logs and readings move over, the newer
+// last-seen sample wins and a draft moves only for a period the target has no draft for
+func moveShipDeviceData(tx *sql.Tx, targetID, sourceID int) error {
+	for _, q := range []string{
+		"UPDATE fms_device_tokens SET ship_id = $1 WHERE ship_id = $2",
+		"UPDATE fms_gateway_logs SET ship_id = $1 WHERE ship_id = $2",
+		`INSERT INTO fms_sensor_last_seen (ship_id, sensor_code, seen_at, online, value)
+		 SELECT $1, sensor_code, seen_at, online, value FROM fms_sensor_last_seen WHERE ship_id = $2
+		 ON CONFLICT (ship_id, sensor_code) DO UPDATE SET
+			seen_at = EXCLUDED.seen_at, online = EXCLUDED.online, value = EXCLUDED.value
+		 WHERE EXCLUDED.seen_at > fms_sensor_last_seen.seen_at`,
+		`UPDATE fms_report_drafts d SET ship_id = $1
+		 WHERE d.ship_id = $2 AND NOT EXISTS (
+			SELECT 1 FROM fms_report_drafts t WHERE t.ship_id = $1 AND t.period = d.period
+		 )`,
+	} {
+		if _, err := tx.Exec(q, targetID, sourceID); err != nil {
+			return err
+		}
+	}
+	return readings.MoveShip(tx, sourceID, targetID)
+}
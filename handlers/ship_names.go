@@ -0,0 +1,60 @@
+package handlers
+
+import "fms-app/shipname"
+
+// shipNames maps the key of every ship name and alias to the master ship name
+type shipNames map[string]string
+
+// resolve returns the master name for a spelling, or its cleaned form for a ship that is
+// not in the master list yet
+func (n shipNames) resolve(name string) string {
+	name = shipname.Clean(name)
+	if master, ok := n[shipname.Key(name)]; ok && name != "" {
+		return master
+	}
+	return name
+}
+
+// shipNameIndex loads the ship names and aliases. Writers of many reports load it once
+// per transaction and resolve every row against it.
+func shipNameIndex(q dbExecutor) (shipNames, error) {
+	index := make(shipNames)
+
+	rows, err := q.Query("SELECT a.alias_key, s.name FROM fms_ship_aliases a JOIN fms_ships s ON s.id = a.ship_id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key, name string
+		if rows.Scan(&key, &name) == nil {
+			index[key] = name
+		}
+	}
+	rows.Close()
+
+	// Master names win over aliases
+	rows, err = q.Query("SELECT name FROM fms_ships")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			index[shipname.Key(name)] = name
+		}
+	}
+	return index, rows.Err()
+}
+
+// resolveShipName resolves a single spelling, see shipNames.resolve
+func resolveShipName(q dbExecutor, name string) (string, error) {
+	if shipname.Clean(name) == "" {
+		return "", nil
+	}
+	index, err := shipNameIndex(q)
+	if err != nil {
+		return "", err
+	}
+	return index.resolve(name), nil
+}
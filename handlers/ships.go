@@ -3,9 +3,12 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"fms-app/db"
+	"fms-app/events"
+	"fms-app/shipname"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,7 +23,7 @@ type Ship struct {
 
 // SettingsShipsPage renders the ship management page
 func SettingsShipsPage(c *gin.Context) {
-	rows, err := db.DB.Query("SELECT id, name, code, created_at FROM fms_ships ORDER BY name ASC")
+	rows, err := db.DB.Query("SELECT id, name, COALESCE(code, ''), created_at FROM fms_ships ORDER BY name ASC")
 	var ships []Ship
 	if err == nil {
 		defer rows.Close()
@@ -45,7 +48,7 @@ func SettingsShipsPage(c *gin.Context) {
 
 // CreateShip adds a new ship
 func CreateShip(c *gin.Context) {
-	name := c.PostForm("name")
+	name := shipname.Clean(c.PostForm("name"))
 	code := c.PostForm("code")
 
 	if name == "" {
@@ -53,12 +56,24 @@ func CreateShip(c *gin.Context) {
 		return
 	}
 
-	_, err := db.DB.Exec("INSERT INTO fms_ships (name, code) VALUES ($1, $2)", name, code)
+	// Reject another spelling of a ship that is already registered
+	if existing, found, err := registeredShipName(db.DB, name); err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	} else if found {
+		c.Redirect(http.StatusSeeOther, "/settings/ships?error="+url.QueryEscape("Kapal sudah terdaftar sebagai "+existing))
+		return
+	}
+
+	var id int
+	err := db.DB.QueryRow("INSERT INTO fms_ships (name, code) VALUES ($1, $2) RETURNING id", name, code).Scan(&id)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Error: %v", err)
 		return
 	}
 
+	events.Publish(events.ShipCreated{ID: id, Name: name, Code: code})
+
 	c.Redirect(http.StatusSeeOther, "/settings/ships?success=Kapal+berhasil+ditambahkan!+🚢")
 }
 
@@ -118,45 +133,70 @@ func SettingsShipConfigPage(c *gin.Context) {
 	c.HTML(http.StatusOK, "settings_ship_config.html", gin.H{
 		"Ship":          ship,
 		"Sensors":       sensors,
+		"Aliases":       loadShipAliases(shipID),
 		"ActiveSidebar": "ships",
 		"ActiveTab":     "settings",
 	})
 }
 
-// ToggleShipSensor updates the sensor status for a ship
+// ToggleShipSensor flips the effective status of a sensor for a ship
 func ToggleShipSensor(c *gin.Context) {
 	shipID, _ := strconv.Atoi(c.Param("id"))
 	sensorCode := c.PostForm("sensor_code")
-	// toggle action
 
-	// We need to know current state to toggle.
-	// Or simpler: User sends "desired state" or just "toggle".
-	// Let's implement Toggle.
-
-	// Check if exists
-	var currentStatus bool
-	err := db.DB.QueryRow("SELECT is_active FROM fms_ship_sensors WHERE ship_id = $1 AND sensor_code = $2", shipID, sensorCode).Scan(&currentStatus)
+	// The override is set to the opposite of what the ship uses now: the override
+	// itself when one exists, otherwise the global status
+	active, _, err := shipSensorState(db.DB, shipID, sensorCode)
+	if err == nil {
+		active = !active
+		err = setShipSensor(db.DB, shipID, sensorCode, &active)
+	}
 
 	if err != nil {
-		// Not exists, creating override.
-		// If not exists, it followed Global. We need to know Global status to flip it.
-		var globalStatus bool
-		db.DB.QueryRow("SELECT is_active FROM fms_sensor_config WHERE code = $1", sensorCode).Scan(&globalStatus)
-
-		// New status = NOT Global
-		newStatus := !globalStatus
-		_, err = db.DB.Exec("INSERT INTO fms_ship_sensors (ship_id, sensor_code, is_active) VALUES ($1, $2, $3)", shipID, sensorCode, newStatus)
-
+		log.Println(err)
 	} else {
-		// Exists, flip it
-		_, err = db.DB.Exec("UPDATE fms_ship_sensors SET is_active = NOT is_active WHERE ship_id = $1 AND sensor_code = $2", shipID, sensorCode)
+		events.Publish(events.SensorConfigChanged{Code: sensorCode, ShipID: shipID, IsActive: active})
 	}
 
+	c.Redirect(http.StatusSeeOther, "/settings/ships/"+strconv.Itoa(shipID)+"?success=Konfigurasi+sensor+diupdate!+📡")
+}
+
+// registeredShipName returns the master name a spelling resolves to when that ship
+// (or one of its aliases) is already registered
+func registeredShipName(q dbExecutor, name string) (string, bool, error) {
+	index, err := shipNameIndex(q)
 	if err != nil {
-		log.Println(err)
+		return "", false, err
 	}
+	existing, ok := index[shipname.Key(name)]
+	return existing, ok, nil
+}
 
-	c.Redirect(http.StatusSeeOther, "/settings/ships/"+strconv.Itoa(shipID)+"?success=Konfigurasi+sensor+diupdate!+📡")
+// shipSensorState returns whether a sensor is active for a ship and whether that comes
+// from a per-ship override rather than the global setting
+func shipSensorState(q dbExecutor, shipID int, code string) (active, override bool, err error) {
+	err = q.QueryRow(`
+		SELECT COALESCE(s.is_active, g.is_active), s.ship_id IS NOT NULL
+		FROM fms_sensor_config g
+		LEFT JOIN fms_ship_sensors s ON s.sensor_code = g.code AND s.ship_id = $1
+		WHERE g.code = $2
+	`, shipID, code).Scan(&active, &override)
+	return active, override, err
+}
+
+// setShipSensor sets the per-ship override of a sensor; nil removes it so the ship
+// follows the global setting again
+func setShipSensor(q dbExecutor, shipID int, code string, active *bool) error {
+	if active == nil {
+		_, err := q.Exec("DELETE FROM fms_ship_sensors WHERE ship_id = $1 AND sensor_code = $2", shipID, code)
+		return err
+	}
+	_, err := q.Exec(`
+		INSERT INTO fms_ship_sensors (ship_id, sensor_code, is_active) VALUES ($1, $2, $3)
+		ON CONFLICT (ship_id, sensor_code) DO UPDATE SET is_active = EXCLUDED.is_active`,
+		shipID, code, *active,
+	)
+	return err
 }
 
 // FormSensors returns the HTML fragment for sensor inputs based on ship selection
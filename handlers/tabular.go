@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// readTable parses an uploaded CSV or XLSX file into a header and data rows.
+// CSV delimiters (, ; tab) are detected from the header line; XLSX uses the first sheet
+// with raw cell values so dates arrive as Excel serial numbers.
+func readTable(filename string, data []byte) (header []string, rows [][]string, err error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".txt":
+		data = bytes.TrimPrefix(data, []byte("\ufeff"))
+		r := csv.NewReader(bytes.NewReader(data))
+		r.Comma = detectDelimiter(data)
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, nil, fmt.Errorf("CSV tidak valid: %w", err)
+		}
+		for _, record := range records {
+			for i, v := range record {
+				record[i] = uncsvCell(v)
+			}
+		}
+		rows = records
+	case ".xlsx":
+		f, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, fmt.Errorf("XLSX tidak valid: %w", err)
+		}
+		defer f.Close()
+		rows, err = f.GetRows(f.GetSheetName(0), excelize.Options{RawCellValue: true})
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("format file harus .csv atau .xlsx")
+	}
+
+	// Drop empty rows
+	var out [][]string
+	for _, row := range rows {
+		for _, v := range row {
+			if strings.TrimSpace(v) != "" {
+				out = append(out, row)
+				break
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil, fmt.Errorf("file kosong")
+	}
+
+	header = make([]string, len(out[0]))
+	for i, h := range out[0] {
+		header[i] = strings.TrimSpace(h)
+	}
+	return header, out[1:], nil
+}
+
+// uncsvCell drops the quote csvCell puts before formula-like text, so our own CSV
+// exports import back unchanged
+func uncsvCell(v string) string {
+	if len(v) > 1 && v[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(v[1])) {
+		return v[1:]
+	}
+	return v
+}
+
+func detectDelimiter(data []byte) rune {
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line = data[:i]
+	}
+	best, bestCount := ',', 0
+	for _, d := range []rune{',', ';', '\t', '|'} {
+		if n := bytes.Count(line, []byte(string(d))); n > bestCount {
+			best, bestCount = d, n
+		}
+	}
+	return best
+}
+
+// cellAt returns the trimmed value of column i, or "" when the row is short
+func cellAt(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// normalizeHeader lowercases and strips spaces/punctuation so "RPM ME Port" matches "rpm_me_port"
+func normalizeHeader(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// parseSensorValue accepts the usual spreadsheet spellings of a sensor status
+func parseSensorValue(v string) (status, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "on", "online", "true", "ya", "y", "yes", "ok", "v", "✓", "✔":
+		return true, true
+	case "0", "off", "offline", "false", "tidak", "t", "n", "no", "x":
+		return false, true
+	}
+	return false, false
+}
+
+// parseImportDate accepts ISO dates, dd/mm/yyyy, dd-mm-yyyy and Excel serial numbers
+func parseImportDate(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	for _, layout := range []string{"2006-01-02", "02/01/2006", "2/1/2006", "02-01-2006", "2006/01/02", "02 Jan 2006"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(v, 64); err == nil && serial > 1 && serial < 100000 {
+		t, err := excelize.ExcelDateToTime(serial, false)
+		if err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("tanggal tidak valid: %q", v)
+}
+
+// parseImportPeriod accepts YYYY-MM, "Jan 2006" and "01/2006"
+func parseImportPeriod(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	for _, layout := range []string{"2006-01", "Jan 2006", "January 2006", "01/2006", "1/2006"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := parseImportDate(v); err == nil {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Time{}, fmt.Errorf("periode tidak valid: %q", v)
+}
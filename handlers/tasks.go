@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/events"
+	"fms-app/jobs"
+	"fms-app/mailer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activeProjects returns the codes of active projects
+func activeProjects() ([]string, error) {
+	rows, err := db.DB.Query("SELECT code FROM fms_projects WHERE is_active = true ORDER BY code ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var projects []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err == nil {
+			projects = append(projects, p)
+		}
+	}
+	return projects, rows.Err()
+}
+
+// RolloverPeriodTask closes the previous month: it stores a summary snapshot per
+// project in fms_period_summaries and moves current_period to the new month
+func RolloverPeriodTask(ctx context.Context) (string, error) {
+	now := time.Now()
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	closed := current.AddDate(0, -1, 0)
+
+	projects, err := activeProjects()
+	if err != nil {
+		return "", err
+	}
+
+	var totalShips int
+	if err := db.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM fms_ships").Scan(&totalShips); err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, project := range projects {
+		reported, online, offline, err := periodStats(ctx, project, closed)
+		if err != nil {
+			return strings.Join(lines, "\n"), err
+		}
+
+		_, err = db.DB.ExecContext(ctx, `
+			INSERT INTO fms_period_summaries (project_code, period, total_ships, reported_ships, total_online, total_offline)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (project_code, period) DO UPDATE SET
+				total_ships = EXCLUDED.total_ships, reported_ships = EXCLUDED.reported_ships,
+				total_online = EXCLUDED.total_online, total_offline = EXCLUDED.total_offline,
+				closed_at = CURRENT_TIMESTAMP
+		`, project, closed, totalShips, reported, online, offline)
+		if err != nil {
+			return strings.Join(lines, "\n"), err
+		}
+
+		lines = append(lines, summaryLine(project, closed, reported, totalShips, online, offline))
+	}
+
+	_, err = db.DB.ExecContext(ctx, `
+		INSERT INTO fms_app_config (key, value) VALUES ('current_period', $1)
+		ON CONFLICT (key) DO UPDATE SET value = $1
+	`, current.Format("2006-01"))
+	if err != nil {
+		return strings.Join(lines, "\n"), err
+	}
+	lines = append(lines, "current_period = "+current.Format("2006-01"))
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// periodStats counts the ships that reported in a project's month and their sensor statuses
+func periodStats(ctx context.Context, project string, period time.Time) (reported, online, offline int, err error) {
+	err = db.DB.QueryRowContext(ctx, `
+		SELECT
+			COUNT(DISTINCT r.ship_name),
+			COALESCE(SUM((SELECT COUNT(*) FROM jsonb_each(r.sensors_data) e WHERE e.value = 'true'::jsonb)), 0),
+			COALESCE(SUM((SELECT COUNT(*) FROM jsonb_each(r.sensors_data) e WHERE e.value = 'false'::jsonb)), 0)
+		FROM fms_device_reports r
+		WHERE r.code LIKE $1 AND r.report_date >= $2 AND r.report_date < $3
+	`, project+" %", period, period.AddDate(0, 1, 0)).Scan(&reported, &online, &offline)
+	return reported, online, offline, err
+}
+
+func summaryLine(project string, period time.Time, reported, totalShips, online, offline int) string {
+	var pct float64
+	if online+offline > 0 {
+		pct = float64(online) / float64(online+offline) * 100
+	}
+	return fmt.Sprintf("%s %s: %d/%d kapal lapor, %.1f%% online",
+		project, period.Format("Jan 2006"), reported, totalShips, pct)
+}
+
+// SummaryJobKind is the background job kind that recomputes the summary of a closed period
+const SummaryJobKind = "summary.recompute"
+
+// summaryJob is the payload of SummaryJobKind, Period is YYYY-MM
+type summaryJob struct {
+	Project string `json:"project"`
+	Period  string `json:"period"`
+}
+
+// SummaryJob is the jobs.Handler for SummaryJobKind. It refreshes the report counts of a
+// period closed by RolloverPeriodTask; the fleet size stays the one at closing time.
+func SummaryJob(ctx context.Context, payload json.RawMessage) error {
+	var p summaryJob
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return jobs.Permanent(err)
+	}
+	period, err := time.Parse("2006-01", p.Period)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	reported, online, offline, err := periodStats(ctx, p.Project, period)
+	if err != nil {
+		return err
+	}
+	var totalShips int
+	err = db.DB.QueryRowContext(ctx, `
+		UPDATE fms_period_summaries SET reported_ships = $3, total_online = $4, total_offline = $5
+		WHERE project_code = $1 AND period = $2
+		RETURNING total_ships
+	`, p.Project, period, reported, online, offline).Scan(&totalShips)
+	if err == sql.ErrNoRows {
+		return jobs.SetResult(ctx, jobs.Result{Message: "periode belum ditutup, tidak ada ringkasan"})
+	}
+	if err != nil {
+		return err
+	}
+	return jobs.SetResult(ctx, jobs.Result{Message: summaryLine(p.Project, period, reported, totalShips, online, offline)})
+}
+
+// queueSummaryRecompute queues SummaryJob when a report of an already closed period changes
+func queueSummaryRecompute(r events.Report) {
+	period := time.Date(r.ReportDate.Year(), r.ReportDate.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var closed bool
+	err := db.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM fms_period_summaries WHERE project_code = $1 AND period = $2)
+	`, r.ProjectCode, period).Scan(&closed)
+	if err == nil && closed {
+		_, err = jobs.EnqueueOnce(SummaryJobKind, summaryJob{Project: r.ProjectCode, Period: period.Format("2006-01")})
+	}
+	if err != nil {
+		log.Println("queue summary recompute:", err)
+	}
+}
+
+// RecomputeSummaryOnCreate keeps closed period summaries in step with late and imported reports
+func RecomputeSummaryOnCreate(e events.ReportCreated) { queueSummaryRecompute(e.Report) }
+
+// RecomputeSummaryOnUpdate keeps closed period summaries in step with edited reports
+func RecomputeSummaryOnUpdate(e events.ReportUpdated) { queueSummaryRecompute(e.Report) }
+
+// MissingReportsTask queues reminder emails for ships that have not reported near period end
+func MissingReportsTask(ctx context.Context) (string, error) {
+	n, err := CheckMissingReports(time.Now())
+	return fmt.Sprintf("%d reminder email(s) queued", n), err
+}
+
+// DataQualityTask runs consistency checks over the reports and returns one line per check
+func DataQualityTask(ctx context.Context) (string, error) {
+	checks := []struct {
+		name  string
+		query string
+	}{
+		{"Kapal tidak terdaftar di master", `
+			SELECT COUNT(*) FROM fms_device_reports r
+			WHERE NOT EXISTS (SELECT 1 FROM fms_ships s WHERE s.name = r.ship_name)`},
+		{"Laporan tanpa data sensor", `
+			SELECT COUNT(*) FROM fms_device_reports
+			WHERE sensors_data IS NULL OR sensors_data = '{}'::jsonb`},
+		{"Kode sensor tidak dikenal", `
+			SELECT COUNT(DISTINCT r.id) FROM fms_device_reports r, jsonb_object_keys(r.sensors_data) k
+			WHERE NOT EXISTS (SELECT 1 FROM fms_sensor_config c WHERE c.code = k)`},
+		{"Laporan duplikat (kapal + kode sama)", `
+			SELECT COALESCE(SUM(n - 1), 0) FROM (
+				SELECT COUNT(*) AS n FROM fms_device_reports GROUP BY ship_name, code HAVING COUNT(*) > 1
+			) d`},
+		{"Tanggal laporan di masa depan", `
+			SELECT COUNT(*) FROM fms_device_reports WHERE report_date > CURRENT_DATE`},
+		{"Kolom legacy tidak sesuai sensors_data", `
+			SELECT COUNT(*) FROM fms_device_reports
+			WHERE (sensors_data ? 'gps' AND (sensors_data->>'gps')::boolean <> gps)
+			   OR (sensors_data ? 'device_condition' AND (sensors_data->>'device_condition')::boolean <> device_condition)`},
+	}
+
+	var lines []string
+	issues := 0
+	for _, check := range checks {
+		var n int
+		if err := db.DB.QueryRowContext(ctx, check.query).Scan(&n); err != nil {
+			return strings.Join(lines, "\n"), fmt.Errorf("%s: %w", check.name, err)
+		}
+		issues += n
+		lines = append(lines, fmt.Sprintf("%s: %d", check.name, n))
+	}
+
+	if issues == 0 {
+		lines = append([]string{"OK, tidak ada masalah data"}, lines...)
+	} else {
+		lines = append([]string{fmt.Sprintf("%d temuan", issues)}, lines...)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// alertInProject matches alerts a (LEFT JOIN report r) of the project whose report codes
+// match $1: through their report, or for rule alerts and alerts whose report was deleted,
+// through the ship reporting in the project
+const alertInProject = `(r.code LIKE $1 OR (r.id IS NULL AND EXISTS (
+	SELECT 1 FROM fms_device_reports p WHERE p.ship_name = a.ship_name AND p.code LIKE $1
+)))`
+
+// WeeklyDigestTask queues a digest email per project with last week's reports and open alerts
+func WeeklyDigestTask(ctx context.Context) (string, error) {
+	projects, err := activeProjects()
+	if err != nil {
+		return "", err
+	}
+	since := time.Now().AddDate(0, 0, -7)
+	names := sensorNames()
+
+	queued := 0
+	for _, project := range projects {
+		to := recipientsFor(project)
+		if len(to) == 0 {
+			continue
+		}
+
+		var reportCount, resolvedCount int
+		err := db.DB.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM fms_device_reports WHERE code LIKE $1 AND created_at >= $2
+		`, project+" %", since).Scan(&reportCount)
+		if err != nil {
+			return "", err
+		}
+		err = db.DB.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM fms_alerts a
+			LEFT JOIN fms_device_reports r ON r.id = a.report_id
+			WHERE `+alertInProject+` AND a.resolved_at >= $2
+		`, project+" %", since).Scan(&resolvedCount)
+		if err != nil {
+			return "", err
+		}
+
+		rows, err := db.DB.QueryContext(ctx, `
+			SELECT a.ship_name, a.sensor_code, a.occurrences, a.escalation_level, a.opened_at
+			FROM fms_alerts a
+			LEFT JOIN fms_device_reports r ON r.id = a.report_id
+			WHERE `+alertInProject+` AND a.resolved_at IS NULL
+			ORDER BY a.escalation_level DESC, a.ship_name ASC
+		`, project+" %")
+		if err != nil {
+			return "", err
+		}
+		var alerts []gin.H
+		for rows.Next() {
+			var ship, sensor string
+			var occurrences, level int
+			var openedAt time.Time
+			if err := rows.Scan(&ship, &sensor, &occurrences, &level, &openedAt); err != nil {
+				continue
+			}
+			name := names[sensor]
+			if name == "" {
+				name = sensor
+			}
+			alerts = append(alerts, gin.H{
+				"ShipName":        ship,
+				"SensorName":      name,
+				"Occurrences":     occurrences,
+				"EscalationLevel": level,
+				"OpenedAt":        openedAt.Format("02 Jan 2006"),
+			})
+		}
+		rows.Close()
+
+		subject := fmt.Sprintf("[%s] Ringkasan mingguan %s", project, time.Now().Format("02 Jan 2006"))
+		err = mailer.Enqueue(to, subject, "email_weekly_digest.html", gin.H{
+			"Project":       project,
+			"Since":         since.Format("02 Jan 2006"),
+			"ReportCount":   reportCount,
+			"ResolvedCount": resolvedCount,
+			"OpenAlerts":    alerts,
+			"AppURL":        os.Getenv("APP_URL"),
+		})
+		if err != nil {
+			return fmt.Sprintf("%d digest(s) queued", queued), err
+		}
+		queued++
+	}
+	return fmt.Sprintf("%d digest(s) queued", queued), nil
+}
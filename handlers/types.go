@@ -10,6 +10,12 @@ type SensorConfig struct {
 	DisplayOrder int
 }
 
+// Report sources, recorded for the latest write of a report
+const (
+	ReportSourceManual = "manual"
+	ReportSourceDevice = "device"
+)
+
 // DeviceReport represents a single ship's device status report
 type DeviceReport struct {
 	ID         int
@@ -29,6 +35,9 @@ type DeviceReport struct {
 	FlowmeterOutput bool
 	FlowmeterBunker bool
 
+	// Source is ReportSourceManual or ReportSourceDevice
+	Source string
+
 	OnlineTotal    int
 	OfflineTotal   int
 	OnlinePercent  float64
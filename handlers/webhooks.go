@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/webhooks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SettingsWebhooksPage renders webhook subscriptions and the delivery log
+func SettingsWebhooksPage(c *gin.Context) {
+	type WebhookRow struct {
+		ID        int
+		URL       string
+		HasSecret bool
+		Events    string
+		IsActive  bool
+	}
+	type DeliveryRow struct {
+		ID           int
+		URL          string
+		Event        string
+		Status       string
+		Attempts     int
+		ResponseCode int
+		Error        string
+		CreatedAt    time.Time
+	}
+
+	var hooks []WebhookRow
+	rows, err := db.DB.Query(`
+		SELECT id, url, COALESCE(secret, '') <> '', events, is_active
+		FROM fms_webhooks
+		ORDER BY id ASC
+	`)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var w WebhookRow
+			if err := rows.Scan(&w.ID, &w.URL, &w.HasSecret, &w.Events, &w.IsActive); err == nil {
+				hooks = append(hooks, w)
+			}
+		}
+	}
+
+	var deliveries []DeliveryRow
+	dRows, err := db.DB.Query(`
+		SELECT d.id, w.url, d.event, d.status, d.attempts,
+		       COALESCE(d.response_code, 0), COALESCE(d.error, ''), d.created_at
+		FROM fms_webhook_deliveries d
+		JOIN fms_webhooks w ON w.id = d.webhook_id
+		ORDER BY d.created_at DESC
+		LIMIT 50
+	`)
+	if err == nil {
+		defer dRows.Close()
+		for dRows.Next() {
+			var d DeliveryRow
+			if err := dRows.Scan(&d.ID, &d.URL, &d.Event, &d.Status, &d.Attempts, &d.ResponseCode, &d.Error, &d.CreatedAt); err == nil {
+				deliveries = append(deliveries, d)
+			}
+		}
+	}
+
+	c.HTML(http.StatusOK, "settings_webhooks.html", gin.H{
+		"Webhooks":      hooks,
+		"Deliveries":    deliveries,
+		"Events":        webhooks.Events,
+		"ActiveSidebar": "webhooks",
+		"ActiveTab":     "settings",
+		"Logo":          GetCompanyLogo(),
+	})
+}
+
+// CreateWebhook adds a webhook subscription
+func CreateWebhook(c *gin.Context) {
+	target := strings.TrimSpace(c.PostForm("url"))
+	secret := strings.TrimSpace(c.PostForm("secret"))
+	events := c.PostFormArray("events")
+
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.Redirect(http.StatusSeeOther, "/settings/webhooks?error=URL+webhook+tidak+valid")
+		return
+	}
+
+	filter := "*"
+	if len(events) > 0 {
+		filter = strings.Join(events, ",")
+	}
+
+	_, err = db.DB.Exec("INSERT INTO fms_webhooks (url, secret, events) VALUES ($1, $2, $3)", target, secret, filter)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/settings/webhooks?error=Gagal+menambah+webhook")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/webhooks?success=Webhook+ditambahkan!+✅")
+}
+
+// ToggleWebhook enables or disables a webhook subscription
+func ToggleWebhook(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	_, err := db.DB.Exec("UPDATE fms_webhooks SET is_active = NOT is_active WHERE id = $1", id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/webhooks?success=Status+webhook+diupdate!+🔄")
+}
+
+// DeleteWebhook removes a webhook subscription and its delivery log
+func DeleteWebhook(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	_, err := db.DB.Exec("DELETE FROM fms_webhooks WHERE id = $1", id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/settings/webhooks?success=Webhook+dihapus")
+}
@@ -0,0 +1,362 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"fms-app/db"
+)
+
+// Handler runs a job. Returning an error schedules a retry until MaxAttempts is reached,
+// unless it is wrapped with Permanent.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// Job is a row of fms_jobs
+type Job struct {
+	ID          int64
+	Kind        string
+	Payload     json.RawMessage
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	RunAt       time.Time
+	LastError   string
+	CreatedAt   time.Time
+	FinishedAt  *time.Time
+}
+
+// Job statuses
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Result is what a job hands back to the user: a message and optionally a file
+// offered for download on the job page
+type Result struct {
+	Message     string
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// ResultRetention is how long downloadable job results are kept
+const ResultRetention = 24 * time.Hour
+
+// DefaultMaxAttempts is used when a job is enqueued without an explicit limit
+const DefaultMaxAttempts = 5
+
+// staleAfter is how long a job may stay "running" before it is assumed orphaned
+// by a crashed worker and requeued
+const staleAfter = 30 * time.Minute
+
+// Execer is satisfied by *sql.DB and *sql.Tx
+type Execer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Handler)
+
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+)
+
+// Register binds a job kind to its handler. Call before Start.
+func Register(kind string, h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[kind] = h
+}
+
+// Kinds returns the registered job kinds
+func Kinds() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	kinds := make([]string, 0, len(registry))
+	for k := range registry {
+		kinds = append(kinds, k)
+	}
+	return kinds
+}
+
+// Enqueue schedules a job to run as soon as a worker is free
+func Enqueue(kind string, payload any) (int64, error) {
+	return EnqueueAt(db.DB, kind, payload, time.Now())
+}
+
+// EnqueueAt schedules a job for runAt. Pass a transaction as q to enqueue
+// atomically with another write.
+func EnqueueAt(q Execer, kind string, payload any, runAt time.Time) (int64, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("jobs: marshal %s: %w", kind, err)
+	}
+	var id int64
+	err = q.QueryRow(`
+		INSERT INTO fms_jobs (kind, payload, run_at, max_attempts)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, kind, body, runAt, DefaultMaxAttempts).Scan(&id)
+	return id, err
+}
+
+// EnqueueOnce is Enqueue unless an identical job is already waiting in the queue,
+// for work that only needs to run once however often it is requested
+func EnqueueOnce(kind string, payload any) (int64, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("jobs: marshal %s: %w", kind, err)
+	}
+	var id int64
+	err = db.DB.QueryRow(`
+		SELECT id FROM fms_jobs
+		WHERE kind = $1 AND payload = $2::jsonb AND status = 'queued'
+		ORDER BY id LIMIT 1
+	`, kind, body).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+	return EnqueueAt(db.DB, kind, payload, time.Now())
+}
+
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// Permanent wraps err so the job fails right away instead of being retried
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+type jobIDKey struct{}
+
+// SetResult stores the result of the running job; ctx must be the one passed to its Handler
+func SetResult(ctx context.Context, r Result) error {
+	id, ok := ctx.Value(jobIDKey{}).(int64)
+	if !ok {
+		return errors.New("jobs: SetResult called outside a job")
+	}
+	var data []byte
+	if r.Filename != "" {
+		data = r.Data
+	}
+	_, err := db.DB.ExecContext(ctx, `
+		UPDATE fms_jobs SET result = $2, result_name = NULLIF($3, ''), result_type = NULLIF($4, ''), result_data = $5
+		WHERE id = $1
+	`, id, r.Message, r.Filename, r.ContentType, data)
+	return err
+}
+
+// Retry puts a failed job back in the queue with a fresh attempt budget
+func Retry(id int64) error {
+	_, err := db.DB.Exec(`
+		UPDATE fms_jobs
+		SET status = 'queued', attempts = 0, run_at = CURRENT_TIMESTAMP, last_error = NULL, finished_at = NULL,
+			result = NULL, result_name = NULL, result_type = NULL, result_data = NULL
+		WHERE id = $1 AND status = 'failed'
+	`, id)
+	return err
+}
+
+// Start launches n workers polling the queue every interval
+func Start(n int, interval time.Duration) {
+	ctx, c := context.WithCancel(context.Background())
+	cancel = c
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go worker(ctx, interval)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			requeueStale()
+			pruneResults()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops claiming new jobs and waits for running ones to finish, or until
+// ctx expires. Jobs cut off at that point are requeued once they go stale.
+func Stop(ctx context.Context) error {
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func worker(ctx context.Context, interval time.Duration) {
+	defer wg.Done()
+	for {
+		// Drain everything that is due before sleeping
+		for ctx.Err() == nil {
+			job, err := claim()
+			if err == sql.ErrNoRows {
+				break
+			}
+			if err != nil {
+				log.Println("jobs: claim:", err)
+				break
+			}
+			run(job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// claim atomically picks the next due job, skipping rows locked by other workers
+func claim() (Job, error) {
+	var j Job
+	err := db.DB.QueryRow(`
+		UPDATE fms_jobs
+		SET status = 'running', attempts = attempts + 1, locked_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM fms_jobs
+			WHERE status = 'queued' AND run_at <= CURRENT_TIMESTAMP
+			ORDER BY run_at ASC, id ASC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, attempts, max_attempts
+	`).Scan(&j.ID, &j.Kind, &j.Payload, &j.Attempts, &j.MaxAttempts)
+	return j, err
+}
+
+func run(j Job) {
+	mu.RLock()
+	h, ok := registry[j.Kind]
+	mu.RUnlock()
+
+	start := time.Now()
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for %q", j.Kind)
+	} else {
+		err = safeCall(h, j)
+	}
+
+	if err == nil {
+		_, dbErr := db.DB.Exec(`
+			UPDATE fms_jobs SET status = 'done', finished_at = CURRENT_TIMESTAMP, last_error = NULL
+			WHERE id = $1
+		`, j.ID)
+		if dbErr != nil {
+			log.Println("jobs: mark done:", dbErr)
+		}
+		return
+	}
+
+	log.Printf("jobs: %s #%d attempt %d failed after %s: %v", j.Kind, j.ID, j.Attempts, time.Since(start).Round(time.Millisecond), err)
+
+	var permanent permanentError
+	if j.Attempts >= j.MaxAttempts || errors.As(err, &permanent) {
+		_, dbErr := db.DB.Exec(`
+			UPDATE fms_jobs SET status = 'failed', finished_at = CURRENT_TIMESTAMP, last_error = $2
+			WHERE id = $1
+		`, j.ID, err.Error())
+		if dbErr != nil {
+			log.Println("jobs: mark failed:", dbErr)
+		}
+		return
+	}
+
+	_, dbErr := db.DB.Exec(`
+		UPDATE fms_jobs
+		SET status = 'queued', last_error = $2, run_at = CURRENT_TIMESTAMP + make_interval(secs => $3)
+		WHERE id = $1
+	`, j.ID, err.Error(), backoff(j.Attempts).Seconds())
+	if dbErr != nil {
+		log.Println("jobs: reschedule:", dbErr)
+	}
+}
+
+// safeCall runs the handler with its own context and turns panics into errors
+func safeCall(h Handler, j Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), staleAfter)
+	defer cancel()
+	return h(context.WithValue(ctx, jobIDKey{}, j.ID), j.Payload)
+}
+
+// requeueStale puts orphaned jobs back in the queue, or fails them once they have used up
+// their attempts: a job that keeps crashing its worker must not run forever
+func requeueStale() {
+	_, err := db.DB.Exec(`
+		UPDATE fms_jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'queued' END,
+		    finished_at = CASE WHEN attempts >= max_attempts THEN CURRENT_TIMESTAMP END,
+		    last_error = CASE WHEN attempts >= max_attempts
+		                      THEN 'worker stopped while running the job (' || attempts || ' attempts)'
+		                      ELSE last_error END,
+		    run_at = CURRENT_TIMESTAMP
+		WHERE status = 'running' AND locked_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+	`, staleAfter.Seconds())
+	if err != nil {
+		log.Println("jobs: requeue stale:", err)
+	}
+}
+
+// pruneResults drops downloadable files of jobs finished more than ResultRetention ago
+func pruneResults() {
+	_, err := db.DB.Exec(`
+		UPDATE fms_jobs SET result_data = NULL
+		WHERE result_data IS NOT NULL AND finished_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+	`, ResultRetention.Seconds())
+	if err != nil {
+		log.Println("jobs: prune results:", err)
+	}
+}
+
+func backoff(attempt int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempt; i++ {
+		d *= 2
+	}
+	if d > time.Hour {
+		d = time.Hour
+	}
+	return d
+}
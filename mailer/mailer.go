@@ -0,0 +1,209 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+
+	"fms-app/db"
+	"fms-app/jobs"
+)
+
+// Config holds the SMTP settings read from the environment
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+var (
+	cfg       Config
+	templates *template.Template
+)
+
+// Init reads SMTP settings from env and keeps the parsed template set for rendering emails
+func Init(tmpl *template.Template) {
+	cfg = Config{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if cfg.Port == "" {
+		cfg.Port = "25"
+	}
+	if cfg.From == "" {
+		cfg.From = "fms-app@localhost"
+	}
+	templates = tmpl
+
+	if Enabled() {
+		log.Printf("mailer: using smtp %s:%s", cfg.Host, cfg.Port)
+	} else {
+		log.Println("mailer: SMTP_HOST is empty, emails will only be logged")
+	}
+}
+
+// Enabled reports whether an SMTP host is configured
+func Enabled() bool {
+	return cfg.Host != ""
+}
+
+// JobKind is the background job kind that delivers queued emails
+const JobKind = "email.send"
+
+// message is the job payload of a queued email
+type message struct {
+	To       []string       `json:"to"`
+	Subject  string         `json:"subject"`
+	Template string         `json:"template"`
+	Data     map[string]any `json:"data"`
+}
+
+// Enqueue queues an email to be rendered and sent by a job worker.
+// Data must survive a JSON round trip (maps, slices, strings and numbers).
+func Enqueue(to []string, subject, templateName string, data map[string]any) error {
+	if len(to) == 0 {
+		return nil
+	}
+	_, err := jobs.Enqueue(JobKind, message{To: to, Subject: subject, Template: templateName, Data: data})
+	return err
+}
+
+// SendJob is the jobs.Handler for JobKind
+func SendJob(ctx context.Context, payload json.RawMessage) error {
+	var m message
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return err
+	}
+	return Send(m.To, m.Subject, m.Template, m.Data)
+}
+
+// Send renders the named template with data and sends it as an HTML email.
+// Every attempt is written to fms_email_log so failures can be traced from settings.
+func Send(to []string, subject, templateName string, data any) error {
+	if len(to) == 0 {
+		return nil
+	}
+
+	body, err := render(templateName, data)
+	if err != nil {
+		logSend(to, subject, "failed", err)
+		return err
+	}
+
+	if !Enabled() {
+		logSend(to, subject, "skipped", fmt.Errorf("SMTP_HOST not configured"))
+		return nil
+	}
+
+	addrs, err := parseRecipients(to)
+	if err != nil {
+		logSend(to, subject, "failed", err)
+		return jobs.Permanent(err)
+	}
+	msg := buildMessage(addrs, subject, body)
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	err = smtp.SendMail(cfg.Host+":"+cfg.Port, auth, cfg.From, addrs, msg)
+	if err != nil {
+		logSend(to, subject, "failed", err)
+		return err
+	}
+
+	logSend(to, subject, "sent", nil)
+	return nil
+}
+
+func render(templateName string, data any) (string, error) {
+	if templates == nil {
+		return "", fmt.Errorf("mailer: templates not initialised")
+	}
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, templateName, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// encodeSubject makes a subject safe for the header: subjects carry ship names typed by
+// users, so line breaks are dropped (no header injection) and non-ASCII is Q-encoded
+func encodeSubject(subject string) string {
+	subject = strings.Join(strings.FieldsFunc(subject, func(r rune) bool { return r == '\r' || r == '\n' }), " ")
+	return mime.QEncoding.Encode("utf-8", subject)
+}
+
+// NormalizeAddress parses a recipient typed by a user and returns the bare address.
+// Anything net/mail rejects, including line breaks, is an error.
+func NormalizeAddress(s string) (string, error) {
+	a, err := mail.ParseAddress(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("alamat email tidak valid: %q", s)
+	}
+	return a.Address, nil
+}
+
+// parseRecipients normalizes to, dropping (and logging) addresses stored before they
+// were validated
+func parseRecipients(to []string) ([]string, error) {
+	var addrs []string
+	for _, s := range to {
+		a, err := NormalizeAddress(s)
+		if err != nil {
+			log.Println("mailer: skipping recipient:", err)
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("mailer: no valid recipient in %q", to)
+	}
+	return addrs, nil
+}
+
+func buildMessage(to []string, subject, body string) []byte {
+	header := make([]string, len(to))
+	for i, a := range to {
+		header[i] = (&mail.Address{Address: a}).String()
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + cfg.From + "\r\n")
+	b.WriteString("To: " + strings.Join(header, ", ") + "\r\n")
+	b.WriteString("Subject: " + encodeSubject(subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/html; charset=\"UTF-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	return []byte(b.String())
+}
+
+func logSend(to []string, subject, status string, sendErr error) {
+	var errText string
+	if sendErr != nil {
+		errText = sendErr.Error()
+		log.Printf("mailer: %s %q: %v", status, subject, sendErr)
+	}
+	_, err := db.DB.Exec(`
+		INSERT INTO fms_email_log (recipients, subject, status, error)
+		VALUES ($1, $2, $3, $4)
+	`, strings.Join(to, ", "), subject, status, errText)
+	if err != nil {
+		log.Println("mailer: failed to write email log:", err)
+	}
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"mime"
+	"strings"
+	"testing"
+)
+
+func TestBuildMessageSubject(t *testing.T) {
+	msg := string(buildMessage([]string{"ops@example.com"}, "Sensor offline: KM SINAR\r\nBcc: x@evil.test", "<p>hi</p>"))
+	if strings.Contains(msg, "\r\nBcc:") {
+		t.Fatalf("subject injected a header:\n%s", msg)
+	}
+	if !strings.Contains(msg, "Subject: Sensor offline: KM SINAR Bcc: x@evil.test\r\n") {
+		t.Errorf("unexpected subject line:\n%s", msg)
+	}
+
+	msg = string(buildMessage([]string{"ops@example.com"}, "Laporan KM Ñusa – Januari", ""))
+	var subject string
+	for _, line := range strings.Split(msg, "\r\n") {
+		if strings.HasPrefix(line, "Subject: ") {
+			subject = strings.TrimPrefix(line, "Subject: ")
+		}
+	}
+	if !strings.HasPrefix(subject, "=?utf-8?q?") {
+		t.Errorf("non-ASCII subject not encoded: %q", subject)
+	}
+	if got, err := new(mime.WordDecoder).DecodeHeader(subject); err != nil || got != "Laporan KM Ñusa – Januari" {
+		t.Errorf("decoded subject %q, %v", got, err)
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	for in, want := range map[string]string{
+		" ops@example.com ":          "ops@example.com",
+		"Ops Team <ops@example.com>": "ops@example.com",
+	} {
+		if got, err := NormalizeAddress(in); err != nil || got != want {
+			t.Errorf("%q: got %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "ops", "ops@example.com\r\nBcc: x@evil.test", "a@b.c, d@e.f"} {
+		if got, err := NormalizeAddress(in); err == nil {
+			t.Errorf("%q accepted as %q", in, got)
+		}
+	}
+
+	msg := string(buildMessage([]string{"ops@example.com", "tim.ops@example.com"}, "s", ""))
+	if !strings.Contains(msg, "To: <ops@example.com>, <tim.ops@example.com>\r\n") {
+		t.Errorf("unexpected To header:\n%s", msg)
+	}
+}
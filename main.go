@@ -1,13 +1,28 @@
 package main
 
 import (
+	"context"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"fms-app/backup"
 	"fms-app/db"
+	"fms-app/events"
+	"fms-app/gatewaylog"
 	"fms-app/handlers"
+	"fms-app/jobs"
+	"fms-app/mailer"
+	"fms-app/outbox"
+	"fms-app/readings"
+	"fms-app/scheduler"
+	"fms-app/telemetry"
+	"fms-app/webhooks"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -25,6 +40,13 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Maintenance subcommands (backup, restore, config) run instead of the server
+	if len(os.Args) > 1 {
+		code := runCommand(os.Args[1:])
+		db.Close()
+		os.Exit(code)
+	}
+
 	r := gin.New()
 	r.Use(gin.Logger(), gin.Recovery())
 
@@ -36,6 +58,51 @@ func main() {
 	template.Must(tmpl.ParseGlob("templates/partials/*.html"))
 	r.SetHTMLTemplate(tmpl)
 
+	// Email notifications render from the same template set
+	mailer.Init(tmpl)
+
+	// Domain event subscribers
+	events.Subscribe(handlers.InvalidateLogoCache)
+	events.Subscribe(events.Async(handlers.NotifyReportCreated))
+	events.Subscribe(events.Async(handlers.NotifyReportUpdated))
+	events.Subscribe(handlers.RecomputeSummaryOnCreate)
+	events.Subscribe(handlers.RecomputeSummaryOnUpdate)
+	events.SubscribeAll(handlers.RecordAudit)
+
+	// Background jobs
+	jobs.Register(mailer.JobKind, mailer.SendJob)
+	jobs.Register(handlers.ExportXLSXJobKind, handlers.ExportXLSXJob)
+	jobs.Register(handlers.ExportPDFJobKind, handlers.ExportPDFJob)
+	jobs.Register(handlers.ImportReportsJobKind, handlers.ImportReportsJob)
+	jobs.Register(handlers.SummaryJobKind, handlers.SummaryJob)
+	jobs.Start(jobWorkers(), time.Second)
+
+	// Parsers for gateway log files uploaded from USB, one per vendor format
+	gatewaylog.Register("fms-csv", "FMS Gateway CSV (timestamp,sensor,value,status)", gatewaylog.ParseLong)
+	gatewaylog.Register("wide-csv", "CSV per kolom sensor (timestamp,gps,rpm_me_port,...)", gatewaylog.ParseWide)
+
+	// Outbox dispatcher delivers committed report/alert events to webhooks
+	outboxStop := make(chan struct{})
+	outbox.AddSink(webhooks.OutboxSink)
+	webhooks.ResumePending()
+	go outbox.Run(2*time.Second, outboxStop)
+
+	// Optional MQTT subscriber feeding gateway messages into device ingestion
+	telemetry.Start(telemetry.LoadConfig(), handlers.IngestTelemetry)
+
+	// Scheduled tasks, cron expressions can be changed from /settings/schedules
+	scheduler.Register("period_rollover", "5 0 1 * *", "Tutup periode bulan lalu dan simpan ringkasannya", handlers.RolloverPeriodTask)
+	scheduler.Register("missing_report_reminders", "0 8 * * *", "Email pengingat kapal yang belum lapor", handlers.MissingReportsTask)
+	scheduler.Register("data_quality_check", "0 2 * * *", "Cek konsistensi data laporan", handlers.DataQualityTask)
+	scheduler.Register("weekly_digest", "0 7 * * 1", "Email ringkasan mingguan per project", handlers.WeeklyDigestTask)
+	scheduler.Register("derive_device_status", "15 * * * *", "Turunkan status sensor dari data gateway menjadi draft laporan", handlers.DeriveDraftsTask)
+	scheduler.Register("evaluate_rules", "30 * * * *", "Evaluasi aturan status dan buka/selesaikan alert", handlers.EvaluateRulesTask)
+	scheduler.Register("readings_maintenance", "20 0 * * *", "Siapkan partisi data sensor dan hapus data lama sesuai retensi", readings.Task)
+	scheduler.Register("backup", "0 3 * * *", "Backup database ke BACKUP_DIR", backup.Task)
+	if err := scheduler.Start(30 * time.Second); err != nil {
+		log.Fatal("scheduler: ", err)
+	}
+
 	// Routes
 	r.GET("/", handlers.Dashboard)
 	r.GET("/input", handlers.Index)
@@ -44,6 +111,17 @@ func main() {
 	// Dashboard (kept for backward compatibility if needed, but root is now preferred)
 	r.GET("/dashboard", handlers.Dashboard)
 	r.GET("/report", handlers.MonthlyReport)
+	r.POST("/report/export.xlsx", handlers.ExportMonthlyReportXLSX)
+	r.POST("/report/export.pdf", handlers.ExportMonthlyReportPDF)
+	r.GET("/reports/export.csv", handlers.ExportReportsCSV)
+	r.GET("/rekap/export.csv", handlers.ExportRekapCSV)
+	r.GET("/dashboard/trouble.csv", handlers.ExportTroubleCSV)
+	r.GET("/alerts/export.csv", handlers.ExportAlertsCSV)
+
+	// Server-rendered charts
+	r.GET("/charts/trend.svg", handlers.ChartTrendSVG)
+	r.GET("/charts/sensors.svg", handlers.ChartSensorsSVG)
+	r.GET("/charts/heatmap.svg", handlers.ChartHeatmapSVG)
 
 	// Device Reports API
 	r.GET("/reports", handlers.ListReports)
@@ -51,6 +129,17 @@ func main() {
 	r.DELETE("/reports/:id", handlers.DeleteReport)
 	r.PUT("/reports/:id", handlers.UpdateReport)
 
+	// Draft reports derived from gateway data
+	r.GET("/drafts", handlers.DraftsPage)
+	r.POST("/drafts/derive", handlers.DeriveDrafts)
+	r.POST("/drafts/confirm-all", handlers.ConfirmAllDrafts)
+	r.POST("/drafts/:id/confirm", handlers.ConfirmDraft)
+	r.POST("/drafts/:id/dismiss", handlers.DismissDraft)
+
+	// Ship detail with sensor value charts
+	r.GET("/ships/:id", handlers.ShipDetailPage)
+	r.GET("/ships/:id/readings.svg", handlers.ShipReadingsSVG)
+
 	// Rekap
 	r.GET("/rekap", handlers.Rekap)
 
@@ -59,6 +148,9 @@ func main() {
 	r.GET("/api/notification-count", handlers.GetNotificationCount)
 	r.POST("/api/resolve-alert/:id", handlers.ResolveAlert)
 
+	// Versioned JSON REST API (routes and /api/openapi.json come from one table)
+	handlers.RegisterAPI(r)
+
 	// Settings
 	r.GET("/settings", handlers.SettingsPage)
 	r.GET("/settings/projects", handlers.SettingsProjectsPage)
@@ -66,17 +158,58 @@ func main() {
 	r.POST("/settings/logo", handlers.UpdateLogo)
 	r.POST("/settings/sensors", handlers.CreateSensor)
 	r.POST("/settings/sensors/:id/toggle", handlers.ToggleSensor)
+	r.POST("/settings/sensors/:id/offline-rule", handlers.UpdateSensorOfflineRule)
 	r.POST("/settings/projects", handlers.CreateProject)
+	r.GET("/settings/notifications", handlers.SettingsNotificationsPage)
+	r.POST("/settings/notifications", handlers.CreateRecipient)
+	r.POST("/settings/notifications/:id/delete", handlers.DeleteRecipient)
+	r.GET("/settings/jobs", handlers.SettingsJobsPage)
+	r.POST("/settings/jobs/:id/retry", handlers.RetryJob)
+	r.GET("/jobs/:id", handlers.JobPage)
+	r.GET("/jobs/:id/download", handlers.DownloadJobResult)
+	r.GET("/settings/schedules", handlers.SettingsSchedulesPage)
+	r.POST("/settings/schedules/:name", handlers.UpdateSchedule)
+	r.POST("/settings/schedules/:name/run", handlers.RunSchedule)
+	r.GET("/settings/import", handlers.SettingsImportPage)
+	r.POST("/settings/import/reports", handlers.UploadReportImport)
+	r.GET("/settings/import/reports/:token", handlers.PreviewReportImport)
+	r.POST("/settings/import/reports/:token/commit", handlers.CommitReportImport)
+	r.POST("/settings/import/ships", handlers.UploadShipImport)
+	r.POST("/settings/import/logs", handlers.UploadGatewayLog)
+	r.GET("/settings/import/ships/:token", handlers.PreviewShipImport)
+	r.POST("/settings/import/ships/:token/commit", handlers.CommitShipImport)
+	r.GET("/settings/rules", handlers.SettingsRulesPage)
+	r.POST("/settings/rules", handlers.CreateRule)
+	r.POST("/settings/rules/evaluate", handlers.EvaluateRules)
+	r.POST("/settings/rules/:id", handlers.UpdateRule)
+	r.POST("/settings/rules/:id/toggle", handlers.ToggleRule)
+	r.POST("/settings/rules/:id/delete", handlers.DeleteRule)
+	r.GET("/settings/webhooks", handlers.SettingsWebhooksPage)
+	r.POST("/settings/webhooks", handlers.CreateWebhook)
+	r.POST("/settings/webhooks/:id/toggle", handlers.ToggleWebhook)
+	r.POST("/settings/webhooks/:id/delete", handlers.DeleteWebhook)
+	r.GET("/settings/devices", handlers.SettingsDevicesPage)
+	r.POST("/settings/devices", handlers.CreateDeviceToken)
+	r.POST("/settings/devices/:id/toggle", handlers.ToggleDeviceToken)
+	r.POST("/settings/devices/:id/delete", handlers.DeleteDeviceToken)
 
 	// Ship Management
 	r.GET("/settings/ships", handlers.SettingsShipsPage)
+	r.GET("/settings/ships/export.csv", handlers.ExportShipsCSV)
+	r.GET("/settings/config/export", handlers.ExportConfig)
+	r.GET("/settings/ships/duplicates", handlers.SettingsShipDuplicatesPage)
+	r.POST("/settings/ships/merge", handlers.MergeShips)
 	r.POST("/settings/ships", handlers.CreateShip)
 	r.GET("/settings/ships/:id", handlers.SettingsShipConfigPage)
 	r.POST("/settings/ships/:id/toggle", handlers.ToggleShipSensor)
+	r.POST("/settings/ships/:id/aliases", handlers.CreateShipAlias)
+	r.POST("/settings/ships/:id/aliases/:alias_id/delete", handlers.DeleteShipAlias)
 
 	// Batch Input
 	r.GET("/batch-input", handlers.BatchInputPage)
 	r.POST("/batch-input", handlers.BatchSubmit)
+	r.GET("/batch-input/template.xlsx", handlers.DownloadBatchTemplate)
+	r.POST("/batch-input/upload", handlers.UploadBatchTemplate)
 
 	// HTMX Partial
 	r.GET("/api/form-sensors", handlers.FormSensors)
@@ -86,8 +219,42 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("listening on :%s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("listening on :%s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("shutting down, draining requests and jobs...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("http shutdown:", err)
+	}
+	close(outboxStop)
+	telemetry.Stop()
+	if err := scheduler.Stop(shutdownCtx); err != nil {
+		log.Println("scheduler drain:", err)
+	}
+	if err := jobs.Stop(shutdownCtx); err != nil {
+		log.Println("jobs drain:", err)
+	}
+}
+
+// jobWorkers reads JOB_WORKERS (default 2)
+func jobWorkers() int {
+	n, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || n < 1 {
+		return 2
 	}
+	return n
 }
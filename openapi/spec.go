@@ -0,0 +1,314 @@
+// Package openapi builds an OpenAPI 3 document from Go request/response types and
+// validates requests (and, in tests, responses) against it.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schema is the subset of the OpenAPI schema object the generator produces
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	Nullable    bool               `json:"nullable,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	// AdditionalProperties is false (struct types reject unknown fields) or a schema (maps)
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+}
+
+// Param is a path or query parameter
+type Param struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Operation declares one endpoint. Body and the Responses values are zero values of the
+// Go types the handler decodes and writes; a nil response means an empty body.
+type Operation struct {
+	Method    string
+	Path      string // gin syntax, e.g. /reports/:id
+	ID        string
+	Summary   string
+	Tag       string
+	Query     []Param
+	Body      any
+	Responses map[int]any
+}
+
+// Spec is a generated OpenAPI document
+type Spec struct {
+	prefix     string
+	doc        document
+	ops        map[string]Operation // "METHOD /gin/path" -> operation
+	components map[string]*Schema
+	patterns   sync.Map // compiled Schema.Pattern values, shared by concurrent requests
+}
+
+type document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       info                            `json:"info"`
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Param             `json:"parameters,omitempty"`
+	RequestBody *requestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]response `json:"responses"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// New starts a document whose operation paths are relative to prefix (e.g. /api/v1)
+func New(title, version, prefix string) *Spec {
+	s := &Spec{
+		prefix:     prefix,
+		ops:        map[string]Operation{},
+		components: map[string]*Schema{},
+	}
+	s.doc.OpenAPI = "3.0.3"
+	s.doc.Info = info{Title: title, Version: version}
+	s.doc.Paths = map[string]map[string]operation{}
+	s.doc.Components.Schemas = s.components
+	return s
+}
+
+// Add declares an operation; adding the same method and path twice panics
+func (s *Spec) Add(op Operation) {
+	key := op.Method + " " + s.prefix + op.Path
+	if _, dup := s.ops[key]; dup {
+		panic("openapi: duplicate operation " + key)
+	}
+	s.ops[key] = op
+
+	o := operation{OperationID: op.ID, Summary: op.Summary, Responses: map[string]response{}}
+	if op.Tag != "" {
+		o.Tags = []string{op.Tag}
+	}
+
+	o.Parameters = append(o.Parameters, pathParams(op.Path)...)
+	for _, p := range op.Query {
+		p.In = "query"
+		o.Parameters = append(o.Parameters, p)
+	}
+
+	if op.Body != nil {
+		o.RequestBody = &requestBody{Required: true, Content: map[string]mediaType{
+			"application/json": {Schema: s.schemaFor(reflect.TypeOf(op.Body))},
+		}}
+	}
+	for status, body := range op.Responses {
+		r := response{Description: http.StatusText(status)}
+		if body != nil {
+			r.Content = map[string]mediaType{"application/json": {Schema: s.schemaFor(reflect.TypeOf(body))}}
+		}
+		o.Responses[strconv.Itoa(status)] = r
+	}
+
+	path := specPath(s.prefix, op.Path)
+	if s.doc.Paths[path] == nil {
+		s.doc.Paths[path] = map[string]operation{}
+	}
+	s.doc.Paths[path][strings.ToLower(op.Method)] = o
+}
+
+// pathParams declares the parameters of a gin path: ids (id, *_id) are positive integers,
+// everything else is a string
+func pathParams(path string) []Param {
+	var params []Param
+	for _, seg := range strings.Split(path, "/") {
+		if !strings.HasPrefix(seg, ":") {
+			continue
+		}
+		name := seg[1:]
+		schema := &Schema{Type: "string"}
+		if name == "id" || strings.HasSuffix(name, "_id") {
+			schema = &Schema{Type: "integer", Minimum: float(1)}
+		}
+		params = append(params, Param{Name: name, In: "path", Required: true, Schema: schema})
+	}
+	return params
+}
+
+// Operations returns the declared operations sorted by path and method
+func (s *Spec) Operations() []Operation {
+	keys := make([]string, 0, len(s.ops))
+	for k := range s.ops {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ops := make([]Operation, 0, len(keys))
+	for _, k := range keys {
+		ops = append(ops, s.ops[k])
+	}
+	return ops
+}
+
+// Lookup finds the operation for a method and a full gin route path
+func (s *Spec) Lookup(method, fullPath string) (Operation, bool) {
+	op, ok := s.ops[method+" "+fullPath]
+	return op, ok
+}
+
+// MarshalJSON renders the OpenAPI document
+func (s *Spec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.doc)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor maps a Go type to a schema. Named structs become components referenced by
+// $ref. Struct fields are required unless they are pointers or tagged omitempty; the
+// `openapi` tag adds constraints, e.g. `openapi:"format=date,enum=online|offline"`.
+func (s *Spec) schemaFor(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := *s.schemaFor(t.Elem())
+		schema.Nullable = true
+		return &schema
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		if _, ok := s.components[t.Name()]; !ok {
+			s.components[t.Name()] = &Schema{} // placeholder for recursive types
+			s.components[t.Name()] = s.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+func (s *Spec) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		prop := s.schemaFor(f.Type)
+		if tag := f.Tag.Get("openapi"); tag != "" {
+			copied := *prop
+			prop = &copied
+			for _, kv := range strings.Split(tag, ",") {
+				k, v, _ := strings.Cut(kv, "=")
+				switch k {
+				case "format":
+					prop.Format = v
+				case "enum":
+					prop.Enum = strings.Split(v, "|")
+				case "pattern":
+					prop.Pattern = v
+				case "min":
+					n, _ := strconv.ParseFloat(v, 64)
+					prop.Minimum = &n
+				case "max":
+					n, _ := strconv.ParseFloat(v, 64)
+					prop.Maximum = &n
+				default:
+					panic(fmt.Sprintf("openapi: unknown tag %q on %s.%s", k, t.Name(), f.Name))
+				}
+			}
+		}
+		schema.Properties[name] = prop
+		if f.Type.Kind() != reflect.Pointer && !strings.Contains(opts, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+func float(v float64) *float64 { return &v }
+
+// Query declares an optional query parameter
+func Query(name, description string, schema *Schema) Param {
+	return Param{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// String is a plain string schema, optionally restricted to a regular expression
+func String(pattern string) *Schema {
+	return &Schema{Type: "string", Pattern: pattern}
+}
+
+// Enum is a string schema limited to values
+func Enum(values ...string) *Schema {
+	return &Schema{Type: "string", Enum: values}
+}
+
+// Integer is an integer schema within [min, max]; max 0 means unbounded
+func Integer(min, max float64) *Schema {
+	schema := &Schema{Type: "integer", Minimum: float(min)}
+	if max > 0 {
+		schema.Maximum = float(max)
+	}
+	return schema
+}
@@ -0,0 +1,305 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBody bounds the request bodies the middleware reads for validation
+const maxBody = 1 << 20
+
+// ValidationError lists the problems found in a request or response, keyed by the JSON
+// path of the offending value ("body" for the document itself)
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+": "+e.Fields[k])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Middleware validates the path parameters, query parameters and JSON body of every request
+// routed to a declared operation and calls reject instead of the handler when they do not
+// match. The body is left readable for the handler.
+func (s *Spec) Middleware(reject func(c *gin.Context, err *ValidationError)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op, ok := s.Lookup(c.Request.Method, c.FullPath())
+		if !ok {
+			c.Next()
+			return
+		}
+		problems := map[string]string{}
+
+		for _, p := range pathParams(op.Path) {
+			s.check(p.Schema, queryValue(p.Schema, c.Param(p.Name)), p.Name, problems)
+		}
+		for _, p := range op.Query {
+			v, present := c.GetQuery(p.Name)
+			if !present || v == "" {
+				if p.Required {
+					problems[p.Name] = "is required"
+				}
+				continue
+			}
+			s.check(p.Schema, queryValue(p.Schema, v), p.Name, problems)
+		}
+
+		if op.Body != nil {
+			data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBody+1))
+			if err != nil {
+				problems["body"] = err.Error()
+			} else if len(data) > maxBody {
+				problems["body"] = "is too large"
+			} else {
+				c.Request.Body = io.NopCloser(bytes.NewReader(data))
+				schema := s.doc.Paths[specPath(s.prefix, op.Path)][strings.ToLower(op.Method)].RequestBody.Content["application/json"].Schema
+				if err := s.validateJSON(schema, data); err != nil {
+					for k, v := range err.Fields {
+						problems[k] = v
+					}
+				}
+			}
+		}
+
+		if len(problems) > 0 {
+			reject(c, &ValidationError{Fields: problems})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ValidateResponse checks that status is declared for the operation and that body matches
+// its schema. It is meant for contract tests.
+func (s *Spec) ValidateResponse(method, fullPath string, status int, body []byte) error {
+	op, ok := s.Lookup(method, fullPath)
+	if !ok {
+		return fmt.Errorf("%s %s is not in the spec", method, fullPath)
+	}
+	r, ok := s.doc.Paths[specPath(s.prefix, op.Path)][strings.ToLower(method)].Responses[strconv.Itoa(status)]
+	if !ok {
+		return fmt.Errorf("%s %s: status %d is not declared", method, fullPath, status)
+	}
+	media, hasBody := r.Content["application/json"]
+	if !hasBody {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return fmt.Errorf("%s %s: status %d is declared without a body", method, fullPath, status)
+		}
+		return nil
+	}
+	if err := s.validateJSON(media.Schema, body); err != nil {
+		return fmt.Errorf("%s %s: status %d: %w", method, fullPath, status, err)
+	}
+	return nil
+}
+
+func specPath(prefix, ginPath string) string {
+	segments := strings.Split(ginPath, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") {
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return prefix + strings.Join(segments, "/")
+}
+
+func (s *Spec) validateJSON(schema *Schema, data []byte) *ValidationError {
+	problems := map[string]string{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		problems["body"] = "is not valid JSON: " + err.Error()
+	} else if dec.More() {
+		problems["body"] = "must hold a single JSON value"
+	} else {
+		s.check(schema, v, "", problems)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Fields: problems}
+	}
+	return nil
+}
+
+// queryValue converts a path or query string to the JSON value its schema expects so it can
+// go through the same checks as a body
+func queryValue(schema *Schema, v string) any {
+	switch schema.Type {
+	case "integer", "number":
+		return json.Number(v)
+	case "boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+func (s *Spec) resolve(schema *Schema) *Schema {
+	for schema.Ref != "" {
+		schema = s.components[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+func (s *Spec) regexp(pattern string) *regexp.Regexp {
+	if re, ok := s.patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, _ := s.patterns.LoadOrStore(pattern, regexp.MustCompile(pattern))
+	return re.(*regexp.Regexp)
+}
+
+func (s *Spec) check(schema *Schema, v any, path string, problems map[string]string) {
+	schema = s.resolve(schema)
+	fail := func(msg string) {
+		key := path
+		if key == "" {
+			key = "body"
+		}
+		problems[key] = msg
+	}
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+
+	if v == nil {
+		if !schema.Nullable && schema.Type != "" {
+			fail("must not be null")
+		}
+		return
+	}
+
+	switch schema.Type {
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, str) {
+			fail("must be one of " + strings.Join(schema.Enum, ", "))
+		}
+		if schema.Pattern != "" && !s.regexp(schema.Pattern).MatchString(str) {
+			fail("must match " + schema.Pattern)
+		}
+		switch schema.Format {
+		case "date":
+			if _, err := time.Parse("2006-01-02", str); err != nil {
+				fail("must be a date (YYYY-MM-DD)")
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				fail("must be an RFC 3339 date-time")
+			}
+		}
+
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		f, err := n.Float64()
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if schema.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				fail("must be an integer")
+				return
+			}
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			fail(fmt.Sprintf("must be at least %v", *schema.Minimum))
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			fail(fmt.Sprintf("must be at most %v", *schema.Maximum))
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("must be true or false")
+		}
+
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		for i, item := range items {
+			s.check(schema.Items, item, join(strconv.Itoa(i)), problems)
+		}
+
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				problems[join(name)] = "is required"
+			}
+		}
+		for name, value := range obj {
+			if prop, ok := schema.Properties[name]; ok {
+				s.check(prop, value, join(name), problems)
+				continue
+			}
+			switch extra := schema.AdditionalProperties.(type) {
+			case *Schema:
+				s.check(extra, value, join(name), problems)
+			case bool:
+				if !extra {
+					problems[join(name)] = "is not a known field"
+				}
+			}
+		}
+	}
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler serves the document as JSON
+func (s *Spec) Handler() gin.HandlerFunc {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+	}
+}
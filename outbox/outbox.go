@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"fms-app/db"
+)
+
+// Message is a committed outbox row handed to sinks
+type Message struct {
+	ID        int64
+	DedupKey  string
+	Event     string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// Sink publishes a message downstream. Returning an error leaves the message
+// in the outbox to be retried, so sinks must tolerate seeing a message twice
+// (use Message.DedupKey).
+type Sink func(m Message) error
+
+// Execer is satisfied by *sql.Tx (and *sql.DB)
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+const (
+	batchSize   = 50
+	maxBackoff  = time.Hour
+	baseBackoff = 5 * time.Second
+)
+
+var sinks []Sink
+
+// AddSink registers a downstream sink. Call before Run.
+func AddSink(s Sink) {
+	sinks = append(sinks, s)
+}
+
+// Write stores an event in the outbox. Pass the transaction of the business
+// write so the event is committed (or rolled back) together with it.
+// Writing the same dedupKey twice is a no-op.
+func Write(q Execer, event, dedupKey string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("outbox: marshal %s: %w", event, err)
+	}
+	_, err = q.Exec(`
+		INSERT INTO fms_outbox (dedup_key, event, payload)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (dedup_key) DO NOTHING
+	`, dedupKey, event, body)
+	return err
+}
+
+// Run polls the outbox and publishes pending rows to every sink until stop is closed
+func Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := dispatchBatch()
+			if err != nil {
+				log.Println("outbox: dispatch:", err)
+				break
+			}
+			if n < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchBatch locks a batch of due rows (skipping rows held by other
+// instances), runs the sinks and marks each row dispatched or schedules a retry
+func dispatchBatch() (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, dedup_key, event, payload, created_at, attempts
+		FROM fms_outbox
+		WHERE dispatched_at IS NULL AND available_at <= CURRENT_TIMESTAMP
+		ORDER BY id ASC
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type pending struct {
+		Message
+		attempts int
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.ID, &p.DedupKey, &p.Event, &p.Payload, &p.CreatedAt, &p.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range batch {
+		var sinkErr error
+		for _, sink := range sinks {
+			if err := sink(p.Message); err != nil {
+				sinkErr = err
+				break
+			}
+		}
+
+		if sinkErr == nil {
+			_, err = tx.Exec(`
+				UPDATE fms_outbox
+				SET dispatched_at = CURRENT_TIMESTAMP, attempts = attempts + 1, last_error = NULL
+				WHERE id = $1
+			`, p.ID)
+		} else {
+			_, err = tx.Exec(`
+				UPDATE fms_outbox
+				SET attempts = attempts + 1, last_error = $2,
+				    available_at = CURRENT_TIMESTAMP + make_interval(secs => $3)
+				WHERE id = $1
+			`, p.ID, sinkErr.Error(), backoff(p.attempts+1).Seconds())
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(batch), tx.Commit()
+}
+
+func backoff(attempt int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,94 @@
+package readings
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Resolution of a series: raw values or hourly/daily rollups
+type Resolution string
+
+const (
+	Raw    Resolution = "raw"
+	Hourly Resolution = "hour"
+	Daily  Resolution = "day"
+)
+
+// MaxSpan is the longest time range served per resolution, so a series stays chartable
+var MaxSpan = map[Resolution]time.Duration{
+	Raw:    31 * 24 * time.Hour,
+	Hourly: 400 * 24 * time.Hour,
+	Daily:  0, // unbounded
+}
+
+// Point is one value of a series. Raw points have Min = Max = Avg and Count 1.
+type Point struct {
+	At    time.Time `json:"t"`
+	Avg   float64   `json:"avg"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Count int       `json:"count"`
+}
+
+// Querier is satisfied by *sql.DB and *sql.Tx
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Pick chooses the finest resolution that still holds [from, to) under the retention and
+// gives a readable number of points: raw up to two days, hourly up to two months, daily
+// beyond.
+func Pick(from, to, now time.Time) Resolution {
+	ret := LoadRetention()
+	span := to.Sub(from)
+	switch {
+	case span <= 48*time.Hour && !from.Before(cutoff(ret.Raw, now)):
+		return Raw
+	case span <= 62*24*time.Hour && !from.Before(cutoff(ret.Hourly, now)):
+		return Hourly
+	default:
+		return Daily
+	}
+}
+
+// Series returns the values of one ship sensor in [from, to) at resolution, oldest first
+func Series(ctx context.Context, q Querier, shipID int, sensor string, from, to time.Time, res Resolution) ([]Point, error) {
+	from, to = from.In(time.Local), to.In(time.Local)
+
+	var rows *sql.Rows
+	var err error
+	switch res {
+	case Raw:
+		rows, err = q.QueryContext(ctx, `
+			SELECT ts, value, value, value, 1
+			FROM fms_sensor_readings
+			WHERE ship_id = $1 AND sensor_code = $2 AND ts >= $3 AND ts < $4
+			ORDER BY ts ASC
+		`, shipID, sensor, from, to)
+	case Hourly, Daily:
+		rows, err = q.QueryContext(ctx, `
+			SELECT bucket, total / samples, min_value, max_value, samples
+			FROM fms_sensor_rollups
+			WHERE resolution = $1 AND ship_id = $2 AND sensor_code = $3 AND bucket >= $4 AND bucket < $5
+			ORDER BY bucket ASC
+		`, res, shipID, sensor, from, to)
+	default:
+		return nil, fmt.Errorf("readings: unknown resolution %q", res)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []Point{}
+	for rows.Next() {
+		var p Point
+		if err := rows.Scan(&p.At, &p.Avg, &p.Min, &p.Max, &p.Count); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
@@ -0,0 +1,223 @@
+// Package readings stores the raw values gateways report (RPM, flow rates, positions) in a
+// month-partitioned time-series table, keeps hourly and daily rollups next to it and
+// answers downsampled series for charts.
+package readings
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"fms-app/db"
+)
+
+// Reading is one value of a ship sensor at a point in time
+type Reading struct {
+	ShipID int
+	Sensor string
+	At     time.Time
+	Value  float64
+}
+
+// Execer is satisfied by *sql.Tx (and *sql.DB)
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Retention is how long each resolution is kept; 0 keeps it forever
+type Retention struct {
+	Raw    time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+// LoadRetention reads READINGS_RETENTION_{RAW,HOURLY,DAILY}_DAYS, defaulting to 90 days of
+// raw values, two years of hourly rollups and daily rollups forever
+func LoadRetention() Retention {
+	days := func(key string, def int) time.Duration {
+		n, err := strconv.Atoi(os.Getenv(key))
+		if err != nil || n < 0 {
+			n = def
+		}
+		return time.Duration(n) * 24 * time.Hour
+	}
+	return Retention{
+		Raw:    days("READINGS_RETENTION_RAW_DAYS", 90),
+		Hourly: days("READINGS_RETENTION_HOURLY_DAYS", 730),
+		Daily:  days("READINGS_RETENTION_DAILY_DAYS", 0),
+	}
+}
+
+// cutoff is the oldest time kept for a retention of keep; zero when kept forever
+func cutoff(keep time.Duration, now time.Time) time.Time {
+	if keep == 0 {
+		return time.Time{}
+	}
+	return now.Add(-keep)
+}
+
+func monthOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func partitionName(month time.Time) string {
+	return fmt.Sprintf("fms_sensor_readings_%04d_%02d", month.Year(), int(month.Month()))
+}
+
+var (
+	partitionsMu sync.Mutex
+	partitions   = map[string]bool{} // created by this process
+)
+
+// EnsurePartitions creates the month partitions holding times. Times outside the raw
+// retention or more than a month ahead are ignored, as Write skips them. Partitions are
+// DDL and lock the parent table, so call this before opening the write transaction.
+func EnsurePartitions(ctx context.Context, times ...time.Time) error {
+	now := time.Now()
+	oldest := cutoff(LoadRetention().Raw, now)
+	latest := monthOf(now).AddDate(0, 2, 0)
+
+	partitionsMu.Lock()
+	defer partitionsMu.Unlock()
+
+	var months []time.Time
+	seen := map[string]bool{}
+	for _, t := range times {
+		t = t.In(time.Local)
+		if t.Before(oldest) || !t.Before(latest) {
+			continue
+		}
+		m := monthOf(t)
+		if name := partitionName(m); !partitions[name] && !seen[name] {
+			seen[name] = true
+			months = append(months, m)
+		}
+	}
+	if len(months) == 0 {
+		return nil
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Other app instances may be creating the same partition
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('fms_sensor_readings partitions'))"); err != nil {
+		return err
+	}
+	for _, m := range months {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s PARTITION OF fms_sensor_readings FOR VALUES FROM ('%s') TO ('%s')",
+			partitionName(m), m.Format("2006-01-02"), m.AddDate(0, 1, 0).Format("2006-01-02")))
+		if err != nil {
+			return fmt.Errorf("readings: partition %s: %w", partitionName(m), err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, m := range months {
+		partitions[partitionName(m)] = true
+	}
+	return nil
+}
+
+// Write stores rs in the caller's transaction and adds every new value to its hourly and
+// daily rollup. A value already stored for the same ship, sensor and time is skipped, so
+// gateways may resend samples; values outside the raw retention are skipped too. The
+// partitions must exist, see EnsurePartitions.
+func Write(q Execer, rs []Reading) (written int, err error) {
+	now := time.Now()
+	oldest := cutoff(LoadRetention().Raw, now)
+	latest := monthOf(now).AddDate(0, 2, 0)
+
+	for _, r := range rs {
+		at := r.At.In(time.Local)
+		if at.Before(oldest) || !at.Before(latest) {
+			continue
+		}
+		res, err := q.Exec(`
+			INSERT INTO fms_sensor_readings (ship_id, sensor_code, ts, value)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+		`, r.ShipID, r.Sensor, at, r.Value)
+		if err != nil {
+			return written, fmt.Errorf("readings: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			continue
+		}
+
+		hour := time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), 0, 0, 0, at.Location())
+		day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+		_, err = q.Exec(`
+			INSERT INTO fms_sensor_rollups (resolution, ship_id, sensor_code, bucket, samples, total, min_value, max_value)
+			VALUES ($1, $3, $4, $5, 1, $7, $7, $7), ($2, $3, $4, $6, 1, $7, $7, $7)
+			ON CONFLICT (resolution, ship_id, sensor_code, bucket) DO UPDATE SET
+				samples = fms_sensor_rollups.samples + 1,
+				total = fms_sensor_rollups.total + EXCLUDED.total,
+				min_value = LEAST(fms_sensor_rollups.min_value, EXCLUDED.min_value),
+				max_value = GREATEST(fms_sensor_rollups.max_value, EXCLUDED.max_value)
+		`, Hourly, Daily, r.ShipID, r.Sensor, hour, day, r.Value)
+		if err != nil {
+			return written, fmt.Errorf("readings: rollup: %w", err)
+		}
+		written++
+	}
+	return written, nil
+}
+
+// MoveShip re-points the values and rollups of ship from to ship to, in the caller's
+// transaction. A value at a time the target already has is dropped and taken out of the
+// source's rollup counts and totals (min and max stay as they were); the rollups are then
+// added to the target's buckets.
+func MoveShip(q Execer, from, to int) error {
+	_, err := q.Exec(`
+		WITH dup AS (
+			DELETE FROM fms_sensor_readings s
+			USING fms_sensor_readings t
+			WHERE s.ship_id = $1 AND t.ship_id = $2 AND t.sensor_code = s.sensor_code AND t.ts = s.ts
+			RETURNING s.sensor_code, s.ts, s.value
+		), buckets AS (
+			SELECT $3::varchar AS resolution, sensor_code, date_trunc('hour', ts) AS bucket, COUNT(*) AS n, SUM(value) AS total
+			FROM dup GROUP BY sensor_code, date_trunc('hour', ts)
+			UNION ALL
+			SELECT $4::varchar, sensor_code, date_trunc('day', ts), COUNT(*), SUM(value)
+			FROM dup GROUP BY sensor_code, date_trunc('day', ts)
+		)
+		UPDATE fms_sensor_rollups r SET samples = r.samples - b.n, total = r.total - b.total
+		FROM buckets b
+		WHERE r.ship_id = $1 AND r.resolution = b.resolution AND r.sensor_code = b.sensor_code AND r.bucket = b.bucket
+	`, from, to, Hourly, Daily)
+	if err != nil {
+		return fmt.Errorf("readings: move duplicates: %w", err)
+	}
+	if _, err := q.Exec("UPDATE fms_sensor_readings SET ship_id = $2 WHERE ship_id = $1", from, to); err != nil {
+		return fmt.Errorf("readings: move: %w", err)
+	}
+	_, err = q.Exec(`
+		INSERT INTO fms_sensor_rollups (resolution, ship_id, sensor_code, bucket, samples, total, min_value, max_value)
+		SELECT resolution, $2, sensor_code, bucket, samples, total, min_value, max_value
+		FROM fms_sensor_rollups
+		WHERE ship_id = $1 AND samples > 0
+		ON CONFLICT (resolution, ship_id, sensor_code, bucket) DO UPDATE SET
+			samples = fms_sensor_rollups.samples + EXCLUDED.samples,
+			total = fms_sensor_rollups.total + EXCLUDED.total,
+			min_value = LEAST(fms_sensor_rollups.min_value, EXCLUDED.min_value),
+			max_value = GREATEST(fms_sensor_rollups.max_value, EXCLUDED.max_value)
+	`, from, to)
+	if err != nil {
+		return fmt.Errorf("readings: move rollups: %w", err)
+	}
+	_, err = q.Exec("DELETE FROM fms_sensor_rollups WHERE ship_id = $1", from)
+	return err
+}
@@ -0,0 +1,83 @@
+package readings
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCutoff(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	if got := cutoff(0, now); !got.IsZero() {
+		t.Errorf("keep forever: got %v, want zero", got)
+	}
+	if got, want := cutoff(90*24*time.Hour, now), time.Date(2024, 12, 10, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("90 days: got %v, want %v", got, want)
+	}
+}
+
+func TestPartitionName(t *testing.T) {
+	cases := map[time.Time]string{
+		time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC):             "fms_sensor_readings_2025_01",
+		time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC):            "fms_sensor_readings_2025_12",
+		monthOf(time.Date(2024, 2, 29, 23, 59, 0, 0, time.UTC)): "fms_sensor_readings_2024_02",
+	}
+	for month, want := range cases {
+		if got := partitionName(month); got != want {
+			t.Errorf("%v: got %q, want %q", month, got, want)
+		}
+	}
+}
+
+func TestPartitionExpired(t *testing.T) {
+	cases := []struct {
+		name   string
+		oldest time.Time
+		want   bool
+	}{
+		{"fms_sensor_readings_2025_01", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), true},    // ends exactly at oldest
+		{"fms_sensor_readings_2025_01", time.Date(2025, 1, 31, 23, 0, 0, 0, time.UTC), false}, // last hour still kept
+		{"fms_sensor_readings_2025_02", time.Date(2025, 2, 15, 0, 0, 0, 0, time.UTC), false},
+		{"fms_sensor_readings_2024_12", time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC), true}, // year rollover
+		{"fms_sensor_readings_2024_12", time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC), false},
+		{"fms_sensor_readings_default", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"fms_sensor_readings_2024_13", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), false},
+	}
+	for _, tc := range cases {
+		if got := partitionExpired(tc.name, tc.oldest); got != tc.want {
+			t.Errorf("%s at %v: got %t, want %t", tc.name, tc.oldest, got, tc.want)
+		}
+	}
+}
+
+func TestPick(t *testing.T) {
+	t.Setenv("READINGS_RETENTION_RAW_DAYS", "90")
+	t.Setenv("READINGS_RETENTION_HOURLY_DAYS", "730")
+	t.Setenv("READINGS_RETENTION_DAILY_DAYS", "0")
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	cases := []struct {
+		name     string
+		from, to time.Time
+		want     Resolution
+	}{
+		{"last 24 hours", now.Add(-day), now, Raw},
+		{"two days", now.Add(-2 * day), now, Raw},
+		{"just over two days", now.Add(-2*day - time.Hour), now, Hourly},
+		{"two days beyond raw retention", now.Add(-100 * day), now.Add(-98 * day), Hourly},
+		{"30 days", now.Add(-30 * day), now, Hourly},
+		{"62 days", now.Add(-62 * day), now, Hourly},
+		{"63 days", now.Add(-63 * day), now, Daily},
+		{"a month beyond hourly retention", now.Add(-800 * day), now.Add(-770 * day), Daily},
+	}
+	for _, tc := range cases {
+		if got := Pick(tc.from, tc.to, now); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	t.Setenv("READINGS_RETENTION_RAW_DAYS", "0")
+	if got := Pick(now.Add(-1000*day), now.Add(-999*day), now); got != Raw {
+		t.Errorf("raw kept forever: got %s, want raw", got)
+	}
+}
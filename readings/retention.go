@@ -0,0 +1,94 @@
+package readings
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"fms-app/db"
+)
+
+// Task is the scheduled maintenance: it creates the partitions of this and next month,
+// drops raw partitions that lie wholly outside the retention and deletes expired rollups
+func Task(ctx context.Context) (string, error) {
+	now := time.Now()
+	if err := EnsurePartitions(ctx, now, monthOf(now).AddDate(0, 1, 0)); err != nil {
+		return "", err
+	}
+	ret := LoadRetention()
+
+	dropped, err := dropPartitions(ctx, cutoff(ret.Raw, now))
+	if err != nil {
+		return "", err
+	}
+
+	deleted := map[Resolution]int64{}
+	for res, keep := range map[Resolution]time.Duration{Hourly: ret.Hourly, Daily: ret.Daily} {
+		if keep == 0 {
+			continue
+		}
+		r, err := db.DB.ExecContext(ctx, "DELETE FROM fms_sensor_rollups WHERE resolution = $1 AND bucket < $2",
+			res, cutoff(keep, now))
+		if err != nil {
+			return "", err
+		}
+		deleted[res], _ = r.RowsAffected()
+	}
+
+	return fmt.Sprintf("%d raw partition(s) dropped, %d hourly and %d daily rollup(s) deleted",
+		dropped, deleted[Hourly], deleted[Daily]), nil
+}
+
+// dropPartitions drops the month partitions ending at or before oldest (zero: none)
+func dropPartitions(ctx context.Context, oldest time.Time) (int, error) {
+	if oldest.IsZero() {
+		return 0, nil
+	}
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'fms_sensor_readings'
+		ORDER BY c.relname ASC
+	`)
+	if err != nil {
+		return 0, err
+	}
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if partitionExpired(name, oldest) {
+			expired = append(expired, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	partitionsMu.Lock()
+	defer partitionsMu.Unlock()
+	for i, name := range expired {
+		if _, err := db.DB.ExecContext(ctx, "DROP TABLE IF EXISTS "+name); err != nil {
+			return i, err
+		}
+		delete(partitions, name)
+	}
+	return len(expired), nil
+}
+
+// partitionExpired reports whether the month partition name ends at or before oldest.
+// Tables not named by partitionName are never expired.
+func partitionExpired(name string, oldest time.Time) bool {
+	var year, month int
+	if _, err := fmt.Sscanf(name, "fms_sensor_readings_%d_%d", &year, &month); err != nil || month < 1 || month > 12 {
+		return false
+	}
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, oldest.Location())
+	return !start.AddDate(0, 1, 0).After(oldest)
+}
@@ -0,0 +1,290 @@
+// Package rules parses and evaluates the status rules administrators define in settings.
+//
+// A rule expression is one or more comparisons joined by "and":
+//
+//	value(rpm_me_port) > 0 and value(flowmeter_input) == 0
+//	age(gps) > 6
+//	report(flowmeter_bunker) == offline
+//	value(flowmeter_input) < value(flowmeter_output)
+//
+// value() is the latest value a gateway sent for a sensor, age() the hours since it was
+// sent and report() the sensor status in the period report (online = 1, offline = 0).
+package rules
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Source is where an operand takes its value from
+type Source string
+
+const (
+	Const  Source = ""
+	Value  Source = "value"
+	Age    Source = "age"
+	Report Source = "report"
+)
+
+// Operators understood by Parse
+var Operators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// Operand is a sensor reading or a constant
+type Operand struct {
+	Source Source
+	Sensor string
+	Const  float64
+}
+
+func (o Operand) String() string {
+	if o.Source == Const {
+		return strconv.FormatFloat(o.Const, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%s(%s)", o.Source, o.Sensor)
+}
+
+// Condition compares two operands; the left one is always a sensor
+type Condition struct {
+	Left  Operand
+	Op    string
+	Right Operand
+}
+
+func (c Condition) String() string {
+	return c.Left.String() + " " + c.Op + " " + c.Right.String()
+}
+
+// Expr is a parsed rule: it holds when every condition holds
+type Expr []Condition
+
+func (e Expr) String() string {
+	parts := make([]string, len(e))
+	for i, c := range e {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, " and ")
+}
+
+// Sensors returns the sensor codes the expression reads, in order of appearance
+func (e Expr) Sensors() []string {
+	var codes []string
+	seen := map[string]bool{}
+	for _, c := range e {
+		for _, o := range []Operand{c.Left, c.Right} {
+			if o.Source != Const && !seen[o.Sensor] {
+				seen[o.Sensor] = true
+				codes = append(codes, o.Sensor)
+			}
+		}
+	}
+	return codes
+}
+
+// Snapshot is what a rule is evaluated against for one ship at one point in time.
+// A sensor missing from Values or Report makes conditions reading it false. Ages is nil
+// for a ship without a gateway; otherwise a sensor missing from it never sent a value
+// and its age is infinite.
+type Snapshot struct {
+	Values map[string]float64
+	Ages   map[string]float64 // hours
+	Report map[string]bool
+}
+
+// operand returns the value of o and whether it is known
+func (s Snapshot) operand(o Operand) (float64, bool) {
+	switch o.Source {
+	case Const:
+		return o.Const, true
+	case Value:
+		v, ok := s.Values[o.Sensor]
+		return v, ok
+	case Age:
+		if s.Ages == nil {
+			return 0, false
+		}
+		if v, ok := s.Ages[o.Sensor]; ok {
+			return v, true
+		}
+		return math.Inf(1), true
+	case Report:
+		online, ok := s.Report[o.Sensor]
+		if online {
+			return 1, ok
+		}
+		return 0, ok
+	}
+	return 0, false
+}
+
+// Eval reports whether every condition holds and, if so, the values that made it hold,
+// e.g. "value(rpm_me_port)=850 > 0, value(flowmeter_input)=0 == 0"
+func (e Expr) Eval(s Snapshot) (bool, string) {
+	if len(e) == 0 {
+		return false, ""
+	}
+	details := make([]string, len(e))
+	for i, c := range e {
+		l, ok := s.operand(c.Left)
+		if !ok {
+			return false, ""
+		}
+		r, ok := s.operand(c.Right)
+		if !ok || !compare(l, c.Op, r) {
+			return false, ""
+		}
+		details[i] = fmt.Sprintf("%s=%s %s %s", c.Left, formatValue(l), c.Op, formatRight(c.Right, r))
+	}
+	return true, strings.Join(details, ", ")
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "∞"
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+func formatRight(o Operand, v float64) string {
+	if o.Source == Const {
+		return o.String()
+	}
+	return fmt.Sprintf("%s=%s", o, formatValue(v))
+}
+
+func compare(l float64, op string, r float64) bool {
+	switch op {
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	}
+	return false
+}
+
+// Parse reads a rule expression. Sensor codes are not checked against the configuration.
+func Parse(src string) (Expr, error) {
+	p := parser{src: src}
+	var e Expr
+	for {
+		c, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		e = append(e, c)
+		p.space()
+		if p.eof() {
+			return e, nil
+		}
+		if w := p.word(); !strings.EqualFold(w, "and") {
+			return nil, p.errorf("expected \"and\" or end of rule, got %q", w)
+		}
+	}
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("rule: position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) eof() bool { return p.pos >= len(p.src) }
+
+func (p *parser) space() {
+	for !p.eof() && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// word reads letters, digits, underscores, dots and a leading minus sign
+func (p *parser) word() string {
+	p.space()
+	start := p.pos
+	for !p.eof() {
+		ch := rune(p.src[p.pos])
+		if !(unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '_' || ch == '.' || (ch == '-' && p.pos == start)) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *parser) expect(ch byte) error {
+	p.space()
+	if p.eof() || p.src[p.pos] != ch {
+		return p.errorf("expected %q", ch)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) condition() (Condition, error) {
+	var c Condition
+	left, err := p.operand()
+	if err != nil {
+		return c, err
+	}
+	if left.Source == Const {
+		return c, p.errorf("a condition must start with value(), age() or report()")
+	}
+	p.space()
+	for _, op := range Operators {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			c.Op = op
+			p.pos += len(op)
+			break
+		}
+	}
+	if c.Op == "" {
+		return c, p.errorf("expected one of %s", strings.Join(Operators, " "))
+	}
+	right, err := p.operand()
+	if err != nil {
+		return c, err
+	}
+	c.Left, c.Right = left, right
+	return c, nil
+}
+
+func (p *parser) operand() (Operand, error) {
+	w := p.word()
+	switch lw := strings.ToLower(w); lw {
+	case "":
+		return Operand{}, p.errorf("expected a sensor or a number")
+	case "online":
+		return Operand{Const: 1}, nil
+	case "offline":
+		return Operand{Const: 0}, nil
+	case string(Value), string(Age), string(Report):
+		if err := p.expect('('); err != nil {
+			return Operand{}, err
+		}
+		sensor := p.word()
+		if sensor == "" {
+			return Operand{}, p.errorf("expected a sensor code")
+		}
+		if err := p.expect(')'); err != nil {
+			return Operand{}, err
+		}
+		return Operand{Source: Source(lw), Sensor: sensor}, nil
+	}
+	v, err := strconv.ParseFloat(w, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return Operand{}, p.errorf("%q is not a number or value()/age()/report()", w)
+	}
+	return Operand{Const: v}, nil
+}
@@ -0,0 +1,61 @@
+package rules
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	valid := map[string]string{
+		"value(rpm_me_port) > 0 AND value(flowmeter_input)==0": "value(rpm_me_port) > 0 and value(flowmeter_input) == 0",
+		"age(gps) >= 6":          "age(gps) >= 6",
+		"report(gps) == offline": "report(gps) == 0",
+		"value(flowmeter_input) < value(flowmeter_output)": "value(flowmeter_input) < value(flowmeter_output)",
+		"value(rpm_me_stbd) != -1.5":                       "value(rpm_me_stbd) != -1.5",
+	}
+	for src, want := range valid {
+		e, err := Parse(src)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if e.String() != want {
+			t.Errorf("%q: got %q, want %q", src, e.String(), want)
+		}
+	}
+
+	for _, src := range []string{"", "value(gps)", "0 < value(gps)", "value(gps) > ", "value() > 1", "value(gps) > 1 or age(gps) > 2", "speed(gps) > 1", "value(gps > 1"} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	suspect, _ := Parse("value(rpm_me_port) > 0 and value(flowmeter_input) == 0")
+	silent, _ := Parse("age(gps) > 6")
+	offline, _ := Parse("report(gps) == offline")
+
+	cases := []struct {
+		name string
+		expr Expr
+		snap Snapshot
+		want bool
+	}{
+		{"engine running, no flow", suspect, Snapshot{Values: map[string]float64{"rpm_me_port": 850, "flowmeter_input": 0}}, true},
+		{"engine running with flow", suspect, Snapshot{Values: map[string]float64{"rpm_me_port": 850, "flowmeter_input": 12}}, false},
+		{"flow unknown", suspect, Snapshot{Values: map[string]float64{"rpm_me_port": 850}}, false},
+		{"gps silent", silent, Snapshot{Ages: map[string]float64{"gps": 7}}, true},
+		{"gps recent", silent, Snapshot{Ages: map[string]float64{"gps": 1}}, false},
+		{"gps never sent", silent, Snapshot{Ages: map[string]float64{"rpm_me_port": 1}}, true},
+		{"no gateway", silent, Snapshot{}, false},
+		{"reported offline", offline, Snapshot{Report: map[string]bool{"gps": false}}, true},
+		{"no report", offline, Snapshot{}, false},
+	}
+	for _, tc := range cases {
+		got, detail := tc.expr.Eval(tc.snap)
+		if got != tc.want {
+			t.Errorf("%s: got %t, want %t", tc.name, got, tc.want)
+		}
+		if got && detail == "" {
+			t.Errorf("%s: no detail", tc.name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"1-x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"@often",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	cases := []struct {
+		expr, from, want string
+	}{
+		// steps
+		{"*/15 * * * *", "2025-01-15 10:07", "2025-01-15 10:15"},
+		{"*/15 * * * *", "2025-01-15 10:45", "2025-01-15 11:00"},
+		{"5/20 * * * *", "2025-01-15 10:26", "2025-01-15 10:45"},
+		{"0 */6 * * *", "2025-01-15 13:00", "2025-01-15 18:00"},
+		{"0 8-18/4 * * *", "2025-01-15 13:00", "2025-01-15 16:00"},
+		// strictly after
+		{"30 2 * * *", "2025-01-15 02:30", "2025-01-16 02:30"},
+		{"30 2 * * *", "2025-01-15 02:29", "2025-01-15 02:30"},
+		// ranges and lists
+		{"0 8 * * 1-5", "2025-01-17 09:00", "2025-01-20 08:00"}, // Friday -> Monday
+		{"30 2 1,15 * *", "2025-01-02 00:00", "2025-01-15 02:30"},
+		{"0 9,17 * * *", "2025-01-15 09:00", "2025-01-15 17:00"},
+		{"0 0 * * 7", "2025-01-15 00:00", "2025-01-19 00:00"}, // 7 is Sunday
+		// macros
+		{"@hourly", "2025-01-15 10:59", "2025-01-15 11:00"},
+		{"@weekly", "2025-01-15 10:00", "2025-01-19 00:00"},
+		{"@monthly", "2025-01-15 10:00", "2025-02-01 00:00"},
+		// month and year rollover
+		{"5 0 1 * *", "2025-01-31 23:59", "2025-02-01 00:05"},
+		{"0 0 31 * *", "2025-04-01 00:00", "2025-05-31 00:00"}, // April has no 31st
+		{"0 0 29 2 *", "2025-03-01 00:00", "2028-02-29 00:00"},
+		{"@yearly", "2025-12-31 23:59", "2026-01-01 00:00"},
+		{"0 0 * 3 *", "2025-12-31 00:00", "2026-03-01 00:00"},
+		// both day fields restricted: either may match
+		{"0 0 13 * 5", "2025-01-01 00:00", "2025-01-03 00:00"}, // Friday 3rd before the 13th
+		{"0 0 13 * 5", "2025-01-11 00:00", "2025-01-13 00:00"}, // Monday 13th
+		// one day field unrestricted: the other one must match
+		{"0 0 13 * *", "2025-01-01 00:00", "2025-01-13 00:00"},
+		{"0 0 * * 5", "2025-01-11 00:00", "2025-01-17 00:00"},
+		{"0 0 */2 * 1", "2025-01-01 00:00", "2025-01-13 00:00"}, // "*/2" is unrestricted: a Monday on an odd day
+		{"0 0 1 * */3", "2025-01-02 00:00", "2025-02-01 00:00"}, // a 1st on Sun, Wed or Sat
+	}
+	for _, tc := range cases {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		if got := c.Next(at(tc.from)); !got.Equal(at(tc.want)) {
+			t.Errorf("%q after %s: got %s, want %s", tc.expr, tc.from, got.Format("2006-01-02 15:04 Mon"), tc.want)
+		}
+	}
+}
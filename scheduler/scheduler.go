@@ -0,0 +1,252 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"fms-app/db"
+)
+
+// TaskFunc runs a scheduled task and returns a short human readable outcome
+type TaskFunc func(ctx context.Context) (string, error)
+
+// Task is a recurring task known to the scheduler
+type Task struct {
+	Name        string
+	DefaultCron string
+	Description string
+	Run         TaskFunc
+}
+
+var (
+	mu    sync.RWMutex
+	tasks = make(map[string]Task)
+
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+)
+
+// Register adds a task. Its schedule row is created with DefaultCron on Start
+// and can be edited from settings afterwards.
+func Register(name, defaultCron, description string, fn TaskFunc) {
+	if _, err := ParseCron(defaultCron); err != nil {
+		panic(fmt.Sprintf("scheduler: task %s: %v", name, err))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	tasks[name] = Task{Name: name, DefaultCron: defaultCron, Description: description, Run: fn}
+}
+
+// Tasks returns the registered tasks sorted by name
+func Tasks() []Task {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]Task, 0, len(tasks))
+	for _, t := range tasks {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Start seeds schedule rows for registered tasks and checks for due tasks every interval
+func Start(interval time.Duration) error {
+	now := time.Now()
+	for _, t := range Tasks() {
+		c, _ := ParseCron(t.DefaultCron)
+		_, err := db.DB.Exec(`
+			INSERT INTO fms_schedules (name, cron, description, next_run_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description
+		`, t.Name, t.DefaultCron, t.Description, c.Next(now))
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, c := context.WithCancel(context.Background())
+	cancel = c
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops scheduling and waits for running tasks to finish or ctx to expire
+func Stop(ctx context.Context) error {
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UpdateSchedule changes a task's cron expression and enabled flag
+func UpdateSchedule(name, cronExpr string, enabled bool) error {
+	c, err := ParseCron(cronExpr)
+	if err != nil {
+		return err
+	}
+	res, err := db.DB.Exec(`
+		UPDATE fms_schedules SET cron = $2, is_enabled = $3, next_run_at = $4
+		WHERE name = $1
+	`, name, cronExpr, enabled, c.Next(time.Now()))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("schedule %q not found", name)
+	}
+	return nil
+}
+
+// RunNow starts a task immediately in the background, outside its schedule
+func RunNow(name string) error {
+	mu.RLock()
+	t, ok := tasks[name]
+	mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown task %q", name)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		execute(context.Background(), t)
+	}()
+	return nil
+}
+
+func runDue(ctx context.Context) {
+	rows, err := db.DB.Query(`
+		SELECT name, cron, next_run_at FROM fms_schedules
+		WHERE is_enabled = true AND next_run_at <= $1
+	`, time.Now())
+	if err != nil {
+		log.Println("scheduler: due query:", err)
+		return
+	}
+
+	type due struct {
+		name, cron string
+		nextRunAt  time.Time
+	}
+	var list []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.name, &d.cron, &d.nextRunAt); err == nil {
+			list = append(list, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range list {
+		mu.RLock()
+		t, ok := tasks[d.name]
+		mu.RUnlock()
+		if !ok {
+			continue // schedule row from a task that is no longer registered
+		}
+
+		c, err := ParseCron(d.cron)
+		if err != nil {
+			log.Printf("scheduler: %s: %v", d.name, err)
+			continue
+		}
+
+		// Advance next_run_at only if nobody else did: one instance runs each occurrence
+		res, err := db.DB.Exec(`
+			UPDATE fms_schedules SET next_run_at = $3
+			WHERE name = $1 AND next_run_at = $2
+		`, d.name, d.nextRunAt, c.Next(time.Now()))
+		if err != nil {
+			log.Printf("scheduler: claim %s: %v", d.name, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(t Task) {
+			defer wg.Done()
+			execute(ctx, t)
+		}(t)
+	}
+}
+
+// execute runs a task and records the outcome and duration in fms_schedule_runs
+func execute(ctx context.Context, t Task) {
+	start := time.Now()
+	var runID int64
+	err := db.DB.QueryRow(`
+		INSERT INTO fms_schedule_runs (schedule_name, started_at, status)
+		VALUES ($1, $2, 'running')
+		RETURNING id
+	`, t.Name, start).Scan(&runID)
+	if err != nil {
+		log.Printf("scheduler: record run %s: %v", t.Name, err)
+	}
+
+	output, runErr := safeRun(ctx, t.Run)
+	duration := time.Since(start)
+
+	status := "success"
+	if runErr != nil {
+		status = "failed"
+		if output != "" {
+			output += "\n"
+		}
+		output += runErr.Error()
+		log.Printf("scheduler: %s failed after %s: %v", t.Name, duration.Round(time.Millisecond), runErr)
+	}
+
+	if runID != 0 {
+		_, err = db.DB.Exec(`
+			UPDATE fms_schedule_runs SET status = $2, duration_ms = $3, output = $4, finished_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+		`, runID, status, duration.Milliseconds(), output)
+		if err != nil {
+			log.Printf("scheduler: finish run %s: %v", t.Name, err)
+		}
+	}
+	_, _ = db.DB.Exec(`UPDATE fms_schedules SET last_run_at = $2, last_status = $3 WHERE name = $1`, t.Name, start, status)
+}
+
+func safeRun(ctx context.Context, fn TaskFunc) (out string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return fn(ctx)
+}
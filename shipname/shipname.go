@@ -0,0 +1,32 @@
+// Package shipname normalizes ship names. Every write of a ship name goes through Clean,
+// and spellings of the same ship are matched on Key (also stored as fms_ship_aliases.alias_key).
+package shipname
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Clean is the stored spelling of a ship name: trimmed, single-spaced and upper case
+func Clean(s string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(s), " "))
+}
+
+// Key is the form used to match spellings of the same ship. Punctuation is dropped and
+// leading zeros are stripped from numbers, so "TB. Celebes Sejati 1" and
+// "TB CELEBES SEJATI 01" share the key "TB CELEBES SEJATI 1".
+func Key(s string) string {
+	words := strings.FieldsFunc(strings.ToUpper(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		if strings.IndexFunc(w, func(r rune) bool { return !unicode.IsDigit(r) }) < 0 {
+			if t := strings.TrimLeft(w, "0"); t != "" {
+				words[i] = t
+			} else {
+				words[i] = "0"
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
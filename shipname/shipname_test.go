@@ -0,0 +1,34 @@
+package shipname
+
+import "testing"
+
+func TestClean(t *testing.T) {
+	cases := map[string]string{
+		"  tb  Celebes   sejati 01 ": "TB CELEBES SEJATI 01",
+		"KM. Nusa\tIndah":            "KM. NUSA INDAH",
+		"":                           "",
+	}
+	for in, want := range cases {
+		if got := Clean(in); got != want {
+			t.Errorf("Clean(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	same := []string{"TB CELEBES SEJATI 01", "TB. Celebes Sejati 1", "tb-celebes sejati 001"}
+	for _, s := range same {
+		if got := Key(s); got != "TB CELEBES SEJATI 1" {
+			t.Errorf("Key(%q) = %q", s, got)
+		}
+	}
+	if Key("TB CELEBES SEJATI 10") == Key("TB CELEBES SEJATI 1") {
+		t.Error("10 and 1 must not share a key")
+	}
+	if got := Key("KAPAL 00"); got != "KAPAL 0" {
+		t.Errorf("Key of zero number = %q", got)
+	}
+	if got := Key("MV A02"); got != "MV A02" {
+		t.Errorf("zeros inside words are kept, got %q", got)
+	}
+}
@@ -0,0 +1,124 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is a heartbeat or sensor state published by the gateway of one ship
+type Message struct {
+	Topic       string
+	ShipCode    string
+	ProjectCode string
+	Timestamp   time.Time
+	Sensors     map[string]Sensor
+}
+
+// Sensor is the state of one sensor with an optional reading
+type Sensor struct {
+	Online bool
+	Value  *float64
+}
+
+// payload is the JSON body of a message. Sensors is either a list of sensor codes (a
+// heartbeat: every listed sensor is online) or the object form of the ingestion API,
+// {"gps": {"status": "online", "value": 1.5}}.
+type payload struct {
+	ShipCode    string          `json:"ship_code"`
+	ProjectCode string          `json:"project_code"`
+	Timestamp   *time.Time      `json:"timestamp"`
+	Sensors     json.RawMessage `json:"sensors"`
+}
+
+type sensorState struct {
+	Status string   `json:"status"`
+	Value  *float64 `json:"value"`
+}
+
+// Parse decodes a message received on topic. The ship code comes from the payload or the
+// topic level matched by the first "+" of the subscription; a payload without timestamp
+// is taken as sent at received.
+func Parse(cfg Config, topic string, data []byte, received time.Time) (Message, error) {
+	var p payload
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return Message{}, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	m := Message{Topic: topic, ShipCode: strings.TrimSpace(p.ShipCode), ProjectCode: p.ProjectCode, Timestamp: received}
+	if m.ProjectCode == "" {
+		m.ProjectCode = cfg.Project
+	}
+	if p.Timestamp != nil {
+		m.Timestamp = *p.Timestamp
+	}
+
+	fromTopic := shipFromTopic(cfg.Topics, topic)
+	switch {
+	case m.ShipCode == "":
+		m.ShipCode = fromTopic
+	case fromTopic != "" && !strings.EqualFold(fromTopic, m.ShipCode):
+		return Message{}, fmt.Errorf("ship_code %q does not match the topic", m.ShipCode)
+	}
+	if m.ShipCode == "" {
+		return Message{}, fmt.Errorf("no ship code in topic or payload")
+	}
+	if m.ProjectCode == "" {
+		return Message{}, fmt.Errorf("no project_code in payload and MQTT_PROJECT_CODE is empty")
+	}
+
+	m.Sensors = map[string]Sensor{}
+	var heartbeat []string
+	var states map[string]sensorState
+	if err := json.Unmarshal(p.Sensors, &heartbeat); err == nil {
+		for _, code := range heartbeat {
+			m.Sensors[code] = Sensor{Online: true}
+		}
+	} else if err := json.Unmarshal(p.Sensors, &states); err == nil {
+		for code, s := range states {
+			if s.Status != "online" && s.Status != "offline" {
+				return Message{}, fmt.Errorf("sensor %s: status must be online or offline", code)
+			}
+			m.Sensors[code] = Sensor{Online: s.Status == "online", Value: s.Value}
+		}
+	} else {
+		return Message{}, fmt.Errorf("sensors must be a list of codes or an object of states")
+	}
+	if len(m.Sensors) == 0 {
+		return Message{}, fmt.Errorf("no sensors in payload")
+	}
+	return m, nil
+}
+
+// shipFromTopic returns the topic level at the first "+" of the first filter matching
+// topic, "" when no filter with a "+" matches
+func shipFromTopic(filters []string, topic string) string {
+	levels := strings.Split(topic, "/")
+	for _, filter := range filters {
+		parts := strings.Split(filter, "/")
+		ship, ok := "", true
+		for i, part := range parts {
+			if part == "#" {
+				break
+			}
+			if i >= len(levels) || (part != "+" && part != levels[i]) {
+				ok = false
+				break
+			}
+			if part == "+" && ship == "" {
+				ship = levels[i]
+			}
+			if i == len(parts)-1 && len(levels) > len(parts) {
+				ok = false
+			}
+		}
+		if ok && ship != "" {
+			return ship
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+// Package telemetry subscribes to the heartbeats and sensor states gateways publish over
+// MQTT and hands every message to the device ingestion pipeline.
+package telemetry
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Config holds the MQTT settings read from the environment
+type Config struct {
+	BrokerURL string // tcp://host:1883, ssl://host:8883 or ws://host/mqtt; empty disables the subscriber
+	ClientID  string
+	Username  string
+	Password  string
+	Topics    []string // subscription filters; the first "+" level is the ship code
+	QoS       byte
+	Project   string // project of messages without project_code
+}
+
+// Handler processes one message. Errors are logged; the message is not redelivered.
+type Handler func(Message) error
+
+var client mqtt.Client
+
+// LoadConfig reads MQTT_* from the environment
+func LoadConfig() Config {
+	cfg := Config{
+		BrokerURL: os.Getenv("MQTT_BROKER_URL"),
+		ClientID:  os.Getenv("MQTT_CLIENT_ID"),
+		Username:  os.Getenv("MQTT_USERNAME"),
+		Password:  os.Getenv("MQTT_PASSWORD"),
+		Project:   os.Getenv("MQTT_PROJECT_CODE"),
+		QoS:       1,
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "fms-app"
+	}
+	for _, t := range strings.Split(os.Getenv("MQTT_TOPICS"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			cfg.Topics = append(cfg.Topics, t)
+		}
+	}
+	if len(cfg.Topics) == 0 {
+		cfg.Topics = []string{"fms/+/heartbeat", "fms/+/sensors"}
+	}
+	if q, err := strconv.Atoi(os.Getenv("MQTT_QOS")); err == nil && q >= 0 && q <= 2 {
+		cfg.QoS = byte(q)
+	}
+	return cfg
+}
+
+// Start connects to the broker in the background and subscribes to cfg.Topics, passing
+// every message to handle in arrival order. The session is persistent (fixed client ID,
+// no clean session), so QoS 1/2 messages published while the app is down are delivered
+// on reconnect. Without a broker URL Start only logs that MQTT is disabled.
+func Start(cfg Config, handle Handler) {
+	if cfg.BrokerURL == "" {
+		log.Println("telemetry: MQTT_BROKER_URL is empty, MQTT subscriber disabled")
+		return
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.BrokerURL).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(false).
+		SetOrderMatters(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second)
+
+	// Messages of the persistent session can arrive before Subscribe returns, so they are
+	// all routed through the default handler rather than per-subscription callbacks
+	opts.SetDefaultPublishHandler(func(_ mqtt.Client, m mqtt.Message) {
+		msg, err := Parse(cfg, m.Topic(), m.Payload(), time.Now())
+		if err == nil {
+			err = handle(msg)
+		}
+		if err != nil {
+			log.Printf("telemetry: %s: %v", m.Topic(), err)
+		}
+	})
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		filters := make(map[string]byte, len(cfg.Topics))
+		for _, t := range cfg.Topics {
+			filters[t] = cfg.QoS
+		}
+		if token := c.SubscribeMultiple(filters, nil); token.Wait() && token.Error() != nil {
+			log.Printf("telemetry: subscribe: %v", token.Error())
+			return
+		}
+		log.Printf("telemetry: subscribed to %s (qos %d)", strings.Join(cfg.Topics, ", "), cfg.QoS)
+	})
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		log.Printf("telemetry: connection lost: %v", err)
+	})
+
+	log.Printf("telemetry: connecting to %s as %s", cfg.BrokerURL, cfg.ClientID)
+	client = mqtt.NewClient(opts)
+	client.Connect()
+}
+
+// Stop disconnects from the broker, letting in-flight work finish for up to 250ms
+func Stop() {
+	if client != nil {
+		client.Disconnect(250)
+	}
+}
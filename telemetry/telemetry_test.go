@@ -0,0 +1,96 @@
+package telemetry
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestParse(t *testing.T) {
+	cfg := Config{Topics: []string{"fms/+/heartbeat", "fms/+/sensors"}, Project: "FMS"}
+	received := time.Date(2025, 1, 15, 8, 0, 0, 0, time.UTC)
+
+	m, err := Parse(cfg, "fms/SHP1/heartbeat", []byte(`{"sensors":["gps","rpm_me_port"]}`), received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ShipCode != "SHP1" || m.ProjectCode != "FMS" || !m.Timestamp.Equal(received) ||
+		len(m.Sensors) != 2 || !m.Sensors["gps"].Online || !m.Sensors["rpm_me_port"].Online {
+		t.Errorf("heartbeat parsed as %+v", m)
+	}
+
+	m, err = Parse(cfg, "fms/SHP1/sensors",
+		[]byte(`{"project_code":"PLN","timestamp":"2025-01-14T23:00:00Z","sensors":{"gps":{"status":"offline"},"flowmeter_input":{"status":"online","value":12.5}}}`), received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ProjectCode != "PLN" || m.Timestamp.Day() != 14 || m.Sensors["gps"].Online ||
+		!m.Sensors["flowmeter_input"].Online || m.Sensors["flowmeter_input"].Value == nil || *m.Sensors["flowmeter_input"].Value != 12.5 {
+		t.Errorf("sensor state parsed as %+v", m)
+	}
+
+	for topic, payload := range map[string]string{
+		"fms/SHP1/sensors":    `{"sensors":{"gps":{"status":"up"}}}`,
+		"fms/SHP1/heartbeat":  `{"sensors":[]}`,
+		"fms/SHP1/heartbeat/": `{"sensors":["gps"]}`,
+		"fms/SHP2/heartbeat":  `{"ship_code":"SHP1","sensors":["gps"]}`,
+		"fms/SHP1/status":     `{"sensors":["gps"]}`,
+		"fms/SHP3/sensors":    `{"sensors":{"gps":"online"}}`,
+		"fms/SHP4/heartbeat":  `{"sensors":["gps"],"extra":1}`,
+	} {
+		if m, err := Parse(cfg, topic, []byte(payload), received); err == nil {
+			t.Errorf("%s %s: accepted as %+v", topic, payload, m)
+		}
+	}
+}
+
+// TestBroker runs against a real broker, e.g. the mosquitto service of docker-compose:
+// MQTT_TEST_BROKER=tcp://localhost:1883 go test ./telemetry
+func TestBroker(t *testing.T) {
+	broker := os.Getenv("MQTT_TEST_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_TEST_BROKER is not set")
+	}
+	prefix := fmt.Sprintf("fms-test-%d", time.Now().UnixNano())
+	cfg := Config{
+		BrokerURL: broker,
+		ClientID:  prefix,
+		Topics:    []string{prefix + "/+/heartbeat"},
+		QoS:       1,
+		Project:   "FMS",
+	}
+
+	got := make(chan Message, 1)
+	Start(cfg, func(m Message) error {
+		got <- m
+		return nil
+	})
+	t.Cleanup(Stop)
+
+	pub := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID(prefix + "-pub"))
+	if token := pub.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer pub.Disconnect(100)
+
+	// Publish until the subscription is in place, then expect the parsed message
+	deadline := time.After(10 * time.Second)
+	tick := time.NewTicker(200 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case m := <-got:
+			if m.ShipCode != "SHP1" || !m.Sensors["gps"].Online {
+				t.Fatalf("received %+v", m)
+			}
+			return
+		case <-tick.C:
+			pub.Publish(prefix+"/SHP1/heartbeat", 1, false, `{"sensors":["gps"]}`)
+		case <-deadline:
+			t.Fatal("no message received from the broker")
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package webhooks
+
+import "fms-app/events"
+
+// ReportData is the JSON shape of a report in webhook payloads
+type ReportData struct {
+	ID           int             `json:"id"`
+	Code         string          `json:"code"`
+	ProjectCode  string          `json:"project_code"`
+	ReportDate   string          `json:"report_date"`
+	ShipName     string          `json:"ship_name"`
+	Sensors      map[string]bool `json:"sensors"`
+	OnlineTotal  int             `json:"online_total"`
+	OfflineTotal int             `json:"offline_total"`
+}
+
+// AlertResolvedData is the JSON shape of a resolved alert in webhook payloads
+type AlertResolvedData struct {
+	ReportID   int    `json:"report_id"`
+	ShipName   string `json:"ship_name"`
+	SensorCode string `json:"sensor_code"`
+	Note       string `json:"note,omitempty"`
+}
+
+// NewReportData converts a report snapshot into its webhook payload shape
+func NewReportData(r events.Report) ReportData {
+	d := ReportData{
+		ID:          r.ID,
+		Code:        r.Code,
+		ProjectCode: r.ProjectCode,
+		ReportDate:  r.ReportDate.Format("2006-01-02"),
+		ShipName:    r.ShipName,
+		Sensors:     r.Sensors,
+	}
+	for _, online := range r.Sensors {
+		if online {
+			d.OnlineTotal++
+		} else {
+			d.OfflineTotal++
+		}
+	}
+	return d
+}
@@ -0,0 +1,266 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"fms-app/db"
+	"fms-app/outbox"
+)
+
+// Supported event names
+const (
+	EventReportCreated = "report.created"
+	EventReportUpdated = "report.updated"
+	EventAlertResolved = "alert.resolved"
+)
+
+// Events lists every event a subscription can filter on
+var Events = []string{EventReportCreated, EventReportUpdated, EventAlertResolved}
+
+// MaxAttempts is how many times a delivery is tried before it is marked failed
+const MaxAttempts = 5
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// claimLease is how long a delivery belongs to the instance sending it. The sender renews
+// it on every attempt; ResumePending only takes over deliveries whose lease ran out.
+const claimLease = 5 * time.Minute
+
+// Payload is the JSON body posted to subscribers. IdempotencyKey is stable
+// across redeliveries of the same event so receivers can deduplicate.
+type Payload struct {
+	Event          string          `json:"event"`
+	DeliveryID     int             `json:"delivery_id"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+	Data           json.RawMessage `json:"data"`
+}
+
+type subscription struct {
+	ID     int
+	URL    string
+	Secret string
+}
+
+// OutboxSink hands outbox messages to Dispatch
+func OutboxSink(m outbox.Message) error {
+	return Dispatch(m.Event, m.DedupKey, m.CreatedAt, m.Payload)
+}
+
+// Dispatch records a delivery for every active subscription listening to the event
+// and sends them in the background with retry and exponential backoff.
+// A subscription gets at most one delivery per dedupKey, so calling Dispatch again
+// for an event that was already recorded is safe.
+func Dispatch(event, dedupKey string, occurredAt time.Time, data json.RawMessage) error {
+	subs, err := subscriptionsFor(event)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		deliveryID, body, err := recordDelivery(sub, event, dedupKey, occurredAt, data)
+		if err == sql.ErrNoRows {
+			continue // already recorded for this subscription
+		}
+		if err != nil {
+			return err
+		}
+		go deliver(sub, deliveryID, event, dedupKey, body, 1)
+	}
+	return nil
+}
+
+// recordDelivery inserts a pending delivery together with its payload. The body carries
+// the delivery id, so both statements share a transaction: a delivery row is never left
+// without the payload ResumePending needs. sql.ErrNoRows means it was already recorded.
+func recordDelivery(sub subscription, event, dedupKey string, occurredAt time.Time, data json.RawMessage) (int, []byte, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var deliveryID int
+	err = tx.QueryRow(`
+		INSERT INTO fms_webhook_deliveries (webhook_id, event, dedup_key, status, locked_at)
+		VALUES ($1, $2, $3, 'pending', CURRENT_TIMESTAMP)
+		ON CONFLICT (webhook_id, dedup_key) DO NOTHING
+		RETURNING id
+	`, sub.ID, event, dedupKey).Scan(&deliveryID)
+	if err == sql.ErrNoRows {
+		return 0, nil, err
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("webhooks: create delivery: %w", err)
+	}
+
+	body, err := json.Marshal(Payload{
+		Event:          event,
+		DeliveryID:     deliveryID,
+		IdempotencyKey: dedupKey,
+		OccurredAt:     occurredAt.UTC(),
+		Data:           data,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := tx.Exec(`UPDATE fms_webhook_deliveries SET payload = $1 WHERE id = $2`, body, deliveryID); err != nil {
+		return 0, nil, err
+	}
+	return deliveryID, body, tx.Commit()
+}
+
+// ResumePending restarts deliveries that were still pending or retrying when their
+// sender stopped. Each is claimed first, so when several instances start together every
+// delivery is resumed by one of them only.
+func ResumePending() {
+	rows, err := db.DB.Query(`
+		UPDATE fms_webhook_deliveries d SET locked_at = CURRENT_TIMESTAMP
+		FROM fms_webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT x.id FROM fms_webhook_deliveries x
+			JOIN fms_webhooks xw ON xw.id = x.webhook_id
+			WHERE x.status IN ('pending', 'retrying') AND x.payload IS NOT NULL AND xw.is_active = true
+			  AND (x.locked_at IS NULL OR x.locked_at < CURRENT_TIMESTAMP - make_interval(secs => $1))
+			FOR UPDATE OF x SKIP LOCKED
+		)
+		RETURNING d.id, d.event, COALESCE(d.dedup_key, ''), d.payload, d.attempts, w.id, w.url, COALESCE(w.secret, '')
+	`, claimLease.Seconds())
+	if err != nil {
+		log.Println("webhooks: resume pending:", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sub subscription
+		var deliveryID, attempts int
+		var event, dedupKey string
+		var body []byte
+		if err := rows.Scan(&deliveryID, &event, &dedupKey, &body, &attempts, &sub.ID, &sub.URL, &sub.Secret); err != nil {
+			continue
+		}
+		go deliver(sub, deliveryID, event, dedupKey, body, attempts+1)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of body using the subscription secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliver(sub subscription, deliveryID int, event, dedupKey string, body []byte, firstAttempt int) {
+	backoff := 2 * time.Second
+	for attempt := firstAttempt; attempt <= MaxAttempts; attempt++ {
+		code, err := post(sub, deliveryID, event, dedupKey, body)
+
+		status := "delivered"
+		var errText string
+		if err != nil {
+			status = "retrying"
+			if attempt == MaxAttempts {
+				status = "failed"
+			}
+			errText = err.Error()
+		}
+
+		_, dbErr := db.DB.Exec(`
+			UPDATE fms_webhook_deliveries
+			SET status = $1, attempts = $2, response_code = $3, error = $4, locked_at = CURRENT_TIMESTAMP,
+			    delivered_at = CASE WHEN $1 = 'delivered' THEN CURRENT_TIMESTAMP ELSE NULL END
+			WHERE id = $5
+		`, status, attempt, code, errText, deliveryID)
+		if dbErr != nil {
+			log.Println("webhooks: update delivery:", dbErr)
+		}
+
+		if err == nil {
+			return
+		}
+		if attempt < MaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	log.Printf("webhooks: delivery %d to %s failed after %d attempts", deliveryID, sub.URL, MaxAttempts)
+}
+
+func post(sub subscription, deliveryID int, event, dedupKey string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fms-app-webhooks/1")
+	req.Header.Set("X-FMS-Event", event)
+	req.Header.Set("X-FMS-Delivery", fmt.Sprint(deliveryID))
+	req.Header.Set("X-FMS-Idempotency-Key", dedupKey)
+	if sub.Secret != "" {
+		req.Header.Set("X-FMS-Signature", "sha256="+Sign(sub.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func subscriptionsFor(event string) ([]subscription, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, url, COALESCE(secret, ''), events
+		FROM fms_webhooks
+		WHERE is_active = true
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []subscription
+	for rows.Next() {
+		var s subscription
+		var events string
+		if err := rows.Scan(&s.ID, &s.URL, &s.Secret, &events); err != nil {
+			continue
+		}
+		if Matches(events, event) {
+			subs = append(subs, s)
+		}
+	}
+	return subs, rows.Err()
+}
+
+// Matches reports whether a comma-separated event filter accepts the event.
+// "*" matches everything and "report.*" matches every report event.
+func Matches(filter, event string) bool {
+	for _, f := range strings.Split(filter, ",") {
+		f = strings.TrimSpace(f)
+		if f == "*" || f == event {
+			return true
+		}
+		if strings.HasSuffix(f, ".*") && strings.HasPrefix(event, strings.TrimSuffix(f, "*")) {
+			return true
+		}
+	}
+	return false
+}